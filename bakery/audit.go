@@ -0,0 +1,141 @@
+package bakery
+
+import (
+	"context"
+
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+// AuditEventKind identifies the kind of an AuditEvent.
+type AuditEventKind string
+
+const (
+	// AuditMacaroonMinted is emitted by Oven.NewMacaroon when
+	// a new macaroon has been created.
+	AuditMacaroonMinted AuditEventKind = "macaroon-minted"
+
+	// AuditMacaroonVerified is emitted when an authorization
+	// check allows the requested operations.
+	AuditMacaroonVerified AuditEventKind = "macaroon-verified"
+
+	// AuditMacaroonDenied is emitted when an authorization
+	// check does not allow the requested operations.
+	AuditMacaroonDenied AuditEventKind = "macaroon-denied"
+
+	// AuditDischargeIssued is emitted when a discharge
+	// macaroon has been created for a third party caveat.
+	AuditDischargeIssued AuditEventKind = "discharge-issued"
+
+	// AuditDischargeRefused is emitted when a third party
+	// caveat could not be discharged.
+	AuditDischargeRefused AuditEventKind = "discharge-refused"
+
+	// AuditInteractionStarted is emitted when a discharger
+	// requires the client to interact before a third party
+	// caveat can be discharged.
+	AuditInteractionStarted AuditEventKind = "interaction-started"
+
+	// AuditInteractionCompleted is emitted when a discharger
+	// discharges a third party caveat using a discharge token
+	// obtained by interaction.
+	AuditInteractionCompleted AuditEventKind = "interaction-completed"
+)
+
+// AuditEvent describes an event of interest for auditing purposes.
+// It never holds secret information such as root keys, private keys
+// or discharge tokens, so it is safe to record in audit logs.
+// Fields that are not relevant to an event are left as their zero
+// value.
+type AuditEvent struct {
+	// Kind holds the kind of the event.
+	Kind AuditEventKind
+
+	// Location holds the location of the macaroon
+	// that was minted.
+	Location string
+
+	// Ops holds the operations that were minted
+	// or that authorization was requested for.
+	Ops []Op
+
+	// Identity holds the id of the authenticated identity,
+	// if known.
+	Identity string
+
+	// MacaroonIds holds the ids of the macaroons that were
+	// minted or discharged, or of the primary macaroons that
+	// were presented for authorization. When authorization
+	// succeeds, only the macaroons that were used are included.
+	MacaroonIds [][]byte
+
+	// Nonce holds the nonce of a newly minted macaroon.
+	Nonce []byte
+
+	// Caveats holds the caveats added to a newly minted
+	// macaroon or discharge macaroon.
+	Caveats []checkers.Caveat
+
+	// Conditions holds the first party caveat conditions that
+	// an authorization decision relied on, or the condition of
+	// a third party caveat that was discharged.
+	Conditions []string
+
+	// Error holds the text of the error that caused the request
+	// to be denied or refused.
+	Error string
+}
+
+// Auditor is used by the bakery to record audit events.
+type Auditor interface {
+	// AuditEvent records the given event. It should not block
+	// for long as it is called synchronously.
+	AuditEvent(ctx context.Context, e AuditEvent)
+}
+
+// AuditorFunc implements Auditor by calling a function.
+type AuditorFunc func(ctx context.Context, e AuditEvent)
+
+// AuditEvent implements Auditor.AuditEvent by calling f(ctx, e).
+func (f AuditorFunc) AuditEvent(ctx context.Context, e AuditEvent) {
+	f(ctx, e)
+}
+
+type nopAuditor struct{}
+
+// AuditEvent implements Auditor.AuditEvent.
+func (nopAuditor) AuditEvent(context.Context, AuditEvent) {}
+
+// AuthAuditEvent returns the event describing an authorization decision
+// for the given operations made with the given macaroons. If err is
+// nil, info should describe the successful decision. It is exported so
+// that packages that wrap AuthChecker can produce events of the same form.
+func AuthAuditEvent(ops []Op, mss []macaroon.Slice, info *AuthInfo, err error) AuditEvent {
+	e := AuditEvent{
+		Kind: AuditMacaroonVerified,
+		Ops:  append([]Op(nil), ops...),
+	}
+	if err != nil {
+		e.Kind = AuditMacaroonDenied
+		e.Error = err.Error()
+		e.MacaroonIds = macaroonIds(mss, nil)
+		return e
+	}
+	e.MacaroonIds = macaroonIds(info.Macaroons, info.Used)
+	e.Conditions = info.Conditions()
+	return e
+}
+
+// macaroonIds returns the ids of the primary macaroons in mss.
+// If used is non-nil, only the macaroons marked as used are included.
+func macaroonIds(mss []macaroon.Slice, used []bool) [][]byte {
+	var ids [][]byte
+	for i, ms := range mss {
+		if len(ms) == 0 || (used != nil && !used[i]) {
+			continue
+		}
+		ids = append(ids, ms[0].Id())
+	}
+	return ids
+}
@@ -0,0 +1,62 @@
+package bakery_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+func TestAuditEvents(t *testing.T) {
+	c := qt.New(t)
+	var events []bakery.AuditEvent
+	b := bakery.New(bakery.BakeryParams{
+		Checker:  testChecker,
+		Location: "here",
+		Auditor: bakery.AuditorFunc(func(ctx context.Context, e bakery.AuditEvent) {
+			events = append(events, e)
+		}),
+	})
+	caveats := []checkers.Caveat{{
+		Condition: "str something",
+		Namespace: "testns",
+	}}
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, caveats, basicOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Nonce, qt.HasLen, 16)
+	c.Assert(events[0], qt.DeepEquals, bakery.AuditEvent{
+		Kind:        bakery.AuditMacaroonMinted,
+		Location:    "here",
+		Ops:         []bakery.Op{basicOp},
+		MacaroonIds: [][]byte{m.M().Id()},
+		Nonce:       events[0].Nonce,
+		Caveats:     caveats,
+	})
+	events = nil
+
+	ms := macaroon.Slice{m.M()}
+	ctx := strContext("something")
+	_, err = b.Checker.Auth(ms).Allow(ctx, basicOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, []bakery.AuditEvent{{
+		Kind:        bakery.AuditMacaroonVerified,
+		Ops:         []bakery.Op{basicOp},
+		MacaroonIds: [][]byte{m.M().Id()},
+		Conditions:  []string{"str something"},
+	}})
+	events = nil
+
+	_, err = b.Checker.Auth(ms).Allow(testContext, basicOp)
+	c.Assert(err, qt.ErrorMatches, `caveat "str something" not satisfied: str doesn.t match `)
+	c.Assert(events, qt.DeepEquals, []bakery.AuditEvent{{
+		Kind:        bakery.AuditMacaroonDenied,
+		Ops:         []bakery.Op{basicOp},
+		MacaroonIds: [][]byte{m.M().Id()},
+		Error:       `caveat "str something" not satisfied: str doesn't match `,
+	}})
+}
@@ -54,6 +54,13 @@ type BakeryParams struct {
 	// operation except itself.
 	OpsAuthorizer OpsAuthorizer
 
+	// OpsHierarchy, if non-nil, is used to create a
+	// HierarchicalOpsAuthorizer that is used to check whether
+	// operations are authorized by some other already-authorized
+	// operation. If its Fallback field is nil, OpsAuthorizer will be
+	// used as the fallback.
+	OpsHierarchy *HierarchyParams
+
 	// Location holds the location to use when creating new macaroons.
 	Location string
 
@@ -62,6 +69,15 @@ type BakeryParams struct {
 	// If this is empty, legacy macaroons will not be associated
 	// with any operations.
 	LegacyMacaroonOp Op
+
+	// Auditor is used to record audit events from both the
+	// Oven and the Checker. If it is nil, no events will
+	// be recorded.
+	Auditor Auditor
+
+	// Metrics is used to record metrics from the Oven.
+	// If it is nil, no metrics will be recorded.
+	Metrics Metrics
 }
 
 // New returns a new Bakery instance which combines an Oven with a
@@ -77,6 +93,8 @@ func New(p BakeryParams) *Bakery {
 		Location:         p.Location,
 		Locator:          p.Locator,
 		LegacyMacaroonOp: p.LegacyMacaroonOp,
+		Auditor:          p.Auditor,
+		Metrics:          p.Metrics,
 	}
 	if p.RootKeyStore != nil {
 		ovenParams.RootKeyStoreForOps = func(ops []Op) RootKeyStore {
@@ -88,7 +106,8 @@ func New(p BakeryParams) *Bakery {
 	checker := NewChecker(CheckerParams{
 		Checker:          p.Checker,
 		MacaroonVerifier: oven,
-		OpsAuthorizer:    p.OpsAuthorizer,
+		OpsAuthorizer:    p.OpsHierarchy.OpsAuthorizer(p.OpsAuthorizer),
+		Auditor:          p.Auditor,
 	})
 	return &Bakery{
 		Oven:    oven,
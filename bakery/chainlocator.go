@@ -0,0 +1,64 @@
+package bakery
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+)
+
+// NamedLocator associates a name with a ThirdPartyLocator
+// so that ChainLocator can report which locator answered.
+type NamedLocator struct {
+	// Name holds the name of the locator, for example "static".
+	Name string
+
+	// Locator holds the locator itself.
+	Locator ThirdPartyLocator
+}
+
+// ChainLocator is a ThirdPartyLocator that consults a sequence of
+// locators in order, for example static configuration first, then a
+// caching HTTP locator, then a fallback.
+//
+// The information from the first locator that finds the location is
+// used. If a locator returns an error other than ErrNotFound, for
+// example a key mismatch reported by an httpbakery.ThirdPartyLocator
+// with pinned keys, the search stops and that error is returned so
+// that a later locator cannot override it.
+type ChainLocator struct {
+	locators []NamedLocator
+}
+
+var _ ThirdPartyLocator = (*ChainLocator)(nil)
+
+// NewChainLocator returns a ChainLocator that
+// consults the given locators in order.
+func NewChainLocator(locators ...NamedLocator) *ChainLocator {
+	return &ChainLocator{
+		locators: locators,
+	}
+}
+
+// ThirdPartyInfo implements ThirdPartyLocator.ThirdPartyInfo.
+func (l *ChainLocator) ThirdPartyInfo(ctx context.Context, loc string) (ThirdPartyInfo, error) {
+	info, _, err := l.ThirdPartyInfoWithSource(ctx, loc)
+	if err != nil {
+		return ThirdPartyInfo{}, errgo.Mask(err, errgo.Any)
+	}
+	return info, nil
+}
+
+// ThirdPartyInfoWithSource is like ThirdPartyInfo except that it
+// also returns the name of the locator that provided the information.
+func (l *ChainLocator) ThirdPartyInfoWithSource(ctx context.Context, loc string) (ThirdPartyInfo, string, error) {
+	for _, nl := range l.locators {
+		info, err := nl.Locator.ThirdPartyInfo(ctx, loc)
+		if err == nil {
+			return info, nl.Name, nil
+		}
+		if errgo.Cause(err) != ErrNotFound {
+			return ThirdPartyInfo{}, "", errgo.NoteMask(err, "cannot get third party information from "+nl.Name, errgo.Any)
+		}
+	}
+	return ThirdPartyInfo{}, "", errgo.WithCausef(nil, ErrNotFound, "third party %q not found", loc)
+}
@@ -50,6 +50,11 @@ type CheckerParams struct {
 	// Logger is used to log checker operations. If it is nil,
 	// DefaultLogger("bakery") will be used.
 	Logger Logger
+
+	// Auditor is used to record an AuditMacaroonVerified or
+	// AuditMacaroonDenied event for each call to AuthChecker.Allow.
+	// If it is nil, no events will be recorded.
+	Auditor Auditor
 }
 
 // OpsAuthorizer is used to check whether an operation authorizes some other
@@ -131,6 +136,9 @@ func NewChecker(p CheckerParams) *Checker {
 	if p.Logger == nil {
 		p.Logger = DefaultLogger("bakery")
 	}
+	if p.Auditor == nil {
+		p.Auditor = nopAuditor{}
+	}
 	return &Checker{
 		FirstPartyCaveatChecker: p.Checker,
 		p:                       p,
@@ -157,6 +165,12 @@ type AuthChecker struct {
 	initOnce   sync.Once
 	initError  error
 	initErrors []error
+	// verifyErrors holds the verification error for
+	// each of the above macaroons, if any.
+	verifyErrors []error
+	// macaroonOps holds the operations granted by each of
+	// the above macaroons.
+	macaroonOps [][]Op
 	// authIndexes holds for each potentially authorized operation
 	// the indexes of the macaroons that authorize it.
 	authIndexes map[Op][]int
@@ -172,18 +186,27 @@ func (a *AuthChecker) init(ctx context.Context) error {
 func (a *AuthChecker) initOnceFunc(ctx context.Context) error {
 	a.authIndexes = make(map[Op][]int)
 	a.conditions = make([][]string, len(a.macaroons))
+	a.verifyErrors = make([]error, len(a.macaroons))
+	a.macaroonOps = make([][]Op, len(a.macaroons))
 	for i, ms := range a.macaroons {
+		fields := []LogField{Field("index", i)}
+		if len(ms) > 0 {
+			fields = append(fields, MacaroonIdField(ms[0].Id()))
+		}
 		ops, conditions, err := a.p.MacaroonVerifier.VerifyMacaroon(ctx, ms)
 		if err != nil {
 			if !isVerificationError(err) {
 				return errgo.Notef(err, "cannot retrieve macaroon")
 			}
+			Log(ctx, a.p.Logger, LevelDebug, "macaroon verification failed", append(fields, ErrorField(err))...)
 			a.initErrors = append(a.initErrors, errgo.Mask(err))
+			a.verifyErrors[i] = err
 			continue
 		}
-		a.p.Logger.Debugf(ctx, "macaroon %d has valid sig; ops %q, conditions %q", i, ops, conditions)
+		Log(ctx, a.p.Logger, LevelDebug, "macaroon has valid signature", append(fields, OpsField(ops), Field("conditions", conditions))...)
 		// It's a valid macaroon (in principle - we haven't checked first party caveats).
 		a.conditions[i] = conditions
+		a.macaroonOps[i] = ops
 		for _, op := range ops {
 			a.authIndexes[op] = append(a.authIndexes[op], i)
 		}
@@ -199,7 +222,7 @@ func (a *AuthChecker) initOnceFunc(ctx context.Context) error {
 // Allowed returns an error only when there is an underlying storage failure,
 // not when operations are not authorized.
 func (a *AuthChecker) Allowed(ctx context.Context) (*AuthInfo, error) {
-	actx, err := a.newAllowContext(ctx, nil)
+	actx, err := a.newAllowContext(ctx, nil, nil)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -253,6 +276,14 @@ type allowContext struct {
 
 	// errors holds any errors encountered during authorization.
 	errors []error
+
+	// explanation holds the explanation of the decision
+	// being made, if one was requested.
+	explanation *Explanation
+
+	// caveatExplanations holds the results of checking the
+	// caveats in each macaroon when explanation is non-nil.
+	caveatExplanations [][]CaveatExplanation
 }
 
 type macaroonStatus uint8
@@ -262,14 +293,18 @@ const (
 	statusUsed
 )
 
-func (a *AuthChecker) newAllowContext(ctx context.Context, ops []Op) (*allowContext, error) {
+func (a *AuthChecker) newAllowContext(ctx context.Context, ops []Op, expl *Explanation) (*allowContext, error) {
 	actx := &allowContext{
-		checker:   a,
-		status:    make([]macaroonStatus, len(a.macaroons)),
-		authed:    make([]bool, len(ops)),
-		need:      append([]Op(nil), ops...),
-		needIndex: make([]int, len(ops)),
-		opIndexes: make(map[Op]int),
+		checker:     a,
+		status:      make([]macaroonStatus, len(a.macaroons)),
+		authed:      make([]bool, len(ops)),
+		need:        append([]Op(nil), ops...),
+		needIndex:   make([]int, len(ops)),
+		opIndexes:   make(map[Op]int),
+		explanation: expl,
+	}
+	if expl != nil {
+		actx.caveatExplanations = make([][]CaveatExplanation, len(a.macaroons))
 	}
 	for i := range actx.needIndex {
 		actx.needIndex[i] = i
@@ -285,8 +320,15 @@ func (a *AuthChecker) newAllowContext(ctx context.Context, ops []Op) (*allowCont
 outer:
 	for i, ms := range a.macaroons {
 		ctx := checkers.ContextWithMacaroons(ctx, a.Namespace(), ms)
+		if expl != nil {
+			actx.caveatExplanations[i] = make([]CaveatExplanation, 0, len(a.conditions[i]))
+		}
 		for _, cond := range a.conditions[i] {
-			if err := a.CheckFirstPartyCaveat(ctx, cond); err != nil {
+			err := a.CheckFirstPartyCaveat(ctx, cond)
+			if expl != nil {
+				actx.explainCaveat(i, cond, err)
+			}
+			if err != nil {
 				actx.addError(err)
 				continue outer
 			}
@@ -313,7 +355,18 @@ func (a *AuthChecker) Macaroons() []macaroon.Slice {
 // be authorized in order to allow authorization to
 // proceed.
 func (a *AuthChecker) Allow(ctx context.Context, ops ...Op) (*AuthInfo, error) {
-	actx, err := a.newAllowContext(ctx, ops)
+	info, err := a.allow(ctx, ops, nil)
+	a.p.Auditor.AuditEvent(ctx, AuthAuditEvent(ops, a.macaroons, info, err))
+	return info, err
+}
+
+// allow implements Allow. If expl is non-nil, an explanation
+// of the decision is recorded in it.
+func (a *AuthChecker) allow(ctx context.Context, ops []Op, expl *Explanation) (*AuthInfo, error) {
+	actx, err := a.newAllowContext(ctx, ops, expl)
+	if expl != nil {
+		defer actx.explain(expl)
+	}
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -329,7 +382,7 @@ func (a *AuthChecker) Allow(ctx context.Context, ops ...Op) (*AuthInfo, error) {
 		// No more ops need to be authenticated and no caveats to be discharged.
 		return actx.newAuthInfo(), nil
 	}
-	a.p.Logger.Debugf(ctx, "operations still needed after auth check: %#v", actx.need)
+	Log(ctx, a.p.Logger, LevelDebug, "operations still needed after auth check", OpsField(actx.need))
 	if len(caveats) == 0 || len(actx.need) > 0 {
 		allErrors := make([]error, 0, len(a.initErrors)+len(actx.errors))
 		allErrors = append(allErrors, a.initErrors...)
@@ -337,7 +390,11 @@ func (a *AuthChecker) Allow(ctx context.Context, ops ...Op) (*AuthInfo, error) {
 		var err error
 		if len(allErrors) > 0 {
 			// TODO return all errors?
-			a.p.Logger.Infof(ctx, "all auth errors: %q", allErrors)
+			errs := make([]string, len(allErrors))
+			for i, err := range allErrors {
+				errs[i] = err.Error()
+			}
+			Log(ctx, a.p.Logger, LevelInfo, "authorization denied", OpsField(actx.need), Field("errors", errs))
 			err = allErrors[0]
 		}
 		return nil, errgo.WithCausef(err, ErrPermissionDenied, "")
@@ -387,6 +444,7 @@ func (a *allowContext) checkIndirect(ctx context.Context) ([]checkers.Caveat, er
 			}
 			ctx := checkers.ContextWithMacaroons(ctx, a.checker.Namespace(), a.checker.macaroons[mindex])
 			authedOK, caveats, err := a.checker.p.OpsAuthorizer.AuthorizeOps(ctx, op, a.need)
+			a.explainDecision(op, mindex, a.need, authedOK, caveats, err)
 			if err != nil {
 				return nil, errgo.Mask(err)
 			}
@@ -412,6 +470,7 @@ func (a *allowContext) checkIndirect(ctx context.Context) ([]checkers.Caveat, er
 	// We've still got at least one operation unauthorized.
 	// Try to see if it can be authorized with no operation at all.
 	authedOK, caveats, err := a.checker.p.OpsAuthorizer.AuthorizeOps(ctx, NoOp, a.need)
+	a.explainDecision(NoOp, -1, a.need, authedOK, caveats, err)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -2,6 +2,7 @@ package bakery
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
@@ -28,39 +29,41 @@ type caveatJSON struct {
 
 // encodeCaveat encrypts a third-party caveat with the given condtion
 // and root key. The thirdPartyInfo key holds information about the
-// third party we're encrypting the caveat for; the key is the
-// public/private key pair of the party that's adding the caveat.
+// third party we're encrypting the caveat for; the key holds the
+// private key of the party that's adding the caveat.
 //
 // The caveat will be encoded according to the version information
 // found in thirdPartyInfo.
 func encodeCaveat(
+	ctx context.Context,
 	condition string,
 	rootKey []byte,
 	thirdPartyInfo ThirdPartyInfo,
-	key *KeyPair,
+	key KeyOpener,
 	ns *checkers.Namespace,
 ) ([]byte, error) {
 	switch thirdPartyInfo.Version {
 	case Version0, Version1:
-		return encodeCaveatV1(condition, rootKey, &thirdPartyInfo.PublicKey, key)
+		return encodeCaveatV1(ctx, condition, rootKey, &thirdPartyInfo.PublicKey, key)
 	case Version2:
-		return encodeCaveatV2(condition, rootKey, &thirdPartyInfo.PublicKey, key)
+		return encodeCaveatV2(ctx, condition, rootKey, &thirdPartyInfo.PublicKey, key)
 	default:
 		// Version 3 or later - use V3.
-		return encodeCaveatV3(condition, rootKey, &thirdPartyInfo.PublicKey, key, ns)
+		return encodeCaveatV3(ctx, condition, rootKey, &thirdPartyInfo.PublicKey, key, ns)
 	}
 }
 
 // encodeCaveatV1 creates a JSON-encoded third-party caveat
 // with the given condtion and root key. The thirdPartyPubKey key
 // represents the public key of the third party we're encrypting
-// the caveat for; the key is the public/private key pair of the party
+// the caveat for; the key holds the private key of the party
 // that's adding the caveat.
 func encodeCaveatV1(
+	ctx context.Context,
 	condition string,
 	rootKey []byte,
 	thirdPartyPubKey *PublicKey,
-	key *KeyPair,
+	key KeyOpener,
 ) ([]byte, error) {
 	var nonce [NonceLen]byte
 	if _, err := rand.Read(nonce[:]); err != nil {
@@ -74,10 +77,14 @@ func encodeCaveatV1(
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot marshal %#v", &plain)
 	}
-	sealed := box.Seal(nil, plainData, &nonce, thirdPartyPubKey.boxKey(), key.Private.boxKey())
+	sharedKey, err := key.SharedKey(ctx, thirdPartyPubKey)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot compute shared key")
+	}
+	sealed := box.SealAfterPrecomputation(nil, plainData, &nonce, sharedKey)
 	id := caveatJSON{
 		ThirdPartyPublicKey: thirdPartyPubKey,
-		FirstPartyPublicKey: &key.Public,
+		FirstPartyPublicKey: key.PublicKey(),
 		Nonce:               nonce[:],
 		Id:                  base64.StdEncoding.EncodeToString(sealed),
 	}
@@ -92,23 +99,25 @@ func encodeCaveatV1(
 
 // encodeCaveatV2 creates a version 2 third-party caveat.
 func encodeCaveatV2(
+	ctx context.Context,
 	condition string,
 	rootKey []byte,
 	thirdPartyPubKey *PublicKey,
-	key *KeyPair,
+	key KeyOpener,
 ) ([]byte, error) {
-	return encodeCaveatV2V3(Version2, condition, rootKey, thirdPartyPubKey, key, nil)
+	return encodeCaveatV2V3(ctx, Version2, condition, rootKey, thirdPartyPubKey, key, nil)
 }
 
 // encodeCaveatV3 creates a version 3 third-party caveat.
 func encodeCaveatV3(
+	ctx context.Context,
 	condition string,
 	rootKey []byte,
 	thirdPartyPubKey *PublicKey,
-	key *KeyPair,
+	key KeyOpener,
 	ns *checkers.Namespace,
 ) ([]byte, error) {
-	return encodeCaveatV2V3(Version3, condition, rootKey, thirdPartyPubKey, key, ns)
+	return encodeCaveatV2V3(ctx, Version3, condition, rootKey, thirdPartyPubKey, key, ns)
 }
 
 const publicKeyPrefixLen = 4
@@ -139,11 +148,12 @@ const version3CaveatMinLen = 1 + 4 + 32 + 24 + box.Overhead + 1
 // 	encoded namespace [n bytes] (Version 3 only)
 // 	condition [rest of encrypted part]
 func encodeCaveatV2V3(
+	ctx context.Context,
 	version Version,
 	condition string,
 	rootKey []byte,
 	thirdPartyPubKey *PublicKey,
-	key *KeyPair,
+	key KeyOpener,
 	ns *checkers.Namespace,
 ) ([]byte, error) {
 
@@ -171,15 +181,19 @@ func encodeCaveatV2V3(
 		len(nsData) +
 		len(condition)
 
+	sharedKey, err := key.SharedKey(ctx, thirdPartyPubKey)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot compute shared key")
+	}
 	var nonce [NonceLen]byte = uuidGen.Next()
 
 	data := make([]byte, 0, dataLen)
 	data = append(data, byte(version))
 	data = append(data, thirdPartyPubKey.Key[:publicKeyPrefixLen]...)
-	data = append(data, key.Public.Key[:]...)
+	data = append(data, key.PublicKey().Key[:]...)
 	data = append(data, nonce[:]...)
 	secret := encodeSecretPartV2V3(version, condition, rootKey, nsData)
-	return box.Seal(data, secret, &nonce, thirdPartyPubKey.boxKey(), key.Private.boxKey()), nil
+	return box.SealAfterPrecomputation(data, secret, &nonce, sharedKey), nil
 }
 
 // encodeSecretPartV2V3 creates a version 2 or version 3 secret part of the third party
@@ -207,23 +221,23 @@ func encodeSecretPartV2V3(version Version, condition string, rootKey, nsData []b
 
 // decodeCaveat attempts to decode caveat by decrypting the encrypted part
 // using key.
-func decodeCaveat(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error) {
+func decodeCaveat(ctx context.Context, key KeyOpener, caveat []byte) (*ThirdPartyCaveatInfo, error) {
 	if len(caveat) == 0 {
 		return nil, errgo.New("empty third party caveat")
 	}
 	switch caveat[0] {
 	case byte(Version2):
-		return decodeCaveatV2V3(Version2, key, caveat)
+		return decodeCaveatV2V3(ctx, Version2, key, caveat)
 	case byte(Version3):
 		if len(caveat) < version3CaveatMinLen {
 			// If it has the version 3 caveat tag and it's too short, it's
 			// almost certainly an id, not an encrypted payload.
 			return nil, errgo.Newf("caveat id payload not provided for caveat id %q", caveat)
 		}
-		return decodeCaveatV2V3(Version3, key, caveat)
+		return decodeCaveatV2V3(ctx, Version3, key, caveat)
 	case 'e':
 		// 'e' will be the first byte if the caveatid is a base64 encoded JSON object.
-		return decodeCaveatV1(key, caveat)
+		return decodeCaveatV1(ctx, key, caveat)
 	default:
 		return nil, errgo.Newf("caveat has unsupported version %d", caveat[0])
 	}
@@ -231,7 +245,7 @@ func decodeCaveat(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error) {
 
 // decodeCaveatV1 attempts to decode a base64 encoded JSON id. This
 // encoding is nominally version -1.
-func decodeCaveatV1(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error) {
+func decodeCaveatV1(ctx context.Context, key KeyOpener, caveat []byte) (*ThirdPartyCaveatInfo, error) {
 	data := make([]byte, (3*len(caveat)+3)/4)
 	n, err := base64.StdEncoding.Decode(data, caveat)
 	if err != nil {
@@ -242,8 +256,8 @@ func decodeCaveatV1(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error)
 	if err := json.Unmarshal(data, &wrapper); err != nil {
 		return nil, errgo.Notef(err, "cannot unmarshal caveat %q", data)
 	}
-	if !bytes.Equal(key.Public.Key[:], wrapper.ThirdPartyPublicKey.Key[:]) {
-		return nil, errgo.New("public key mismatch")
+	if wrapper.ThirdPartyPublicKey == nil || !bytes.Equal(key.PublicKey().Key[:], wrapper.ThirdPartyPublicKey.Key[:]) {
+		return nil, errgo.WithCausef(nil, errPublicKeyMismatch, "")
 	}
 	if wrapper.FirstPartyPublicKey == nil {
 		return nil, errgo.New("target service public key not specified")
@@ -257,9 +271,12 @@ func decodeCaveatV1(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error)
 	if copy(nonce[:], wrapper.Nonce) < NonceLen {
 		return nil, errgo.Newf("nonce too short %x", wrapper.Nonce)
 	}
-	c, ok := box.Open(nil, secret, &nonce, wrapper.FirstPartyPublicKey.boxKey(), key.Private.boxKey())
-	if !ok {
-		return nil, errgo.Newf("cannot decrypt caveat %#v", wrapper)
+	c, err := key.OpenBox(ctx, wrapper.FirstPartyPublicKey, &nonce, secret)
+	if err != nil {
+		if errgo.Cause(err) == ErrCannotOpenBox {
+			return nil, errgo.Newf("cannot decrypt caveat %#v", wrapper)
+		}
+		return nil, errgo.Notef(err, "cannot decrypt caveat")
 	}
 	var record caveatRecord
 	if err := json.Unmarshal(c, &record); err != nil {
@@ -268,7 +285,8 @@ func decodeCaveatV1(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error)
 	return &ThirdPartyCaveatInfo{
 		Condition:           []byte(record.Condition),
 		FirstPartyPublicKey: *wrapper.FirstPartyPublicKey,
-		ThirdPartyKeyPair:   *key,
+		ThirdPartyPublicKey: *key.PublicKey(),
+		ThirdPartyKeyPair:   keyPairOf(key),
 		RootKey:             record.RootKey,
 		Caveat:              caveat,
 		Version:             Version1,
@@ -277,7 +295,7 @@ func decodeCaveatV1(key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error)
 }
 
 // decodeCaveatV2V3 decodes a version 2 or version 3 caveat.
-func decodeCaveatV2V3(version Version, key *KeyPair, caveat []byte) (*ThirdPartyCaveatInfo, error) {
+func decodeCaveatV2V3(ctx context.Context, version Version, key KeyOpener, caveat []byte) (*ThirdPartyCaveatInfo, error) {
 	origCaveat := caveat
 	if len(caveat) < 1+publicKeyPrefixLen+KeyLen+NonceLen+box.Overhead {
 		return nil, errgo.New("caveat id too short")
@@ -285,8 +303,8 @@ func decodeCaveatV2V3(version Version, key *KeyPair, caveat []byte) (*ThirdParty
 	caveat = caveat[1:] // skip version (already checked)
 
 	publicKeyPrefix, caveat := caveat[:publicKeyPrefixLen], caveat[publicKeyPrefixLen:]
-	if !bytes.Equal(key.Public.Key[:publicKeyPrefixLen], publicKeyPrefix) {
-		return nil, errgo.New("public key mismatch")
+	if !bytes.Equal(key.PublicKey().Key[:publicKeyPrefixLen], publicKeyPrefix) {
+		return nil, errgo.WithCausef(nil, errPublicKeyMismatch, "")
 	}
 
 	var firstPartyPub PublicKey
@@ -297,9 +315,12 @@ func decodeCaveatV2V3(version Version, key *KeyPair, caveat []byte) (*ThirdParty
 	copy(nonce[:], caveat[:NonceLen])
 	caveat = caveat[NonceLen:]
 
-	data, ok := box.Open(nil, caveat, &nonce, firstPartyPub.boxKey(), key.Private.boxKey())
-	if !ok {
-		return nil, errgo.Newf("cannot decrypt caveat id")
+	data, err := key.OpenBox(ctx, &firstPartyPub, &nonce, caveat)
+	if err != nil {
+		if errgo.Cause(err) == ErrCannotOpenBox {
+			return nil, errgo.Newf("cannot decrypt caveat id")
+		}
+		return nil, errgo.Notef(err, "cannot decrypt caveat id")
 	}
 	rootKey, ns, condition, err := decodeSecretPartV2V3(version, data)
 	if err != nil {
@@ -308,7 +329,8 @@ func decodeCaveatV2V3(version Version, key *KeyPair, caveat []byte) (*ThirdParty
 	return &ThirdPartyCaveatInfo{
 		Condition:           condition,
 		FirstPartyPublicKey: firstPartyPub,
-		ThirdPartyKeyPair:   *key,
+		ThirdPartyPublicKey: *key.PublicKey(),
+		ThirdPartyKeyPair:   keyPairOf(key),
 		RootKey:             rootKey,
 		Caveat:              origCaveat,
 		Version:             version,
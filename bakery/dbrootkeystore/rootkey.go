@@ -27,6 +27,7 @@ const maxPolicyCache = 100
 type RootKeys struct {
 	maxCacheSize int
 	clock        Clock
+	metrics      bakery.Metrics
 
 	// TODO (rogpeppe) use RWMutex instead of Mutex here so that
 	// it's faster in the probably-common case that we
@@ -155,14 +156,39 @@ func (rk RootKey) IsValidWithPolicy(p Policy, now time.Time) bool {
 // If clock is non-nil, it will be used to find the current
 // time, otherwise time.Now will be used.
 func NewRootKeys(maxCacheSize int, clock Clock) *RootKeys {
-	if clock == nil {
-		clock = wallClock{}
+	return NewRootKeysWithParams(RootKeysParams{
+		MaxCacheSize: maxCacheSize,
+		Clock:        clock,
+	})
+}
+
+// RootKeysParams holds parameters for NewRootKeysWithParams.
+type RootKeysParams struct {
+	// MaxCacheSize holds the approximate maximum number
+	// of keys held in the cache.
+	MaxCacheSize int
+
+	// Clock is used to find the current time. If it is nil,
+	// time.Now will be used.
+	Clock Clock
+
+	// Metrics is used to record the bakery.MetricRootKeyCache
+	// metric. If it is nil, no metrics will be recorded.
+	Metrics bakery.Metrics
+}
+
+// NewRootKeysWithParams is like NewRootKeys but takes
+// its parameters in a RootKeysParams value.
+func NewRootKeysWithParams(p RootKeysParams) *RootKeys {
+	if p.Clock == nil {
+		p.Clock = wallClock{}
 	}
 	return &RootKeys{
-		maxCacheSize: maxCacheSize,
+		maxCacheSize: p.MaxCacheSize,
 		cache:        make(map[string]RootKey),
 		current:      make(map[Policy]RootKey),
-		clock:        clock,
+		clock:        p.Clock,
+		metrics:      p.Metrics,
 	}
 }
 
@@ -205,46 +231,77 @@ func (s *RootKeys) NewStore(b Backing, policy Policy) bakery.RootKeyStore {
 	}
 }
 
+// Results of root key cache lookups, used as the "result" label
+// of the bakery.MetricRootKeyCache metric.
+const (
+	// cacheHit is recorded when a key is found in the cache.
+	cacheHit = "hit"
+
+	// cacheOldHit is recorded when a key is found in the
+	// previous generation of the cache, from which it
+	// is moved back into the cache.
+	cacheOldHit = "old_hit"
+
+	// cacheMiss is recorded when a key is not in the cache.
+	cacheMiss = "miss"
+)
+
 // get gets the root key for the given id, trying the cache first and
-// falling back to calling fallback if it's not found there.
+// falling back to calling fallback if it's not found there. It also
+// returns the result of the cache lookup, which should be recorded
+// with countCache once s.mu has been unlocked.
 //
 // If the key does not exist or has expired, it returns
 // bakery.ErrNotFound.
 //
 // Called with s.mu locked.
-func (s *RootKeys) get(ctx context.Context, id []byte, b ContextBacking) (RootKey, error) {
-	key, cached, err := s.get0(ctx, id, b)
+func (s *RootKeys) get(ctx context.Context, id []byte, b ContextBacking) (RootKey, string, error) {
+	key, result, err := s.get0(ctx, id, b)
 	if err != nil && err != bakery.ErrNotFound {
-		return RootKey{}, errgo.Mask(err)
+		return RootKey{}, result, errgo.Mask(err)
 	}
 	if err == nil && s.clock.Now().After(key.Expires) {
 		key = RootKey{}
 		err = bakery.ErrNotFound
 	}
-	if !cached {
+	if result != cacheHit {
 		s.addCache(id, key)
 	}
-	return key, err
+	return key, result, err
 }
 
-// get0 is the inner version of RootKeys.get. It returns an item and reports
-// whether it was found in the cache, but doesn't check whether the
+// get0 is the inner version of RootKeys.get. It returns an item and the
+// result of looking it up in the cache, but doesn't check whether the
 // item has expired or move the returned item to s.cache.
-func (s *RootKeys) get0(ctx context.Context, id []byte, b ContextBacking) (key RootKey, inCache bool, err error) {
+func (s *RootKeys) get0(ctx context.Context, id []byte, b ContextBacking) (key RootKey, result string, err error) {
 	if k, ok := s.cache[string(id)]; ok {
 		if !k.IsValid() {
-			return RootKey{}, true, bakery.ErrNotFound
+			return RootKey{}, cacheHit, bakery.ErrNotFound
 		}
-		return k, true, nil
+		return k, cacheHit, nil
 	}
 	if k, ok := s.oldCache[string(id)]; ok {
 		if !k.IsValid() {
-			return RootKey{}, false, bakery.ErrNotFound
+			return RootKey{}, cacheOldHit, bakery.ErrNotFound
 		}
-		return k, false, nil
+		return k, cacheOldHit, nil
 	}
 	k, err := b.GetKeyContext(ctx, id)
-	return k, false, err
+	return k, cacheMiss, err
+}
+
+// countCache increments the bakery.MetricRootKeyCache
+// metric with the given labels. It must not be called
+// with s.mu locked, because the metrics implementation
+// may block.
+func (s *RootKeys) countCache(method, result string) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.IncCounter(bakery.MetricRootKeyCache, map[string]string{
+		"method": method,
+		"result": result,
+	})
 }
 
 // addCache adds the given key to the cache.
@@ -282,9 +339,9 @@ type store struct {
 // Get implements bakery.RootKeyStore.Get.
 func (s *store) Get(ctx context.Context, id []byte) ([]byte, error) {
 	s.keys.mu.Lock()
-	defer s.keys.mu.Unlock()
-
-	key, err := s.keys.get(ctx, id, s.backing)
+	key, result, err := s.keys.get(ctx, id, s.backing)
+	s.keys.mu.Unlock()
+	s.keys.countCache("get", result)
 	if err != nil {
 		return nil, err
 	}
@@ -296,8 +353,10 @@ func (s *store) Get(ctx context.Context, id []byte) ([]byte, error) {
 // with the current policy.
 func (s *store) RootKey(ctx context.Context) ([]byte, []byte, error) {
 	if key := s.rootKeyFromCache(); key.IsValid() {
+		s.keys.countCache("root_key", cacheHit)
 		return key.RootKey, key.Id, nil
 	}
+	s.keys.countCache("root_key", cacheMiss)
 	// Try to find a root key from the collection.
 	// It doesn't matter much if two concurrent mongo
 	// clients are doing this at the same time because
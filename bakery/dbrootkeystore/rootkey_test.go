@@ -2,6 +2,7 @@ package dbrootkeystore_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
 	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/dbrootkeystore"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/prommetrics"
 )
 
 var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -531,3 +533,119 @@ func stoppedClock(t time.Time) dbrootkeystore.Clock {
 		return t
 	})
 }
+
+func TestCacheMetrics(t *testing.T) {
+	c := qt.New(t)
+	metrics := prommetrics.New(prommetrics.Params{})
+	keys := dbrootkeystore.NewRootKeysWithParams(dbrootkeystore.RootKeysParams{
+		MaxCacheSize: 5,
+		Clock:        clockVal(&epoch),
+		Metrics:      metrics,
+	})
+	store := keys.NewStore(memBacking{}, dbrootkeystore.Policy{
+		ExpiryDuration: 5 * time.Minute,
+	})
+	_, id, err := store.RootKey(context.Background())
+	c.Assert(err, qt.IsNil)
+	_, _, err = store.RootKey(context.Background())
+	c.Assert(err, qt.IsNil)
+	_, err = store.Get(context.Background(), id)
+	c.Assert(err, qt.IsNil)
+	_, err = store.Get(context.Background(), []byte("unknown"))
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+
+	var buf strings.Builder
+	metrics.WriteTo(&buf)
+	c.Assert(buf.String(), qt.Equals, `# TYPE bakery_root_key_cache_total counter
+bakery_root_key_cache_total{method="get",result="hit"} 1
+bakery_root_key_cache_total{method="get",result="miss"} 1
+bakery_root_key_cache_total{method="root_key",result="hit"} 1
+bakery_root_key_cache_total{method="root_key",result="miss"} 1
+`)
+}
+
+func TestCacheMetricsOldGeneration(t *testing.T) {
+	c := qt.New(t)
+	metrics := prommetrics.New(prommetrics.Params{})
+	keys := dbrootkeystore.NewRootKeysWithParams(dbrootkeystore.RootKeysParams{
+		MaxCacheSize: 2,
+		Clock:        clockVal(&epoch),
+		Metrics:      metrics,
+	})
+	store := keys.NewStore(memBacking{}, dbrootkeystore.Policy{
+		ExpiryDuration: 5 * time.Minute,
+	})
+	_, id, err := store.RootKey(context.Background())
+	c.Assert(err, qt.IsNil)
+	// Looking up other ids fills the cache, so that the key
+	// moves to the old generation.
+	for _, other := range []string{"other1", "other2"} {
+		_, err = store.Get(context.Background(), []byte(other))
+		c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+	}
+	// The first lookup finds the key in the old generation and
+	// moves it back into the cache, where the second finds it.
+	for i := 0; i < 2; i++ {
+		_, err = store.Get(context.Background(), id)
+		c.Assert(err, qt.IsNil)
+	}
+
+	var buf strings.Builder
+	metrics.WriteTo(&buf)
+	c.Assert(buf.String(), qt.Equals, `# TYPE bakery_root_key_cache_total counter
+bakery_root_key_cache_total{method="get",result="hit"} 1
+bakery_root_key_cache_total{method="get",result="miss"} 2
+bakery_root_key_cache_total{method="get",result="old_hit"} 1
+bakery_root_key_cache_total{method="root_key",result="miss"} 1
+`)
+}
+
+func TestCacheMetricsCalledWithoutLock(t *testing.T) {
+	c := qt.New(t)
+	var store bakery.RootKeyStore
+	var nested bool
+	metrics := metricsFunc(func() {
+		if nested {
+			return
+		}
+		nested = true
+		defer func() {
+			nested = false
+		}()
+		// Using the store from the metrics implementation
+		// would deadlock if its lock were held.
+		_, err := store.Get(context.Background(), []byte("unknown"))
+		c.Check(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+	})
+	keys := dbrootkeystore.NewRootKeysWithParams(dbrootkeystore.RootKeysParams{
+		MaxCacheSize: 5,
+		Clock:        clockVal(&epoch),
+		Metrics:      metrics,
+	})
+	store = keys.NewStore(memBacking{}, dbrootkeystore.Policy{
+		ExpiryDuration: 5 * time.Minute,
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, id, err := store.RootKey(context.Background())
+		c.Check(err, qt.IsNil)
+		_, err = store.Get(context.Background(), id)
+		c.Check(err, qt.IsNil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("deadlock recording metrics")
+	}
+}
+
+// metricsFunc implements bakery.Metrics by calling
+// the function for each counter increment.
+type metricsFunc func()
+
+func (f metricsFunc) IncCounter(string, map[string]string) {
+	f()
+}
+
+func (f metricsFunc) ObserveDuration(string, map[string]string, time.Duration) {}
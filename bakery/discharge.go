@@ -84,6 +84,19 @@ type DischargeParams struct {
 	// third party caveats returned by the caveat checker.
 	Key *KeyPair
 
+	// KeyOpener may be set instead of Key when the private
+	// key is not held in memory. If both are set, KeyOpener
+	// is used.
+	KeyOpener KeyOpener
+
+	// Keys may be set instead of Key or KeyOpener when the
+	// discharger has several keys, for example during key
+	// rotation. The caveat is decrypted with whichever key in
+	// the set it was encrypted for, and the primary key is used
+	// to encrypt any additional third party caveats. If Keys
+	// is set, Key and KeyOpener are ignored.
+	Keys *KeySet
+
 	// Checker is used to check the third party caveat,
 	// and may also return further caveats to be added to
 	// the discharge macaroon.
@@ -113,7 +126,17 @@ func Discharge(ctx context.Context, p DischargeParams) (*Macaroon, error) {
 		// for any more ids.
 		caveatIdPrefix = p.Id
 	}
-	cavInfo, err := decodeCaveat(p.Key, p.Caveat)
+	keys := p.Keys
+	switch {
+	case keys != nil:
+	case p.KeyOpener != nil:
+		keys = keySetOf(p.KeyOpener)
+	case p.Key != nil:
+		keys = keySetOf(p.Key)
+	default:
+		return nil, errgo.Newf("no key provided to discharge caveat")
+	}
+	cavInfo, err := keys.decodeCaveat(ctx, p.Caveat)
 	if err != nil {
 		return nil, errgo.Notef(err, "discharger cannot decode caveat id")
 	}
@@ -143,7 +166,7 @@ func Discharge(ctx context.Context, p DischargeParams) (*Macaroon, error) {
 	}
 	m.caveatIdPrefix = caveatIdPrefix
 	for _, cav := range caveats {
-		if err := m.AddCaveat(ctx, cav, p.Key, p.Locator); err != nil {
+		if err := m.addCaveat(ctx, cav, keys.Primary, p.Locator); err != nil {
 			return nil, errgo.Notef(err, "could not add caveat")
 		}
 	}
@@ -218,8 +241,17 @@ type ThirdPartyCaveatInfo struct {
 	// that created the third party caveat.
 	FirstPartyPublicKey PublicKey
 
+	// ThirdPartyPublicKey holds the public key of the
+	// discharging service that was used to decrypt the caveat.
+	ThirdPartyPublicKey PublicKey
+
 	// ThirdPartyKeyPair holds the key pair used to decrypt
 	// the caveat - the key pair of the discharging service.
+	// It is only set when the caveat was decrypted with a
+	// *KeyPair; when another KeyOpener (for example
+	// a keyserver client) was used, the private key is not
+	// available and ThirdPartyKeyPair holds the zero KeyPair.
+	// Use ThirdPartyPublicKey to find out which key was used.
 	ThirdPartyKeyPair KeyPair
 
 	// RootKey holds the secret root key encoded by the caveat.
@@ -48,6 +48,22 @@ func DischargeAllWithKey(
 	return discharges.Bind(), nil
 }
 
+// DischargeAllWithKeyOpener is like DischargeAllWithKey except that
+// the local key is held by a KeyOpener, so the client's private key
+// need not be held in memory.
+func DischargeAllWithKeyOpener(
+	ctx context.Context,
+	m *Macaroon,
+	getDischarge func(ctx context.Context, cav macaroon.Caveat, encodedCaveat []byte) (*Macaroon, error),
+	localKey KeyOpener,
+) (macaroon.Slice, error) {
+	discharges, err := Slice{m}.DischargeAllWithKeyOpener(ctx, getDischarge, localKey)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return discharges.Bind(), nil
+}
+
 var localDischargeChecker = ThirdPartyCaveatCheckerFunc(func(_ context.Context, info *ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
 	if string(info.Condition) != "true" {
 		return nil, checkers.ErrCaveatNotRecognized
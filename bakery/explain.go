@@ -0,0 +1,166 @@
+package bakery
+
+import (
+	"context"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+// Explanation describes how an authorization decision was made by
+// AuthChecker.Explain. It is intended to help debug why access was
+// denied; its contents should not be shown to untrusted clients
+// because they may reveal details of the authorization policy.
+type Explanation struct {
+	// Ops holds the operations that were requested.
+	Ops []Op
+
+	// Macaroons holds an explanation for each of the macaroon
+	// slices passed to Checker.Auth, in the same order.
+	Macaroons []MacaroonExplanation
+
+	// Decisions holds the calls made to the OpsAuthorizer, in order.
+	Decisions []OpsAuthorizerDecision
+
+	// Unauthorized holds the requested operations
+	// that were not authorized.
+	Unauthorized []Op
+
+	// Error holds the text of the error returned by the check,
+	// or the empty string if there was none.
+	Error string
+}
+
+// MacaroonExplanation describes how a macaroon was
+// used in an authorization decision.
+type MacaroonExplanation struct {
+	// Id holds the id of the primary macaroon.
+	Id []byte
+
+	// Verified holds whether the macaroon's signature and root key
+	// were verified.
+	Verified bool
+
+	// VerifyError holds the reason that the macaroon could not be
+	// verified, if it was not.
+	VerifyError string
+
+	// Ops holds the operations granted by the macaroon
+	// if it was verified.
+	Ops []Op
+
+	// Caveats holds the result of checking each of the first
+	// party caveats in the macaroon, in order. Checking stops
+	// at the first caveat that is not satisfied, so any
+	// caveats after that one are not included.
+	Caveats []CaveatExplanation
+
+	// Used holds whether the macaroon was used to
+	// authorize any of the operations.
+	Used bool
+}
+
+// CaveatExplanation holds the result of checking a first party caveat.
+type CaveatExplanation struct {
+	// Condition holds the caveat condition.
+	Condition string
+
+	// Error holds the reason that the caveat was not satisfied,
+	// or the empty string if it was.
+	Error string
+}
+
+// OpsAuthorizerDecision records a call to OpsAuthorizer.AuthorizeOps.
+type OpsAuthorizerDecision struct {
+	// AuthorizedOp holds the already-authorized operation that
+	// was passed to AuthorizeOps. This is NoOp for the final call
+	// that checks whether operations are authorized regardless of
+	// authority; in identchecker, this is the call that consults
+	// the Authorizer.
+	AuthorizedOp Op
+
+	// MacaroonIndex holds the index of the macaroon that authorized
+	// AuthorizedOp, or -1 when AuthorizedOp is NoOp.
+	MacaroonIndex int
+
+	// QueryOps holds the operations that were queried.
+	QueryOps []Op
+
+	// Allowed holds the result for each element of QueryOps.
+	Allowed []bool
+
+	// Caveats holds any caveats that were returned.
+	Caveats []checkers.Caveat
+
+	// Error holds the text of any error returned.
+	Error string
+}
+
+// Explain is like Allow except that it also returns an explanation of
+// the authorization decision, which is returned even when Allow
+// would return an error.
+func (a *AuthChecker) Explain(ctx context.Context, ops ...Op) (*AuthInfo, *Explanation, error) {
+	expl := &Explanation{
+		Ops: append([]Op(nil), ops...),
+	}
+	info, err := a.allow(ctx, ops, expl)
+	if err != nil {
+		expl.Error = err.Error()
+	}
+	return info, expl, err
+}
+
+// explain fills out expl from the state of the allow context
+// at the end of an authorization decision.
+func (a *allowContext) explain(expl *Explanation) {
+	c := a.checker
+	expl.Unauthorized = append([]Op(nil), a.need...)
+	expl.Macaroons = make([]MacaroonExplanation, len(c.macaroons))
+	for i, ms := range c.macaroons {
+		mexpl := &expl.Macaroons[i]
+		if len(ms) > 0 {
+			mexpl.Id = ms[0].Id()
+		}
+		if c.initError != nil {
+			// The macaroons could not be verified at all.
+			continue
+		}
+		if c.verifyErrors[i] != nil {
+			mexpl.VerifyError = c.verifyErrors[i].Error()
+			continue
+		}
+		mexpl.Verified = true
+		mexpl.Ops = c.macaroonOps[i]
+		mexpl.Caveats = a.caveatExplanations[i]
+		mexpl.Used = a.status[i]&statusUsed != 0
+	}
+}
+
+// explainCaveat records the result of checking
+// a first party caveat of the i'th macaroon.
+func (a *allowContext) explainCaveat(i int, cond string, err error) {
+	cav := CaveatExplanation{
+		Condition: cond,
+	}
+	if err != nil {
+		cav.Error = err.Error()
+	}
+	a.caveatExplanations[i] = append(a.caveatExplanations[i], cav)
+}
+
+// explainDecision records a call to the OpsAuthorizer.
+func (a *allowContext) explainDecision(authorizedOp Op, mindex int, queryOps []Op, allowed []bool, caveats []checkers.Caveat, err error) {
+	if a.explanation == nil {
+		return
+	}
+	d := OpsAuthorizerDecision{
+		AuthorizedOp:  authorizedOp,
+		MacaroonIndex: mindex,
+		QueryOps:      append([]Op(nil), queryOps...),
+		Allowed:       append([]bool(nil), allowed...),
+		Caveats:       caveats,
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	a.explanation.Decisions = append(a.explanation.Decisions, d)
+}
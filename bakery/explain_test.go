@@ -0,0 +1,138 @@
+package bakery_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+func TestExplain(t *testing.T) {
+	c := qt.New(t)
+	store := newMacaroonStore(nil)
+	ts := &service{
+		checker: bakery.NewChecker(bakery.CheckerParams{
+			Checker:          testChecker,
+			OpsAuthorizer:    hierarchicalOpsAuthorizer{},
+			MacaroonVerifier: store,
+		}),
+		store: store,
+	}
+	// A macaroon from another service, which cannot be verified.
+	m0 := newService(nil).newMacaroon(readOp("e1"))
+	// A macaroon with a caveat that is not satisfied.
+	m1 := ts.newMacaroon(readOp("e1"))
+	m1[0].AddFirstPartyCaveat([]byte("invalid"))
+	// A macaroon that authorizes operations through the OpsAuthorizer.
+	bobOp := bakery.Op{
+		Entity: "path-/user/bob",
+		Action: "*",
+	}
+	m2 := ts.newMacaroon(bobOp)
+
+	ai, expl, err := ts.checker.Auth(m0, m1, m2).Explain(testContext, writeOp("path-/user/bob/foo"), readOp("e1"))
+	// The first verification error is returned.
+	c.Assert(err, qt.ErrorMatches, `verification failed: signature mismatch after caveat verification`)
+	c.Assert(ai, qt.IsNil)
+	c.Assert(expl, qt.DeepEquals, &bakery.Explanation{
+		Ops: []bakery.Op{writeOp("path-/user/bob/foo"), readOp("e1")},
+		Macaroons: []bakery.MacaroonExplanation{{
+			Id:          m0[0].Id(),
+			VerifyError: `verification failed: signature mismatch after caveat verification`,
+		}, {
+			Id:       m1[0].Id(),
+			Verified: true,
+			Ops:      []bakery.Op{readOp("e1")},
+			Caveats: []bakery.CaveatExplanation{{
+				Condition: "invalid",
+				Error:     `caveat "invalid" not satisfied: caveat not recognized`,
+			}},
+		}, {
+			Id:       m2[0].Id(),
+			Verified: true,
+			Ops:      []bakery.Op{bobOp},
+			Caveats:  []bakery.CaveatExplanation{},
+			Used:     true,
+		}},
+		Decisions: []bakery.OpsAuthorizerDecision{{
+			AuthorizedOp:  bobOp,
+			MacaroonIndex: 2,
+			QueryOps:      []bakery.Op{writeOp("path-/user/bob/foo"), readOp("e1")},
+			Allowed:       []bool{true, false},
+		}, {
+			AuthorizedOp:  bakery.NoOp,
+			MacaroonIndex: -1,
+			QueryOps:      []bakery.Op{readOp("e1")},
+			Allowed:       []bool{false},
+		}},
+		Unauthorized: []bakery.Op{readOp("e1")},
+		Error:        `verification failed: signature mismatch after caveat verification`,
+	})
+
+	// Check that the explanation agrees with Allow when
+	// the operations are allowed.
+	ai, expl, err = ts.checker.Auth(m2).Explain(testContext, writeOp("path-/user/bob/foo"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(ai.Used, qt.DeepEquals, []bool{true})
+	c.Assert(expl.Unauthorized, qt.HasLen, 0)
+	c.Assert(expl.Error, qt.Equals, "")
+	c.Assert(expl.Macaroons[0].Used, qt.Equals, true)
+}
+
+func TestExplainWithNoMacaroons(t *testing.T) {
+	c := qt.New(t)
+	ts := newService(nil)
+	_, expl, err := ts.checker.Auth([]macaroon.Slice{}...).Explain(testContext, readOp("e1"))
+	c.Assert(err, qt.ErrorMatches, `permission denied`)
+	c.Assert(expl, qt.DeepEquals, &bakery.Explanation{
+		Ops:          []bakery.Op{readOp("e1")},
+		Macaroons:    []bakery.MacaroonExplanation{},
+		Unauthorized: []bakery.Op{readOp("e1")},
+		Error:        "permission denied",
+	})
+}
+
+func TestExplainChecksCaveatsOnce(t *testing.T) {
+	c := qt.New(t)
+	calls := make(map[string]int)
+	checker := checkers.New(nil)
+	checker.Namespace().Register("testns", "")
+	checker.Register("count", "testns", func(ctx context.Context, cond, arg string) error {
+		calls[arg]++
+		if arg == "bad" {
+			return errgo.New("bad argument")
+		}
+		return nil
+	})
+	store := newMacaroonStore(nil)
+	ts := &service{
+		checker: bakery.NewChecker(bakery.CheckerParams{
+			Checker:          checker,
+			MacaroonVerifier: store,
+		}),
+		store: store,
+	}
+	m := ts.newMacaroon(readOp("e1"))
+	for _, arg := range []string{"good", "bad", "unchecked"} {
+		m[0].AddFirstPartyCaveat([]byte("count " + arg))
+	}
+	_, expl, err := ts.checker.Auth(m).Explain(testContext, readOp("e1"))
+	c.Assert(err, qt.ErrorMatches, `caveat "count bad" not satisfied: bad argument`)
+	// Caveats after the first unsatisfied one are not checked,
+	// so they are not included in the explanation.
+	c.Assert(expl.Macaroons[0].Caveats, qt.DeepEquals, []bakery.CaveatExplanation{{
+		Condition: "count good",
+	}, {
+		Condition: "count bad",
+		Error:     `caveat "count bad" not satisfied: bad argument`,
+	}})
+	c.Assert(calls, qt.DeepEquals, map[string]int{
+		"good": 1,
+		"bad":  1,
+	})
+}
@@ -0,0 +1,143 @@
+package bakery
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/reloadfile"
+)
+
+// DefaultFileLocatorReloadInterval holds the default interval
+// at which FileLocator checks its file for changes.
+const DefaultFileLocatorReloadInterval = 10 * time.Second
+
+// FileLocatorParams holds parameters for NewFileLocator.
+type FileLocatorParams struct {
+	// Path holds the path of the file to read. See FileLocator
+	// for the file format.
+	Path string
+
+	// ReloadInterval holds the minimum interval between checks for
+	// changes to the file. If it is zero,
+	// DefaultFileLocatorReloadInterval will be used. If it is
+	// negative, the file will only be read again when Reload is
+	// called.
+	ReloadInterval time.Duration
+
+	// Logger is used to log failures to reload the file.
+	// If it is nil, nothing will be logged.
+	Logger Logger
+}
+
+// FileLocator is a ThirdPartyLocator that reads information on third
+// parties from a YAML or JSON file. The file holds a list of
+// dischargers, for example:
+//
+//	dischargers:
+//	- location: https://discharger.example.com
+//	  public-key: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+//	  version: 3
+//
+// If the version is omitted, LatestVersion is assumed. As with
+// ThirdPartyStore, a trailing slash on locations is ignored.
+//
+// The file is checked for changes as it is used, at most once per
+// reload interval, so changes take effect without a restart. If the
+// changed file cannot be read or is invalid, the previous information
+// continues to be used.
+type FileLocator struct {
+	path   string
+	logger Logger
+	file   *reloadfile.File
+}
+
+var _ ThirdPartyLocator = (*FileLocator)(nil)
+
+// locatorFile defines the format of a FileLocator file.
+type locatorFile struct {
+	Dischargers []locatorFileEntry `yaml:"dischargers"`
+}
+
+type locatorFileEntry struct {
+	Location  string     `yaml:"location"`
+	PublicKey *PublicKey `yaml:"public-key"`
+	Version   *Version   `yaml:"version"`
+}
+
+// NewFileLocator returns a new FileLocator that reads the file
+// specified in p. It returns an error if the file cannot be read or
+// is invalid.
+func NewFileLocator(p FileLocatorParams) (*FileLocator, error) {
+	if p.ReloadInterval == 0 {
+		p.ReloadInterval = DefaultFileLocatorReloadInterval
+	}
+	file, err := reloadfile.New(p.Path, p.ReloadInterval, func(data []byte) (interface{}, error) {
+		return parseLocatorFile(data)
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &FileLocator{
+		path:   p.Path,
+		logger: p.Logger,
+		file:   file,
+	}, nil
+}
+
+// Reload reads the file again. If the file cannot be read
+// or is invalid, it returns an error and the previous
+// information continues to be used.
+func (l *FileLocator) Reload() error {
+	return errgo.Mask(l.file.Reload())
+}
+
+// ThirdPartyInfo implements ThirdPartyLocator.ThirdPartyInfo.
+func (l *FileLocator) ThirdPartyInfo(ctx context.Context, loc string) (ThirdPartyInfo, error) {
+	store, err := l.file.Value()
+	if err != nil {
+		Log(ctx, l.logger, LevelWarn, "cannot reload third party information", Field("path", l.path), ErrorField(err))
+	}
+	info, err := store.(*ThirdPartyStore).ThirdPartyInfo(ctx, loc)
+	if err != nil {
+		return ThirdPartyInfo{}, errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	return info, nil
+}
+
+// parseLocatorFile parses the contents of a FileLocator file.
+func parseLocatorFile(data []byte) (*ThirdPartyStore, error) {
+	var f locatorFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	store := NewThirdPartyStore()
+	seen := make(map[string]bool)
+	for i, d := range f.Dischargers {
+		if d.Location == "" {
+			return nil, errgo.Newf("discharger %d has no location", i)
+		}
+		loc := canonicalLocation(d.Location)
+		if seen[loc] {
+			return nil, errgo.Newf("duplicate discharger location %q", d.Location)
+		}
+		seen[loc] = true
+		if d.PublicKey == nil {
+			return nil, errgo.Newf("no public key for discharger %q", d.Location)
+		}
+		info := ThirdPartyInfo{
+			PublicKey: *d.PublicKey,
+			Version:   LatestVersion,
+		}
+		if d.Version != nil {
+			if *d.Version < Version0 || *d.Version > LatestVersion {
+				return nil, errgo.Newf("invalid version %d for discharger %q", *d.Version, d.Location)
+			}
+			info.Version = *d.Version
+		}
+		store.AddInfo(loc, info)
+	}
+	return store, nil
+}
@@ -0,0 +1,213 @@
+package bakery
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+// AllActions may be used in HierarchyParams.ImpliedActions to
+// specify that an action implies every other action.
+const AllActions = "*"
+
+// HierarchyParams holds parameters for NewHierarchicalOpsAuthorizer.
+type HierarchyParams struct {
+	// ImpliedActions maps each action to the actions that it
+	// directly implies on the same entity. Implication is
+	// transitive, so if "admin" implies "write" and "write"
+	// implies "read", then "admin" also implies "read". An action
+	// that implies AllActions implies every action.
+	ImpliedActions map[string][]string
+
+	// InheritedActions holds the actions that, when authorized on
+	// an entity, are also authorized on all of its descendants,
+	// together with the actions they imply. For example, if
+	// "admin" is inherited and implies AllActions, then "admin" on
+	// "org/foo" authorizes every action on "org/foo/model/bar".
+	InheritedActions []string
+
+	// Separator holds the separator between the path elements of
+	// entity names. An entity is a descendant of another when its
+	// name starts with the other's name followed by the separator.
+	// If it is empty, "/" will be used.
+	Separator string
+
+	// Fallback, if non-nil, is consulted for any query operations
+	// that are not authorized by the hierarchy.
+	Fallback OpsAuthorizer
+}
+
+// OpsAuthorizer returns a HierarchicalOpsAuthorizer created from p,
+// using the given fallback if p.Fallback is nil. If p is nil, it
+// returns fallback itself. It is used to implement the OpsHierarchy
+// field of BakeryParams and identchecker.BakeryParams.
+func (p *HierarchyParams) OpsAuthorizer(fallback OpsAuthorizer) OpsAuthorizer {
+	if p == nil {
+		return fallback
+	}
+	hp := *p
+	if hp.Fallback == nil {
+		hp.Fallback = fallback
+	}
+	return NewHierarchicalOpsAuthorizer(hp)
+}
+
+// HierarchicalOpsAuthorizer is an OpsAuthorizer that authorizes
+// operations implied by an authorized operation, either because the
+// authorized action implies the queried action, or because the
+// queried entity is a descendant of the authorized entity and an
+// inherited action applies.
+//
+// The implied actions are computed when the authorizer is created,
+// so each query costs time proportional only to the number of query
+// operations.
+type HierarchicalOpsAuthorizer struct {
+	separator string
+	fallback  OpsAuthorizer
+
+	// same maps each action to the actions it authorizes
+	// on the same entity.
+	same map[string]actionSet
+
+	// descendant maps each action to the actions it
+	// authorizes on descendant entities.
+	descendant map[string]actionSet
+
+	// inherited holds the set of inherited actions.
+	inherited actionSet
+}
+
+var _ OpsAuthorizer = (*HierarchicalOpsAuthorizer)(nil)
+
+// actionSet holds a set of actions. If it contains AllActions,
+// it contains every action.
+type actionSet map[string]bool
+
+func (s actionSet) contains(action string) bool {
+	return s[action] || s[AllActions]
+}
+
+// NewHierarchicalOpsAuthorizer returns a new HierarchicalOpsAuthorizer
+// using the given parameters.
+func NewHierarchicalOpsAuthorizer(p HierarchyParams) *HierarchicalOpsAuthorizer {
+	if p.Separator == "" {
+		p.Separator = "/"
+	}
+	a := &HierarchicalOpsAuthorizer{
+		separator:  p.Separator,
+		fallback:   p.Fallback,
+		same:       make(map[string]actionSet),
+		descendant: make(map[string]actionSet),
+		inherited:  make(actionSet),
+	}
+	for _, action := range p.InheritedActions {
+		a.inherited[action] = true
+	}
+	actions := make(map[string]bool)
+	for action, implied := range p.ImpliedActions {
+		actions[action] = true
+		for _, action1 := range implied {
+			actions[action1] = true
+		}
+	}
+	for action := range a.inherited {
+		actions[action] = true
+	}
+	for action := range actions {
+		a.same[action] = impliedClosure(p.ImpliedActions, action)
+	}
+	for action, same := range a.same {
+		desc := make(actionSet)
+		for action1 := range same {
+			if !a.inherited.contains(action1) {
+				continue
+			}
+			for action2 := range a.same[action1] {
+				desc[action2] = true
+			}
+		}
+		if len(desc) > 0 {
+			a.descendant[action] = desc
+		}
+	}
+	return a
+}
+
+// impliedClosure returns the set holding the given action
+// and all the actions that it implies, directly or indirectly.
+func impliedClosure(implied map[string][]string, action string) actionSet {
+	set := actionSet{action: true}
+	stack := []string{action}
+	for len(stack) > 0 {
+		a := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, a1 := range implied[a] {
+			if !set[a1] {
+				set[a1] = true
+				stack = append(stack, a1)
+			}
+		}
+	}
+	return set
+}
+
+// AuthorizeOps implements OpsAuthorizer.AuthorizeOps.
+func (a *HierarchicalOpsAuthorizer) AuthorizeOps(ctx context.Context, authorizedOp Op, queryOps []Op) ([]bool, []checkers.Caveat, error) {
+	allowed := make([]bool, len(queryOps))
+	var remaining []Op
+	var remainingIndex []int
+	for i, op := range queryOps {
+		if authorizedOp != NoOp && a.implies(authorizedOp, op) {
+			allowed[i] = true
+			continue
+		}
+		remaining = append(remaining, op)
+		remainingIndex = append(remainingIndex, i)
+	}
+	if a.fallback == nil || len(remaining) == 0 {
+		return allowed, nil, nil
+	}
+	fallbackAllowed, caveats, err := a.fallback.AuthorizeOps(ctx, authorizedOp, remaining)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	for i, ok := range fallbackAllowed {
+		if ok && i < len(remainingIndex) {
+			allowed[remainingIndex[i]] = true
+		}
+	}
+	return allowed, caveats, nil
+}
+
+// implies reports whether the authorized operation
+// implies the query operation.
+func (a *HierarchicalOpsAuthorizer) implies(authorized, query Op) bool {
+	switch {
+	case query.Entity == authorized.Entity:
+		if query.Action == authorized.Action {
+			return true
+		}
+		same := a.same[authorized.Action]
+		return same != nil && same.contains(query.Action)
+	case a.isDescendant(query.Entity, authorized.Entity):
+		desc := a.descendant[authorized.Action]
+		if desc == nil {
+			// The action is not mentioned in the parameters,
+			// so it can only be inherited as itself.
+			return a.inherited[AllActions] && query.Action == authorized.Action
+		}
+		return desc.contains(query.Action)
+	}
+	return false
+}
+
+// isDescendant reports whether entity is a descendant of ancestor.
+func (a *HierarchicalOpsAuthorizer) isDescendant(entity, ancestor string) bool {
+	return ancestor != "" &&
+		len(entity) > len(ancestor)+len(a.separator) &&
+		strings.HasPrefix(entity, ancestor) &&
+		strings.HasPrefix(entity[len(ancestor):], a.separator)
+}
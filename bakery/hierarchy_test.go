@@ -0,0 +1,191 @@
+package bakery_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+var testHierarchy = bakery.HierarchyParams{
+	ImpliedActions: map[string][]string{
+		"admin": {bakery.AllActions},
+		"write": {"read"},
+		"owner": {"write", "share"},
+	},
+	InheritedActions: []string{"admin", "read"},
+}
+
+var hierarchyTests = []struct {
+	about        string
+	authorizedOp bakery.Op
+	queryOps     []bakery.Op
+	expect       []bool
+}{{
+	about:        "same operation",
+	authorizedOp: bakery.Op{"model-x", "frobnicate"},
+	queryOps:     []bakery.Op{{"model-x", "frobnicate"}, {"model-y", "frobnicate"}},
+	expect:       []bool{true, false},
+}, {
+	about:        "directly implied action",
+	authorizedOp: bakery.Op{"model-x", "write"},
+	queryOps:     []bakery.Op{{"model-x", "read"}, {"model-x", "share"}, {"model-y", "read"}},
+	expect:       []bool{true, false, false},
+}, {
+	about:        "transitively implied action",
+	authorizedOp: bakery.Op{"model-x", "owner"},
+	queryOps:     []bakery.Op{{"model-x", "read"}, {"model-x", "share"}, {"model-x", "admin"}},
+	expect:       []bool{true, true, false},
+}, {
+	about:        "admin implies everything",
+	authorizedOp: bakery.Op{"controller", "admin"},
+	queryOps:     []bakery.Op{{"controller", "read"}, {"controller", "anything"}},
+	expect:       []bool{true, true},
+}, {
+	about:        "admin inherited by descendants",
+	authorizedOp: bakery.Op{"org/foo", "admin"},
+	queryOps: []bakery.Op{
+		{"org/foo/model/bar", "write"},
+		{"org/foo/model", "delete"},
+		{"org/foobar", "read"},
+		{"org", "read"},
+		{"org/foo/", "read"},
+	},
+	expect: []bool{true, true, false, false, false},
+}, {
+	about:        "inherited action implied by non-inherited action",
+	authorizedOp: bakery.Op{"org/foo", "write"},
+	queryOps:     []bakery.Op{{"org/foo/model/bar", "read"}, {"org/foo/model/bar", "write"}},
+	expect:       []bool{true, false},
+}, {
+	about:        "non-inherited action",
+	authorizedOp: bakery.Op{"org/foo", "share"},
+	queryOps:     []bakery.Op{{"org/foo/model/bar", "share"}},
+	expect:       []bool{false},
+}, {
+	about:        "NoOp authorizes nothing",
+	authorizedOp: bakery.NoOp,
+	queryOps:     []bakery.Op{{"org/foo", "read"}},
+	expect:       []bool{false},
+}}
+
+func TestHierarchicalOpsAuthorizer(t *testing.T) {
+	c := qt.New(t)
+	a := bakery.NewHierarchicalOpsAuthorizer(testHierarchy)
+	for _, test := range hierarchyTests {
+		c.Run(test.about, func(c *qt.C) {
+			allowed, caveats, err := a.AuthorizeOps(testContext, test.authorizedOp, test.queryOps)
+			c.Assert(err, qt.IsNil)
+			c.Assert(allowed, qt.DeepEquals, test.expect)
+			c.Assert(caveats, qt.HasLen, 0)
+		})
+	}
+}
+
+func TestHierarchicalOpsAuthorizerSeparator(t *testing.T) {
+	c := qt.New(t)
+	a := bakery.NewHierarchicalOpsAuthorizer(bakery.HierarchyParams{
+		InheritedActions: []string{bakery.AllActions},
+		Separator:        ".",
+	})
+	allowed, _, err := a.AuthorizeOps(testContext, bakery.Op{"a.b", "read"}, []bakery.Op{
+		{"a.b.c", "read"},
+		{"a.b.c", "write"},
+		{"a/b/c", "read"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, false, false})
+}
+
+func TestHierarchicalOpsAuthorizerFallback(t *testing.T) {
+	c := qt.New(t)
+	var queried []bakery.Op
+	hp := testHierarchy
+	hp.Fallback = opsAuthorizerFunc(func(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error) {
+		queried = append(queried, queryOps...)
+		allowed := make([]bool, len(queryOps))
+		for i, op := range queryOps {
+			allowed[i] = op.Entity == "public"
+		}
+		return allowed, []checkers.Caveat{{Location: "somewhere", Condition: "c"}}, nil
+	})
+	a := bakery.NewHierarchicalOpsAuthorizer(hp)
+	allowed, caveats, err := a.AuthorizeOps(testContext, bakery.Op{"x", "write"}, []bakery.Op{
+		{"x", "read"},
+		{"public", "read"},
+		{"y", "read"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, true, false})
+	c.Assert(caveats, qt.DeepEquals, []checkers.Caveat{{Location: "somewhere", Condition: "c"}})
+	c.Assert(queried, qt.DeepEquals, []bakery.Op{{"public", "read"}, {"y", "read"}})
+
+	hp.Fallback = opsAuthorizerFunc(func(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error) {
+		return nil, nil, errgo.New("fallback failed")
+	})
+	a = bakery.NewHierarchicalOpsAuthorizer(hp)
+	_, _, err = a.AuthorizeOps(testContext, bakery.Op{"x", "write"}, []bakery.Op{{"y", "read"}})
+	c.Assert(err, qt.ErrorMatches, "fallback failed")
+}
+
+func TestHierarchyParamsOpsAuthorizer(t *testing.T) {
+	c := qt.New(t)
+	fallback := opsAuthorizerFunc(func(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error) {
+		allowed := make([]bool, len(queryOps))
+		for i, op := range queryOps {
+			allowed[i] = op.Entity == "public"
+		}
+		return allowed, nil, nil
+	})
+	queryOps := []bakery.Op{{"x", "read"}, {"public", "read"}}
+
+	// A nil hierarchy uses the fallback alone.
+	var hp *bakery.HierarchyParams
+	allowed, _, err := hp.OpsAuthorizer(fallback).AuthorizeOps(testContext, bakery.Op{"x", "write"}, queryOps)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{false, true})
+
+	// The fallback is used when the hierarchy has none.
+	hp = &bakery.HierarchyParams{
+		ImpliedActions: testHierarchy.ImpliedActions,
+	}
+	allowed, _, err = hp.OpsAuthorizer(fallback).AuthorizeOps(testContext, bakery.Op{"x", "write"}, queryOps)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, true})
+	c.Assert(hp.Fallback, qt.IsNil)
+
+	// The hierarchy's own fallback takes precedence.
+	hp.Fallback = opsAuthorizerFunc(func(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error) {
+		return make([]bool, len(queryOps)), nil, nil
+	})
+	allowed, _, err = hp.OpsAuthorizer(fallback).AuthorizeOps(testContext, bakery.Op{"x", "write"}, queryOps)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, false})
+}
+
+func TestNewWithOpsHierarchy(t *testing.T) {
+	c := qt.New(t)
+	hp := testHierarchy
+	b := bakery.New(bakery.BakeryParams{
+		OpsHierarchy: &hp,
+	})
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, nil, bakery.Op{"org/foo", "admin"})
+	c.Assert(err, qt.IsNil)
+	authInfo, err := b.Checker.Auth(macaroon.Slice{m.M()}).Allow(testContext, bakery.Op{"org/foo/model/bar", "write"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(authInfo.Used, qt.DeepEquals, []bool{true})
+
+	_, err = b.Checker.Auth(macaroon.Slice{m.M()}).Allow(testContext, bakery.Op{"org/other", "read"})
+	c.Assert(err, qt.ErrorMatches, "permission denied")
+}
+
+type opsAuthorizerFunc func(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error)
+
+func (f opsAuthorizerFunc) AuthorizeOps(ctx context.Context, authorizedOp bakery.Op, queryOps []bakery.Op) ([]bool, []checkers.Caveat, error) {
+	return f(ctx, authorizedOp, queryOps)
+}
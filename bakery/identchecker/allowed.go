@@ -0,0 +1,133 @@
+package identchecker
+
+import (
+	"context"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+// AllowedInfo holds information returned by AuthChecker.Allowed.
+type AllowedInfo struct {
+	// AuthInfo holds the identity, if one was found, and
+	// information on the operations directly authorized by the
+	// macaroons, as returned by bakery.AuthChecker.Allowed.
+	*AuthInfo
+
+	// Granted holds the candidate operations that are not directly
+	// authorized by the macaroons but that the Authorizer allows
+	// for the identity without any caveats.
+	Granted []bakery.Op
+
+	// Conditional holds the candidate operations that the
+	// Authorizer allows for the identity only if Caveats are
+	// satisfied. The Authorizer returns a single set of caveats
+	// for all the operations, so when it returns any caveats,
+	// every operation it allows is held here rather than in
+	// Granted.
+	Conditional []bakery.Op
+
+	// Caveats holds the caveats returned by the Authorizer that
+	// must be satisfied for the operations in Conditional to be
+	// allowed.
+	Caveats []checkers.Caveat
+}
+
+// Ops returns all the operations that are allowed without further
+// caveats: those authorized directly by macaroons, other than
+// LoginOp, and those in Granted. The operations in Conditional are
+// not included. They are sorted by entity and then by action.
+func (info *AllowedInfo) Ops() []bakery.Op {
+	ops := make([]bakery.Op, 0, len(info.OpIndexes)+len(info.Granted))
+	for op := range info.OpIndexes {
+		if op != LoginOp {
+			ops = append(ops, op)
+		}
+	}
+	ops = append(ops, info.Granted...)
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Entity != ops[j].Entity {
+			return ops[i].Entity < ops[j].Entity
+		}
+		return ops[i].Action < ops[j].Action
+	})
+	return ops
+}
+
+// Allowed returns information on the operations that the request is
+// allowed to perform. This includes all the operations directly
+// authorized by the macaroons provided to Checker.Auth and those
+// operations in candidates that the Authorizer allows for the
+// identity associated with the request.
+//
+// The identity is taken from a login macaroon if there is one, and
+// otherwise inferred from the context. If no identity can be found,
+// the Authorizer is consulted with a nil identity. The Authorizer is
+// called once with all the candidate operations that are not directly
+// authorized by the macaroons. Operations that would be indirectly
+// allowed via the OpsAuthorizer are not included. Operations that the
+// Authorizer allows only subject to caveats are reported in
+// AllowedInfo.Conditional rather than AllowedInfo.Granted.
+//
+// Allowed returns an error only when the identity or authorization
+// cannot be determined, not when operations are not authorized.
+func (c *AuthChecker) Allowed(ctx context.Context, candidates ...bakery.Op) (*AllowedInfo, error) {
+	info, err := c.authChecker.Allowed(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var identity Identity
+	if mindex, ok := info.OpIndexes[LoginOp]; ok {
+		identity, err = c.inferIdentityFromMacaroon(ctx, c.authChecker.Namespace(), info.Macaroons[mindex])
+	} else {
+		// Any caveats returned are ignored because we are
+		// only reporting what is currently allowed.
+		identity, _, err = c.inferIdentityFromContext(ctx)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	result := &AllowedInfo{
+		AuthInfo: &AuthInfo{
+			AuthInfo: info,
+			Identity: identity,
+		},
+	}
+	need := make([]bakery.Op, 0, len(candidates))
+	seen := make(map[bakery.Op]bool)
+	for _, op := range candidates {
+		if op == LoginOp || seen[op] {
+			continue
+		}
+		seen[op] = true
+		if _, ok := info.OpIndexes[op]; !ok {
+			need = append(need, op)
+		}
+	}
+	if len(need) == 0 {
+		return result, nil
+	}
+	allowed, caveats, err := c.checker.p.Authorizer.Authorize(ctx, identity, need)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot authorize operations")
+	}
+	var granted []bakery.Op
+	for i, ok := range allowed {
+		if ok && i < len(need) {
+			granted = append(granted, need[i])
+		}
+	}
+	if len(granted) == 0 {
+		return result, nil
+	}
+	if len(caveats) > 0 {
+		result.Conditional = granted
+		result.Caveats = caveats
+	} else {
+		result.Granted = granted
+	}
+	return result, nil
+}
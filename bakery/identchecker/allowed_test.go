@@ -0,0 +1,126 @@
+package identchecker_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+func TestAllowed(t *testing.T) {
+	c := qt.New(t)
+	locator := make(dischargerLocator)
+	auth := &recordingAuthorizer{
+		Authorizer: opACL{
+			readOp("e1"):  {"sherlock"},
+			writeOp("e1"): {"bob"},
+			readOp("e2"):  {"bob", "sherlock"},
+		},
+	}
+	ts := newService(auth, basicAuthIdService{}, locator)
+	m, err := ts.oven.NewMacaroon(testContext, bakery.LatestVersion, nil, readOp("e3"), writeOp("e3"))
+	c.Assert(err, qt.IsNil)
+	ctx := contextWithBasicAuth(testContext, "sherlock", "holmes")
+
+	info, err := ts.checker.Auth(macaroon.Slice{m.M()}).Allowed(ctx,
+		readOp("e1"),
+		writeOp("e1"),
+		readOp("e2"),
+		readOp("e3"),
+		readOp("e1"),
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Identity, qt.Equals, identchecker.SimpleIdentity("sherlock"))
+	c.Assert(info.Granted, qt.DeepEquals, []bakery.Op{readOp("e1"), readOp("e2")})
+	c.Assert(info.Conditional, qt.HasLen, 0)
+	c.Assert(info.Caveats, qt.HasLen, 0)
+	c.Assert(info.Used, qt.DeepEquals, []bool{true})
+	c.Assert(info.Ops(), qt.DeepEquals, []bakery.Op{readOp("e1"), readOp("e2"), readOp("e3"), writeOp("e3")})
+
+	// The authorizer is called once, only with the candidates
+	// not authorized by macaroons.
+	c.Assert(auth.calls, qt.DeepEquals, [][]bakery.Op{{readOp("e1"), writeOp("e1"), readOp("e2")}})
+}
+
+func TestAllowedWithoutIdentity(t *testing.T) {
+	c := qt.New(t)
+	locator := make(dischargerLocator)
+	auth := opACL{
+		readOp("e1"): {"sherlock"},
+		readOp("e2"): {"everyone"},
+	}
+	ts := newService(auth, basicAuthIdService{}, locator)
+	info, err := ts.checker.Auth().Allowed(testContext, readOp("e1"), readOp("e2"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Identity, qt.IsNil)
+	c.Assert(info.Granted, qt.DeepEquals, []bakery.Op{readOp("e2")})
+	c.Assert(info.Ops(), qt.DeepEquals, []bakery.Op{readOp("e2")})
+}
+
+func TestAllowedWithNoCandidates(t *testing.T) {
+	c := qt.New(t)
+	auth := &recordingAuthorizer{
+		Authorizer: identchecker.OpenAuthorizer,
+	}
+	ts := newService(auth, basicAuthIdService{}, make(dischargerLocator))
+	info, err := ts.checker.Auth().Allowed(contextWithBasicAuth(testContext, "sherlock", "holmes"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Identity, qt.Equals, identchecker.SimpleIdentity("sherlock"))
+	c.Assert(info.Ops(), qt.HasLen, 0)
+	c.Assert(auth.calls, qt.HasLen, 0)
+}
+
+func TestAllowedReportsConditionalOps(t *testing.T) {
+	c := qt.New(t)
+	caveats := []checkers.Caveat{{
+		Location:  "somewhere",
+		Condition: "something",
+	}}
+	auth := identchecker.AuthorizerFunc(func(ctx context.Context, id identchecker.Identity, op bakery.Op) (bool, []checkers.Caveat, error) {
+		if op == readOp("e1") {
+			return true, caveats, nil
+		}
+		return false, nil, nil
+	})
+	ts := newService(auth, basicAuthIdService{}, make(dischargerLocator))
+	m, err := ts.oven.NewMacaroon(testContext, bakery.LatestVersion, nil, readOp("e3"))
+	c.Assert(err, qt.IsNil)
+	info, err := ts.checker.Auth(macaroon.Slice{m.M()}).Allowed(testContext, readOp("e1"), readOp("e2"))
+	c.Assert(err, qt.IsNil)
+	// Operations allowed subject to caveats are reported
+	// separately and are not included in Ops.
+	c.Assert(info.Granted, qt.HasLen, 0)
+	c.Assert(info.Conditional, qt.DeepEquals, []bakery.Op{readOp("e1")})
+	c.Assert(info.Caveats, qt.DeepEquals, caveats)
+	c.Assert(info.Ops(), qt.DeepEquals, []bakery.Op{readOp("e3")})
+}
+
+func TestAllowedAuthorizerError(t *testing.T) {
+	c := qt.New(t)
+	auth := identchecker.AuthorizerFunc(func(ctx context.Context, id identchecker.Identity, op bakery.Op) (bool, []checkers.Caveat, error) {
+		return false, nil, errgo.New("no database")
+	})
+	ts := newService(auth, basicAuthIdService{}, make(dischargerLocator))
+	info, err := ts.checker.Auth().Allowed(testContext, readOp("e1"))
+	c.Assert(err, qt.ErrorMatches, "cannot authorize operations: no database")
+	c.Assert(info, qt.IsNil)
+}
+
+// recordingAuthorizer records the operations passed
+// to each call of an underlying Authorizer.
+type recordingAuthorizer struct {
+	identchecker.Authorizer
+	calls [][]bakery.Op
+}
+
+func (a *recordingAuthorizer) Authorize(ctx context.Context, id identchecker.Identity, ops []bakery.Op) ([]bool, []checkers.Caveat, error) {
+	a.calls = append(a.calls, append([]bakery.Op(nil), ops...))
+	return a.Authorizer.Authorize(ctx, id, ops)
+}
@@ -0,0 +1,45 @@
+package identchecker_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+func TestAuditEvents(t *testing.T) {
+	c := qt.New(t)
+	var events []bakery.AuditEvent
+	checker := identchecker.NewChecker(identchecker.CheckerParams{
+		Checker:          testChecker,
+		Authorizer:       opACL{readOp("e1"): {"sherlock"}},
+		IdentityClient:   basicAuthIdService{},
+		MacaroonVerifier: newMacaroonStore(mustGenerateKey(), nil),
+		Auditor: bakery.AuditorFunc(func(ctx context.Context, e bakery.AuditEvent) {
+			events = append(events, e)
+		}),
+	})
+	ctx := contextWithBasicAuth(testContext, "sherlock", "holmes")
+	_, err := checker.Auth().Allow(ctx, readOp("e1"))
+	c.Assert(err, qt.IsNil)
+	// Only a single event is recorded even though the
+	// underlying checker is called twice.
+	c.Assert(events, qt.DeepEquals, []bakery.AuditEvent{{
+		Kind:     bakery.AuditMacaroonVerified,
+		Ops:      []bakery.Op{readOp("e1")},
+		Identity: "sherlock",
+	}})
+	events = nil
+
+	_, err = checker.Auth().Allow(ctx, readOp("e2"))
+	c.Assert(err, qt.ErrorMatches, "permission denied")
+	c.Assert(events, qt.DeepEquals, []bakery.AuditEvent{{
+		Kind:     bakery.AuditMacaroonDenied,
+		Ops:      []bakery.Op{readOp("e2")},
+		Identity: "sherlock",
+		Error:    "permission denied",
+	}})
+}
@@ -56,12 +56,38 @@ type BakeryParams struct {
 	// IdentityClient.DeclaredIdentity.
 	Authorizer Authorizer
 
+	// OpsAuthorizer is used to check whether operations are
+	// authorized by some other already-authorized operation. If it
+	// is nil, no operation is authorized by any operation except
+	// itself.
+	OpsAuthorizer bakery.OpsAuthorizer
+
+	// OpsHierarchy, if non-nil, is used to create a
+	// bakery.HierarchicalOpsAuthorizer that is used to check
+	// whether operations are authorized by some other
+	// already-authorized operation. If its Fallback field is nil,
+	// OpsAuthorizer will be used as the fallback.
+	OpsHierarchy *bakery.HierarchyParams
+
+	// IdentityCache, if non-nil, is used by the Checker to cache
+	// the identities obtained from login macaroons across requests.
+	IdentityCache *IdentityCache
+
 	// Location holds the location to use when creating new macaroons.
 	Location string
 
 	// Logger is used to log checker operations. If it is nil,
 	// DefaultLogger("bakery.identchecker") will be used.
 	Logger bakery.Logger
+
+	// Auditor is used to record audit events from both the
+	// Oven and the Checker. If it is nil, no events will
+	// be recorded.
+	Auditor bakery.Auditor
+
+	// Metrics is used to record metrics from the Oven.
+	// If it is nil, no metrics will be recorded.
+	Metrics bakery.Metrics
 }
 
 // NewBakery returns a new Bakery instance which combines an Oven with a
@@ -77,6 +103,8 @@ func NewBakery(p BakeryParams) *Bakery {
 		Location:         p.Location,
 		Locator:          p.Locator,
 		LegacyMacaroonOp: LoginOp,
+		Auditor:          p.Auditor,
+		Metrics:          p.Metrics,
 	}
 	if p.RootKeyStore != nil {
 		ovenParams.RootKeyStoreForOps = func(ops []bakery.Op) bakery.RootKeyStore {
@@ -90,7 +118,10 @@ func NewBakery(p BakeryParams) *Bakery {
 		MacaroonVerifier: oven,
 		IdentityClient:   p.IdentityClient,
 		Authorizer:       p.Authorizer,
+		OpsAuthorizer:    p.OpsHierarchy.OpsAuthorizer(p.OpsAuthorizer),
+		IdentityCache:    p.IdentityCache,
 		Logger:           p.Logger,
+		Auditor:          p.Auditor,
 	})
 	return &Bakery{
 		Oven:    oven,
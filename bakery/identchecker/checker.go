@@ -46,9 +46,21 @@ type CheckerParams struct {
 	// IdentityClient.DeclaredIdentity.
 	Authorizer Authorizer
 
+	// IdentityCache, if non-nil, is used to cache the identities
+	// obtained from login macaroons across requests, so that
+	// IdentityClient.DeclaredIdentity is not called for every
+	// request.
+	IdentityCache *IdentityCache
+
 	// Logger is used to log checker operations. If it is nil,
 	// DefaultLogger("bakery.identchecker") will be used.
 	Logger bakery.Logger
+
+	// Auditor is used to record a bakery.AuditMacaroonVerified or
+	// bakery.AuditMacaroonDenied event, including the identity if
+	// known, for each call to AuthChecker.Allow. If it is nil, no
+	// events will be recorded.
+	Auditor bakery.Auditor
 }
 
 // NewChecker returns a new Checker using the given parameters.
@@ -147,14 +159,51 @@ type AuthChecker struct {
 // be authorized in order to allow authorization to
 // proceed.
 func (c *AuthChecker) Allow(ctx context.Context, ops ...bakery.Op) (*AuthInfo, error) {
-	loginInfo, loginErr := c.authChecker.Allow(ctx, LoginOp)
-	c.checker.p.Logger.Infof(ctx, "allow loginop: %#v; err %#v", loginInfo, loginErr)
+	info, err := c.allow(ctx, ops, nil)
+	if c.checker.p.Auditor == nil {
+		return info, err
+	}
+	var e bakery.AuditEvent
+	if err != nil {
+		e = bakery.AuthAuditEvent(ops, c.authChecker.Macaroons(), nil, err)
+	} else {
+		e = bakery.AuthAuditEvent(ops, nil, info.AuthInfo, nil)
+	}
+	if identity := c.auditIdentity(info); identity != nil {
+		e.Identity = identity.Id()
+	}
+	c.checker.p.Auditor.AuditEvent(ctx, e)
+	return info, err
+}
+
+// auditIdentity returns the identity to record in an audit event
+// for a call to Allow that returned the given info.
+func (c *AuthChecker) auditIdentity(info *AuthInfo) Identity {
+	if info != nil && info.Identity != nil {
+		return info.Identity
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.identity_
+}
+
+// allow implements Allow. If expl is non-nil, an explanation
+// of the decision is recorded in it.
+func (c *AuthChecker) allow(ctx context.Context, ops []bakery.Op, expl *Explanation) (*AuthInfo, error) {
+	loginInfo, loginErr := c.bakeryAllow(ctx, expl, LoginOp)
+	if loginErr == nil {
+		ms := loginInfo.Macaroons[loginInfo.OpIndexes[LoginOp]]
+		bakery.Log(ctx, c.checker.p.Logger, bakery.LevelDebug, "login operation allowed", bakery.MacaroonIdField(ms[0].Id()))
+	} else {
+		bakery.Log(ctx, c.checker.p.Logger, bakery.LevelDebug, "login operation not allowed", bakery.ErrorField(loginErr))
+	}
 	var identity Identity
 	var identityCaveats []checkers.Caveat
 	if loginErr == nil {
 		// We've got a login macaroon. Extract the identity from it.
 		identity1, err := c.inferIdentityFromMacaroon(ctx, c.authChecker.Namespace(), loginInfo.Macaroons[loginInfo.OpIndexes[LoginOp]])
 		if err != nil {
+			expl.setIdentity(nil, err)
 			return nil, errgo.Mask(err)
 		}
 		identity = identity1
@@ -162,6 +211,7 @@ func (c *AuthChecker) Allow(ctx context.Context, ops ...bakery.Op) (*AuthInfo, e
 		// No login macaroon found. Try to infer an identity from the context.
 		identity1, caveats1, err := c.inferIdentityFromContext(ctx)
 		if err != nil {
+			expl.setIdentity(nil, err)
 			return nil, errgo.WithCausef(err, bakery.ErrPermissionDenied, "")
 		}
 		identity, identityCaveats = identity1, caveats1
@@ -173,6 +223,10 @@ func (c *AuthChecker) Allow(ctx context.Context, ops ...bakery.Op) (*AuthInfo, e
 			OpIndexes: make(map[bakery.Op]int),
 		}
 	}
+	expl.setIdentity(identity, nil)
+	if identity != nil {
+		bakery.Log(ctx, c.checker.p.Logger, bakery.LevelDebug, "found identity", bakery.Field("identity", identity.Id()), bakery.OpsField(ops))
+	}
 	// Form a slice holding all the non-login operations that are required.
 	need := make([]bakery.Op, 0, len(ops))
 	for _, op := range ops {
@@ -196,7 +250,7 @@ func (c *AuthChecker) Allow(ctx context.Context, ops ...bakery.Op) (*AuthInfo, e
 		// so that it can use any identity we inferred above in
 		// the authorization decision.
 		ctx := contextWithIdentity(ctx, identity)
-		opInfo, err := c.authChecker.Allow(ctx, need...)
+		opInfo, err := c.bakeryAllow(ctx, expl, need...)
 		if err == nil {
 			// All operations allowed.
 			if loginErr == nil {
@@ -265,8 +319,16 @@ func (c *AuthChecker) inferIdentityFromMacaroon(ctx context.Context, ns *checker
 	if c.identity_ != nil {
 		return c.identity_, nil
 	}
-	declared := checkers.InferDeclared(ns, ms)
-	identity, err := c.checker.p.IdentityClient.DeclaredIdentity(ctx, declared)
+	resolve := func(declared map[string]string) (Identity, error) {
+		return c.checker.p.IdentityClient.DeclaredIdentity(ctx, declared)
+	}
+	var identity Identity
+	var err error
+	if c.checker.p.IdentityCache != nil {
+		identity, err = c.checker.p.IdentityCache.get(ns, ms, resolve)
+	} else {
+		identity, err = resolve(checkers.InferDeclared(ns, ms))
+	}
 	if err != nil {
 		return nil, errgo.Notef(err, "could not determine identity")
 	}
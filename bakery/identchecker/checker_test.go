@@ -809,3 +809,24 @@ type macaroonVerifierWithError struct {
 func (s macaroonVerifierWithError) VerifyMacaroon(ctx context.Context, ms macaroon.Slice) (ops []bakery.Op, conditions []string, err error) {
 	return nil, nil, errgo.Mask(s.err, errgo.Any)
 }
+
+func TestNewBakeryWithOpsHierarchy(t *testing.T) {
+	c := qt.New(t)
+	b := identchecker.NewBakery(identchecker.BakeryParams{
+		Authorizer: opACL{},
+		OpsHierarchy: &bakery.HierarchyParams{
+			ImpliedActions: map[string][]string{
+				"write": {"read"},
+			},
+			InheritedActions: []string{"read"},
+		},
+	})
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, nil, writeOp("org/foo"))
+	c.Assert(err, qt.IsNil)
+	authInfo, err := b.Checker.Auth(macaroon.Slice{m.M()}).Allow(testContext, readOp("org/foo/model/bar"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(authInfo.Used, qt.DeepEquals, []bool{true})
+
+	_, err = b.Checker.Auth(macaroon.Slice{m.M()}).Allow(testContext, writeOp("org/foo/model/bar"))
+	c.Assert(err, qt.ErrorMatches, "permission denied")
+}
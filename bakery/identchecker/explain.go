@@ -0,0 +1,73 @@
+package identchecker
+
+import (
+	"context"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// Explanation describes how an authorization decision was made by
+// AuthChecker.Explain. Like bakery.Explanation, its contents should
+// not be shown to untrusted clients.
+type Explanation struct {
+	// Ops holds the operations that were requested.
+	Ops []bakery.Op
+
+	// Identity holds the id of the identity that was used
+	// in the decision, or the empty string if there was none.
+	Identity string
+
+	// IdentityError holds the text of the error encountered
+	// when determining the identity, if any.
+	IdentityError string
+
+	// Checks holds explanations of the checks made by the
+	// underlying bakery.AuthChecker, in order. The first check
+	// is always for LoginOp; if there is a second, it is for
+	// the remaining operations and includes the decisions
+	// made by the Authorizer.
+	Checks []*bakery.Explanation
+
+	// Error holds the text of the error returned by the check,
+	// or the empty string if there was none.
+	Error string
+}
+
+// Explain is like Allow except that it also returns an explanation of
+// the authorization decision, which is returned even when Allow
+// would return an error.
+func (c *AuthChecker) Explain(ctx context.Context, ops ...bakery.Op) (*AuthInfo, *Explanation, error) {
+	expl := &Explanation{
+		Ops: append([]bakery.Op(nil), ops...),
+	}
+	info, err := c.allow(ctx, ops, expl)
+	if err != nil {
+		expl.Error = err.Error()
+	}
+	return info, expl, err
+}
+
+// bakeryAllow calls Allow on the underlying bakery.AuthChecker,
+// recording an explanation in expl if it is non-nil.
+func (c *AuthChecker) bakeryAllow(ctx context.Context, expl *Explanation, ops ...bakery.Op) (*bakery.AuthInfo, error) {
+	if expl == nil {
+		return c.authChecker.Allow(ctx, ops...)
+	}
+	info, bexpl, err := c.authChecker.Explain(ctx, ops...)
+	expl.Checks = append(expl.Checks, bexpl)
+	return info, err
+}
+
+// setIdentity records the result of determining the identity.
+// It does nothing if expl is nil.
+func (expl *Explanation) setIdentity(identity Identity, err error) {
+	if expl == nil {
+		return
+	}
+	if identity != nil {
+		expl.Identity = identity.Id()
+	}
+	if err != nil {
+		expl.IdentityError = err.Error()
+	}
+}
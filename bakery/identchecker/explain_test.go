@@ -0,0 +1,47 @@
+package identchecker_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+func TestExplain(t *testing.T) {
+	c := qt.New(t)
+	locator := make(dischargerLocator)
+	auth := opACL{readOp("e1"): {"sherlock"}, readOp("e2"): {"bob"}}
+	ts := newService(auth, basicAuthIdService{}, locator)
+	ctx := contextWithBasicAuth(testContext, "sherlock", "holmes")
+
+	authInfo, expl, err := ts.checker.Auth().Explain(ctx, readOp("e1"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(authInfo.Identity, qt.Equals, identchecker.SimpleIdentity("sherlock"))
+	c.Assert(expl.Ops, qt.DeepEquals, []bakery.Op{readOp("e1")})
+	c.Assert(expl.Identity, qt.Equals, "sherlock")
+	c.Assert(expl.IdentityError, qt.Equals, "")
+	c.Assert(expl.Error, qt.Equals, "")
+	c.Assert(expl.Checks, qt.HasLen, 2)
+	// The first check is for LoginOp, which fails because
+	// there are no macaroons.
+	c.Assert(expl.Checks[0].Ops, qt.DeepEquals, []bakery.Op{identchecker.LoginOp})
+	c.Assert(expl.Checks[0].Error, qt.Equals, "permission denied")
+	// The second check consults the Authorizer.
+	c.Assert(expl.Checks[1].Ops, qt.DeepEquals, []bakery.Op{readOp("e1")})
+	c.Assert(expl.Checks[1].Decisions, qt.DeepEquals, []bakery.OpsAuthorizerDecision{{
+		AuthorizedOp:  bakery.NoOp,
+		MacaroonIndex: -1,
+		QueryOps:      []bakery.Op{readOp("e1")},
+		Allowed:       []bool{true},
+	}})
+
+	_, expl, err = ts.checker.Auth().Explain(ctx, readOp("e1"), readOp("e2"))
+	c.Assert(err, qt.ErrorMatches, "permission denied")
+	c.Assert(expl.Identity, qt.Equals, "sherlock")
+	c.Assert(expl.Error, qt.Equals, "permission denied")
+	c.Assert(expl.Checks, qt.HasLen, 2)
+	c.Assert(expl.Checks[1].Decisions[0].Allowed, qt.DeepEquals, []bool{true, false})
+	c.Assert(expl.Checks[1].Unauthorized, qt.DeepEquals, []bakery.Op{readOp("e2")})
+}
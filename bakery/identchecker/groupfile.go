@@ -0,0 +1,186 @@
+package identchecker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/reloadfile"
+)
+
+// GroupMembers holds the direct members of a group.
+type GroupMembers struct {
+	// Users holds the ids of the users in the group.
+	Users []string `yaml:"users" json:"users"`
+
+	// Groups holds the names of the groups in the group.
+	Groups []string `yaml:"groups" json:"groups"`
+}
+
+// StaticGroups is a GroupResolver that resolves groups from
+// membership information held in memory. It maps each group name
+// to the members of that group. Users are identified by their
+// id alone; their domain is ignored.
+type StaticGroups map[string]GroupMembers
+
+var _ GroupResolver = StaticGroups(nil)
+
+// UserGroups implements GroupResolver.UserGroups.
+func (g StaticGroups) UserGroups(ctx context.Context, id Identity) ([]string, error) {
+	return g.groupsContaining(id.Id(), func(m GroupMembers) []string {
+		return m.Users
+	}), nil
+}
+
+// ParentGroups implements GroupResolver.ParentGroups.
+func (g StaticGroups) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	return g.groupsContaining(group, func(m GroupMembers) []string {
+		return m.Groups
+	}), nil
+}
+
+// groupsContaining returns, in alphabetical order, the names of the
+// groups for which the members returned by members contain name.
+func (g StaticGroups) groupsContaining(name string, members func(GroupMembers) []string) []string {
+	var groups []string
+	for group, m := range g {
+		for _, member := range members(m) {
+			if member == name {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// DefaultGroupFileReloadInterval holds the default interval
+// at which FileGroupResolver checks its file for changes.
+const DefaultGroupFileReloadInterval = 10 * time.Second
+
+// FileGroupResolverParams holds parameters for NewFileGroupResolver.
+type FileGroupResolverParams struct {
+	// Path holds the path of the file to read. See
+	// FileGroupResolver for the file format.
+	Path string
+
+	// ReloadInterval holds the minimum interval between checks for
+	// changes to the file. If it is zero,
+	// DefaultGroupFileReloadInterval will be used. If it is
+	// negative, the file will only be read again when Reload is
+	// called.
+	ReloadInterval time.Duration
+
+	// Logger is used to log failures to reload the file.
+	// If it is nil, nothing will be logged.
+	Logger bakery.Logger
+}
+
+// FileGroupResolver is a GroupResolver that reads group membership
+// from a YAML or JSON file, for example:
+//
+//	groups:
+//	  editors:
+//	    users: [alice, bob]
+//	    groups: [admins]
+//	  admins:
+//	    users: [root]
+//
+// Here, root is a member of both admins and editors. Every group named
+// as a member of another group must itself be defined in the file.
+//
+// The file is checked for changes as it is used, at most once per
+// reload interval, so changes take effect without a restart. If the
+// changed file cannot be read or is invalid, the previous information
+// continues to be used.
+type FileGroupResolver struct {
+	path   string
+	logger bakery.Logger
+	file   *reloadfile.File
+}
+
+var _ GroupResolver = (*FileGroupResolver)(nil)
+
+// groupFile defines the format of a FileGroupResolver file.
+type groupFile struct {
+	Groups StaticGroups `yaml:"groups"`
+}
+
+// NewFileGroupResolver returns a new FileGroupResolver that reads the
+// file specified in p. It returns an error if the file cannot be read
+// or is invalid.
+func NewFileGroupResolver(p FileGroupResolverParams) (*FileGroupResolver, error) {
+	if p.ReloadInterval == 0 {
+		p.ReloadInterval = DefaultGroupFileReloadInterval
+	}
+	file, err := reloadfile.New(p.Path, p.ReloadInterval, func(data []byte) (interface{}, error) {
+		return parseGroupFile(data)
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &FileGroupResolver{
+		path:   p.Path,
+		logger: p.Logger,
+		file:   file,
+	}, nil
+}
+
+// Reload reads the file again. If the file cannot be read
+// or is invalid, it returns an error and the previous
+// information continues to be used.
+func (r *FileGroupResolver) Reload() error {
+	return errgo.Mask(r.file.Reload())
+}
+
+// UserGroups implements GroupResolver.UserGroups.
+func (r *FileGroupResolver) UserGroups(ctx context.Context, id Identity) ([]string, error) {
+	return r.currentGroups(ctx).UserGroups(ctx, id)
+}
+
+// ParentGroups implements GroupResolver.ParentGroups.
+func (r *FileGroupResolver) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	return r.currentGroups(ctx).ParentGroups(ctx, group)
+}
+
+// currentGroups returns the current group information, first checking
+// the file for changes if the reload interval has passed.
+func (r *FileGroupResolver) currentGroups(ctx context.Context) StaticGroups {
+	groups, err := r.file.Value()
+	if err != nil {
+		bakery.Log(ctx, r.logger, bakery.LevelWarn, "cannot reload groups", bakery.Field("path", r.path), bakery.ErrorField(err))
+	}
+	return groups.(StaticGroups)
+}
+
+// parseGroupFile parses the contents of a FileGroupResolver file.
+func parseGroupFile(data []byte) (StaticGroups, error) {
+	var f groupFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if f.Groups == nil {
+		f.Groups = make(StaticGroups)
+	}
+	names := make([]string, 0, len(f.Groups))
+	for name := range f.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			return nil, errgo.Newf("empty group name")
+		}
+		for _, g := range f.Groups[name].Groups {
+			if _, ok := f.Groups[g]; !ok {
+				return nil, errgo.Newf("group %q has undefined member group %q", name, g)
+			}
+		}
+	}
+	return f.Groups, nil
+}
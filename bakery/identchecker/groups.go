@@ -0,0 +1,277 @@
+package identchecker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/ttlcache"
+)
+
+// GroupResolver is used to find out the groups that users are members
+// of. Groups may themselves be members of other groups.
+//
+// Implementations must be safe to call concurrently.
+type GroupResolver interface {
+	// UserGroups returns the names of the groups that the given
+	// user is a direct member of. It should return no groups and no
+	// error if the user is unknown.
+	UserGroups(ctx context.Context, id Identity) ([]string, error)
+
+	// ParentGroups returns the names of the groups that the given
+	// group is a direct member of. It should return no groups and
+	// no error if the group is unknown.
+	ParentGroups(ctx context.Context, group string) ([]string, error)
+}
+
+var (
+	_ ACLIdentity    = GroupIdentity{}
+	_ IdentityClient = GroupIdentityClient{}
+)
+
+// GroupIdentity implements ACLIdentity by resolving the groups
+// of an identity with a GroupResolver.
+type GroupIdentity struct {
+	// Identity holds the underlying identity.
+	Identity
+
+	// Resolver is used to find the groups that
+	// the identity is a member of.
+	Resolver GroupResolver
+}
+
+// Allow implements ACLIdentity.Allow by allowing the identity access
+// to ACLs that contain the identity's id, the group "everyone" (see
+// Everyone) or any group that the identity is a member of, directly or
+// through nested groups. Groups are resolved lazily, so the resolver is
+// not consulted further once a matching group is found.
+func (id GroupIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	if len(acl) == 0 {
+		return false, nil
+	}
+	aclSet := make(map[string]bool)
+	for _, g := range acl {
+		if g == id.Id() || g == Everyone {
+			return true, nil
+		}
+		aclSet[g] = true
+	}
+	found := false
+	err := walkGroups(ctx, id.Resolver, id.Identity, func(g string) bool {
+		found = aclSet[g]
+		return !found
+	})
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	return found, nil
+}
+
+// Groups returns all the groups that the identity is a member of,
+// directly or through nested groups, in alphabetical order.
+func (id GroupIdentity) Groups(ctx context.Context) ([]string, error) {
+	var groups []string
+	err := walkGroups(ctx, id.Resolver, id.Identity, func(g string) bool {
+		groups = append(groups, g)
+		return true
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// walkGroups calls f with each group that the given identity is a
+// member of, directly or indirectly, visiting direct memberships first.
+// Each group is visited once, even if group membership is cyclic. The
+// walk stops when f returns false.
+func walkGroups(ctx context.Context, r GroupResolver, id Identity, f func(group string) bool) error {
+	groups, err := r.UserGroups(ctx, id)
+	if err != nil {
+		return errgo.Notef(err, "cannot get groups for %q", id.Id())
+	}
+	// Copy the groups so that appending to the queue cannot
+	// modify a slice owned by the resolver, which may be
+	// shared with other goroutines.
+	queue := append([]string(nil), groups...)
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		if !f(g) {
+			return nil
+		}
+		parents, err := r.ParentGroups(ctx, g)
+		if err != nil {
+			return errgo.Notef(err, "cannot get groups for group %q", g)
+		}
+		queue = append(queue, parents...)
+	}
+	return nil
+}
+
+// GroupIdentityClient is an IdentityClient that wraps
+// the identities returned by another IdentityClient
+// in GroupIdentity values that use Resolver.
+type GroupIdentityClient struct {
+	IdentityClient
+	Resolver GroupResolver
+}
+
+// IdentityFromContext implements IdentityClient.IdentityFromContext.
+func (c GroupIdentityClient) IdentityFromContext(ctx context.Context) (Identity, []checkers.Caveat, error) {
+	id, caveats, err := c.IdentityClient.IdentityFromContext(ctx)
+	if err != nil || id == nil {
+		return nil, caveats, errgo.Mask(err, errgo.Any)
+	}
+	return c.wrap(id), caveats, nil
+}
+
+// DeclaredIdentity implements IdentityClient.DeclaredIdentity.
+func (c GroupIdentityClient) DeclaredIdentity(ctx context.Context, declared map[string]string) (Identity, error) {
+	id, err := c.IdentityClient.DeclaredIdentity(ctx, declared)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return c.wrap(id), nil
+}
+
+func (c GroupIdentityClient) wrap(id Identity) Identity {
+	return GroupIdentity{
+		Identity: id,
+		Resolver: c.Resolver,
+	}
+}
+
+// Default values used by NewGroupCache.
+const (
+	DefaultGroupCacheTTL         = 5 * time.Minute
+	DefaultGroupCacheNegativeTTL = time.Minute
+	DefaultGroupCacheMaxSize     = 10000
+)
+
+// GroupCacheParams holds parameters for NewGroupCache.
+type GroupCacheParams struct {
+	// Resolver holds the resolver whose results are cached.
+	Resolver GroupResolver
+
+	// TTL holds the length of time for which results that contain
+	// at least one group are cached. If it is zero,
+	// DefaultGroupCacheTTL will be used.
+	TTL time.Duration
+
+	// NegativeTTL holds the length of time for which results that
+	// contain no groups are cached. These are usually for unknown
+	// users, which are often looked up repeatedly. If it is zero,
+	// DefaultGroupCacheNegativeTTL will be used. If it is negative,
+	// such results will not be cached.
+	NegativeTTL time.Duration
+
+	// MaxSize holds the maximum number of results held in the cache.
+	// When the cache is full, the least recently used result is
+	// evicted. If it is zero, DefaultGroupCacheMaxSize will be used.
+	MaxSize int
+
+	// Clock is used to determine when entries expire. If it
+	// is nil, the system clock will be used.
+	Clock checkers.Clock
+}
+
+// GroupCache is a GroupResolver that caches the results of another
+// GroupResolver. Errors are never cached. It is safe to call
+// concurrently; each call returns a new slice that the caller may
+// modify.
+type GroupCache struct {
+	p     GroupCacheParams
+	cache *ttlcache.Cache
+}
+
+var _ GroupResolver = (*GroupCache)(nil)
+
+type groupCacheKey struct {
+	// isGroup holds whether the name is a group name
+	// rather than a user id.
+	isGroup bool
+	domain  string
+	name    string
+}
+
+// NewGroupCache returns a new GroupCache using the given parameters.
+func NewGroupCache(p GroupCacheParams) *GroupCache {
+	if p.TTL == 0 {
+		p.TTL = DefaultGroupCacheTTL
+	}
+	if p.NegativeTTL == 0 {
+		p.NegativeTTL = DefaultGroupCacheNegativeTTL
+	}
+	if p.MaxSize == 0 {
+		p.MaxSize = DefaultGroupCacheMaxSize
+	}
+	return &GroupCache{
+		p:     p,
+		cache: ttlcache.New(p.MaxSize, p.Clock),
+	}
+}
+
+// UserGroups implements GroupResolver.UserGroups.
+func (c *GroupCache) UserGroups(ctx context.Context, id Identity) ([]string, error) {
+	return c.get(groupCacheKey{
+		domain: id.Domain(),
+		name:   id.Id(),
+	}, func() ([]string, error) {
+		return c.p.Resolver.UserGroups(ctx, id)
+	})
+}
+
+// ParentGroups implements GroupResolver.ParentGroups.
+func (c *GroupCache) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	return c.get(groupCacheKey{
+		isGroup: true,
+		name:    group,
+	}, func() ([]string, error) {
+		return c.p.Resolver.ParentGroups(ctx, group)
+	})
+}
+
+// Invalidate removes all entries from the cache, so that subsequent
+// lookups will consult the underlying resolver.
+func (c *GroupCache) Invalidate() {
+	c.cache.Clear()
+}
+
+func (c *GroupCache) get(key groupCacheKey, resolve func() ([]string, error)) ([]string, error) {
+	now := c.cache.Now()
+	if groups, ok := c.cache.Get(key); ok {
+		return copyGroups(groups.([]string)), nil
+	}
+	groups, err := resolve()
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	ttl := c.p.TTL
+	if len(groups) == 0 {
+		ttl = c.p.NegativeTTL
+	}
+	if ttl < 0 {
+		return groups, nil
+	}
+	c.cache.Add(key, copyGroups(groups), now.Add(ttl))
+	return groups, nil
+}
+
+// copyGroups returns a copy of groups, preserving
+// the distinction between nil and empty slices.
+func copyGroups(groups []string) []string {
+	if groups == nil {
+		return nil
+	}
+	return append([]string{}, groups...)
+}
@@ -0,0 +1,471 @@
+package identchecker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+var testGroups = identchecker.StaticGroups{
+	"editors": {
+		Users:  []string{"alice", "bob"},
+		Groups: []string{"admins"},
+	},
+	"admins": {
+		Users:  []string{"root"},
+		Groups: []string{"superusers"},
+	},
+	"superusers": {
+		// A cycle.
+		Groups: []string{"editors"},
+	},
+	"readers": {
+		Users: []string{"bob"},
+	},
+}
+
+var groupIdentityAllowTests = []struct {
+	about       string
+	user        string
+	acl         []string
+	expectAllow bool
+}{{
+	about: "empty ACL",
+	user:  "bob",
+}, {
+	about:       "user id",
+	user:        "bob",
+	acl:         []string{"bob"},
+	expectAllow: true,
+}, {
+	about:       "everyone",
+	user:        "nobody",
+	acl:         []string{"everyone"},
+	expectAllow: true,
+}, {
+	about:       "direct group",
+	user:        "bob",
+	acl:         []string{"x", "readers"},
+	expectAllow: true,
+}, {
+	about:       "nested group",
+	user:        "root",
+	acl:         []string{"editors"},
+	expectAllow: true,
+}, {
+	about:       "nested group through cycle",
+	user:        "alice",
+	acl:         []string{"superusers"},
+	expectAllow: true,
+}, {
+	about: "not a member",
+	user:  "alice",
+	acl:   []string{"readers"},
+}, {
+	about: "unknown user",
+	user:  "nobody",
+	acl:   []string{"readers", "editors"},
+}}
+
+func TestGroupIdentityAllow(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range groupIdentityAllowTests {
+		c.Run(test.about, func(c *qt.C) {
+			id := identchecker.GroupIdentity{
+				Identity: identchecker.SimpleIdentity(test.user),
+				Resolver: testGroups,
+			}
+			ok, err := id.Allow(testContext, test.acl)
+			c.Assert(err, qt.IsNil)
+			c.Assert(ok, qt.Equals, test.expectAllow)
+		})
+	}
+}
+
+func TestGroupIdentityGroups(t *testing.T) {
+	c := qt.New(t)
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("bob"),
+		Resolver: testGroups,
+	}
+	groups, err := id.Groups(testContext)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"admins", "editors", "readers", "superusers"})
+}
+
+func TestGroupIdentityStopsResolvingWhenFound(t *testing.T) {
+	c := qt.New(t)
+	r := &countingResolver{resolver: testGroups}
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("root"),
+		Resolver: r,
+	}
+	ok, err := id.Allow(testContext, []string{"admins"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(r.calls, qt.DeepEquals, []string{"user root"})
+}
+
+func TestGroupIdentityError(t *testing.T) {
+	c := qt.New(t)
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("bob"),
+		Resolver: &countingResolver{
+			resolver: testGroups,
+			err:      errgo.New("directory unavailable"),
+		},
+	}
+	ok, err := id.Allow(testContext, []string{"admins"})
+	c.Assert(err, qt.ErrorMatches, `cannot get groups for "bob": directory unavailable`)
+	c.Assert(ok, qt.Equals, false)
+}
+
+func TestGroupIdentityClient(t *testing.T) {
+	c := qt.New(t)
+	client := identchecker.GroupIdentityClient{
+		IdentityClient: basicAuthIdService{},
+		Resolver: identchecker.StaticGroups{
+			"detectives": {Users: []string{"sherlock"}},
+		},
+	}
+	id, _, err := client.IdentityFromContext(contextWithBasicAuth(testContext, "sherlock", "holmes"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Id(), qt.Equals, "sherlock")
+	ok, err := id.(identchecker.ACLIdentity).Allow(testContext, []string{"detectives"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, true)
+
+	id, _, err = client.IdentityFromContext(testContext)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.IsNil)
+}
+
+func TestGroupCache(t *testing.T) {
+	c := qt.New(t)
+	clock := &mutableClock{now: epoch}
+	r := &countingResolver{resolver: testGroups}
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver:    r,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+		Clock:       clock,
+	})
+	bob := identchecker.SimpleIdentity("bob")
+	nobody := identchecker.SimpleIdentity("nobody")
+	for i := 0; i < 2; i++ {
+		groups, err := cache.UserGroups(testContext, bob)
+		c.Assert(err, qt.IsNil)
+		c.Assert(groups, qt.DeepEquals, []string{"editors", "readers"})
+		groups, err = cache.UserGroups(testContext, nobody)
+		c.Assert(err, qt.IsNil)
+		c.Assert(groups, qt.HasLen, 0)
+		groups, err = cache.ParentGroups(testContext, "admins")
+		c.Assert(err, qt.IsNil)
+		c.Assert(groups, qt.DeepEquals, []string{"editors"})
+	}
+	c.Assert(r.calls, qt.DeepEquals, []string{"user bob", "user nobody", "group admins"})
+
+	// The negative result expires first.
+	r.calls = nil
+	clock.now = clock.now.Add(30 * time.Second)
+	_, err := cache.UserGroups(testContext, bob)
+	c.Assert(err, qt.IsNil)
+	_, err = cache.UserGroups(testContext, nobody)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r.calls, qt.DeepEquals, []string{"user nobody"})
+
+	r.calls = nil
+	clock.now = clock.now.Add(time.Minute)
+	_, err = cache.UserGroups(testContext, bob)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r.calls, qt.DeepEquals, []string{"user bob"})
+
+	r.calls = nil
+	cache.Invalidate()
+	_, err = cache.UserGroups(testContext, bob)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r.calls, qt.DeepEquals, []string{"user bob"})
+}
+
+func TestGroupCacheConcurrent(t *testing.T) {
+	c := qt.New(t)
+	// The resolver returns slices with spare capacity so that
+	// appending to them would modify the shared backing array.
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver: spareCapacityResolver{testGroups},
+	})
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("bob"),
+		Resolver: cache,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groups, err := id.Groups(testContext)
+			c.Check(err, qt.IsNil)
+			c.Check(groups, qt.DeepEquals, []string{"admins", "editors", "readers", "superusers"})
+			// Modifying the result must not affect the cache.
+			groups, err = cache.UserGroups(testContext, id.Identity)
+			c.Check(err, qt.IsNil)
+			groups[0] = "modified"
+		}()
+	}
+	wg.Wait()
+	groups, err := cache.UserGroups(testContext, id.Identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"editors", "readers"})
+}
+
+func TestGroupCacheDoesNotCacheErrors(t *testing.T) {
+	c := qt.New(t)
+	r := &countingResolver{
+		resolver: testGroups,
+		err:      errgo.New("directory unavailable"),
+	}
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver: r,
+	})
+	for i := 0; i < 2; i++ {
+		_, err := cache.UserGroups(testContext, identchecker.SimpleIdentity("bob"))
+		c.Assert(err, qt.ErrorMatches, "directory unavailable")
+	}
+	c.Assert(r.calls, qt.HasLen, 2)
+}
+
+func TestGroupCacheNoNegativeCaching(t *testing.T) {
+	c := qt.New(t)
+	r := &countingResolver{resolver: testGroups}
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver:    r,
+		NegativeTTL: -1,
+	})
+	for i := 0; i < 2; i++ {
+		_, err := cache.UserGroups(testContext, identchecker.SimpleIdentity("nobody"))
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(r.calls, qt.HasLen, 2)
+}
+
+func TestGroupCacheMaxSize(t *testing.T) {
+	c := qt.New(t)
+	r := &countingResolver{resolver: testGroups}
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver: r,
+		MaxSize:  1,
+	})
+	for _, user := range []string{"alice", "bob", "alice"} {
+		_, err := cache.UserGroups(testContext, identchecker.SimpleIdentity(user))
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(r.calls, qt.DeepEquals, []string{"user alice", "user bob", "user alice"})
+}
+
+func TestGroupCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := qt.New(t)
+	r := &countingResolver{resolver: testGroups}
+	cache := identchecker.NewGroupCache(identchecker.GroupCacheParams{
+		Resolver: r,
+		MaxSize:  2,
+	})
+	for _, user := range []string{"alice", "bob", "alice", "root", "alice", "bob"} {
+		_, err := cache.UserGroups(testContext, identchecker.SimpleIdentity(user))
+		c.Assert(err, qt.IsNil)
+	}
+	// Adding root evicts bob, which is less recently used than alice.
+	c.Assert(r.calls, qt.DeepEquals, []string{"user alice", "user bob", "user root", "user bob"})
+}
+
+func TestFileGroupResolver(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.Mkdir(), "groups.yaml")
+	err := ioutil.WriteFile(path, []byte(`
+groups:
+  editors:
+    users: [alice]
+    groups: [admins]
+  admins:
+    users: [root]
+`), 0666)
+	c.Assert(err, qt.IsNil)
+	r, err := identchecker.NewFileGroupResolver(identchecker.FileGroupResolverParams{
+		Path:           path,
+		ReloadInterval: -1,
+	})
+	c.Assert(err, qt.IsNil)
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("root"),
+		Resolver: r,
+	}
+	groups, err := id.Groups(testContext)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"admins", "editors"})
+
+	err = ioutil.WriteFile(path, []byte(`{"groups": {"admins": {"users": ["alice"]}}}`), 0666)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r.Reload(), qt.IsNil)
+	groups, err = id.Groups(testContext)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+
+	err = ioutil.WriteFile(path, []byte("groups:\n  a:\n    groups: [b]\n"), 0666)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r.Reload(), qt.ErrorMatches, `cannot parse ".*": group "a" has undefined member group "b"`)
+	groups, err = r.UserGroups(testContext, identchecker.SimpleIdentity("alice"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"admins"})
+}
+
+func TestLDAPGroupResolver(t *testing.T) {
+	c := qt.New(t)
+	dir := fakeDirectory{{
+		DN: "cn=editors,ou=groups,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"cn": {"editors"},
+			"member": {
+				"uid=alice,ou=people,dc=example,dc=com",
+				"cn=admins,ou=groups,dc=example,dc=com",
+			},
+		},
+	}, {
+		DN: "cn=admins,ou=groups,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"cn":     {"admins"},
+			"member": {`uid=root\,x,ou=people,dc=example,dc=com`},
+		},
+	}, {
+		DN: "cn=other,ou=groups,dc=other,dc=com",
+		Attributes: map[string][]string{
+			"cn":     {"other"},
+			"member": {"uid=alice,ou=people,dc=example,dc=com"},
+		},
+	}}
+	r := identchecker.NewLDAPGroupResolver(identchecker.LDAPGroupResolverParams{
+		Directory:   dir,
+		UserBaseDN:  "ou=people,dc=example,dc=com",
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+	})
+	groups, err := r.UserGroups(testContext, identchecker.SimpleIdentity("alice"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"editors"})
+
+	id := identchecker.GroupIdentity{
+		Identity: identchecker.SimpleIdentity("root,x"),
+		Resolver: r,
+	}
+	groups, err = id.Groups(testContext)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"admins", "editors"})
+
+	// Filter metacharacters in the id are escaped.
+	groups, err = r.UserGroups(testContext, identchecker.SimpleIdentity("*"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+}
+
+// countingResolver records calls to an underlying
+// GroupResolver, optionally failing them.
+type countingResolver struct {
+	resolver identchecker.GroupResolver
+	err      error
+	calls    []string
+}
+
+func (r *countingResolver) UserGroups(ctx context.Context, id identchecker.Identity) ([]string, error) {
+	r.calls = append(r.calls, "user "+id.Id())
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.resolver.UserGroups(ctx, id)
+}
+
+func (r *countingResolver) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	r.calls = append(r.calls, "group "+group)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.resolver.ParentGroups(ctx, group)
+}
+
+// spareCapacityResolver is a GroupResolver that returns
+// slices with spare capacity from an underlying resolver.
+type spareCapacityResolver struct {
+	resolver identchecker.GroupResolver
+}
+
+func (r spareCapacityResolver) UserGroups(ctx context.Context, id identchecker.Identity) ([]string, error) {
+	groups, err := r.resolver.UserGroups(ctx, id)
+	return withSpareCapacity(groups), err
+}
+
+func (r spareCapacityResolver) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	groups, err := r.resolver.ParentGroups(ctx, group)
+	return withSpareCapacity(groups), err
+}
+
+func withSpareCapacity(groups []string) []string {
+	return append(make([]string, 0, len(groups)+10), groups...)
+}
+
+type mutableClock struct {
+	now time.Time
+}
+
+func (c *mutableClock) Now() time.Time {
+	return c.now
+}
+
+// fakeDirectory implements identchecker.Directory for a fixed set of
+// entries. It only supports filters of the form (attr=value).
+type fakeDirectory []identchecker.DirectoryEntry
+
+var simpleFilterPattern = regexp.MustCompile(`^\(([a-zA-Z]+)=((?:[^*()\\]|\\[0-9a-f]{2})*)\)$`)
+
+func (d fakeDirectory) Search(ctx context.Context, req identchecker.DirectorySearchRequest) ([]identchecker.DirectoryEntry, error) {
+	m := simpleFilterPattern.FindStringSubmatch(req.Filter)
+	if m == nil {
+		return nil, errgo.Newf("unsupported filter %q", req.Filter)
+	}
+	attr, value := m[1], unescapeFilterValue(m[2])
+	var entries []identchecker.DirectoryEntry
+	for _, e := range d {
+		if !strings.HasSuffix(e.DN, ","+req.BaseDN) && e.DN != req.BaseDN {
+			continue
+		}
+		for _, v := range e.Attributes[attr] {
+			if v == value {
+				entries = append(entries, e)
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+func unescapeFilterValue(v string) string {
+	var buf strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+2 < len(v) {
+			var b byte
+			for _, c := range v[i+1 : i+3] {
+				b = b<<4 | byte(strings.IndexRune("0123456789abcdef", c))
+			}
+			buf.WriteByte(b)
+			i += 2
+			continue
+		}
+		buf.WriteByte(v[i])
+	}
+	return buf.String()
+}
@@ -0,0 +1,146 @@
+package identchecker
+
+import (
+	"crypto/sha256"
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/ttlcache"
+)
+
+// Default values used by NewIdentityCache.
+const (
+	DefaultIdentityCacheTTL     = 5 * time.Minute
+	DefaultIdentityCacheMaxSize = 10000
+)
+
+// IdentityCacheParams holds parameters for NewIdentityCache.
+type IdentityCacheParams struct {
+	// TTL holds the maximum length of time for which an identity
+	// is cached. An identity is never cached beyond the expiry time
+	// of the login macaroon it was obtained from. If it is zero,
+	// DefaultIdentityCacheTTL will be used.
+	TTL time.Duration
+
+	// MaxSize holds the maximum number of identities held in the
+	// cache. When the cache is full, the least recently used
+	// identity is evicted. If it is zero,
+	// DefaultIdentityCacheMaxSize will be used.
+	MaxSize int
+
+	// KeyByDeclared specifies that identities are keyed by the
+	// attributes declared in the login macaroon rather than by its
+	// signatures. This allows an identity to be shared between
+	// different login macaroons that declare the same attributes,
+	// which is only appropriate when IdentityClient.DeclaredIdentity
+	// depends on nothing but the declared attributes.
+	KeyByDeclared bool
+
+	// Clock is used to determine when entries expire. If it
+	// is nil, the system clock will be used.
+	Clock checkers.Clock
+}
+
+// IdentityCache caches identities obtained from login macaroons so
+// that IdentityClient.DeclaredIdentity need not be called for every
+// request. It may be shared between several Checkers that use the
+// same IdentityClient. Failures are never cached.
+type IdentityCache struct {
+	p     IdentityCacheParams
+	cache *ttlcache.Cache
+}
+
+// NewIdentityCache returns a new IdentityCache using the given parameters.
+func NewIdentityCache(p IdentityCacheParams) *IdentityCache {
+	if p.TTL == 0 {
+		p.TTL = DefaultIdentityCacheTTL
+	}
+	if p.MaxSize == 0 {
+		p.MaxSize = DefaultIdentityCacheMaxSize
+	}
+	return &IdentityCache{
+		p:     p,
+		cache: ttlcache.New(p.MaxSize, p.Clock),
+	}
+}
+
+// Invalidate removes all identities from the cache.
+func (c *IdentityCache) Invalidate() {
+	c.cache.Clear()
+}
+
+// InvalidateIdentity removes all cached identities with the given id,
+// so that the next request that uses one of their login macaroons
+// will call IdentityClient.DeclaredIdentity again.
+func (c *IdentityCache) InvalidateIdentity(id string) {
+	c.cache.RemoveFunc(func(_, identity interface{}) bool {
+		return identity.(Identity).Id() == id
+	})
+}
+
+// Len returns the number of entries in the cache, including
+// any that have expired but have not yet been removed.
+func (c *IdentityCache) Len() int {
+	return c.cache.Len()
+}
+
+// get returns the identity for the given login macaroon, calling
+// resolve with the declared attributes if it is not in the cache.
+func (c *IdentityCache) get(ns *checkers.Namespace, ms macaroon.Slice, resolve func(declared map[string]string) (Identity, error)) (Identity, error) {
+	declared := checkers.InferDeclared(ns, ms)
+	key := c.key(declared, ms)
+	now := c.cache.Now()
+	if identity, ok := c.cache.Get(key); ok {
+		return identity.(Identity), nil
+	}
+	identity, err := resolve(declared)
+	if err != nil || identity == nil {
+		return identity, errgo.Mask(err, errgo.Any)
+	}
+	expires := now.Add(c.p.TTL)
+	if t, ok := checkers.MacaroonsExpiryTime(ns, ms); ok && t.Before(expires) {
+		expires = t
+	}
+	if !now.Before(expires) {
+		return identity, nil
+	}
+	c.cache.Add(key, identity, expires)
+	return identity, nil
+}
+
+// key returns the cache key for a login macaroon with the
+// given declared attributes.
+func (c *IdentityCache) key(declared map[string]string, ms macaroon.Slice) [sha256.Size]byte {
+	h := sha256.New()
+	if c.p.KeyByDeclared {
+		keys := make([]string, 0, len(declared))
+		for k := range declared {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			// Include the lengths so that the encoding
+			// is unambiguous.
+			writeLengthPrefixed(h, k)
+			writeLengthPrefixed(h, declared[k])
+		}
+	} else {
+		for _, m := range ms {
+			h.Write(m.Signature())
+		}
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func writeLengthPrefixed(w io.Writer, s string) {
+	n := len(s)
+	w.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	w.Write([]byte(s))
+}
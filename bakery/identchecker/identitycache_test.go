@@ -0,0 +1,221 @@
+package identchecker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+func TestIdentityCache(t *testing.T) {
+	c := qt.New(t)
+	clock := &mutableClock{now: epoch}
+	ids := &countingIdService{}
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		TTL:   time.Minute,
+		Clock: clock,
+	})
+	ts := newCachingService(ids, cache)
+	m := ts.loginMacaroon(c, "bob")
+
+	for i := 0; i < 3; i++ {
+		authInfo, err := ts.checker.Auth(m).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil)
+		c.Assert(authInfo.Identity, qt.Equals, identchecker.SimpleIdentity("bob"))
+	}
+	c.Assert(ids.calls, qt.Equals, 1)
+	c.Assert(cache.Len(), qt.Equals, 1)
+
+	// A different macaroon declaring the same user has its
+	// own entry.
+	_, err := ts.checker.Auth(ts.loginMacaroon(c, "bob")).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids.calls, qt.Equals, 2)
+	c.Assert(cache.Len(), qt.Equals, 2)
+
+	// Once the TTL has passed, the identity is fetched again.
+	clock.now = epoch.Add(time.Minute)
+	_, err = ts.checker.Auth(m).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids.calls, qt.Equals, 3)
+}
+
+func TestIdentityCacheKeyByDeclared(t *testing.T) {
+	c := qt.New(t)
+	ids := &countingIdService{}
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		KeyByDeclared: true,
+		Clock:         &mutableClock{now: epoch},
+	})
+	ts := newCachingService(ids, cache)
+	for _, user := range []string{"bob", "bob", "alice", "bob"} {
+		authInfo, err := ts.checker.Auth(ts.loginMacaroon(c, user)).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil)
+		c.Assert(authInfo.Identity, qt.Equals, identchecker.SimpleIdentity(user))
+	}
+	c.Assert(ids.calls, qt.Equals, 2)
+}
+
+func TestIdentityCacheBoundedByMacaroonExpiry(t *testing.T) {
+	c := qt.New(t)
+	clock := &mutableClock{now: epoch}
+	ids := &countingIdService{}
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		TTL:   time.Hour,
+		Clock: clock,
+	})
+	ts := newCachingService(ids, cache)
+	m, err := ts.oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{
+		checkers.DeclaredCaveat("username", "bob"),
+		checkers.TimeBeforeCaveat(epoch.Add(time.Minute)),
+	}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	ms := macaroon.Slice{m.M()}
+
+	_, err = ts.checker.Auth(ms).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	_, err = ts.checker.Auth(ms).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids.calls, qt.Equals, 1)
+
+	clock.now = epoch.Add(time.Minute)
+	_, err = ts.checker.Auth(ms).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids.calls, qt.Equals, 2)
+}
+
+func TestIdentityCacheDoesNotCacheFailures(t *testing.T) {
+	c := qt.New(t)
+	ids := &countingIdService{
+		err: errgo.New("identity service unavailable"),
+	}
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{})
+	ts := newCachingService(ids, cache)
+	m := ts.loginMacaroon(c, "bob")
+	for i := 0; i < 2; i++ {
+		_, err := ts.checker.Auth(m).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.ErrorMatches, "could not determine identity: identity service unavailable")
+	}
+	c.Assert(ids.calls, qt.Equals, 2)
+	c.Assert(cache.Len(), qt.Equals, 0)
+
+	ids.err = nil
+	_, err := ts.checker.Auth(m).Allow(testContext, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ids.calls, qt.Equals, 3)
+	c.Assert(cache.Len(), qt.Equals, 1)
+}
+
+func TestIdentityCacheInvalidate(t *testing.T) {
+	c := qt.New(t)
+	ids := &countingIdService{}
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		Clock: &mutableClock{now: epoch},
+	})
+	ts := newCachingService(ids, cache)
+	bob := ts.loginMacaroon(c, "bob")
+	alice := ts.loginMacaroon(c, "alice")
+	allow := func(m macaroon.Slice) {
+		_, err := ts.checker.Auth(m).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil)
+	}
+	allow(bob)
+	allow(alice)
+	c.Assert(ids.calls, qt.Equals, 2)
+
+	cache.InvalidateIdentity("bob")
+	c.Assert(cache.Len(), qt.Equals, 1)
+	allow(bob)
+	allow(alice)
+	c.Assert(ids.calls, qt.Equals, 3)
+
+	cache.Invalidate()
+	c.Assert(cache.Len(), qt.Equals, 0)
+	allow(alice)
+	c.Assert(ids.calls, qt.Equals, 4)
+}
+
+func TestIdentityCacheMaxSize(t *testing.T) {
+	c := qt.New(t)
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		MaxSize: 2,
+		Clock:   &mutableClock{now: epoch},
+	})
+	ts := newCachingService(&countingIdService{}, cache)
+	for _, user := range []string{"a", "b", "c", "d"} {
+		_, err := ts.checker.Auth(ts.loginMacaroon(c, user)).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil)
+		c.Assert(cache.Len() <= 2, qt.Equals, true)
+	}
+}
+
+func TestIdentityCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := qt.New(t)
+	cache := identchecker.NewIdentityCache(identchecker.IdentityCacheParams{
+		MaxSize: 2,
+		Clock:   &mutableClock{now: epoch},
+	})
+	ids := &countingIdService{}
+	ts := newCachingService(ids, cache)
+	ms := map[string]macaroon.Slice{
+		"a": ts.loginMacaroon(c, "a"),
+		"b": ts.loginMacaroon(c, "b"),
+		"c": ts.loginMacaroon(c, "c"),
+	}
+	for i, user := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err := ts.checker.Auth(ms[user]).Allow(testContext, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil, qt.Commentf("request %d", i))
+	}
+	// Adding c evicts b, which is less recently used than a,
+	// so only b is looked up a second time.
+	c.Assert(ids.calls, qt.Equals, 4)
+	c.Assert(cache.Len(), qt.Equals, 2)
+}
+
+func newCachingService(ids identchecker.IdentityClient, cache *identchecker.IdentityCache) *service {
+	oven := newMacaroonStore(mustGenerateKey(), make(dischargerLocator))
+	return &service{
+		checker: identchecker.NewChecker(identchecker.CheckerParams{
+			Checker:          testChecker,
+			IdentityClient:   ids,
+			IdentityCache:    cache,
+			MacaroonVerifier: oven,
+		}),
+		oven: oven,
+	}
+}
+
+// loginMacaroon returns a login macaroon declaring the given username.
+func (svc *service) loginMacaroon(c *qt.C, username string) macaroon.Slice {
+	m, err := svc.oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{
+		checkers.DeclaredCaveat("username", username),
+	}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	return macaroon.Slice{m.M()}
+}
+
+// countingIdService is an IdentityClient that counts
+// calls to DeclaredIdentity.
+type countingIdService struct {
+	calls int
+	err   error
+}
+
+func (ids *countingIdService) IdentityFromContext(ctx context.Context) (identchecker.Identity, []checkers.Caveat, error) {
+	return nil, nil, nil
+}
+
+func (ids *countingIdService) DeclaredIdentity(ctx context.Context, declared map[string]string) (identchecker.Identity, error) {
+	ids.calls++
+	if ids.err != nil {
+		return nil, ids.err
+	}
+	return identchecker.SimpleIdentity(declared["username"]), nil
+}
@@ -0,0 +1,180 @@
+package identchecker
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Directory represents an LDAP-style directory service. It is
+// deliberately small so that it can be implemented on top of any LDAP
+// client library, or by a local fake in tests.
+type Directory interface {
+	// Search returns the entries at or below req.BaseDN that match
+	// req.Filter, which is in the string form defined by RFC 4515.
+	// Each returned entry should hold at least the requested
+	// attributes that are present.
+	Search(ctx context.Context, req DirectorySearchRequest) ([]DirectoryEntry, error)
+}
+
+// DirectorySearchRequest holds the parameters of a directory search.
+type DirectorySearchRequest struct {
+	// BaseDN holds the distinguished name of the
+	// entry at which to start the search.
+	BaseDN string
+
+	// Filter holds the search filter.
+	Filter string
+
+	// Attributes holds the attributes to return.
+	Attributes []string
+}
+
+// DirectoryEntry holds an entry returned by a directory search.
+type DirectoryEntry struct {
+	// DN holds the distinguished name of the entry.
+	DN string
+
+	// Attributes holds the values of the entry's
+	// attributes, keyed by attribute name.
+	Attributes map[string][]string
+}
+
+// LDAPGroupResolverParams holds parameters for NewLDAPGroupResolver.
+type LDAPGroupResolverParams struct {
+	// Directory holds the directory to search.
+	Directory Directory
+
+	// UserBaseDN holds the distinguished name of the entry under
+	// which user entries are held. The DN of a user is formed by
+	// prefixing it with UserAttribute=id, where id is the user's id.
+	UserBaseDN string
+
+	// GroupBaseDN holds the distinguished name of the entry under
+	// which group entries are held. The DN of a group is formed by
+	// prefixing it with GroupAttribute=name.
+	GroupBaseDN string
+
+	// UserAttribute holds the naming attribute of user entries.
+	// If it is empty, "uid" will be used.
+	UserAttribute string
+
+	// GroupAttribute holds the naming attribute of group entries,
+	// which also holds the group's name. If it is empty, "cn" will
+	// be used.
+	GroupAttribute string
+
+	// MemberAttribute holds the attribute of group entries that
+	// holds the DNs of the group's members. If it is empty, "member"
+	// will be used.
+	MemberAttribute string
+}
+
+// LDAPGroupResolver is a GroupResolver that finds group membership
+// by searching a Directory for group entries that name a user or group
+// as a member, as with the groupOfNames object class.
+type LDAPGroupResolver struct {
+	p LDAPGroupResolverParams
+}
+
+var _ GroupResolver = (*LDAPGroupResolver)(nil)
+
+// NewLDAPGroupResolver returns a new LDAPGroupResolver
+// using the given parameters.
+func NewLDAPGroupResolver(p LDAPGroupResolverParams) *LDAPGroupResolver {
+	if p.UserAttribute == "" {
+		p.UserAttribute = "uid"
+	}
+	if p.GroupAttribute == "" {
+		p.GroupAttribute = "cn"
+	}
+	if p.MemberAttribute == "" {
+		p.MemberAttribute = "member"
+	}
+	return &LDAPGroupResolver{
+		p: p,
+	}
+}
+
+// UserGroups implements GroupResolver.UserGroups.
+func (r *LDAPGroupResolver) UserGroups(ctx context.Context, id Identity) ([]string, error) {
+	groups, err := r.groupsWithMember(ctx, joinDN(r.p.UserAttribute, id.Id(), r.p.UserBaseDN))
+	return groups, errgo.Mask(err)
+}
+
+// ParentGroups implements GroupResolver.ParentGroups.
+func (r *LDAPGroupResolver) ParentGroups(ctx context.Context, group string) ([]string, error) {
+	groups, err := r.groupsWithMember(ctx, joinDN(r.p.GroupAttribute, group, r.p.GroupBaseDN))
+	return groups, errgo.Mask(err)
+}
+
+// groupsWithMember returns the names of the groups
+// that have the given DN as a member.
+func (r *LDAPGroupResolver) groupsWithMember(ctx context.Context, dn string) ([]string, error) {
+	entries, err := r.p.Directory.Search(ctx, DirectorySearchRequest{
+		BaseDN:     r.p.GroupBaseDN,
+		Filter:     "(" + r.p.MemberAttribute + "=" + escapeFilterValue(dn) + ")",
+		Attributes: []string{r.p.GroupAttribute},
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot search directory")
+	}
+	groups := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names := e.Attributes[r.p.GroupAttribute]
+		if len(names) == 0 {
+			return nil, errgo.Newf("group entry %q has no %s attribute", e.DN, r.p.GroupAttribute)
+		}
+		groups = append(groups, names[0])
+	}
+	return groups, nil
+}
+
+// joinDN returns the distinguished name formed by adding
+// the relative distinguished name attr=value to base.
+func joinDN(attr, value, base string) string {
+	rdn := attr + "=" + escapeDNValue(value)
+	if base == "" {
+		return rdn
+	}
+	return rdn + "," + base
+}
+
+// escapeDNValue escapes an attribute value for use
+// in a distinguished name as described by RFC 4514.
+func escapeDNValue(v string) string {
+	var buf strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(v)-1 && c == ' ':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == 0:
+			buf.WriteString(`\00`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// escapeFilterValue escapes a value for use in
+// a search filter as described by RFC 4515.
+func escapeFilterValue(v string) string {
+	var buf strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*', '(', ')', '\\', 0:
+			buf.WriteByte('\\')
+			buf.WriteByte("0123456789abcdef"[c>>4])
+			buf.WriteByte("0123456789abcdef"[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,408 @@
+package identchecker
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/reloadfile"
+)
+
+// DefaultPolicyReloadInterval holds the default interval
+// at which PolicyAuthorizer checks its file for changes.
+const DefaultPolicyReloadInterval = 10 * time.Second
+
+// Policy holds a set of authorization rules, as read from a policy
+// file by ParsePolicy. It implements Authorizer.
+//
+// A policy file is written in YAML (or JSON) and holds a list of
+// rules, for example:
+//
+//	rules:
+//	- name: public-docs
+//	  entities: ["doc-public-*"]
+//	  actions: [read]
+//	  acl: [everyone]
+//	- name: editors
+//	  entity-prefixes: ["doc-"]
+//	  actions: [read, write]
+//	  acl: [editors]
+//	  caveats:
+//	  - location: https://mfa.example.com
+//	    condition: recent-login
+//	- name: no-secrets
+//	  entity-prefixes: ["doc-secret-"]
+//	  effect: deny
+//	  acl: [contractors]
+//	  priority: 10
+//
+// A rule applies to an operation when the operation's entity matches
+// any of its entity patterns (using path.Match) or starts with any of
+// its entity prefixes, and its action is one of the rule's actions. A
+// rule with no entity patterns or prefixes applies to all entities; a
+// rule with no actions, or with the action "*", applies to all actions.
+//
+// A rule applies to an identity when its ACL contains the group
+// "everyone" (see Everyone), which includes unauthenticated users, or
+// when the identity implements ACLIdentity and its Allow method returns
+// true for the ACL.
+//
+// The effect of a rule is either "allow" (the default) or "deny". Of
+// the rules that apply to an operation and identity, the ones with the
+// highest priority take precedence, and at equal priority a deny rule
+// takes precedence over an allow rule. An operation is denied when no
+// rule applies to it.
+//
+// When an operation is allowed, the caveats of the first of the deciding
+// allow rules are returned. A caveat with a location is a third party
+// caveat addressed to that location; otherwise it is a first party
+// caveat, optionally in the given namespace.
+type Policy struct {
+	rules []policyRule
+}
+
+var _ Authorizer = (*Policy)(nil)
+
+// policyFile defines the format of a policy file.
+type policyFile struct {
+	Rules []policyFileRule `yaml:"rules"`
+}
+
+type policyFileRule struct {
+	Name           string             `yaml:"name"`
+	Entities       []string           `yaml:"entities"`
+	EntityPrefixes []string           `yaml:"entity-prefixes"`
+	Actions        []string           `yaml:"actions"`
+	ACL            []string           `yaml:"acl"`
+	Effect         string             `yaml:"effect"`
+	Priority       int                `yaml:"priority"`
+	Caveats        []policyFileCaveat `yaml:"caveats"`
+}
+
+type policyFileCaveat struct {
+	Location  string `yaml:"location"`
+	Namespace string `yaml:"namespace"`
+	Condition string `yaml:"condition"`
+}
+
+// policyRule holds a parsed policy rule.
+type policyRule struct {
+	name           string
+	entities       []string
+	entityPrefixes []string
+	// actions holds the set of actions the rule applies to.
+	// It is nil if the rule applies to all actions.
+	actions  map[string]bool
+	acl      []string
+	public   bool
+	deny     bool
+	priority int
+	caveats  []checkers.Caveat
+}
+
+// PolicyDecision describes the decision made by a Policy
+// for a single operation.
+type PolicyDecision struct {
+	// Allowed holds whether the operation is allowed.
+	Allowed bool
+
+	// Rule holds the index of the deciding rule in the policy
+	// file, or -1 if no rule applied.
+	Rule int
+
+	// RuleName holds the name of the deciding rule.
+	// If the rule has no name, it holds a name derived
+	// from its index, such as "rule 2".
+	RuleName string
+
+	// Caveats holds the caveats that apply when
+	// the operation is allowed.
+	Caveats []checkers.Caveat
+}
+
+// ParsePolicy parses the contents of a policy file. See Policy
+// for the file format.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var f policyFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	p := &Policy{
+		rules: make([]policyRule, len(f.Rules)),
+	}
+	for i, r := range f.Rules {
+		rule, err := parsePolicyRule(r)
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = ruleName(i)
+			}
+			return nil, errgo.Notef(err, "invalid %s", name)
+		}
+		if rule.name == "" {
+			rule.name = ruleName(i)
+		}
+		p.rules[i] = rule
+	}
+	return p, nil
+}
+
+func parsePolicyRule(r policyFileRule) (policyRule, error) {
+	rule := policyRule{
+		name:           r.Name,
+		entities:       r.Entities,
+		entityPrefixes: r.EntityPrefixes,
+		acl:            r.ACL,
+		public:         isPublicACL(r.ACL),
+		priority:       r.Priority,
+	}
+	for _, pattern := range r.Entities {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return policyRule{}, errgo.Newf("bad entity pattern %q", pattern)
+		}
+	}
+	for _, a := range r.Actions {
+		if a == "" {
+			return policyRule{}, errgo.New("empty action")
+		}
+		if a == "*" {
+			rule.actions = nil
+			break
+		}
+		if rule.actions == nil {
+			rule.actions = make(map[string]bool)
+		}
+		rule.actions[a] = true
+	}
+	if len(r.ACL) == 0 {
+		return policyRule{}, errgo.New("no ACL")
+	}
+	switch r.Effect {
+	case "", "allow":
+	case "deny":
+		rule.deny = true
+	default:
+		return policyRule{}, errgo.Newf("unknown effect %q", r.Effect)
+	}
+	if rule.deny && len(r.Caveats) > 0 {
+		return policyRule{}, errgo.New("caveats specified on deny rule")
+	}
+	for _, c := range r.Caveats {
+		if c.Condition == "" {
+			return policyRule{}, errgo.New("caveat with no condition")
+		}
+		if c.Location != "" && c.Namespace != "" {
+			return policyRule{}, errgo.Newf("caveat %q has both location and namespace", c.Condition)
+		}
+		rule.caveats = append(rule.caveats, checkers.Caveat{
+			Location:  c.Location,
+			Namespace: c.Namespace,
+			Condition: c.Condition,
+		})
+	}
+	return rule, nil
+}
+
+func ruleName(i int) string {
+	return "rule " + strconv.Itoa(i)
+}
+
+// Authorize implements Authorizer.Authorize by calling p.Decide
+// for each operation.
+func (p *Policy) Authorize(ctx context.Context, ident Identity, ops []bakery.Op) (allowed []bool, caveats []checkers.Caveat, err error) {
+	if len(ops) == 0 {
+		return nil, nil, nil
+	}
+	allowed = make([]bool, len(ops))
+	for i, op := range ops {
+		d, err := p.Decide(ctx, ident, op)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		allowed[i] = d.Allowed
+		if d.Allowed {
+			caveats = appendNewCaveats(caveats, d.Caveats)
+		}
+	}
+	return allowed, caveats, nil
+}
+
+// Decide returns the decision made by the policy for the given
+// identity, which may be nil, and operation.
+func (p *Policy) Decide(ctx context.Context, ident Identity, op bakery.Op) (PolicyDecision, error) {
+	d := PolicyDecision{
+		Rule: -1,
+	}
+	aclIdent, _ := ident.(ACLIdentity)
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !r.appliesToOp(op) {
+			continue
+		}
+		if d.Rule != -1 && !r.takesPrecedence(&p.rules[d.Rule]) {
+			// The rule cannot change the decision, so
+			// avoid checking the identity against it.
+			continue
+		}
+		ok, err := r.appliesToIdentity(ctx, aclIdent)
+		if err != nil {
+			return PolicyDecision{}, errgo.Notef(err, "cannot check permissions")
+		}
+		if ok {
+			d.Rule = i
+		}
+	}
+	if d.Rule == -1 {
+		return d, nil
+	}
+	r := &p.rules[d.Rule]
+	d.RuleName = r.name
+	d.Allowed = !r.deny
+	if d.Allowed {
+		d.Caveats = r.caveats
+	}
+	return d, nil
+}
+
+// appliesToOp reports whether the rule applies to the given operation.
+func (r *policyRule) appliesToOp(op bakery.Op) bool {
+	if r.actions != nil && !r.actions[op.Action] {
+		return false
+	}
+	if len(r.entities) == 0 && len(r.entityPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range r.entityPrefixes {
+		if strings.HasPrefix(op.Entity, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range r.entities {
+		// The pattern has been checked when parsing,
+		// so the error can be ignored.
+		if ok, _ := path.Match(pattern, op.Entity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesToIdentity reports whether the rule applies to the given
+// identity, which is nil for unauthenticated users and for identities
+// that do not implement ACLIdentity.
+func (r *policyRule) appliesToIdentity(ctx context.Context, ident ACLIdentity) (bool, error) {
+	if r.public {
+		return true, nil
+	}
+	if ident == nil {
+		return false, nil
+	}
+	return ident.Allow(ctx, r.acl)
+}
+
+// takesPrecedence reports whether r takes precedence over r1,
+// which occurs earlier in the policy.
+func (r *policyRule) takesPrecedence(r1 *policyRule) bool {
+	if r.priority != r1.priority {
+		return r.priority > r1.priority
+	}
+	return r.deny && !r1.deny
+}
+
+// appendNewCaveats returns caveats with any of the new caveats
+// that it does not already contain appended.
+func appendNewCaveats(caveats, newCaveats []checkers.Caveat) []checkers.Caveat {
+outer:
+	for _, c := range newCaveats {
+		for _, c1 := range caveats {
+			if c == c1 {
+				continue outer
+			}
+		}
+		caveats = append(caveats, c)
+	}
+	return caveats
+}
+
+// PolicyAuthorizerParams holds parameters for NewPolicyAuthorizer.
+type PolicyAuthorizerParams struct {
+	// Path holds the path of the policy file to read.
+	// See Policy for the file format.
+	Path string
+
+	// ReloadInterval holds the minimum interval between checks for
+	// changes to the file. If it is zero, DefaultPolicyReloadInterval
+	// will be used. If it is negative, the file will only be read
+	// again when Reload is called.
+	ReloadInterval time.Duration
+
+	// Logger is used to log failures to reload the file.
+	// If it is nil, nothing will be logged.
+	Logger bakery.Logger
+}
+
+// PolicyAuthorizer is an Authorizer that authorizes operations
+// according to a Policy read from a file.
+//
+// The file is checked for changes as it is used, at most once per
+// reload interval, so changes take effect without a restart. If the
+// changed file cannot be read or is invalid, the previous policy
+// continues to be used.
+type PolicyAuthorizer struct {
+	path   string
+	logger bakery.Logger
+	file   *reloadfile.File
+}
+
+var _ Authorizer = (*PolicyAuthorizer)(nil)
+
+// NewPolicyAuthorizer returns a new PolicyAuthorizer that reads the
+// policy file specified in p. It returns an error if the file cannot be
+// read or is invalid.
+func NewPolicyAuthorizer(p PolicyAuthorizerParams) (*PolicyAuthorizer, error) {
+	if p.ReloadInterval == 0 {
+		p.ReloadInterval = DefaultPolicyReloadInterval
+	}
+	file, err := reloadfile.New(p.Path, p.ReloadInterval, func(data []byte) (interface{}, error) {
+		return ParsePolicy(data)
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &PolicyAuthorizer{
+		path:   p.Path,
+		logger: p.Logger,
+		file:   file,
+	}, nil
+}
+
+// Reload reads the policy file again. If the file cannot be read
+// or is invalid, it returns an error and the previous policy
+// continues to be used.
+func (a *PolicyAuthorizer) Reload() error {
+	return errgo.Mask(a.file.Reload())
+}
+
+// Policy returns the policy currently in use, first checking
+// the file for changes if the reload interval has passed.
+func (a *PolicyAuthorizer) Policy(ctx context.Context) *Policy {
+	policy, err := a.file.Value()
+	if err != nil {
+		bakery.Log(ctx, a.logger, bakery.LevelWarn, "cannot reload policy", bakery.Field("path", a.path), bakery.ErrorField(err))
+	}
+	return policy.(*Policy)
+}
+
+// Authorize implements Authorizer.Authorize by authorizing
+// the operations with the current policy.
+func (a *PolicyAuthorizer) Authorize(ctx context.Context, ident Identity, ops []bakery.Op) (allowed []bool, caveats []checkers.Caveat, err error) {
+	allowed, caveats, err = a.Policy(ctx).Authorize(ctx, ident, ops)
+	return allowed, caveats, errgo.Mask(err)
+}
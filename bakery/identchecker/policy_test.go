@@ -0,0 +1,257 @@
+package identchecker_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+const testPolicy = `
+rules:
+- name: public-docs
+  entities: ["doc-public-*"]
+  actions: [read]
+  acl: [everyone]
+- name: editors
+  entity-prefixes: ["doc-"]
+  actions: [read, write]
+  acl: [editors]
+  caveats:
+  - location: https://mfa.example.com
+    condition: recent-login
+- name: no-secrets
+  entity-prefixes: ["doc-secret-"]
+  effect: deny
+  acl: [contractors]
+  priority: 10
+- entities: ["admin/*"]
+  acl: [admins]
+`
+
+var policyTests = []struct {
+	about         string
+	identity      identchecker.Identity
+	op            bakery.Op
+	expectAllowed bool
+	expectRule    string
+	expectCaveats []checkers.Caveat
+}{{
+	about:         "public entity with no identity",
+	op:            bakery.Op{"doc-public-1", "read"},
+	expectAllowed: true,
+	expectRule:    "public-docs",
+}, {
+	about:      "public entity with wrong action",
+	op:         bakery.Op{"doc-public-1", "write"},
+	expectRule: "",
+}, {
+	about:         "editor allowed with caveats",
+	identity:      groupIdentity{"bob", []string{"editors"}},
+	op:            bakery.Op{"doc-1", "write"},
+	expectAllowed: true,
+	expectRule:    "editors",
+	expectCaveats: []checkers.Caveat{{
+		Location:  "https://mfa.example.com",
+		Condition: "recent-login",
+	}},
+}, {
+	about:    "non-editor not allowed",
+	identity: groupIdentity{"alice", []string{"readers"}},
+	op:       bakery.Op{"doc-1", "write"},
+}, {
+	about:         "earlier rule at equal priority wins",
+	identity:      groupIdentity{"bob", []string{"editors"}},
+	op:            bakery.Op{"doc-public-1", "read"},
+	expectAllowed: true,
+	expectRule:    "public-docs",
+}, {
+	about:      "deny rule with higher priority",
+	identity:   groupIdentity{"bob", []string{"editors", "contractors"}},
+	op:         bakery.Op{"doc-secret-1", "read"},
+	expectRule: "no-secrets",
+}, {
+	about:         "deny rule does not apply to other identities",
+	identity:      groupIdentity{"bob", []string{"editors"}},
+	op:            bakery.Op{"doc-secret-1", "read"},
+	expectAllowed: true,
+	expectRule:    "editors",
+	expectCaveats: []checkers.Caveat{{
+		Location:  "https://mfa.example.com",
+		Condition: "recent-login",
+	}},
+}, {
+	about:         "unnamed rule matching all actions",
+	identity:      groupIdentity{"root", []string{"admins"}},
+	op:            bakery.Op{"admin/users", "delete"},
+	expectAllowed: true,
+	expectRule:    "rule 3",
+}, {
+	about:    "glob does not match path separator",
+	identity: groupIdentity{"root", []string{"admins"}},
+	op:       bakery.Op{"admin/users/x", "delete"},
+}, {
+	about:    "identity without group information",
+	identity: simplestIdentity("bob"),
+	op:       bakery.Op{"doc-1", "read"},
+}}
+
+func TestPolicyDecide(t *testing.T) {
+	c := qt.New(t)
+	p, err := identchecker.ParsePolicy([]byte(testPolicy))
+	c.Assert(err, qt.IsNil)
+	for _, test := range policyTests {
+		c.Run(test.about, func(c *qt.C) {
+			d, err := p.Decide(testContext, test.identity, test.op)
+			c.Assert(err, qt.IsNil)
+			c.Assert(d.Allowed, qt.Equals, test.expectAllowed)
+			c.Assert(d.RuleName, qt.Equals, test.expectRule)
+			c.Assert(d.Caveats, qt.DeepEquals, test.expectCaveats)
+		})
+	}
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	c := qt.New(t)
+	p, err := identchecker.ParsePolicy([]byte(testPolicy))
+	c.Assert(err, qt.IsNil)
+	allowed, caveats, err := p.Authorize(testContext, groupIdentity{"bob", []string{"editors"}}, []bakery.Op{
+		{"doc-1", "read"},
+		{"doc-2", "write"},
+		{"admin/users", "read"},
+		{"doc-public-1", "read"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, true, false, true})
+	c.Assert(caveats, qt.DeepEquals, []checkers.Caveat{{
+		Location:  "https://mfa.example.com",
+		Condition: "recent-login",
+	}})
+}
+
+var parsePolicyErrorTests = []struct {
+	about       string
+	policy      string
+	expectError string
+}{{
+	about:       "unknown field",
+	policy:      "rules:\n- acl: [a]\n  entity: x\n",
+	expectError: `(?s)yaml: unmarshal errors:.*field entity not found.*`,
+}, {
+	about:       "bad pattern",
+	policy:      "rules:\n- acl: [a]\n  entities: ['[']\n",
+	expectError: `invalid rule 0: bad entity pattern "\["`,
+}, {
+	about:       "no ACL",
+	policy:      "rules:\n- name: foo\n  entities: [x]\n",
+	expectError: `invalid foo: no ACL`,
+}, {
+	about:       "unknown effect",
+	policy:      "rules:\n- acl: [a]\n- acl: [a]\n  effect: maybe\n",
+	expectError: `invalid rule 1: unknown effect "maybe"`,
+}, {
+	about:       "caveats on deny rule",
+	policy:      "rules:\n- acl: [a]\n  effect: deny\n  caveats:\n  - condition: x\n",
+	expectError: `invalid rule 0: caveats specified on deny rule`,
+}, {
+	about:       "caveat without condition",
+	policy:      "rules:\n- acl: [a]\n  caveats:\n  - location: x\n",
+	expectError: `invalid rule 0: caveat with no condition`,
+}}
+
+func TestParsePolicyError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parsePolicyErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			p, err := identchecker.ParsePolicy([]byte(test.policy))
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(p, qt.IsNil)
+		})
+	}
+}
+
+func TestPolicyJSON(t *testing.T) {
+	c := qt.New(t)
+	p, err := identchecker.ParsePolicy([]byte(`{"rules": [{"entities": ["x"], "acl": ["everyone"]}]}`))
+	c.Assert(err, qt.IsNil)
+	allowed, _, err := p.Authorize(testContext, nil, []bakery.Op{{"x", "read"}, {"y", "read"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, false})
+}
+
+func TestPolicyAuthorizerReload(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.Mkdir(), "policy.yaml")
+	err := ioutil.WriteFile(path, []byte("rules:\n- entities: [x]\n  acl: [everyone]\n"), 0666)
+	c.Assert(err, qt.IsNil)
+
+	a, err := identchecker.NewPolicyAuthorizer(identchecker.PolicyAuthorizerParams{
+		Path:           path,
+		ReloadInterval: time.Millisecond,
+	})
+	c.Assert(err, qt.IsNil)
+	ops := []bakery.Op{{"x", "read"}, {"y", "read"}}
+	allowed, _, err := a.Authorize(testContext, nil, ops)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{true, false})
+
+	err = ioutil.WriteFile(path, []byte("rules:\n- entities: [y]\n  acl: [everyone]\n"), 0666)
+	c.Assert(err, qt.IsNil)
+	time.Sleep(2 * time.Millisecond)
+	allowed, _, err = a.Authorize(testContext, nil, ops)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{false, true})
+
+	// An invalid file leaves the previous policy in place.
+	err = ioutil.WriteFile(path, []byte("rules:\n- entities: [x]\n"), 0666)
+	c.Assert(err, qt.IsNil)
+	time.Sleep(2 * time.Millisecond)
+	allowed, _, err = a.Authorize(testContext, nil, ops)
+	c.Assert(err, qt.IsNil)
+	c.Assert(allowed, qt.DeepEquals, []bool{false, true})
+	c.Assert(a.Reload(), qt.ErrorMatches, `cannot parse ".*": invalid rule 0: no ACL`)
+}
+
+func TestNewPolicyAuthorizerError(t *testing.T) {
+	c := qt.New(t)
+	_, err := identchecker.NewPolicyAuthorizer(identchecker.PolicyAuthorizerParams{
+		Path: filepath.Join(c.Mkdir(), "nonexistent"),
+	})
+	c.Assert(err, qt.ErrorMatches, `open .*: no such file or directory`)
+}
+
+// groupIdentity implements identchecker.ACLIdentity
+// for a user that is a member of the given groups.
+type groupIdentity struct {
+	id     string
+	groups []string
+}
+
+func (id groupIdentity) Id() string {
+	return id.id
+}
+
+func (id groupIdentity) Domain() string {
+	return ""
+}
+
+func (id groupIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, g := range acl {
+		if g == id.id {
+			return true, nil
+		}
+		for _, g1 := range id.groups {
+			if g == g1 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,68 @@
+package bakery
+
+import (
+	"context"
+
+	"golang.org/x/crypto/nacl/box"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrCannotOpenBox is returned as the cause of the error from
+// KeyOpener.OpenBox when the sealed data cannot be authenticated.
+var ErrCannotOpenBox = errgo.New("cannot open box")
+
+// KeyOpener performs the operations that require a bakery private key.
+// It allows the private key to be held elsewhere, for example in a
+// separate signing process, rather than in memory as a KeyPair.
+//
+// *KeyPair implements KeyOpener using its private key directly.
+type KeyOpener interface {
+	// PublicKey returns the public key corresponding
+	// to the private key.
+	PublicKey() *PublicKey
+
+	// OpenBox decrypts and authenticates data that was sealed with
+	// NaCl box using the given nonce by the holder of the private
+	// key corresponding to peer. If the data cannot be
+	// authenticated, it returns an error with an ErrCannotOpenBox
+	// cause.
+	OpenBox(ctx context.Context, peer *PublicKey, nonce *[NonceLen]byte, sealed []byte) ([]byte, error)
+
+	// SharedKey returns the NaCl box shared key between the private
+	// key and the given peer public key, as computed by
+	// box.Precompute. It is used to encrypt third party caveats
+	// for the peer.
+	SharedKey(ctx context.Context, peer *PublicKey) (*[KeyLen]byte, error)
+}
+
+var _ KeyOpener = (*KeyPair)(nil)
+
+// PublicKey implements KeyOpener.PublicKey.
+func (key *KeyPair) PublicKey() *PublicKey {
+	return &key.Public
+}
+
+// OpenBox implements KeyOpener.OpenBox.
+func (key *KeyPair) OpenBox(ctx context.Context, peer *PublicKey, nonce *[NonceLen]byte, sealed []byte) ([]byte, error) {
+	data, ok := box.Open(nil, sealed, nonce, peer.boxKey(), key.Private.boxKey())
+	if !ok {
+		return nil, errgo.WithCausef(nil, ErrCannotOpenBox, "")
+	}
+	return data, nil
+}
+
+// SharedKey implements KeyOpener.SharedKey.
+func (key *KeyPair) SharedKey(ctx context.Context, peer *PublicKey) (*[KeyLen]byte, error) {
+	var shared [KeyLen]byte
+	box.Precompute(&shared, peer.boxKey(), key.Private.boxKey())
+	return &shared, nil
+}
+
+// keyPairOf returns the key pair held by the given opener if it is a
+// *KeyPair, or the zero KeyPair otherwise.
+func keyPairOf(key KeyOpener) KeyPair {
+	if kp, ok := key.(*KeyPair); ok {
+		return *kp
+	}
+	return KeyPair{}
+}
@@ -0,0 +1,209 @@
+// Package keyserver provides a reference implementation of a
+// bakery.KeyOpener that keeps the private key in a separate process,
+// which is reached over a local socket (normally a Unix domain socket).
+//
+// The protocol is JSON-RPC 1.0 as implemented by net/rpc/jsonrpc,
+// with the service name "KeyServer". It is intended mainly for tests
+// and as an example for other implementations; access to the socket
+// must be restricted (for example by file permissions) because anyone
+// who can connect to it can decrypt third party caveats addressed to
+// the key.
+package keyserver
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// serviceName holds the name of the RPC service.
+const serviceName = "KeyServer"
+
+// errCannotOpenBox holds the text of the RPC error returned when
+// sealed data cannot be authenticated.
+const errCannotOpenBox = "cannot open box"
+
+// Server serves key operations for a private key.
+type Server struct {
+	rpc *rpc.Server
+}
+
+// NewServer returns a new server that performs operations
+// with the given key.
+func NewServer(key *bakery.KeyPair) *Server {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, &service{key: key}); err != nil {
+		panic(err)
+	}
+	return &Server{
+		rpc: srv,
+	}
+}
+
+// Serve accepts connections on l, serving each one in a separate
+// goroutine. It returns when l.Accept returns an error,
+// for example because l has been closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		go s.rpc.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// OpenBoxArgs holds the arguments to the KeyServer.OpenBox method.
+type OpenBoxArgs struct {
+	Peer   *bakery.PublicKey
+	Nonce  []byte
+	Sealed []byte
+}
+
+// SharedKeyArgs holds the arguments to the KeyServer.SharedKey method.
+type SharedKeyArgs struct {
+	Peer *bakery.PublicKey
+}
+
+// service defines the RPC methods.
+type service struct {
+	key *bakery.KeyPair
+}
+
+// PublicKey returns the public key.
+func (s *service) PublicKey(_ struct{}, reply *bakery.PublicKey) error {
+	*reply = s.key.Public
+	return nil
+}
+
+// OpenBox opens sealed data.
+func (s *service) OpenBox(args OpenBoxArgs, reply *[]byte) error {
+	if args.Peer == nil {
+		return errgo.Newf("no peer public key")
+	}
+	var nonce [bakery.NonceLen]byte
+	if len(args.Nonce) != len(nonce) {
+		return errgo.Newf("invalid nonce length %d", len(args.Nonce))
+	}
+	copy(nonce[:], args.Nonce)
+	data, err := s.key.OpenBox(context.Background(), args.Peer, &nonce, args.Sealed)
+	if err != nil {
+		if errgo.Cause(err) == bakery.ErrCannotOpenBox {
+			return errgo.New(errCannotOpenBox)
+		}
+		return errgo.Mask(err)
+	}
+	*reply = data
+	return nil
+}
+
+// SharedKey computes a shared key.
+func (s *service) SharedKey(args SharedKeyArgs, reply *[]byte) error {
+	if args.Peer == nil {
+		return errgo.Newf("no peer public key")
+	}
+	key, err := s.key.SharedKey(context.Background(), args.Peer)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	*reply = key[:]
+	return nil
+}
+
+// Client implements bakery.KeyOpener by making calls to a Server.
+type Client struct {
+	rpc       *rpc.Client
+	publicKey bakery.PublicKey
+
+	mu         sync.Mutex
+	sharedKeys map[bakery.PublicKey]*[bakery.KeyLen]byte
+}
+
+var _ bakery.KeyOpener = (*Client)(nil)
+
+// Dial connects to the server listening on the given
+// network address (for example "unix" and a socket path).
+func Dial(ctx context.Context, network, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot connect to key server")
+	}
+	c := &Client{
+		rpc:        jsonrpc.NewClient(conn),
+		sharedKeys: make(map[bakery.PublicKey]*[bakery.KeyLen]byte),
+	}
+	if err := c.call(ctx, "PublicKey", struct{}{}, &c.publicKey); err != nil {
+		c.Close()
+		return nil, errgo.Notef(err, "cannot get public key")
+	}
+	return c, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// PublicKey implements bakery.KeyOpener.PublicKey.
+func (c *Client) PublicKey() *bakery.PublicKey {
+	return &c.publicKey
+}
+
+// OpenBox implements bakery.KeyOpener.OpenBox.
+func (c *Client) OpenBox(ctx context.Context, peer *bakery.PublicKey, nonce *[bakery.NonceLen]byte, sealed []byte) ([]byte, error) {
+	var data []byte
+	err := c.call(ctx, "OpenBox", OpenBoxArgs{
+		Peer:   peer,
+		Nonce:  nonce[:],
+		Sealed: sealed,
+	}, &data)
+	if err != nil {
+		if rerr, ok := err.(rpc.ServerError); ok && string(rerr) == errCannotOpenBox {
+			return nil, errgo.WithCausef(nil, bakery.ErrCannotOpenBox, "")
+		}
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+// SharedKey implements bakery.KeyOpener.SharedKey. Shared keys are
+// cached, so the server is only asked once for each peer.
+func (c *Client) SharedKey(ctx context.Context, peer *bakery.PublicKey) (*[bakery.KeyLen]byte, error) {
+	c.mu.Lock()
+	key, ok := c.sharedKeys[*peer]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	var data []byte
+	if err := c.call(ctx, "SharedKey", SharedKeyArgs{Peer: peer}, &data); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	key = new([bakery.KeyLen]byte)
+	if copy(key[:], data) != len(key) {
+		return nil, errgo.Newf("shared key has unexpected length %d", len(data))
+	}
+	c.mu.Lock()
+	c.sharedKeys[*peer] = key
+	c.mu.Unlock()
+	return key, nil
+}
+
+// call calls the given method, returning early
+// if the context is done.
+func (c *Client) call(ctx context.Context, method string, args, reply interface{}) error {
+	call := c.rpc.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return errgo.Mask(ctx.Err(), errgo.Any)
+	}
+}
@@ -0,0 +1,137 @@
+package keyserver_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/keyserver"
+)
+
+var testOp = bakery.Op{
+	Entity: "something",
+	Action: "read",
+}
+
+func TestDischargeWithKeyServer(t *testing.T) {
+	c := qt.New(t)
+	dischargerKey := bakery.MustGenerateKey()
+	client := newClient(c, dischargerKey)
+	c.Assert(*client.PublicKey(), qt.Equals, dischargerKey.Public)
+
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo("as-loc", bakery.ThirdPartyInfo{
+		PublicKey: dischargerKey.Public,
+		Version:   bakery.LatestVersion,
+	})
+	ts := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: locator,
+	})
+	checker := bakery.ThirdPartyCaveatCheckerFunc(func(ctx context.Context, info *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+		c.Assert(info.ThirdPartyPublicKey, qt.Equals, dischargerKey.Public)
+		// The private key is held by the key server, so
+		// no key pair is available.
+		c.Assert(info.ThirdPartyKeyPair, qt.DeepEquals, bakery.KeyPair{})
+		switch string(info.Condition) {
+		case "first":
+			// Return a third party caveat so that the key server
+			// is used to encrypt a caveat as well as to decrypt one.
+			return []checkers.Caveat{{
+				Location:  "as-loc",
+				Condition: "second",
+			}}, nil
+		case "second":
+			return nil, nil
+		}
+		return nil, errgo.Newf("unexpected condition %q", info.Condition)
+	})
+	for _, v := range []bakery.Version{bakery.Version1, bakery.Version2, bakery.LatestVersion} {
+		c.Run(fmt.Sprintf("version%d", v), func(c *qt.C) {
+			m, err := ts.Oven.NewMacaroon(context.Background(), v, []checkers.Caveat{{
+				Location:  "as-loc",
+				Condition: "first",
+			}}, testOp)
+			c.Assert(err, qt.IsNil)
+			ms, err := bakery.DischargeAll(context.Background(), m, func(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+				return bakery.Discharge(ctx, bakery.DischargeParams{
+					Id:        cav.Id,
+					Caveat:    payload,
+					KeyOpener: client,
+					Checker:   checker,
+					Locator:   locator,
+				})
+			})
+			c.Assert(err, qt.IsNil)
+			c.Assert(ms, qt.HasLen, 3)
+			_, err = ts.Checker.Auth(ms).Allow(context.Background(), testOp)
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestLocalDischargeWithKeyServer(t *testing.T) {
+	c := qt.New(t)
+	clientKey := bakery.MustGenerateKey()
+	client := newClient(c, clientKey)
+	ts := bakery.New(bakery.BakeryParams{
+		Key: bakery.MustGenerateKey(),
+	})
+	m, err := ts.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{
+		bakery.LocalThirdPartyCaveat(&clientKey.Public, bakery.LatestVersion),
+	}, testOp)
+	c.Assert(err, qt.IsNil)
+	ms, err := bakery.DischargeAllWithKeyOpener(context.Background(), m, nil, client)
+	c.Assert(err, qt.IsNil)
+	_, err = ts.Checker.Auth(ms).Allow(context.Background(), testOp)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestOpenBoxFailure(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	client := newClient(c, key)
+	var nonce [bakery.NonceLen]byte
+	_, err := client.OpenBox(context.Background(), &bakery.MustGenerateKey().Public, &nonce, []byte("0123456789abcdef0123456789"))
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrCannotOpenBox)
+
+	// The in-process implementation behaves the same way.
+	_, err = key.OpenBox(context.Background(), &bakery.MustGenerateKey().Public, &nonce, []byte("0123456789abcdef0123456789"))
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrCannotOpenBox)
+
+	// Shared keys agree with the in-process implementation.
+	peer := bakery.MustGenerateKey()
+	k1, err := client.SharedKey(context.Background(), &peer.Public)
+	c.Assert(err, qt.IsNil)
+	k2, err := key.SharedKey(context.Background(), &peer.Public)
+	c.Assert(err, qt.IsNil)
+	c.Assert(k1, qt.DeepEquals, k2)
+}
+
+func TestDialError(t *testing.T) {
+	c := qt.New(t)
+	_, err := keyserver.Dial(context.Background(), "unix", filepath.Join(c.TempDir(), "nonexistent"))
+	c.Assert(err, qt.ErrorMatches, `cannot connect to key server: .*`)
+}
+
+// newClient starts a key server for the given key listening on a
+// Unix socket and returns a client connected to it.
+func newClient(c *qt.C, key *bakery.KeyPair) *keyserver.Client {
+	socket := filepath.Join(c.TempDir(), "socket")
+	l, err := net.Listen("unix", socket)
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { l.Close() })
+	go keyserver.NewServer(key).Serve(l)
+	client, err := keyserver.Dial(context.Background(), "unix", socket)
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,90 @@
+package bakery
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+)
+
+// errPublicKeyMismatch is returned as the cause of the error from
+// decodeCaveat when the caveat was not encrypted for the given key.
+var errPublicKeyMismatch = errgo.New("public key mismatch")
+
+// KeySet holds the keys of a discharger whose key is being rotated.
+// Third party caveats encrypted for any of the keys in the set can be
+// discharged, so a new key can be introduced, and an old one retired,
+// without invalidating outstanding caveats.
+//
+// A typical rotation adds the new key to Upcoming and publishes it
+// for long enough that clients learn of it, then makes it Primary and
+// moves the old primary key to Deprecated, and finally removes the
+// old key once no caveats that use it are likely to remain.
+type KeySet struct {
+	// Primary holds the key that is advertised as the discharger's
+	// public key and that is used to encrypt any third party
+	// caveats added to discharge macaroons.
+	Primary KeyOpener
+
+	// Upcoming holds keys that will become primary in the future.
+	Upcoming []KeyOpener
+
+	// Deprecated holds keys that were previously primary.
+	Deprecated []KeyOpener
+}
+
+// PublicKeys returns the public keys of all the
+// keys in the set, primary key first.
+func (ks *KeySet) PublicKeys() []*PublicKey {
+	keys := ks.all()
+	pks := make([]*PublicKey, len(keys))
+	for i, key := range keys {
+		pks[i] = key.PublicKey()
+	}
+	return pks
+}
+
+// all returns all the keys in the set, in the order in which
+// they should be tried.
+func (ks *KeySet) all() []KeyOpener {
+	keys := make([]KeyOpener, 0, 1+len(ks.Upcoming)+len(ks.Deprecated))
+	if ks.Primary != nil {
+		keys = append(keys, ks.Primary)
+	}
+	keys = append(keys, ks.Upcoming...)
+	keys = append(keys, ks.Deprecated...)
+	return keys
+}
+
+// decodeCaveat decodes the given caveat with whichever
+// key in the set it was encrypted for.
+//
+// Caveats identify their key by a short prefix of the public key, so
+// more than one key in the set may appear to match. Every such key is
+// tried, and the error from the first is returned if none succeeds.
+func (ks *KeySet) decodeCaveat(ctx context.Context, caveat []byte) (*ThirdPartyCaveatInfo, error) {
+	keys := ks.all()
+	if len(keys) == 0 {
+		return nil, errgo.Newf("no keys in key set")
+	}
+	var firstErr error
+	for _, key := range keys {
+		info, err := decodeCaveat(ctx, key, caveat)
+		if err == nil {
+			return info, nil
+		}
+		if errgo.Cause(err) != errPublicKeyMismatch && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, errgo.Mask(firstErr, errgo.Any)
+	}
+	return nil, errgo.WithCausef(nil, errPublicKeyMismatch, "")
+}
+
+// keySetOf returns a key set containing only the given key.
+func keySetOf(key KeyOpener) *KeySet {
+	return &KeySet{
+		Primary: key,
+	}
+}
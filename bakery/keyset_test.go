@@ -0,0 +1,141 @@
+package bakery_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+func TestDischargeWithKeySet(t *testing.T) {
+	c := qt.New(t)
+	oldKey := bakery.MustGenerateKey()
+	newKey := bakery.MustGenerateKey()
+	nextKey := bakery.MustGenerateKey()
+	otherKey := bakery.MustGenerateKey()
+	keys := &bakery.KeySet{
+		Primary:    newKey,
+		Upcoming:   []bakery.KeyOpener{nextKey},
+		Deprecated: []bakery.KeyOpener{oldKey},
+	}
+	c.Assert(keys.PublicKeys(), qt.DeepEquals, []*bakery.PublicKey{
+		&newKey.Public,
+		&nextKey.Public,
+		&oldKey.Public,
+	})
+	for _, test := range []struct {
+		about       string
+		key         *bakery.KeyPair
+		expectError string
+	}{{
+		about: "primary key",
+		key:   newKey,
+	}, {
+		about: "upcoming key",
+		key:   nextKey,
+	}, {
+		about: "deprecated key",
+		key:   oldKey,
+	}, {
+		about:       "unknown key",
+		key:         otherKey,
+		expectError: `cannot get discharge from "as-loc": discharger cannot decode caveat id: public key mismatch`,
+	}} {
+		c.Run(test.about, func(c *qt.C) {
+			// The target service has a locator that knows
+			// the discharger by the key being tested.
+			locator := bakery.NewThirdPartyStore()
+			locator.AddInfo("as-loc", bakery.ThirdPartyInfo{
+				PublicKey: test.key.Public,
+				Version:   bakery.LatestVersion,
+			})
+			ts := newBakery("ts-loc", locator)
+			m, err := ts.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{{
+				Location:  "as-loc",
+				Condition: "something",
+			}}, basicOp)
+			c.Assert(err, qt.IsNil)
+			ms, err := bakery.DischargeAll(testContext, m, func(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+				return bakery.Discharge(ctx, bakery.DischargeParams{
+					Id:     cav.Id,
+					Caveat: payload,
+					Keys:   keys,
+					Checker: bakery.ThirdPartyCaveatCheckerFunc(func(ctx context.Context, info *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+						c.Check(info.ThirdPartyPublicKey, qt.Equals, test.key.Public)
+						c.Check(info.ThirdPartyKeyPair, qt.DeepEquals, *test.key)
+						return nil, nil
+					}),
+				})
+			})
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			_, err = ts.Checker.Auth(ms).Allow(testContext, basicOp)
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestDischargeWithKeySetPrefixCollision(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo("as-loc", bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	ts := newBakery("ts-loc", locator)
+	m, err := ts.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{{
+		Location:  "as-loc",
+		Condition: "something",
+	}}, basicOp)
+	c.Assert(err, qt.IsNil)
+	// The primary key has the same public key prefix as the key
+	// the caveat was encrypted for but cannot open it, so the
+	// deprecated key must be tried too.
+	keys := &bakery.KeySet{
+		Primary:    collidingKey{key},
+		Deprecated: []bakery.KeyOpener{key},
+	}
+	_, err = bakery.DischargeAll(testContext, m, func(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+		return bakery.Discharge(ctx, bakery.DischargeParams{
+			Id:     cav.Id,
+			Caveat: payload,
+			Keys:   keys,
+			Checker: bakery.ThirdPartyCaveatCheckerFunc(func(ctx context.Context, info *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+				return nil, nil
+			}),
+		})
+	})
+	c.Assert(err, qt.IsNil)
+
+	// When no key can open the caveat, the error
+	// from the first matching key is returned.
+	keys.Deprecated = nil
+	_, err = bakery.DischargeAll(testContext, m, func(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+		return bakery.Discharge(ctx, bakery.DischargeParams{
+			Id:      cav.Id,
+			Caveat:  payload,
+			Keys:    keys,
+			Checker: bakery.ThirdPartyCaveatCheckerFunc(nil),
+		})
+	})
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from "as-loc": discharger cannot decode caveat id: cannot decrypt caveat id`)
+}
+
+// collidingKey is a KeyOpener that has the public key of another
+// key but cannot open any box, simulating a key whose public key
+// shares a prefix with another.
+type collidingKey struct {
+	*bakery.KeyPair
+}
+
+func (k collidingKey) OpenBox(ctx context.Context, theirPublicKey *bakery.PublicKey, nonce *[bakery.NonceLen]byte, data []byte) ([]byte, error) {
+	return nil, bakery.ErrCannotOpenBox
+}
@@ -0,0 +1,284 @@
+package bakery_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+var (
+	locatorKey1 = mustParsePublicKey("r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=")
+	locatorKey2 = mustParsePublicKey("zYHJI9d1DndZxL7Wgb8r1sF7ysjQGbD4qkmQ6MZTRU8=")
+)
+
+var parseLocatorFileTests = []struct {
+	about       string
+	data        string
+	expect      map[string]bakery.ThirdPartyInfo
+	expectError string
+}{{
+	about: "yaml",
+	data: `
+dischargers:
+- location: https://one.example.com/
+  public-key: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+  version: 1
+- location: https://two.example.com
+  public-key: zYHJI9d1DndZxL7Wgb8r1sF7ysjQGbD4qkmQ6MZTRU8=
+`,
+	expect: map[string]bakery.ThirdPartyInfo{
+		"https://one.example.com": {
+			PublicKey: locatorKey1,
+			Version:   bakery.Version1,
+		},
+		"https://two.example.com/": {
+			PublicKey: locatorKey2,
+			Version:   bakery.LatestVersion,
+		},
+	},
+}, {
+	about: "json",
+	data:  `{"dischargers": [{"location": "https://one.example.com", "public-key": "r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0="}]}`,
+	expect: map[string]bakery.ThirdPartyInfo{
+		"https://one.example.com": {
+			PublicKey: locatorKey1,
+			Version:   bakery.LatestVersion,
+		},
+	},
+}, {
+	about: "missing location",
+	data: `
+dischargers:
+- public-key: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+`,
+	expectError: `cannot parse ".*": discharger 0 has no location`,
+}, {
+	about: "missing key",
+	data: `
+dischargers:
+- location: https://one.example.com
+`,
+	expectError: `cannot parse ".*": no public key for discharger "https://one.example.com"`,
+}, {
+	about: "invalid key",
+	data: `
+dischargers:
+- location: https://one.example.com
+  public-key: xxx
+`,
+	expectError: `cannot parse ".*": .*`,
+}, {
+	about: "duplicate location",
+	data: `
+dischargers:
+- location: https://one.example.com
+  public-key: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+- location: https://one.example.com/
+  public-key: zYHJI9d1DndZxL7Wgb8r1sF7ysjQGbD4qkmQ6MZTRU8=
+`,
+	expectError: `cannot parse ".*": duplicate discharger location "https://one.example.com/"`,
+}, {
+	about: "invalid version",
+	data: `
+dischargers:
+- location: https://one.example.com
+  public-key: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+  version: 99
+`,
+	expectError: `cannot parse ".*": invalid version 99 for discharger "https://one.example.com"`,
+}, {
+	about: "unknown field",
+	data: `
+dischargers:
+- location: https://one.example.com
+  publickey: r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0=
+`,
+	expectError: `(?s)cannot parse ".*": .*field publickey not found.*`,
+}}
+
+func TestFileLocator(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseLocatorFileTests {
+		c.Run(test.about, func(c *qt.C) {
+			path := filepath.Join(c.TempDir(), "dischargers.yaml")
+			err := ioutil.WriteFile(path, []byte(test.data), 0644)
+			c.Assert(err, qt.IsNil)
+			l, err := bakery.NewFileLocator(bakery.FileLocatorParams{
+				Path: path,
+			})
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			for loc, expect := range test.expect {
+				info, err := l.ThirdPartyInfo(testContext, loc)
+				c.Assert(err, qt.IsNil)
+				c.Assert(info, qt.DeepEquals, expect)
+			}
+			_, err = l.ThirdPartyInfo(testContext, "https://unknown.example.com")
+			c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+		})
+	}
+}
+
+func TestFileLocatorReload(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.TempDir(), "dischargers.yaml")
+	writeFile := func(key string) {
+		err := ioutil.WriteFile(path, []byte(`
+dischargers:
+- location: https://one.example.com
+  public-key: `+key+`
+`), 0644)
+		c.Assert(err, qt.IsNil)
+	}
+	assertKey := func(l *bakery.FileLocator, key bakery.PublicKey) {
+		info, err := l.ThirdPartyInfo(testContext, "https://one.example.com")
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.PublicKey, qt.Equals, key)
+	}
+	writeFile(locatorKey1.String())
+
+	// With a negative reload interval, changes are
+	// only seen when Reload is called.
+	l, err := bakery.NewFileLocator(bakery.FileLocatorParams{
+		Path:           path,
+		ReloadInterval: -1,
+	})
+	c.Assert(err, qt.IsNil)
+	writeFile(locatorKey2.String())
+	assertKey(l, locatorKey1)
+	err = l.Reload()
+	c.Assert(err, qt.IsNil)
+	assertKey(l, locatorKey2)
+
+	// An invalid file leaves the old information in place.
+	writeFile("invalid")
+	err = l.Reload()
+	c.Assert(err, qt.ErrorMatches, `cannot parse ".*": .*`)
+	assertKey(l, locatorKey2)
+
+	// Changes are picked up automatically after the reload interval.
+	writeFile(locatorKey1.String())
+	l, err = bakery.NewFileLocator(bakery.FileLocatorParams{
+		Path:           path,
+		ReloadInterval: time.Millisecond,
+	})
+	c.Assert(err, qt.IsNil)
+	assertKey(l, locatorKey1)
+	writeFile(locatorKey2.String())
+	time.Sleep(2 * time.Millisecond)
+	assertKey(l, locatorKey2)
+}
+
+func TestChainLocator(t *testing.T) {
+	c := qt.New(t)
+	static := bakery.NewThirdPartyStore()
+	static.AddInfo("https://one.example.com", bakery.ThirdPartyInfo{
+		PublicKey: locatorKey1,
+		Version:   bakery.LatestVersion,
+	})
+	fallback := bakery.NewThirdPartyStore()
+	fallback.AddInfo("https://one.example.com", bakery.ThirdPartyInfo{
+		PublicKey: locatorKey2,
+		Version:   bakery.LatestVersion,
+	})
+	fallback.AddInfo("https://two.example.com", bakery.ThirdPartyInfo{
+		PublicKey: locatorKey2,
+		Version:   bakery.Version1,
+	})
+	fallback.AddInfo("https://three.example.com", bakery.ThirdPartyInfo{
+		PublicKey: locatorKey2,
+		Version:   bakery.LatestVersion,
+	})
+	errMismatch := errgo.New("public key mismatch")
+	failing := locatorFunc(func(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
+		switch loc {
+		case "https://three.example.com":
+			return bakery.ThirdPartyInfo{}, errgo.New("network failure")
+		case "https://two.example.com", "https://five.example.com":
+			return bakery.ThirdPartyInfo{}, bakery.ErrNotFound
+		}
+		return bakery.ThirdPartyInfo{}, errgo.WithCausef(nil, errMismatch, "key for %q does not match", loc)
+	})
+	l := bakery.NewChainLocator(
+		bakery.NamedLocator{Name: "static", Locator: static},
+		bakery.NamedLocator{Name: "http", Locator: failing},
+		bakery.NamedLocator{Name: "fallback", Locator: fallback},
+	)
+	tests := []struct {
+		loc          string
+		expectInfo   bakery.ThirdPartyInfo
+		expectSource string
+		expectError  string
+		expectCause  error
+	}{{
+		loc: "https://one.example.com",
+		expectInfo: bakery.ThirdPartyInfo{
+			PublicKey: locatorKey1,
+			Version:   bakery.LatestVersion,
+		},
+		expectSource: "static",
+	}, {
+		loc: "https://two.example.com",
+		expectInfo: bakery.ThirdPartyInfo{
+			PublicKey: locatorKey2,
+			Version:   bakery.Version1,
+		},
+		expectSource: "fallback",
+	}, {
+		loc:         "https://three.example.com",
+		expectError: `cannot get third party information from http: network failure`,
+	}, {
+		// The fallback must not be consulted when an earlier
+		// locator rejects the location.
+		loc:         "https://four.example.com",
+		expectError: `cannot get third party information from http: key for "https://four.example.com" does not match`,
+		expectCause: errMismatch,
+	}, {
+		loc:         "https://five.example.com",
+		expectError: `third party "https://five.example.com" not found`,
+		expectCause: bakery.ErrNotFound,
+	}}
+	for _, test := range tests {
+		c.Run(test.loc, func(c *qt.C) {
+			info, source, err := l.ThirdPartyInfoWithSource(testContext, test.loc)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				if test.expectCause != nil {
+					c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+				}
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(info, qt.DeepEquals, test.expectInfo)
+			c.Assert(source, qt.Equals, test.expectSource)
+
+			info, err = l.ThirdPartyInfo(testContext, test.loc)
+			c.Assert(err, qt.IsNil)
+			c.Assert(info, qt.DeepEquals, test.expectInfo)
+		})
+	}
+}
+
+type locatorFunc func(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error)
+
+func (f locatorFunc) ThirdPartyInfo(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
+	return f(ctx, loc)
+}
+
+func mustParsePublicKey(s string) bakery.PublicKey {
+	var k bakery.PublicKey
+	if err := k.UnmarshalText([]byte(s)); err != nil {
+		panic(err)
+	}
+	return k
+}
@@ -2,15 +2,165 @@ package bakery
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
 )
 
 // Logger is used by the bakery to log informational messages
 // about bakery operations.
+//
+// A Logger may also implement StructuredLogger to receive
+// messages with levels and structured fields.
 type Logger interface {
 	Infof(ctx context.Context, f string, args ...interface{})
 	Debugf(ctx context.Context, f string, args ...interface{})
 }
 
+// StructuredLogger may be implemented by a Logger to receive log
+// messages with a level and structured fields rather than as
+// preformatted text. See the bakery/slogger package for an
+// implementation that uses log/slog.
+type StructuredLogger interface {
+	Logger
+
+	// Log logs the given message at the given level with
+	// the given fields attached.
+	Log(ctx context.Context, level LogLevel, msg string, fields ...LogField)
+}
+
+// LogLevel represents the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String implements fmt.Stringer.
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// LogField holds a structured field attached to a log message.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Field returns a LogField with the given key and value.
+func Field(key string, value interface{}) LogField {
+	return LogField{
+		Key:   key,
+		Value: value,
+	}
+}
+
+// OpsField returns a field holding the given operations,
+// each formatted as "entity:action".
+func OpsField(ops []Op) LogField {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = op.Entity + ":" + op.Action
+	}
+	return Field("ops", s)
+}
+
+// MacaroonIdField returns a field holding a hash of the given
+// macaroon id. The hash identifies the macaroon in logs without
+// revealing the id itself.
+func MacaroonIdField(id []byte) LogField {
+	sum := sha256.Sum256(id)
+	return Field("macaroon_id_hash", hex.EncodeToString(sum[:8]))
+}
+
+// LocationField returns a field holding the given location.
+func LocationField(loc string) LogField {
+	return Field("location", loc)
+}
+
+// ErrorField returns a field holding the text of the given error.
+func ErrorField(err error) LogField {
+	return Field("error", err.Error())
+}
+
+// Log logs a message to the given logger. If the logger implements
+// StructuredLogger, its Log method is called; otherwise the fields,
+// and any request id found in the context, are appended to the message
+// as key=value pairs, which is passed to Debugf for LevelDebug messages
+// and to Infof for all other levels, prefixed with the level for
+// LevelWarn and LevelError.
+func Log(ctx context.Context, logger Logger, level LogLevel, msg string, fields ...LogField) {
+	if logger == nil {
+		return
+	}
+	if sl, ok := logger.(StructuredLogger); ok {
+		sl.Log(ctx, level, msg, fields...)
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString(msg)
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append([]LogField{Field("request_id", id)}, fields...)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(&buf, " %s=%s", f.Key, formatLogValue(f.Value))
+	}
+	switch level {
+	case LevelDebug:
+		logger.Debugf(ctx, "%s", buf.String())
+	case LevelInfo:
+		logger.Infof(ctx, "%s", buf.String())
+	default:
+		logger.Infof(ctx, "%s: %s", level, buf.String())
+	}
+}
+
+// formatLogValue formats a field value for inclusion
+// in a text log message.
+func formatLogValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			return fmt.Sprintf("%q", v)
+		}
+		return v
+	case []string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(v)
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context holding the given request
+// id. The id is attached to messages logged with Log, so that bakery
+// logs can be correlated with the logs of the enclosing server.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id added
+// with ContextWithRequestID, or the empty string if there
+// is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
 // DefaultLogger returns a Logger instance that does nothing.
 //
 // Deprecated: DefaultLogger exists for historical compatibility
@@ -0,0 +1,82 @@
+package bakery_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+func TestLogWithPlainLogger(t *testing.T) {
+	c := qt.New(t)
+	var logger recordingLogger
+	ctx := bakery.ContextWithRequestID(context.Background(), "req-1")
+	bakery.Log(ctx, &logger, bakery.LevelDebug, "debug message",
+		bakery.OpsField([]bakery.Op{{Entity: "e1", Action: "read"}}),
+		bakery.LocationField("https://example.com"),
+	)
+	bakery.Log(ctx, &logger, bakery.LevelInfo, "info message", bakery.Field("n", 5))
+	bakery.Log(context.Background(), &logger, bakery.LevelWarn, "warn message", bakery.ErrorField(errgo.New("some error")))
+	bakery.Log(context.Background(), &logger, bakery.LevelError, "error message", bakery.Field("empty", ""))
+	c.Assert(logger, qt.DeepEquals, recordingLogger{
+		`debug: debug message request_id=req-1 ops=["e1:read"] location=https://example.com`,
+		`info: info message request_id=req-1 n=5`,
+		`info: warn: warn message error="some error"`,
+		`info: error: error message empty=""`,
+	})
+
+	// A nil logger is ignored.
+	bakery.Log(ctx, nil, bakery.LevelError, "message")
+}
+
+func TestLogWithStructuredLogger(t *testing.T) {
+	c := qt.New(t)
+	var logger structuredLogger
+	id := []byte("macaroon id")
+	bakery.Log(context.Background(), &logger, bakery.LevelWarn, "message", bakery.MacaroonIdField(id))
+	c.Assert(logger, qt.DeepEquals, structuredLogger{{
+		Level: bakery.LevelWarn,
+		Msg:   "message",
+		Fields: []bakery.LogField{{
+			Key:   "macaroon_id_hash",
+			Value: "8d464cc51ca0b9ec",
+		}},
+	}})
+}
+
+func TestLogLevelString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(bakery.LevelDebug.String(), qt.Equals, "debug")
+	c.Assert(bakery.LevelError.String(), qt.Equals, "error")
+	c.Assert(bakery.LogLevel(10).String(), qt.Equals, "LogLevel(10)")
+}
+
+type recordingLogger []string
+
+func (l *recordingLogger) Debugf(ctx context.Context, f string, args ...interface{}) {
+	*l = append(*l, "debug: "+fmt.Sprintf(f, args...))
+}
+
+func (l *recordingLogger) Infof(ctx context.Context, f string, args ...interface{}) {
+	*l = append(*l, "info: "+fmt.Sprintf(f, args...))
+}
+
+type logRecord struct {
+	Level  bakery.LogLevel
+	Msg    string
+	Fields []bakery.LogField
+}
+
+type structuredLogger []logRecord
+
+func (l *structuredLogger) Debugf(ctx context.Context, f string, args ...interface{}) {}
+
+func (l *structuredLogger) Infof(ctx context.Context, f string, args ...interface{}) {}
+
+func (l *structuredLogger) Log(ctx context.Context, level bakery.LogLevel, msg string, fields ...bakery.LogField) {
+	*l = append(*l, logRecord{level, msg, fields})
+}
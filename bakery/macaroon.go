@@ -250,6 +250,15 @@ func (m *Macaroon) AddCaveats(ctx context.Context, cavs []checkers.Caveat, key *
 // caveat will encode the condition "true" encrypted with that public
 // key. See LocalThirdPartyCaveat for a way of creating such caveats.
 func (m *Macaroon) AddCaveat(ctx context.Context, cav checkers.Caveat, key *KeyPair, loc ThirdPartyLocator) error {
+	if key == nil {
+		return m.addCaveat(ctx, cav, nil, loc)
+	}
+	return m.addCaveat(ctx, cav, key, loc)
+}
+
+// addCaveat is like AddCaveat except that the key used to
+// encrypt third party caveats is held by a KeyOpener.
+func (m *Macaroon) addCaveat(ctx context.Context, cav checkers.Caveat, key KeyOpener, loc ThirdPartyLocator) error {
 	if cav.Location == "" {
 		if err := m.m.AddFirstPartyCaveat([]byte(m.namespace.ResolveCaveat(cav).Condition)); err != nil {
 			return errgo.Mask(err)
@@ -285,7 +294,7 @@ func (m *Macaroon) AddCaveat(ctx context.Context, cav checkers.Caveat, key *KeyP
 	if m.version < info.Version {
 		info.Version = m.version
 	}
-	caveatInfo, err := encodeCaveat(cav.Condition, rootKey, info, key, m.namespace)
+	caveatInfo, err := encodeCaveat(ctx, cav.Condition, rootKey, info, key, m.namespace)
 	if err != nil {
 		return errgo.Notef(err, "cannot create third party caveat at %q", cav.Location)
 	}
@@ -0,0 +1,52 @@
+package bakery
+
+import (
+	"time"
+)
+
+// Metrics is used by the bakery to record metrics. Metric names and
+// labels follow Prometheus naming conventions; see the
+// bakery/prommetrics package for an implementation that exports them in
+// the Prometheus text format.
+//
+// Implementations must be safe to call concurrently.
+type Metrics interface {
+	// IncCounter increments the counter with the given
+	// name and labels.
+	IncCounter(name string, labels map[string]string)
+
+	// ObserveDuration records a duration in the histogram with
+	// the given name and labels.
+	ObserveDuration(name string, labels map[string]string, d time.Duration)
+}
+
+// Names of the metrics recorded by the bakery package and its
+// subpackages.
+const (
+	// MetricMacaroonVerify counts calls to Oven.VerifyMacaroon. It is
+	// labelled with "outcome", which is one of "ok", "bad_id",
+	// "root_key_not_found", "invalid_signature" or "error".
+	MetricMacaroonVerify = "bakery_macaroon_verify_total"
+
+	// MetricRootKeyGet records the time taken by RootKeyStore.Get
+	// when called by Oven.VerifyMacaroon. It is labelled with
+	// "outcome", which is one of "ok", "not_found" or "error".
+	MetricRootKeyGet = "bakery_root_key_get_seconds"
+
+	// MetricRootKeyCache counts lookups in the root key cache
+	// maintained by the dbrootkeystore package. It is labelled with
+	// "method", which is "get" for lookups by id and "root_key" for
+	// lookups of the current key, and "result", which is "hit",
+	// "miss" or, for lookups by id, "old_hit" when the key was
+	// found in the older of the cache's two generations (from
+	// which it is moved back into the current generation).
+	MetricRootKeyCache = "bakery_root_key_cache_total"
+)
+
+type nopMetrics struct{}
+
+// IncCounter implements Metrics.IncCounter.
+func (nopMetrics) IncCounter(string, map[string]string) {}
+
+// ObserveDuration implements Metrics.ObserveDuration.
+func (nopMetrics) ObserveDuration(string, map[string]string, time.Duration) {}
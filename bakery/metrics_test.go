@@ -0,0 +1,60 @@
+package bakery_test
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/prommetrics"
+)
+
+func TestVerifyMacaroonMetrics(t *testing.T) {
+	c := qt.New(t)
+	metrics := prommetrics.New(prommetrics.Params{
+		Buckets: []float64{1},
+	})
+	oven := bakery.NewOven(bakery.OvenParams{
+		Metrics: metrics,
+	})
+	m, err := oven.NewMacaroon(testContext, bakery.LatestVersion, nil, basicOp)
+	c.Assert(err, qt.IsNil)
+	_, _, err = oven.VerifyMacaroon(testContext, macaroon.Slice{m.M()})
+	c.Assert(err, qt.IsNil)
+
+	// A macaroon with the right id but the wrong root key.
+	badm, err := macaroon.New([]byte("bad key"), m.M().Id(), "", macaroon.LatestVersion)
+	c.Assert(err, qt.IsNil)
+	_, _, err = oven.VerifyMacaroon(testContext, macaroon.Slice{badm})
+	c.Assert(err, qt.ErrorMatches, `verification failed: signature mismatch after caveat verification`)
+
+	// An oven with an empty root key store cannot find the root key.
+	oven1 := bakery.NewOven(bakery.OvenParams{
+		Metrics: metrics,
+	})
+	_, _, err = oven1.VerifyMacaroon(testContext, macaroon.Slice{m.M()})
+	c.Assert(err, qt.ErrorMatches, `verification failed: macaroon not found in storage`)
+
+	_, _, err = oven.VerifyMacaroon(testContext, nil)
+	c.Assert(err, qt.ErrorMatches, `no macaroons in slice`)
+
+	var buf strings.Builder
+	metrics.WriteTo(&buf)
+	c.Assert(buf.String(), qt.Matches, `# TYPE bakery_macaroon_verify_total counter
+bakery_macaroon_verify_total{outcome="bad_id"} 1
+bakery_macaroon_verify_total{outcome="invalid_signature"} 1
+bakery_macaroon_verify_total{outcome="ok"} 1
+bakery_macaroon_verify_total{outcome="root_key_not_found"} 1
+# TYPE bakery_root_key_get_seconds histogram
+bakery_root_key_get_seconds_bucket{outcome="not_found",le="1"} 1
+bakery_root_key_get_seconds_bucket{outcome="not_found",le="\+Inf"} 1
+bakery_root_key_get_seconds_sum{outcome="not_found"} .*
+bakery_root_key_get_seconds_count{outcome="not_found"} 1
+bakery_root_key_get_seconds_bucket{outcome="ok",le="1"} 2
+bakery_root_key_get_seconds_bucket{outcome="ok",le="\+Inf"} 2
+bakery_root_key_get_seconds_sum{outcome="ok"} .*
+bakery_root_key_get_seconds_count{outcome="ok"} 2
+`)
+}
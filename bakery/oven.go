@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"sort"
+	"time"
 
 	"github.com/go-macaroon-bakery/macaroonpb"
 	"github.com/rogpeppe/fastuuid"
@@ -79,6 +80,16 @@ type OvenParams struct {
 	// with any operations.
 	LegacyMacaroonOp Op
 
+	// Auditor is used to record an AuditMacaroonMinted event
+	// for each macaroon created by NewMacaroon. If it is nil,
+	// no events will be recorded.
+	Auditor Auditor
+
+	// Metrics is used to record the MetricMacaroonVerify and
+	// MetricRootKeyGet metrics from VerifyMacaroon. If it is nil,
+	// no metrics will be recorded.
+	Metrics Metrics
+
 	// TODO max macaroon or macaroon id size?
 }
 
@@ -96,6 +107,12 @@ func NewOven(p OvenParams) *Oven {
 	if p.Namespace == nil {
 		p.Namespace = checkers.New(nil).Namespace()
 	}
+	if p.Auditor == nil {
+		p.Auditor = nopAuditor{}
+	}
+	if p.Metrics == nil {
+		p.Metrics = nopMetrics{}
+	}
 	return &Oven{
 		p: p,
 	}
@@ -108,17 +125,24 @@ func NewOven(p OvenParams) *Oven {
 // returns a single LoginOp operation.
 func (o *Oven) VerifyMacaroon(ctx context.Context, ms macaroon.Slice) (ops []Op, conditions []string, err error) {
 	if len(ms) == 0 {
+		o.countVerify("bad_id")
 		return nil, nil, errgo.Newf("no macaroons in slice")
 	}
 	storageId, ops, err := o.decodeMacaroonId(ms[0].Id())
 	if err != nil {
+		o.countVerify("bad_id")
 		return nil, nil, errgo.Mask(err)
 	}
+	start := time.Now()
 	rootKey, err := o.p.RootKeyStoreForOps(ops).Get(ctx, storageId)
 	if err != nil {
 		if errgo.Cause(err) != ErrNotFound {
+			o.observeRootKeyGet("error", start)
+			o.countVerify("error")
 			return nil, nil, errgo.Notef(err, "cannot get macaroon")
 		}
+		o.observeRootKeyGet("not_found", start)
+		o.countVerify("root_key_not_found")
 		// If the macaroon was not found, it is probably
 		// because it's been removed after time-expiry,
 		// so return a verification error.
@@ -126,15 +150,34 @@ func (o *Oven) VerifyMacaroon(ctx context.Context, ms macaroon.Slice) (ops []Op,
 			Reason: errgo.Newf("macaroon not found in storage"),
 		}
 	}
+	o.observeRootKeyGet("ok", start)
 	conditions, err = ms[0].VerifySignature(rootKey, ms[1:])
 	if err != nil {
+		o.countVerify("invalid_signature")
 		return nil, nil, &VerificationError{
 			Reason: errgo.Mask(err),
 		}
 	}
+	o.countVerify("ok")
 	return ops, conditions, nil
 }
 
+// countVerify increments the MetricMacaroonVerify
+// metric with the given outcome.
+func (o *Oven) countVerify(outcome string) {
+	o.p.Metrics.IncCounter(MetricMacaroonVerify, map[string]string{
+		"outcome": outcome,
+	})
+}
+
+// observeRootKeyGet records the time since start in the
+// MetricRootKeyGet metric with the given outcome.
+func (o *Oven) observeRootKeyGet(outcome string, start time.Time) {
+	o.p.Metrics.ObserveDuration(MetricRootKeyGet, map[string]string{
+		"outcome": outcome,
+	}, time.Since(start))
+}
+
 func (o *Oven) decodeMacaroonId(id []byte) (storageId []byte, ops []Op, err error) {
 	base64Decoded := false
 	if id[0] == 'A' {
@@ -233,6 +276,14 @@ func (o *Oven) NewMacaroon(ctx context.Context, version Version, caveats []check
 	if err := o.AddCaveats(ctx, m, caveats); err != nil {
 		return nil, errgo.Mask(err)
 	}
+	o.p.Auditor.AuditEvent(ctx, AuditEvent{
+		Kind:        AuditMacaroonMinted,
+		Location:    o.p.Location,
+		Ops:         ops,
+		MacaroonIds: [][]byte{m.M().Id()},
+		Nonce:       id.Nonce,
+		Caveats:     append([]checkers.Caveat(nil), caveats...),
+	})
 	return m, nil
 }
 
@@ -0,0 +1,285 @@
+// Package prommetrics provides an implementation of bakery.Metrics
+// that exports metrics in the Prometheus text exposition format
+// without depending on the Prometheus client libraries.
+package prommetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// DefaultBuckets holds the default histogram bucket upper bounds,
+// in seconds. They are the same as the Prometheus client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Params holds parameters for New.
+type Params struct {
+	// Buckets holds the upper bounds, in seconds, of the buckets
+	// used for histograms, in increasing order. If it is empty,
+	// DefaultBuckets will be used.
+	Buckets []float64
+
+	// Help holds help text for metrics, keyed by metric name.
+	// Metrics without help text are written without a HELP line.
+	Help map[string]string
+}
+
+// Registry implements bakery.Metrics by keeping metric values in
+// memory. It implements http.Handler by serving the current values
+// in the Prometheus text format, so it can be used as the handler
+// for a /metrics endpoint.
+//
+// A metric name should be used for either counters or histograms but
+// not both; values recorded with the wrong kind are ignored.
+type Registry struct {
+	p Params
+
+	// mu guards metrics.
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+var _ bakery.Metrics = (*Registry)(nil)
+
+// metric holds all the labelled series for a metric name.
+type metric struct {
+	kind   string
+	series map[string]*series
+}
+
+// series holds the state of a single labelled counter or histogram.
+type series struct {
+	// value holds the value of a counter.
+	value float64
+
+	// counts holds the number of observations in each histogram
+	// bucket, not including the observations in earlier buckets.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// New returns a new Registry using the given parameters.
+func New(p Params) *Registry {
+	if len(p.Buckets) == 0 {
+		p.Buckets = DefaultBuckets
+	}
+	return &Registry{
+		p:       p,
+		metrics: make(map[string]*metric),
+	}
+}
+
+// IncCounter implements bakery.Metrics.IncCounter.
+func (r *Registry) IncCounter(name string, labels map[string]string) {
+	key := formatLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.getSeries(name, "counter", key); s != nil {
+		s.value++
+	}
+}
+
+// ObserveDuration implements bakery.Metrics.ObserveDuration.
+func (r *Registry) ObserveDuration(name string, labels map[string]string, d time.Duration) {
+	key := formatLabels(labels)
+	v := d.Seconds()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.getSeries(name, "histogram", key)
+	if s == nil {
+		return
+	}
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.p.Buckets))
+	}
+	s.count++
+	s.sum += v
+	if i := sort.SearchFloat64s(r.p.Buckets, v); i < len(r.p.Buckets) {
+		s.counts[i]++
+	}
+}
+
+// getSeries returns the series with the given metric name, kind and
+// formatted labels, creating it if necessary. It returns nil if the
+// name is already used for a metric of a different kind.
+//
+// Called with r.mu held.
+func (r *Registry) getSeries(name, kind, labels string) *series {
+	m := r.metrics[name]
+	if m == nil {
+		m = &metric{
+			kind:   kind,
+			series: make(map[string]*series),
+		}
+		r.metrics[name] = m
+	}
+	if m.kind != kind {
+		return nil
+	}
+	s := m.series[labels]
+	if s == nil {
+		s = new(series)
+		m.series[labels] = s
+	}
+	return s
+}
+
+// WriteTo writes the current metric values to w in the Prometheus
+// text exposition format. Metrics are written in name order.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	// Take a snapshot so that the lock is not held while writing
+	// to w, which may be slow.
+	metrics := r.snapshot()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		r.writeHeader(bw, m.name, m.kind)
+		for _, s := range m.series {
+			if m.kind == "counter" {
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, braces(s.labels), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, le := range r.p.Buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, braces(joinLabels(s.labels, `le="`+formatFloat(le)+`"`)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, braces(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", m.name, braces(s.labels), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", m.name, braces(s.labels), s.count)
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// metricSnapshot holds a copy of a metric taken by snapshot.
+type metricSnapshot struct {
+	name   string
+	kind   string
+	series []seriesSnapshot
+}
+
+// seriesSnapshot holds a copy of a series taken by snapshot.
+type seriesSnapshot struct {
+	labels string
+	series
+}
+
+// snapshot returns a copy of all the current metric values,
+// sorted by metric name and then by labels.
+func (r *Registry) snapshot() []metricSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metrics := make([]metricSnapshot, 0, len(r.metrics))
+	for name, m := range r.metrics {
+		ms := metricSnapshot{
+			name:   name,
+			kind:   m.kind,
+			series: make([]seriesSnapshot, 0, len(m.series)),
+		}
+		for labels, s := range m.series {
+			ss := seriesSnapshot{
+				labels: labels,
+				series: *s,
+			}
+			ss.counts = append([]uint64(nil), s.counts...)
+			ms.series = append(ms.series, ss)
+		}
+		sort.Slice(ms.series, func(i, j int) bool {
+			return ms.series[i].labels < ms.series[j].labels
+		})
+		metrics = append(metrics, ms)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+	return metrics
+}
+
+// ServeHTTP implements http.Handler by writing the current
+// metric values in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func (r *Registry) writeHeader(w io.Writer, name, kind string) {
+	if help, ok := r.p.Help[name]; ok {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, helpReplacer.Replace(help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatLabels returns the labels formatted as they appear
+// inside braces in the text format, sorted by label name.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(name)
+		buf.WriteString(`="`)
+		buf.WriteString(labelReplacer.Replace(labels[name]))
+		buf.WriteByte('"')
+	}
+	return buf.String()
+}
+
+// joinLabels returns the formatted labels with
+// the formatted label l added at the end.
+func joinLabels(labels, l string) string {
+	if labels == "" {
+		return l
+	}
+	return labels + "," + l
+}
+
+// braces returns the formatted labels enclosed in braces,
+// or the empty string if there are no labels.
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
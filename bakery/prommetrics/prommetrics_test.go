@@ -0,0 +1,87 @@
+package prommetrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/prommetrics"
+)
+
+func TestRegistry(t *testing.T) {
+	c := qt.New(t)
+	r := prommetrics.New(prommetrics.Params{
+		Buckets: []float64{0.1, 1},
+		Help: map[string]string{
+			"test_total": "Test counter\nwith two lines.",
+		},
+	})
+	r.IncCounter("test_total", map[string]string{"outcome": "ok"})
+	r.IncCounter("test_total", map[string]string{"outcome": "ok"})
+	r.IncCounter("test_total", map[string]string{"outcome": `bad "x"\`})
+	r.IncCounter("other_total", nil)
+	r.ObserveDuration("test_seconds", map[string]string{"b": "2", "a": "1"}, 50*time.Millisecond)
+	r.ObserveDuration("test_seconds", map[string]string{"a": "1", "b": "2"}, 500*time.Millisecond)
+	r.ObserveDuration("test_seconds", map[string]string{"a": "1", "b": "2"}, 2*time.Second)
+	r.ObserveDuration("test_seconds", nil, time.Second)
+	// A name that is already used for a counter is ignored.
+	r.ObserveDuration("test_total", nil, time.Second)
+
+	var buf strings.Builder
+	n, err := r.WriteTo(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, int64(buf.Len()))
+	c.Assert(buf.String(), qt.Equals, `# TYPE other_total counter
+other_total 1
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 0
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="+Inf"} 1
+test_seconds_sum 1
+test_seconds_count 1
+test_seconds_bucket{a="1",b="2",le="0.1"} 1
+test_seconds_bucket{a="1",b="2",le="1"} 2
+test_seconds_bucket{a="1",b="2",le="+Inf"} 3
+test_seconds_sum{a="1",b="2"} 2.55
+test_seconds_count{a="1",b="2"} 3
+# HELP test_total Test counter\nwith two lines.
+# TYPE test_total counter
+test_total{outcome="bad \"x\"\\"} 1
+test_total{outcome="ok"} 2
+`)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "text/plain; version=0.0.4; charset=utf-8")
+}
+
+func TestWriteToDoesNotHoldLock(t *testing.T) {
+	c := qt.New(t)
+	r := prommetrics.New(prommetrics.Params{})
+	r.IncCounter("test_total", nil)
+	// A writer that records a metric would deadlock if the
+	// registry lock were held while writing.
+	w := writerFunc(func(buf []byte) (int, error) {
+		r.IncCounter("test_total", nil)
+		return len(buf), nil
+	})
+	_, err := r.WriteTo(w)
+	c.Assert(err, qt.IsNil)
+
+	var buf strings.Builder
+	_, err = r.WriteTo(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, "# TYPE test_total counter\ntest_total 2\n")
+}
+
+type writerFunc func(buf []byte) (int, error)
+
+func (f writerFunc) Write(buf []byte) (int, error) {
+	return f(buf)
+}
@@ -67,6 +67,15 @@ func (ms Slice) Purge(t time.Time) Slice {
 // so it's still possible to add caveats and reacquire expired discharges
 // without reacquiring the primary macaroon.
 func (ms Slice) DischargeAll(ctx context.Context, getDischarge func(ctx context.Context, cav macaroon.Caveat, encryptedCaveat []byte) (*Macaroon, error), localKey *KeyPair) (Slice, error) {
+	if localKey == nil {
+		return ms.DischargeAllWithKeyOpener(ctx, getDischarge, nil)
+	}
+	return ms.DischargeAllWithKeyOpener(ctx, getDischarge, localKey)
+}
+
+// DischargeAllWithKeyOpener is like DischargeAll except that the
+// local key is held by a KeyOpener.
+func (ms Slice) DischargeAllWithKeyOpener(ctx context.Context, getDischarge func(ctx context.Context, cav macaroon.Caveat, encryptedCaveat []byte) (*Macaroon, error), localKey KeyOpener) (Slice, error) {
 	if len(ms) == 0 {
 		return nil, errgo.Newf("no macaroons to discharge")
 	}
@@ -110,11 +119,11 @@ func (ms Slice) DischargeAll(ctx context.Context, getDischarge func(ctx context.
 		if localKey != nil && cav.cav.Location == "local" {
 			// TODO use a small caveat id.
 			dm, err = Discharge(ctx, DischargeParams{
-				Key:     localKey,
-				Checker: localDischargeChecker,
-				Caveat:  cav.encryptedCaveat,
-				Id:      cav.cav.Id,
-				Locator: emptyLocator{},
+				KeyOpener: localKey,
+				Checker:   localDischargeChecker,
+				Caveat:    cav.encryptedCaveat,
+				Id:        cav.cav.Id,
+				Locator:   emptyLocator{},
 			})
 		} else {
 			dm, err = getDischarge(ctx, cav.cav, cav.encryptedCaveat)
@@ -0,0 +1,109 @@
+//go:build go1.21
+// +build go1.21
+
+// Package slogger provides an implementation of bakery.Logger
+// and bakery.StructuredLogger that logs using log/slog.
+package slogger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// DefaultRequestIDKey holds the attribute key used for
+// request ids when Params.RequestIDKey is empty.
+const DefaultRequestIDKey = "request_id"
+
+// Params holds parameters for New.
+type Params struct {
+	// Logger holds the logger to log to. If it is nil,
+	// slog.Default() will be used.
+	Logger *slog.Logger
+
+	// RequestID is used to find the id of the request associated
+	// with a context, so that log records can be correlated with
+	// other logs for the same request. If it returns the empty
+	// string, no request id is attached. If it is nil,
+	// bakery.RequestIDFromContext will be used.
+	RequestID func(ctx context.Context) string
+
+	// RequestIDKey holds the attribute key used for the request id.
+	// If it is empty, DefaultRequestIDKey will be used.
+	RequestIDKey string
+}
+
+// Logger implements bakery.StructuredLogger using a *slog.Logger.
+type Logger struct {
+	p Params
+}
+
+var _ bakery.StructuredLogger = (*Logger)(nil)
+
+// New returns a new Logger using the given parameters.
+func New(p Params) *Logger {
+	if p.Logger == nil {
+		p.Logger = slog.Default()
+	}
+	if p.RequestID == nil {
+		p.RequestID = bakery.RequestIDFromContext
+	}
+	if p.RequestIDKey == "" {
+		p.RequestIDKey = DefaultRequestIDKey
+	}
+	return &Logger{
+		p: p,
+	}
+}
+
+// Debugf implements bakery.Logger.Debugf by logging
+// at slog.LevelDebug.
+func (l *Logger) Debugf(ctx context.Context, f string, args ...interface{}) {
+	l.log(ctx, slog.LevelDebug, f, args, nil)
+}
+
+// Infof implements bakery.Logger.Infof by logging
+// at slog.LevelInfo.
+func (l *Logger) Infof(ctx context.Context, f string, args ...interface{}) {
+	l.log(ctx, slog.LevelInfo, f, args, nil)
+}
+
+// Log implements bakery.StructuredLogger.Log by logging the
+// message with each field as an attribute.
+func (l *Logger) Log(ctx context.Context, level bakery.LogLevel, msg string, fields ...bakery.LogField) {
+	l.log(ctx, slogLevel(level), msg, nil, fields)
+}
+
+func (l *Logger) log(ctx context.Context, level slog.Level, f string, args []interface{}, fields []bakery.LogField) {
+	if !l.p.Logger.Enabled(ctx, level) {
+		return
+	}
+	msg := f
+	if len(args) > 0 {
+		msg = fmt.Sprintf(f, args...)
+	}
+	attrs := make([]slog.Attr, 0, len(fields)+1)
+	if id := l.p.RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String(l.p.RequestIDKey, id))
+	}
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	l.p.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// slogLevel returns the slog level corresponding
+// to the given bakery level.
+func slogLevel(level bakery.LogLevel) slog.Level {
+	switch level {
+	case bakery.LevelDebug:
+		return slog.LevelDebug
+	case bakery.LevelInfo:
+		return slog.LevelInfo
+	case bakery.LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
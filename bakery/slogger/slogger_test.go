@@ -0,0 +1,91 @@
+//go:build go1.21
+// +build go1.21
+
+package slogger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/slogger"
+)
+
+type requestIDKey struct{}
+
+func TestLogger(t *testing.T) {
+	c := qt.New(t)
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slogger.New(slogger.Params{
+		Logger: slog.New(handler),
+		RequestID: func(ctx context.Context) string {
+			id, _ := ctx.Value(requestIDKey{}).(string)
+			return id
+		},
+		RequestIDKey: "req",
+	})
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc")
+	bakery.Log(ctx, logger, bakery.LevelWarn, "cannot refresh",
+		bakery.LocationField("https://example.com"),
+		bakery.OpsField([]bakery.Op{{Entity: "e", Action: "a"}}),
+	)
+	logger.Infof(context.Background(), "hello %s", "world")
+	// Debug messages are below the handler's level.
+	logger.Debugf(ctx, "not logged")
+	bakery.Log(ctx, logger, bakery.LevelDebug, "not logged")
+	bakery.Log(context.Background(), logger, bakery.LevelError, "failed")
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r map[string]interface{}
+		c.Assert(dec.Decode(&r), qt.IsNil)
+		records = append(records, r)
+	}
+	c.Assert(records, qt.DeepEquals, []map[string]interface{}{{
+		"level":    "WARN",
+		"msg":      "cannot refresh",
+		"req":      "abc",
+		"location": "https://example.com",
+		"ops":      []interface{}{"e:a"},
+	}, {
+		"level": "INFO",
+		"msg":   "hello world",
+	}, {
+		"level": "ERROR",
+		"msg":   "failed",
+	}})
+}
+
+func TestLoggerDefaultRequestID(t *testing.T) {
+	c := qt.New(t)
+	var buf bytes.Buffer
+	logger := slogger.New(slogger.Params{
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})),
+	})
+	ctx := bakery.ContextWithRequestID(context.Background(), "xyz")
+	logger.Debugf(ctx, "message")
+	c.Assert(buf.String(), qt.Equals, "level=DEBUG msg=message request_id=xyz\n")
+}
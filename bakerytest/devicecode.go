@@ -0,0 +1,62 @@
+package bakerytest
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/devicecode"
+)
+
+// DeviceCodeDischarger is a discharger that requires device-code
+// interaction for every discharge. Tests act as the user by
+// calling Approve or Deny with the user code shown by
+// the client.
+type DeviceCodeDischarger struct {
+	*Discharger
+
+	// Handler holds the device-code handler used
+	// by the discharger.
+	Handler *devicecode.Handler
+}
+
+// NewDeviceCodeDischarger returns a new DeviceCodeDischarger that uses
+// the given locator to add third party caveats. The verification URL
+// sent to clients is the discharger's location with a "/device" path;
+// nothing is served there.
+//
+// The returned discharger should be closed after use.
+func NewDeviceCodeDischarger(locator bakery.ThirdPartyLocator) *DeviceCodeDischarger {
+	d := &DeviceCodeDischarger{
+		Discharger: NewDischarger(locator),
+	}
+	d.Handler = devicecode.NewHandler(devicecode.HandlerParams{
+		VerificationURL: d.Location() + "/device",
+	})
+	d.AddHTTPHandlers(d.Handler.Handlers())
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			return d.Handler.CheckToken(p.Token, p.Caveat)
+		}
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		if err1 := d.Handler.SetInteraction(err, p.Caveat); err1 != nil {
+			return nil, errgo.Mask(err1)
+		}
+		return nil, err
+	})
+	return d
+}
+
+// Approve approves the discharge associated with the given user code,
+// adding the given caveats to the discharge macaroon.
+func (d *DeviceCodeDischarger) Approve(userCode string, caveats ...checkers.Caveat) error {
+	return d.Handler.Approve(userCode, caveats)
+}
+
+// Deny refuses the discharge associated with the given user code.
+func (d *DeviceCodeDischarger) Deny(userCode string) error {
+	return d.Handler.Deny(userCode, nil)
+}
@@ -0,0 +1,149 @@
+// The bakery-agent-encrypt command converts an agent file (as used by
+// the BAKERY_AGENT_FILE environment variable) to the
+// passphrase-encrypted format, or back again with the -d flag.
+//
+// The passphrase is taken from $BAKERY_AGENT_PASSPHRASE or
+// $BAKERY_AGENT_PASSPHRASE_COMMAND if either is set; otherwise
+// it is read from the terminal.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/agent"
+)
+
+var (
+	decrypt = flag.Bool("d", false, "decrypt the agent file instead of encrypting it")
+	output  = flag.String("o", "", "write the result to the named file instead of replacing the agent file")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bakery-agent-encrypt [-d] [-o outfile] agentfile\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if err := convert(flag.Arg(0), *output, *decrypt); err != nil {
+		fmt.Fprintf(os.Stderr, "bakery-agent-encrypt: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func convert(file, outFile string, decrypt bool) error {
+	if outFile == "" {
+		outFile = file
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if agent.IsEncrypted(data) != decrypt {
+		if decrypt {
+			return errgo.Newf("%s is not encrypted", file)
+		}
+		return errgo.Newf("%s is already encrypted", file)
+	}
+	ai, err := agent.ReadAuthInfoFile(file, passphrase(false))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if decrypt {
+		data, err = json.MarshalIndent(ai, "", "\t")
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		data = append(data, '\n')
+	} else {
+		p, err := passphrase(true)(file)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		data, err = agent.EncryptAuthInfo(ai, p)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return writeFile(outFile, data)
+}
+
+// passphrase returns a function that obtains the passphrase from the
+// environment if possible, or otherwise by prompting on the terminal.
+// If confirm is true, the user must enter a prompted passphrase twice.
+func passphrase(confirm bool) agent.PassphraseFunc {
+	return func(file string) ([]byte, error) {
+		p, err := agent.DefaultPassphrase(file)
+		if errgo.Cause(err) != agent.ErrNoPassphrase {
+			return p, errgo.Mask(err)
+		}
+		p, err = readPassword(fmt.Sprintf("Passphrase for %s: ", file))
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if !confirm {
+			return p, nil
+		}
+		p1, err := readPassword("Confirm passphrase: ")
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if !bytes.Equal(p, p1) {
+			return nil, errgo.Newf("passphrases do not match")
+		}
+		return p, nil
+	}
+}
+
+func readPassword(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errgo.WithCausef(nil, agent.ErrNoPassphrase, "")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read passphrase")
+	}
+	if len(p) == 0 {
+		return nil, errgo.Newf("empty passphrase")
+	}
+	return p, nil
+}
+
+// writeFile atomically replaces the named file with the given
+// data, readable only by the current user.
+func writeFile(file string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), ".bakery-agent")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := f.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// The bakery-key-server command serves operations on a bakery private
+// key over a Unix domain socket, so that the key need not be held by
+// the programs that use it. Clients connect with keyserver.Dial.
+//
+// The key file holds a JSON-encoded key pair as printed by
+// bakery-keygen.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/keyserver"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bakery-key-server keyfile socket\n")
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+	}
+	if err := serve(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "bakery-key-server: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func serve(keyFile, socket string) error {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var key bakery.KeyPair
+	if err := json.Unmarshal(data, &key); err != nil {
+		return errgo.Notef(err, "cannot unmarshal key from %q", keyFile)
+	}
+	// Remove any socket left behind by an earlier server.
+	os.Remove(socket)
+	// Make sure that the socket is only accessible by the
+	// current user.
+	oldMask := umask(0177)
+	l, err := net.Listen("unix", socket)
+	umask(oldMask)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer l.Close()
+	return keyserver.NewServer(&key).Serve(l)
+}
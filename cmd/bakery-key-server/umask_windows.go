@@ -0,0 +1,5 @@
+package main
+
+func umask(mask int) int {
+	return 0
+}
@@ -0,0 +1,173 @@
+// The bakery-policy-check command checks that an identchecker policy
+// file (see identchecker.Policy) is valid and, optionally, that it makes
+// the expected decisions for a set of sample identities and operations.
+//
+// The sample cases are read from a YAML or JSON file, for example:
+//
+//	cases:
+//	- identity: bob
+//	  groups: [editors]
+//	  entity: doc-1
+//	  action: write
+//	  expect: allow
+//	- entity: doc-1
+//	  action: read
+//	  expect: deny
+//
+// A case with no identity is checked as an unauthenticated user. The
+// identity is considered to be a member of the listed groups and of the
+// group with the same name as the identity. If expect is omitted, the
+// decision is printed but not checked.
+//
+// The command exits with a non-zero status if the policy is invalid
+// or any decision does not match its expected value.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+)
+
+var casesFile = flag.String("cases", "", "check the decisions for the sample cases in the named file")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bakery-policy-check [-cases file] policyfile\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	ok, err := check(flag.Arg(0), *casesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bakery-policy-check: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// casesFileContents defines the format of the sample cases file.
+type casesFileContents struct {
+	Cases []sampleCase `yaml:"cases"`
+}
+
+type sampleCase struct {
+	Identity string   `yaml:"identity"`
+	Groups   []string `yaml:"groups"`
+	Entity   string   `yaml:"entity"`
+	Action   string   `yaml:"action"`
+	Expect   string   `yaml:"expect"`
+}
+
+// check checks the given policy file against the sample cases in
+// casesPath, if it is non-empty, printing the result of each case. It
+// reports whether all the decisions were as expected.
+func check(policyPath, casesPath string) (bool, error) {
+	data, err := ioutil.ReadFile(policyPath)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	policy, err := identchecker.ParsePolicy(data)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot parse %q", policyPath)
+	}
+	if casesPath == "" {
+		fmt.Printf("%s: ok\n", policyPath)
+		return true, nil
+	}
+	data, err = ioutil.ReadFile(casesPath)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	var cases casesFileContents
+	if err := yaml.UnmarshalStrict(data, &cases); err != nil {
+		return false, errgo.Notef(err, "cannot parse %q", casesPath)
+	}
+	ctx := context.Background()
+	allOK := true
+	for i, sc := range cases.Cases {
+		if sc.Expect != "" && sc.Expect != "allow" && sc.Expect != "deny" {
+			return false, errgo.Newf("case %d: invalid expect value %q", i, sc.Expect)
+		}
+		var ident identchecker.Identity
+		if sc.Identity != "" {
+			ident = sampleIdentity{
+				id:     sc.Identity,
+				groups: sc.Groups,
+			}
+		}
+		d, err := policy.Decide(ctx, ident, bakery.Op{
+			Entity: sc.Entity,
+			Action: sc.Action,
+		})
+		if err != nil {
+			return false, errgo.Notef(err, "case %d", i)
+		}
+		result := "deny"
+		if d.Allowed {
+			result = "allow"
+		}
+		status := "ok"
+		if sc.Expect != "" && sc.Expect != result {
+			status = "FAIL"
+			allOK = false
+		} else if sc.Expect == "" {
+			status = "-"
+		}
+		rule := d.RuleName
+		if rule == "" {
+			rule = "no matching rule"
+		}
+		user := sc.Identity
+		if user == "" {
+			user = "(unauthenticated)"
+		}
+		fmt.Printf("%s\t%s\t%s:%s\t%s (%s)\n", status, user, sc.Entity, sc.Action, result, rule)
+	}
+	return allOK, nil
+}
+
+// sampleIdentity implements identchecker.ACLIdentity for
+// an identity in a sample case.
+type sampleIdentity struct {
+	id     string
+	groups []string
+}
+
+// Id implements identchecker.Identity.Id.
+func (id sampleIdentity) Id() string {
+	return id.id
+}
+
+// Domain implements identchecker.Identity.Domain.
+func (id sampleIdentity) Domain() string {
+	return ""
+}
+
+// Allow implements identchecker.ACLIdentity.Allow.
+func (id sampleIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, g := range acl {
+		if g == id.id {
+			return true, nil
+		}
+		for _, g1 := range id.groups {
+			if g == g1 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -10,6 +10,7 @@ require (
 	github.com/juju/mgotest v1.0.3
 	github.com/juju/postgrestest v1.1.0
 	github.com/juju/qthttptest v0.1.3
+	github.com/juju/schema v1.0.0
 	github.com/juju/webbrowser v0.0.0-20160309143629-54b8c57083b4
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/rogpeppe/fastuuid v0.0.0-20150106093220-6724a57986af
@@ -20,11 +21,11 @@ require (
 	gopkg.in/juju/environschema.v1 v1.0.0
 	gopkg.in/macaroon.v2 v2.1.0
 	gopkg.in/yaml.v2 v2.4.0
+	rsc.io/qr v0.2.0
 )
 
 require (
 	github.com/golang/protobuf v1.4.3 // indirect
-	github.com/juju/schema v1.0.0 // indirect
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/lib/pq v1.3.0 // indirect
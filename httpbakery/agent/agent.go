@@ -8,12 +8,9 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"net/url"
 	"os"
-	"strings"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
@@ -27,6 +24,10 @@ import (
 // It holds the agent's private key and information
 // about the username associated with each
 // known agent-authentication server.
+//
+// Key is used by any agent that does not specify
+// its own key. It may be omitted if all agents have
+// their own keys.
 type AuthInfo struct {
 	Key    *bakery.KeyPair `json:"key,omitempty" yaml:"key,omitempty"`
 	Agents []Agent         `json:"agents" yaml:"agents"`
@@ -34,10 +35,19 @@ type AuthInfo struct {
 
 // Agent represents an agent that can be used for agent authentication.
 type Agent struct {
-	// URL holds the URL associated with the agent.
+	// URL holds the URL associated with the agent. The agent
+	// is used for discharge locations with the same scheme and
+	// host that are at or below the URL's path. The host
+	// may start with "*." to match any host with the given
+	// suffix. When several agents match, the one with the
+	// longest path is used, then one with an exact host, then
+	// the one with the longest wildcard host.
 	URL string `json:"url" yaml:"url"`
 	// Username holds the username to use for the agent.
 	Username string `json:"username" yaml:"username"`
+	// Key holds the key to use for the agent. If this is nil,
+	// the key in the enclosing AuthInfo is used.
+	Key *bakery.KeyPair `json:"key,omitempty" yaml:"key,omitempty"`
 }
 
 var ErrNoAuthInfo = errgo.New("no bakery agent info found in environment")
@@ -47,47 +57,68 @@ var ErrNoAuthInfo = errgo.New("no bakery agent info found in environment")
 //
 // It recognizes the following variable:
 // BAKERY_AGENT_FILE - path to a file containing agent authentication
-//    info in JSON format (as marshaled by the AuthInfo type).
+//    info in JSON format (as marshaled by the AuthInfo type), or
+//    encrypted with EncryptAuthInfo.
+//
+// If the file is encrypted, the passphrase is obtained
+// with DefaultPassphrase.
 //
 // If BAKERY_AGENT_FILE is not set, ErrNoAuthInfo will be returned.
 func AuthInfoFromEnvironment() (*AuthInfo, error) {
+	return AuthInfoFromEnvironmentWithPassphrase(DefaultPassphrase)
+}
+
+// AuthInfoFromEnvironmentWithPassphrase is like AuthInfoFromEnvironment
+// except that the given function is used to obtain the passphrase
+// when the agent file is encrypted. This can be used, for example, to
+// prompt the user for the passphrase.
+func AuthInfoFromEnvironmentWithPassphrase(passphrase PassphraseFunc) (*AuthInfo, error) {
 	agentFile := os.Getenv("BAKERY_AGENT_FILE")
 	if agentFile == "" {
 		return nil, errgo.WithCausef(nil, ErrNoAuthInfo, "")
 	}
-	var ai AuthInfo
-	data, err := ioutil.ReadFile(agentFile)
+	ai, err := ReadAuthInfoFile(agentFile, passphrase)
 	if err != nil {
-		return nil, errgo.Mask(err)
-	}
-	if err := json.Unmarshal(data, &ai); err != nil {
-		return nil, errgo.Notef(err, "cannot unmarshal agent information from %q: %v", agentFile, err)
+		return nil, errgo.Mask(err, errgo.Is(ErrNoPassphrase), errgo.Is(ErrBadPassphrase))
 	}
-	if ai.Key == nil {
-		return nil, errgo.Newf("no private key found in %q", agentFile)
-	}
-	return &ai, nil
+	return ai, nil
 }
 
 // SetUpAuth sets up agent authentication on the given client.
 // If this is called several times on the same client, earlier
 // calls will take precedence over later calls when there's
 // a URL and username match for both.
+//
+// If the client has no key, it is set to authInfo.Key. The client's
+// key need not match the agents' keys: each agent login uses the key
+// for the matching agent.
 func SetUpAuth(client *httpbakery.Client, authInfo *AuthInfo) error {
-	if authInfo.Key == nil {
-		return errgo.Newf("no key in auth info")
+	if err := authInfo.checkKeys(); err != nil {
+		return errgo.Mask(err)
 	}
-	if client.Key != nil {
-		if *client.Key != *authInfo.Key {
-			return errgo.Newf("client already has a different key set up")
-		}
-	} else {
+	if client.Key == nil {
 		client.Key = authInfo.Key
 	}
 	client.AddInteractor(interactor{authInfo})
 	return nil
 }
 
+// checkKeys checks that there is a key for every agent.
+func (ai *AuthInfo) checkKeys() error {
+	if ai.Key != nil {
+		return nil
+	}
+	if len(ai.Agents) == 0 {
+		return errgo.Newf("no key in auth info")
+	}
+	for _, a := range ai.Agents {
+		if a.Key == nil {
+			return errgo.Newf("no key in auth info for agent %q at %q", a.Username, a.URL)
+		}
+	}
+	return nil
+}
+
 // InteractionInfo holds the information expected in
 // the agent interaction entry in an interaction-required
 // error.
@@ -150,6 +181,7 @@ func (i interactor) Interact(ctx context.Context, client *httpbakery.Client, loc
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	key := i.key(agent)
 	loginURL, err := relativeURL(location, p.LoginURL)
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -159,7 +191,7 @@ func (i interactor) Interact(ctx context.Context, client *httpbakery.Client, loc
 		Doer: client,
 	}).CallURL(ctx, loginURL.String(), &agentMacaroonRequest{
 		Username:  agent.Username,
-		PublicKey: &client.Key.Public,
+		PublicKey: &key.Public,
 	}, &resp)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot acquire agent macaroon")
@@ -167,7 +199,10 @@ func (i interactor) Interact(ctx context.Context, client *httpbakery.Client, loc
 	if resp.Macaroon == nil {
 		return nil, errgo.Newf("no macaroon in response")
 	}
-	ms, err := client.DischargeAll(ctx, resp.Macaroon)
+	// Discharge the agent macaroon with the agent's key
+	// rather than the client's, as the agent macaroon's local
+	// third party caveat is addressed to the agent's key.
+	ms, err := bakery.DischargeAllWithKey(ctx, resp.Macaroon, client.AcquireDischarge, key)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot discharge agent macaroon")
 	}
@@ -184,15 +219,32 @@ func (i interactor) Interact(ctx context.Context, client *httpbakery.Client, loc
 // findAgent finds an appropriate agent entry
 // for the given location.
 func (i interactor) findAgent(location string) (*Agent, error) {
-	for _, a := range i.authInfo.Agents {
-		// Don't worry about trailing slashes
-		if strings.TrimSuffix(a.URL, "/") == strings.TrimSuffix(location, "/") {
-			return &a, nil
-		}
+	if a := findAgent(i.authInfo.Agents, location); a != nil {
+		return a, nil
 	}
 	return nil, errgo.WithCausef(nil, httpbakery.ErrInteractionMethodNotFound, "cannot find username for discharge location %q", location)
 }
 
+// key returns the key to use for the given agent.
+func (i interactor) key(a *Agent) *bakery.KeyPair {
+	return i.authInfo.agentKey(*a)
+}
+
+// agentKey returns the key to use for the given agent.
+func (ai *AuthInfo) agentKey(a Agent) *bakery.KeyPair {
+	if a.Key != nil {
+		return a.Key
+	}
+	return ai.Key
+}
+
+func keyEqual(k1, k2 *bakery.KeyPair) bool {
+	if k1 == nil || k2 == nil {
+		return k1 == k2
+	}
+	return *k1 == *k2
+}
+
 type agentLoginRequest struct {
 	httprequest.Route `httprequest:"POST"`
 	Body              LegacyAgentLoginBody `httprequest:",body"`
@@ -221,11 +273,18 @@ func (i interactor) LegacyInteract(ctx context.Context, client *httpbakery.Clien
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	// The legacy protocol issues a macaroon that is later
+	// discharged with the client's key, so the agent's key
+	// must be the same.
+	key := i.key(agent)
+	if client.Key == nil || *client.Key != *key {
+		return errgo.Newf("legacy agent login for %q requires the client key to match the agent key", agent.Username)
+	}
 	var resp LegacyAgentResponse
 	err = c.CallURL(ctx, visitURL.String(), &agentLoginRequest{
 		Body: LegacyAgentLoginBody{
 			Username:  agent.Username,
-			PublicKey: &client.Key.Public,
+			PublicKey: &key.Public,
 		},
 	}, &resp)
 	if err != nil {
@@ -196,3 +196,114 @@ func (h agentHandlers) AgentMacaroon(p httprequest.Params, r *agentMacaroonReque
 		Macaroon: m,
 	}, nil
 }
+
+func TestSetUpAuthWithPerAgentKeys(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	serverBakery := bakery.New(bakery.BakeryParams{
+		Locator: httpbakery.NewThirdPartyLocator(nil, nil),
+		Key:     bakery.MustGenerateKey(),
+	})
+	bobKey := bakery.MustGenerateKey()
+	charlieKey := bakery.MustGenerateKey()
+	bobDischarger := newAgentDischarger(c, "bob", &bobKey.Public)
+	charlieDischarger := newAgentDischarger(c, "charlie", &charlieKey.Public)
+
+	// Set up the client with a key that's different from both
+	// agent keys, which should be allowed.
+	client := httpbakery.NewClient()
+	client.Key = bakery.MustGenerateKey()
+	err := agent.SetUpAuth(client, &agent.AuthInfo{
+		Agents: []agent.Agent{{
+			URL:      bobDischarger.Location(),
+			Username: "bob",
+			Key:      bobKey,
+		}, {
+			URL:      charlieDischarger.Location(),
+			Username: "charlie",
+			Key:      charlieKey,
+		}},
+	})
+	c.Assert(err, qt.IsNil)
+	someOp := bakery.Op{
+		Entity: "something",
+		Action: "doit",
+	}
+	for _, d := range []*bakerytest.Discharger{bobDischarger, charlieDischarger} {
+		m, err := serverBakery.Oven.NewMacaroon(
+			context.Background(),
+			bakery.LatestVersion,
+			[]checkers.Caveat{{
+				Location:  d.Location(),
+				Condition: "some-third-party-caveat",
+			}},
+			someOp,
+		)
+		c.Assert(err, qt.IsNil)
+		ms, err := client.DischargeAll(context.Background(), m)
+		c.Assert(err, qt.IsNil)
+		_, err = serverBakery.Checker.Auth(ms).Allow(context.Background(), someOp)
+		c.Assert(err, qt.IsNil)
+	}
+}
+
+func TestSetUpAuthWithMissingAgentKey(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	client := httpbakery.NewClient()
+	err := agent.SetUpAuth(client, &agent.AuthInfo{
+		Agents: []agent.Agent{{
+			URL:      "https://0.1.2.3",
+			Username: "bob",
+			Key:      bakery.MustGenerateKey(),
+		}, {
+			URL:      "https://0.2.3.4",
+			Username: "charlie",
+		}},
+	})
+	c.Assert(err, qt.ErrorMatches, `no key in auth info for agent "charlie" at "https://0.2.3.4"`)
+	err = agent.SetUpAuth(client, &agent.AuthInfo{})
+	c.Assert(err, qt.ErrorMatches, `no key in auth info`)
+	c.Assert(client.Key, qt.IsNil)
+}
+
+// newAgentDischarger returns a discharger that requires agent
+// login as the given user with the given public key.
+func newAgentDischarger(c *qt.C, username string, pubKey *bakery.PublicKey) *bakerytest.Discharger {
+	d := bakerytest.NewDischarger(nil)
+	c.Defer(d.Close)
+	dischargerBakery := bakery.New(bakery.BakeryParams{
+		Key: d.Key,
+	})
+	d.AddHTTPHandlers(AgentHandlers(AgentHandler{
+		AgentMacaroon: func(p httprequest.Params, gotUsername string, gotPubKey *bakery.PublicKey) (*bakery.Macaroon, error) {
+			if gotUsername != username || *gotPubKey != *pubKey {
+				return nil, errgo.Newf("mismatched user/pubkey; want %s %s got %s %s", username, pubKey, gotUsername, gotPubKey)
+			}
+			return dischargerBakery.Oven.NewMacaroon(
+				context.Background(),
+				bakery.LatestVersion,
+				[]checkers.Caveat{
+					bakery.LocalThirdPartyCaveat(gotPubKey, httpbakery.RequestVersion(p.Request)),
+				},
+				agentLoginOp,
+			)
+		},
+	}))
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			var m macaroon.Slice
+			if err := m.UnmarshalBinary(p.Token.Value); err != nil {
+				return nil, errgo.Notef(err, "cannot unmarshal token")
+			}
+			if _, err := dischargerBakery.Checker.Auth(m).Allow(ctx, agentLoginOp); err != nil {
+				return nil, errgo.Newf("received unexpected discharge token")
+			}
+			return nil, nil
+		}
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		agent.SetInteraction(err, "/agent-macaroon")
+		return nil, err
+	})
+	return d
+}
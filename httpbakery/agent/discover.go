@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+)
+
+// DiscoverParams holds parameters for DiscoverAuthInfo.
+type DiscoverParams struct {
+	// Files holds the names of agent files to read in addition to
+	// those found in the environment. They take precedence over
+	// all other files, with earlier files taking precedence over
+	// later ones.
+	Files []string
+
+	// Passphrase is used to obtain the passphrase for any encrypted
+	// agent files. If it is nil, DefaultPassphrase is used.
+	Passphrase PassphraseFunc
+
+	// IgnoreXDG specifies that the XDG configuration
+	// directories should not be searched.
+	IgnoreXDG bool
+}
+
+// DiscoverAuthInfo returns agent information gathered from several
+// agent files, which may be plain or encrypted (see ReadAuthInfoFile).
+// The files are, in order of precedence:
+//
+// - the files named in p.Files;
+// - the files named in $BAKERY_AGENT_FILE, which may hold a list
+//    of files separated by the OS-specific path list separator;
+// - bakery/agent.json in $XDG_CONFIG_HOME (defaulting to ~/.config)
+//    and in each directory in $XDG_CONFIG_DIRS (defaulting to /etc/xdg).
+//
+// It is an error for a file named in p.Files or $BAKERY_AGENT_FILE to
+// be missing, but files in the XDG directories are optional.
+//
+// The agents from all the files are combined, with the agents from
+// files of higher precedence first, so that they will be used in
+// preference to agents with the same URL from other files. Agents
+// without their own key are given the key from the file they were
+// found in. The resulting default key is taken from the first file
+// that has one.
+//
+// If no files are found, an error with an ErrNoAuthInfo
+// cause is returned.
+func DiscoverAuthInfo(p DiscoverParams) (*AuthInfo, error) {
+	var files []string
+	files = append(files, p.Files...)
+	if env := os.Getenv("BAKERY_AGENT_FILE"); env != "" {
+		files = append(files, filepath.SplitList(env)...)
+	}
+	var ais []*AuthInfo
+	for _, f := range files {
+		ai, err := ReadAuthInfoFile(f, p.Passphrase)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrNoPassphrase), errgo.Is(ErrBadPassphrase))
+		}
+		ais = append(ais, ai)
+	}
+	if !p.IgnoreXDG {
+		for _, dir := range xdgConfigDirs() {
+			f := filepath.Join(dir, "bakery", "agent.json")
+			if _, err := os.Stat(f); os.IsNotExist(err) {
+				continue
+			}
+			ai, err := ReadAuthInfoFile(f, p.Passphrase)
+			if err != nil {
+				return nil, errgo.Mask(err, errgo.Is(ErrNoPassphrase), errgo.Is(ErrBadPassphrase))
+			}
+			ais = append(ais, ai)
+		}
+	}
+	if len(ais) == 0 {
+		return nil, errgo.WithCausef(nil, ErrNoAuthInfo, "")
+	}
+	return mergeAuthInfo(ais), nil
+}
+
+// mergeAuthInfo combines the given agent information,
+// in order of precedence.
+func mergeAuthInfo(ais []*AuthInfo) *AuthInfo {
+	var merged AuthInfo
+	for _, ai := range ais {
+		if merged.Key == nil {
+			merged.Key = ai.Key
+		}
+		for _, a := range ai.Agents {
+			a.Key = ai.agentKey(a)
+			merged.Agents = append(merged.Agents, a)
+		}
+	}
+	return &merged
+}
+
+// xdgConfigDirs returns the XDG configuration directories
+// in order of precedence.
+func xdgConfigDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		dirs = append(dirs, dir)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config"))
+	}
+	if env := os.Getenv("XDG_CONFIG_DIRS"); env != "" {
+		dirs = append(dirs, filepath.SplitList(env)...)
+	} else {
+		dirs = append(dirs, "/etc/xdg")
+	}
+	return dirs
+}
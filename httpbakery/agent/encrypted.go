@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	// ErrNoPassphrase is returned as the cause of errors when
+	// an agent file is encrypted but no passphrase is available.
+	ErrNoPassphrase = errgo.New("no passphrase available for encrypted agent file")
+
+	// ErrBadPassphrase is returned as the cause of errors when
+	// an encrypted agent file cannot be decrypted with the given
+	// passphrase.
+	ErrBadPassphrase = errgo.New("incorrect passphrase for encrypted agent file")
+)
+
+// PassphraseFunc returns the passphrase to use to decrypt the
+// agent file with the given name. It should return an error
+// with an ErrNoPassphrase cause if no passphrase is available.
+type PassphraseFunc func(file string) ([]byte, error)
+
+// PassphraseFromEnv returns a PassphraseFunc that reads the passphrase
+// from the given environment variable.
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		p := os.Getenv(name)
+		if p == "" {
+			return nil, errgo.WithCausef(nil, ErrNoPassphrase, "$%s not set", name)
+		}
+		return []byte(p), nil
+	}
+}
+
+// PassphraseFromCommand returns a PassphraseFunc that runs the given
+// command (for example a keyring helper) and uses its standard output,
+// with trailing white space removed, as the passphrase. The name of
+// the agent file is available to the command in the BAKERY_AGENT_FILE
+// environment variable.
+func PassphraseFromCommand(name string, args ...string) PassphraseFunc {
+	return func(file string) ([]byte, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(), "BAKERY_AGENT_FILE="+file)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, errgo.Notef(err, "cannot run passphrase command %q: %s", name, msg)
+			}
+			return nil, errgo.Notef(err, "cannot run passphrase command %q", name)
+		}
+		p := bytes.TrimRight(out, " \t\r\n")
+		if len(p) == 0 {
+			return nil, errgo.WithCausef(nil, ErrNoPassphrase, "passphrase command %q printed no passphrase", name)
+		}
+		return p, nil
+	}
+}
+
+// DefaultPassphrase is the PassphraseFunc used by
+// AuthInfoFromEnvironment. It uses the first of the following
+// environment variables that is set:
+//
+// BAKERY_AGENT_PASSPHRASE - the passphrase itself.
+// BAKERY_AGENT_PASSPHRASE_COMMAND - a command that prints the
+//    passphrase (see PassphraseFromCommand). The command is
+//    split into words at white space; no shell is involved.
+func DefaultPassphrase(file string) ([]byte, error) {
+	if os.Getenv("BAKERY_AGENT_PASSPHRASE") != "" {
+		return PassphraseFromEnv("BAKERY_AGENT_PASSPHRASE")(file)
+	}
+	if args := strings.Fields(os.Getenv("BAKERY_AGENT_PASSPHRASE_COMMAND")); len(args) > 0 {
+		return PassphraseFromCommand(args[0], args[1:]...)(file)
+	}
+	return nil, errgo.WithCausef(nil, ErrNoPassphrase, "neither $BAKERY_AGENT_PASSPHRASE nor $BAKERY_AGENT_PASSPHRASE_COMMAND is set")
+}
+
+// scrypt parameters used when encrypting. These follow the
+// recommendations for interactive logins in the scrypt package.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	// maxScryptN, maxScryptMemory and maxScryptWork bound the
+	// resources that decrypting a file can demand. Scrypt uses
+	// 128*N*r bytes of memory and time proportional to N*r*p.
+	maxScryptN      = 1 << 20
+	maxScryptMemory = 256 << 20
+	maxScryptWork   = 1 << 25
+)
+
+// encryptedFile holds the format of an encrypted agent file.
+// The "encrypted" field distinguishes it from a plain
+// AuthInfo, which has no such field.
+type encryptedFile struct {
+	Encrypted *encryptedAuthInfo `json:"encrypted"`
+}
+
+// encryptedAuthInfo holds the JSON-encoded AuthInfo sealed with
+// NaCl secretbox using a key derived from the passphrase with
+// scrypt.
+type encryptedAuthInfo struct {
+	KDF   scryptParams `json:"kdf"`
+	Nonce []byte       `json:"nonce"`
+	Box   []byte       `json:"box"`
+}
+
+type scryptParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// IsEncrypted reports whether the given agent file
+// contents are in the encrypted format.
+func IsEncrypted(data []byte) bool {
+	var f encryptedFile
+	return json.Unmarshal(data, &f) == nil && f.Encrypted != nil
+}
+
+// EncryptAuthInfo returns the given agent information encrypted with
+// the given passphrase, in a form suitable for writing to an agent
+// file.
+func EncryptAuthInfo(ai *AuthInfo, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errgo.Newf("empty passphrase")
+	}
+	plain, err := json.Marshal(ai)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	kdf := scryptParams{
+		Name: "scrypt",
+		Salt: make([]byte, 16),
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+	}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return nil, errgo.Notef(err, "cannot generate salt")
+	}
+	key, err := kdf.key(passphrase)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, errgo.Notef(err, "cannot generate nonce")
+	}
+	data, err := json.MarshalIndent(encryptedFile{
+		Encrypted: &encryptedAuthInfo{
+			KDF:   kdf,
+			Nonce: nonce[:],
+			Box:   secretbox.Seal(nil, plain, &nonce, key),
+		},
+	}, "", "\t")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return append(data, '\n'), nil
+}
+
+// DecryptAuthInfo decrypts agent information as returned by
+// EncryptAuthInfo. If the passphrase is incorrect, it returns an
+// error with an ErrBadPassphrase cause.
+func DecryptAuthInfo(data, passphrase []byte) (*AuthInfo, error) {
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if f.Encrypted == nil {
+		return nil, errgo.Newf("agent information is not encrypted")
+	}
+	e := f.Encrypted
+	var nonce [24]byte
+	if len(e.Nonce) != len(nonce) {
+		return nil, errgo.Newf("invalid nonce length %d", len(e.Nonce))
+	}
+	copy(nonce[:], e.Nonce)
+	key, err := e.KDF.key(passphrase)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	plain, ok := secretbox.Open(nil, e.Box, &nonce, key)
+	if !ok {
+		return nil, errgo.WithCausef(nil, ErrBadPassphrase, "")
+	}
+	var ai AuthInfo
+	if err := json.Unmarshal(plain, &ai); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal decrypted agent information")
+	}
+	return &ai, nil
+}
+
+// key derives a secretbox key from the given passphrase.
+func (p scryptParams) key(passphrase []byte) (*[32]byte, error) {
+	if p.Name != "scrypt" {
+		return nil, errgo.Newf("unsupported key derivation function %q", p.Name)
+	}
+	if p.N > maxScryptN {
+		return nil, errgo.Newf("scrypt parameter N=%d too large", p.N)
+	}
+	if p.N < 2 || p.R < 1 || p.P < 1 {
+		return nil, errgo.Newf("invalid scrypt parameters N=%d r=%d p=%d", p.N, p.R, p.P)
+	}
+	// Divide rather than multiply so that large
+	// values of r and p cannot overflow.
+	if p.R > maxScryptMemory/(128*p.N) {
+		return nil, errgo.Newf("scrypt parameters N=%d r=%d need too much memory", p.N, p.R)
+	}
+	if p.P > maxScryptWork/(p.N*p.R) {
+		return nil, errgo.Newf("scrypt parameters N=%d r=%d p=%d need too much work", p.N, p.R, p.P)
+	}
+	k, err := scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, 32)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot derive key")
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// ReadAuthInfoFile reads agent information from the given file,
+// which may be in either the plain JSON format or the encrypted
+// format produced by EncryptAuthInfo. The passphrase function is
+// only called if the file is encrypted; if it is nil,
+// DefaultPassphrase is used.
+func ReadAuthInfoFile(file string, passphrase PassphraseFunc) (*AuthInfo, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var ai *AuthInfo
+	if IsEncrypted(data) {
+		if passphrase == nil {
+			passphrase = DefaultPassphrase
+		}
+		p, err := passphrase(file)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get passphrase for "+file, errgo.Is(ErrNoPassphrase))
+		}
+		ai, err = DecryptAuthInfo(data, p)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot decrypt agent information from "+file, errgo.Is(ErrBadPassphrase))
+		}
+	} else {
+		ai = new(AuthInfo)
+		if err := json.Unmarshal(data, ai); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal agent information from %q: %v", file, err)
+		}
+	}
+	if ai.Key == nil && len(ai.Agents) == 0 {
+		return nil, errgo.Newf("no private key found in %q", file)
+	}
+	if err := ai.checkKeys(); err != nil {
+		return nil, errgo.Notef(err, "invalid agent information in %q", file)
+	}
+	return ai, nil
+}
@@ -0,0 +1,129 @@
+package agent_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/agent"
+)
+
+var testAuthInfo = &agent.AuthInfo{
+	Key: bakery.MustGenerateKey(),
+	Agents: []agent.Agent{{
+		URL:      "https://0.1.2.3/x",
+		Username: "bob",
+	}},
+}
+
+var encryptedAuthInfoFromEnvironmentTests = []struct {
+	about       string
+	env         map[string]string
+	passphrase  agent.PassphraseFunc
+	expectError string
+	expectCause error
+}{{
+	about: "passphrase in environment",
+	env: map[string]string{
+		"BAKERY_AGENT_PASSPHRASE": "secret",
+	},
+}, {
+	about: "passphrase from command",
+	env: map[string]string{
+		"BAKERY_AGENT_PASSPHRASE_COMMAND": "echo secret",
+	},
+}, {
+	about: "passphrase from callback",
+	passphrase: func(string) ([]byte, error) {
+		return []byte("secret"), nil
+	},
+}, {
+	about:       "no passphrase",
+	expectError: `cannot get passphrase for .*: neither \$BAKERY_AGENT_PASSPHRASE nor \$BAKERY_AGENT_PASSPHRASE_COMMAND is set`,
+	expectCause: agent.ErrNoPassphrase,
+}, {
+	about: "incorrect passphrase",
+	env: map[string]string{
+		"BAKERY_AGENT_PASSPHRASE": "wrong",
+	},
+	expectError: `cannot decrypt agent information from .*: incorrect passphrase for encrypted agent file`,
+	expectCause: agent.ErrBadPassphrase,
+}, {
+	about: "passphrase command fails",
+	env: map[string]string{
+		"BAKERY_AGENT_PASSPHRASE_COMMAND": "false",
+	},
+	expectError: `cannot get passphrase for .*: cannot run passphrase command "false": exit status 1`,
+}}
+
+func TestEncryptedAuthInfoFromEnvironment(t *testing.T) {
+	c := qt.New(t)
+	data, err := agent.EncryptAuthInfo(testAuthInfo, []byte("secret"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(agent.IsEncrypted(data), qt.Equals, true)
+	file := filepath.Join(c.TempDir(), "agent.json")
+	err = ioutil.WriteFile(file, data, 0600)
+	c.Assert(err, qt.IsNil)
+
+	for _, test := range encryptedAuthInfoFromEnvironmentTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Setenv("BAKERY_AGENT_FILE", file)
+			c.Setenv("BAKERY_AGENT_PASSPHRASE", "")
+			c.Setenv("BAKERY_AGENT_PASSPHRASE_COMMAND", "")
+			for k, v := range test.env {
+				c.Setenv(k, v)
+			}
+			var ai *agent.AuthInfo
+			var err error
+			if test.passphrase != nil {
+				ai, err = agent.AuthInfoFromEnvironmentWithPassphrase(test.passphrase)
+			} else {
+				ai, err = agent.AuthInfoFromEnvironment()
+			}
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				if test.expectCause != nil {
+					c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+				}
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(ai, qt.DeepEquals, testAuthInfo)
+		})
+	}
+}
+
+func TestReadPlainAuthInfoFileDoesNotNeedPassphrase(t *testing.T) {
+	c := qt.New(t)
+	file := filepath.Join(c.TempDir(), "agent.json")
+	err := ioutil.WriteFile(file, []byte(`{"key":{"public":"`+testAuthInfo.Key.Public.String()+`","private":"`+testAuthInfo.Key.Private.String()+`"},"agents":[{"url":"https://0.1.2.3/x","username":"bob"}]}`), 0600)
+	c.Assert(err, qt.IsNil)
+	ai, err := agent.ReadAuthInfoFile(file, func(string) ([]byte, error) {
+		c.Errorf("passphrase function called unexpectedly")
+		return nil, nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ai, qt.DeepEquals, testAuthInfo)
+}
+
+func TestDecryptAuthInfoErrors(t *testing.T) {
+	c := qt.New(t)
+	_, err := agent.EncryptAuthInfo(testAuthInfo, nil)
+	c.Assert(err, qt.ErrorMatches, `empty passphrase`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"key":null}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `agent information is not encrypted`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"encrypted":{"kdf":{"name":"scrypt","n":1073741824,"r":8,"p":1},"nonce":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `scrypt parameter N=1073741824 too large`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"encrypted":{"kdf":{"name":"scrypt","n":1048576,"r":1000000,"p":1},"nonce":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `scrypt parameters N=1048576 r=1000000 need too much memory`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"encrypted":{"kdf":{"name":"scrypt","n":32768,"r":8,"p":1000000},"nonce":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `scrypt parameters N=32768 r=8 p=1000000 need too much work`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"encrypted":{"kdf":{"name":"scrypt","n":32768,"r":0,"p":1},"nonce":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `invalid scrypt parameters N=32768 r=0 p=1`)
+	_, err = agent.DecryptAuthInfo([]byte(`{"encrypted":{"kdf":{"name":"other"},"nonce":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}}`), []byte("secret"))
+	c.Assert(err, qt.ErrorMatches, `unsupported key derivation function "other"`)
+}
@@ -3,3 +3,5 @@ package agent
 type AgentLogin agentLogin
 
 const CookieName = cookieName
+
+var FindAgent = findAgent
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// agentPattern holds a parsed Agent.URL.
+//
+// An agent URL matches a discharge location when the schemes are
+// the same, the hosts match and the location's path is equal to, or
+// is below, the agent URL's path. The host may start with "*.", in
+// which case it matches any host with the given suffix, on any port
+// unless the agent URL specifies one; for example
+// "https://*.example.com" matches "https://idm.example.com",
+// "https://idm.example.com:8443" and "https://a.b.example.com", but
+// not "https://example.com".
+type agentPattern struct {
+	scheme   string
+	host     string
+	wildcard bool
+	path     string
+
+	// port holds the port specified in a wildcard agent URL.
+	// It is always empty when wildcard is false, because
+	// any port is then part of host.
+	port string
+}
+
+// parseAgentPattern parses the given agent URL.
+func parseAgentPattern(s string) (agentPattern, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return agentPattern{}, errgo.Mask(err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return agentPattern{}, errgo.Newf("agent URL %q is not absolute", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return agentPattern{}, errgo.Newf("agent URL %q has a query or fragment", s)
+	}
+	p := agentPattern{
+		scheme: strings.ToLower(u.Scheme),
+		host:   strings.ToLower(u.Host),
+		path:   strings.TrimSuffix(u.Path, "/"),
+	}
+	if strings.HasPrefix(p.host, "*.") {
+		p.wildcard = true
+		p.host = strings.ToLower(u.Hostname())[1:]
+		p.port = u.Port()
+	}
+	if strings.Contains(p.host, "*") {
+		return agentPattern{}, errgo.Newf("agent URL %q has a wildcard that is not at the start of the host", s)
+	}
+	return p, nil
+}
+
+// match reports whether the pattern matches the given location.
+func (p agentPattern) match(loc *url.URL) bool {
+	if strings.ToLower(loc.Scheme) != p.scheme {
+		return false
+	}
+	if p.wildcard {
+		host := strings.ToLower(loc.Hostname())
+		if len(host) <= len(p.host) || !strings.HasSuffix(host, p.host) {
+			return false
+		}
+		if p.port != "" && loc.Port() != p.port {
+			return false
+		}
+	} else if strings.ToLower(loc.Host) != p.host {
+		return false
+	}
+	if p.path == "" {
+		return true
+	}
+	path := strings.TrimSuffix(loc.Path, "/")
+	return path == p.path || strings.HasPrefix(path, p.path+"/")
+}
+
+// moreSpecific reports whether p takes precedence over q when both
+// match a location. A longer path is more specific, so an entry for a
+// particular path overrides one for its host. For equal paths, an
+// exact host is more specific than a wildcard, and a longer wildcard
+// suffix is more specific than a shorter one.
+func (p agentPattern) moreSpecific(q agentPattern) bool {
+	if len(p.path) != len(q.path) {
+		return len(p.path) > len(q.path)
+	}
+	if p.wildcard != q.wildcard {
+		return !p.wildcard
+	}
+	return len(p.host) > len(q.host)
+}
+
+func (p agentPattern) String() string {
+	host := p.host
+	if p.wildcard {
+		host = "*" + host
+		if p.port != "" {
+			host += ":" + p.port
+		}
+	}
+	return p.scheme + "://" + host + p.path
+}
+
+// findAgent returns the agent in agents that best matches the given
+// location, or nil if there is none. When several agents have the
+// same URL, the first one is used. Agents with invalid URLs are
+// ignored.
+func findAgent(agents []Agent, location string) *Agent {
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	var best *Agent
+	var bestPattern agentPattern
+	for i := range agents {
+		p, err := parseAgentPattern(agents[i].URL)
+		if err != nil || !p.match(loc) {
+			continue
+		}
+		if best == nil || p.moreSpecific(bestPattern) {
+			best, bestPattern = &agents[i], p
+		}
+	}
+	return best
+}
+
+// Validate checks the agent entries in ai. It reports an error
+// if any entry has an invalid URL, has no key, is ambiguous (has the
+// same URL as an earlier entry but a different username or key, so
+// the earlier entry is always used instead) or is shadowed (is an
+// exact duplicate of an earlier entry).
+func (ai *AuthInfo) Validate() error {
+	var problems []string
+	seen := make(map[agentPattern]int)
+	for i, a := range ai.Agents {
+		p, err := parseAgentPattern(a.URL)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("agent %d: %v", i, err))
+			continue
+		}
+		if a.Key == nil && ai.Key == nil {
+			problems = append(problems, fmt.Sprintf("agent %d (%q at %q) has no key", i, a.Username, a.URL))
+		}
+		j, ok := seen[p]
+		if !ok {
+			seen[p] = i
+			continue
+		}
+		prev := ai.Agents[j]
+		if prev.Username == a.Username && keyEqual(ai.agentKey(prev), ai.agentKey(a)) {
+			problems = append(problems, fmt.Sprintf("agent %d (%q at %q) is shadowed by agent %d", i, a.Username, a.URL, j))
+		} else {
+			problems = append(problems, fmt.Sprintf("agent %d (%q at %q) is ambiguous with agent %d (%q at %q)", i, a.Username, a.URL, j, prev.Username, prev.URL))
+		}
+	}
+	if len(problems) > 0 {
+		return errgo.Newf("invalid agent information: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
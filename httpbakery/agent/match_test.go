@@ -0,0 +1,251 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/agent"
+)
+
+var findAgentAgents = []agent.Agent{{
+	URL:      "https://idm.example.com",
+	Username: "exact",
+}, {
+	URL:      "https://*.example.com",
+	Username: "wildcard",
+}, {
+	URL:      "https://*.eu.example.com/",
+	Username: "longer-wildcard",
+}, {
+	URL:      "https://*.example.com/special",
+	Username: "path-override",
+}, {
+	URL:      "https://idm.example.com",
+	Username: "duplicate",
+}, {
+	URL:      "http://plain.example.com:8080/a/b",
+	Username: "port",
+}, {
+	URL:      "https://*.ports.example.com:9443",
+	Username: "wildcard-port",
+}, {
+	URL:      "::bad",
+	Username: "bad",
+}}
+
+var findAgentTests = []struct {
+	location       string
+	expectUsername string
+}{{
+	location:       "https://idm.example.com",
+	expectUsername: "exact",
+}, {
+	location:       "https://IDM.example.com/",
+	expectUsername: "exact",
+}, {
+	location:       "https://idm.example.com/foo",
+	expectUsername: "exact",
+}, {
+	location:       "https://other.example.com",
+	expectUsername: "wildcard",
+}, {
+	location:       "https://a.b.example.com",
+	expectUsername: "wildcard",
+}, {
+	location:       "https://idm.example.com:8443",
+	expectUsername: "wildcard",
+}, {
+	location:       "https://idm.ports.example.com:9443/x",
+	expectUsername: "wildcard-port",
+}, {
+	location:       "https://idm.ports.example.com:8443",
+	expectUsername: "wildcard",
+}, {
+	location:       "https://idm.eu.example.com",
+	expectUsername: "longer-wildcard",
+}, {
+	location:       "https://idm.example.com/special/x",
+	expectUsername: "path-override",
+}, {
+	location:       "https://idm.example.com/specialx",
+	expectUsername: "exact",
+}, {
+	location:       "https://example.com",
+	expectUsername: "",
+}, {
+	location:       "http://idm.example.com",
+	expectUsername: "",
+}, {
+	location:       "http://plain.example.com:8080/a/b/c",
+	expectUsername: "port",
+}, {
+	location:       "http://plain.example.com:8080/a",
+	expectUsername: "",
+}, {
+	location:       "http://plain.example.com/a/b",
+	expectUsername: "",
+}}
+
+func TestFindAgent(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range findAgentTests {
+		c.Run(test.location, func(c *qt.C) {
+			a := agent.FindAgent(findAgentAgents, test.location)
+			if test.expectUsername == "" {
+				c.Assert(a, qt.IsNil)
+				return
+			}
+			c.Assert(a, qt.Not(qt.IsNil))
+			c.Assert(a.Username, qt.Equals, test.expectUsername)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+	key1 := bakery.MustGenerateKey()
+	key2 := bakery.MustGenerateKey()
+	ai := &agent.AuthInfo{
+		Key: key1,
+		Agents: []agent.Agent{{
+			URL:      "https://idm.example.com/",
+			Username: "bob",
+		}, {
+			URL:      "https://IDM.example.com",
+			Username: "bob",
+			Key:      key1,
+		}, {
+			URL:      "https://idm.example.com",
+			Username: "bob",
+			Key:      key2,
+		}, {
+			URL:      "https://*.example.com",
+			Username: "alice",
+		}, {
+			URL:      "https://*.example.com",
+			Username: "charlie",
+		}, {
+			URL:      "/relative",
+			Username: "dave",
+		}, {
+			URL:      "https://idm.*.com",
+			Username: "eve",
+		}},
+	}
+	err := ai.Validate()
+	c.Assert(err, qt.ErrorMatches, `invalid agent information: `+
+		`agent 1 \("bob" at "https://IDM.example.com"\) is shadowed by agent 0; `+
+		`agent 2 \("bob" at "https://idm.example.com"\) is ambiguous with agent 0 \("bob" at "https://idm.example.com/"\); `+
+		`agent 4 \("charlie" at "https://\*.example.com"\) is ambiguous with agent 3 \("alice" at "https://\*.example.com"\); `+
+		`agent 5: agent URL "/relative" is not absolute; `+
+		`agent 6: agent URL "https://idm.\*.com" has a wildcard that is not at the start of the host`)
+
+	ai = &agent.AuthInfo{
+		Agents: []agent.Agent{{
+			URL:      "https://idm.example.com",
+			Username: "bob",
+		}, {
+			URL:      "https://idm.example.com/x",
+			Username: "bob",
+			Key:      key1,
+		}},
+	}
+	err = ai.Validate()
+	c.Assert(err, qt.ErrorMatches, `invalid agent information: agent 0 \("bob" at "https://idm.example.com"\) has no key`)
+	ai.Key = key2
+	c.Assert(ai.Validate(), qt.IsNil)
+}
+
+func TestDiscoverAuthInfo(t *testing.T) {
+	c := qt.New(t)
+	dir := c.TempDir()
+	key1 := bakery.MustGenerateKey()
+	key2 := bakery.MustGenerateKey()
+	key3 := bakery.MustGenerateKey()
+	writeAuthInfo := func(file string, ai *agent.AuthInfo) string {
+		file = filepath.Join(dir, file)
+		err := os.MkdirAll(filepath.Dir(file), 0700)
+		c.Assert(err, qt.IsNil)
+		data, err := json.Marshal(ai)
+		c.Assert(err, qt.IsNil)
+		err = ioutil.WriteFile(file, data, 0600)
+		c.Assert(err, qt.IsNil)
+		return file
+	}
+	explicit := writeAuthInfo("explicit.json", &agent.AuthInfo{
+		Agents: []agent.Agent{{
+			URL:      "https://a.example.com",
+			Username: "explicit",
+			Key:      key1,
+		}},
+	})
+	env := writeAuthInfo("env.json", &agent.AuthInfo{
+		Key: key2,
+		Agents: []agent.Agent{{
+			URL:      "https://a.example.com",
+			Username: "env",
+		}, {
+			URL:      "https://b.example.com",
+			Username: "env",
+		}},
+	})
+	writeAuthInfo("config/bakery/agent.json", &agent.AuthInfo{
+		Key: key3,
+		Agents: []agent.Agent{{
+			URL:      "https://*.example.com",
+			Username: "xdg",
+		}},
+	})
+	c.Setenv("BAKERY_AGENT_FILE", env)
+	c.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	c.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "nonexistent"))
+
+	ai, err := agent.DiscoverAuthInfo(agent.DiscoverParams{
+		Files: []string{explicit},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ai, qt.DeepEquals, &agent.AuthInfo{
+		Key: key2,
+		Agents: []agent.Agent{{
+			URL:      "https://a.example.com",
+			Username: "explicit",
+			Key:      key1,
+		}, {
+			URL:      "https://a.example.com",
+			Username: "env",
+			Key:      key2,
+		}, {
+			URL:      "https://b.example.com",
+			Username: "env",
+			Key:      key2,
+		}, {
+			URL:      "https://*.example.com",
+			Username: "xdg",
+			Key:      key3,
+		}},
+	})
+
+	ai, err = agent.DiscoverAuthInfo(agent.DiscoverParams{
+		IgnoreXDG: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ai.Agents, qt.HasLen, 2)
+
+	c.Setenv("BAKERY_AGENT_FILE", "")
+	_, err = agent.DiscoverAuthInfo(agent.DiscoverParams{
+		IgnoreXDG: true,
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, agent.ErrNoAuthInfo)
+
+	_, err = agent.DiscoverAuthInfo(agent.DiscoverParams{
+		Files: []string{filepath.Join(dir, "nonexistent.json")},
+	})
+	c.Assert(err, qt.ErrorMatches, `open .*nonexistent.json: no such file or directory`)
+}
@@ -0,0 +1,124 @@
+package httpbakery_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func TestDischargerAuditEvents(t *testing.T) {
+	c := qt.New(t)
+	var (
+		mu     sync.Mutex
+		events []bakery.AuditEvent
+	)
+	key := bakery.MustGenerateKey()
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Key: key,
+		CheckerP: httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+			switch string(p.Caveat.Condition) {
+			case "ok":
+				return []checkers.Caveat{checkers.DeclaredCaveat("user", "bob")}, nil
+			case "interact":
+				if p.Token != nil {
+					return nil, nil
+				}
+				err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+				err.SetInteraction("test", nil)
+				return nil, err
+			}
+			return nil, errgo.Newf("refused")
+		}),
+		Auditor: bakery.AuditorFunc(func(ctx context.Context, e bakery.AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		}),
+	})
+	srv := httptest.NewServer(handlersToMux(d))
+	defer srv.Close()
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo(srv.URL, bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	b := newBakery("loc", locator, nil)
+	client := httpbakery.NewClient()
+	client.AddInteractor(tokenInteractor{})
+
+	tests := []struct {
+		condition   string
+		expectKinds []bakery.AuditEventKind
+		expectError string
+	}{{
+		condition:   "ok",
+		expectKinds: []bakery.AuditEventKind{bakery.AuditDischargeIssued},
+	}, {
+		condition: "interact",
+		expectKinds: []bakery.AuditEventKind{
+			bakery.AuditInteractionStarted,
+			bakery.AuditInteractionCompleted,
+			bakery.AuditDischargeIssued,
+		},
+	}, {
+		condition:   "other",
+		expectKinds: []bakery.AuditEventKind{bakery.AuditDischargeRefused},
+		expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: refused`,
+	}}
+	for _, test := range tests {
+		c.Run(test.condition, func(c *qt.C) {
+			events = nil
+			m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{{
+				Location:  srv.URL,
+				Condition: test.condition,
+			}}, testOp)
+			c.Assert(err, qt.IsNil)
+			ms, err := client.DischargeAll(testContext, m)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+			} else {
+				c.Assert(err, qt.IsNil)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			var kinds []bakery.AuditEventKind
+			for _, e := range events {
+				kinds = append(kinds, e.Kind)
+				c.Assert(e.Conditions, qt.DeepEquals, []string{test.condition})
+				switch e.Kind {
+				case bakery.AuditDischargeRefused:
+					c.Assert(e.Error, qt.Equals, "refused")
+					continue
+				case bakery.AuditInteractionStarted:
+					c.Assert(e.Error, qt.Equals, "interaction required")
+					continue
+				}
+				c.Assert(e.MacaroonIds, qt.DeepEquals, [][]byte{ms[1].Id()})
+			}
+			c.Assert(kinds, qt.DeepEquals, test.expectKinds)
+		})
+	}
+}
+
+// tokenInteractor is an Interactor that always
+// returns a discharge token.
+type tokenInteractor struct{}
+
+func (tokenInteractor) Kind() string {
+	return "test"
+}
+
+func (tokenInteractor) Interact(ctx context.Context, client *httpbakery.Client, location string, ierr *httpbakery.Error) (*httpbakery.DischargeToken, error) {
+	return &httpbakery.DischargeToken{
+		Kind:  "test",
+		Value: []byte("token"),
+	}, nil
+}
@@ -15,11 +15,16 @@ type httpRequestKey struct{}
 // ContextWithRequest returns the context with information from the
 // given request attached as context.  This is used by the httpbakery
 // checkers (see RegisterCheckers for details).
+//
+// It does not attach a request id for logging; use
+// ContextWithRequestIDHeader or RequestIDHandler for that.
 func ContextWithRequest(ctx context.Context, req *http.Request) context.Context {
 	return context.WithValue(ctx, httpRequestKey{}, req)
 }
 
-func requestFromContext(ctx context.Context) *http.Request {
+// RequestFromContext returns the request attached to the context
+// by ContextWithRequest, or nil if there is none.
+func RequestFromContext(ctx context.Context) *http.Request {
 	req, _ := ctx.Value(httpRequestKey{}).(*http.Request)
 	return req
 }
@@ -72,7 +77,7 @@ func NewChecker() *checkers.Checker {
 // ipAddrCheck implements the IP client address checker
 // for an HTTP request.
 func ipAddrCheck(ctx context.Context, cond, args string) error {
-	req := requestFromContext(ctx)
+	req := RequestFromContext(ctx)
 	if req == nil {
 		return errgo.Newf("no IP address found in context")
 	}
@@ -96,7 +101,7 @@ func ipAddrCheck(ctx context.Context, cond, args string) error {
 // clientOriginCheck implements the Origin header checker
 // for an HTTP request.
 func clientOriginCheck(ctx context.Context, cond, args string) error {
-	req := requestFromContext(ctx)
+	req := RequestFromContext(ctx)
 	if req == nil {
 		return errgo.Newf("no origin found in context")
 	}
@@ -119,9 +119,19 @@ type Client struct {
 	// bakery.LocalThirdPartyCaveat for more information
 	Key *bakery.KeyPair
 
+	// KeyOpener may be set instead of Key when the client's private
+	// key is not held in memory. If both are set, KeyOpener is used
+	// to discharge "local" third party caveats.
+	KeyOpener bakery.KeyOpener
+
 	// Logger is used to log information about client activities.
 	// If it is nil, bakery.DefaultLogger("httpbakery") will be used.
 	Logger bakery.Logger
+
+	// Metrics is used to record the MetricDischargeAcquire and
+	// MetricInteraction metrics. If it is nil, no metrics will
+	// be recorded.
+	Metrics bakery.Metrics
 }
 
 // An Interactor represents a way of persuading a discharger
@@ -197,13 +207,25 @@ func (c *Client) AddInteractor(i Interactor) {
 // The returned macaroon slice will not be stored in the client
 // cookie jar (see SetCookie if you need to do that).
 func (c *Client) DischargeAll(ctx context.Context, m *bakery.Macaroon) (macaroon.Slice, error) {
-	return bakery.DischargeAllWithKey(ctx, m, c.AcquireDischarge, c.Key)
+	return bakery.DischargeAllWithKeyOpener(ctx, m, c.AcquireDischarge, c.localKey())
 }
 
 // DischargeAllUnbound is like DischargeAll except that it does not
 // bind the resulting macaroons.
 func (c *Client) DischargeAllUnbound(ctx context.Context, ms bakery.Slice) (bakery.Slice, error) {
-	return ms.DischargeAll(ctx, c.AcquireDischarge, c.Key)
+	return ms.DischargeAllWithKeyOpener(ctx, c.AcquireDischarge, c.localKey())
+}
+
+// localKey returns the key to use to discharge
+// "local" third party caveats, or nil if there is none.
+func (c *Client) localKey() bakery.KeyOpener {
+	if c.KeyOpener != nil {
+		return c.KeyOpener
+	}
+	if c.Key != nil {
+		return c.Key
+	}
+	return nil
 }
 
 // Do is like DoWithContext, except the context is automatically derived.
@@ -252,9 +274,11 @@ func (c *Client) DoWithCustomError(req *http.Request, getError func(resp *http.R
 }
 
 func (c *Client) do(ctx context.Context, req *http.Request, getError func(resp *http.Response) error) (*http.Response, error) {
-	c.logDebugf(ctx, "client do %s %s {", req.Method, req.URL)
+	c.log(ctx, bakery.LevelDebug, "client request", bakery.Field("method", req.Method), bakery.Field("url", req.URL.String()))
 	resp, err := c.do1(ctx, req, getError)
-	c.logDebugf(ctx, "} -> error %#v", err)
+	if err != nil {
+		c.log(ctx, bakery.LevelDebug, "client request failed", bakery.Field("method", req.Method), bakery.Field("url", req.URL.String()), bakery.ErrorField(err))
+	}
 	return resp, err
 }
 
@@ -289,7 +313,7 @@ func (c *Client) do1(ctx context.Context, req *http.Request, getError func(resp
 		if err1 := c.HandleError(ctx, req.URL, err); err1 != nil {
 			return nil, errgo.Mask(err1, errgo.Any)
 		}
-		c.logDebugf(ctx, "discharge succeeded; retry %d", retry)
+		c.log(ctx, bakery.LevelDebug, "discharge succeeded; retrying request", bakery.Field("retry", retry))
 	}
 }
 
@@ -300,7 +324,7 @@ func (c *Client) do2(ctx context.Context, rreq *retryableRequest, getError func(
 	}
 	err = getError(httpResp)
 	if err == nil {
-		c.logInfof(ctx, "HTTP response OK (status %v)", httpResp.Status)
+		c.log(ctx, bakery.LevelInfo, "HTTP response OK", bakery.Field("status", httpResp.Status))
 		return httpResp, nil
 	}
 	httpResp.Body.Close()
@@ -328,7 +352,7 @@ func (c *Client) HandleError(ctx context.Context, reqURL *url.URL, err error) er
 		return errgo.New("no macaroon found in discharge-required response")
 	}
 	mac := respErr.Info.Macaroon
-	macaroons, err := bakery.DischargeAllWithKey(ctx, mac, c.AcquireDischarge, c.Key)
+	macaroons, err := bakery.DischargeAllWithKeyOpener(ctx, mac, c.AcquireDischarge, c.localKey())
 	if err != nil {
 		return errgo.Mask(err, errgo.Any)
 	}
@@ -336,7 +360,7 @@ func (c *Client) HandleError(ctx context.Context, reqURL *url.URL, err error) er
 	if path := respErr.Info.MacaroonPath; path != "" {
 		relURL, err := parseURLPath(path)
 		if err != nil {
-			c.logInfof(ctx, "ignoring invalid path in discharge-required response: %v", err)
+			c.log(ctx, bakery.LevelWarn, "ignoring invalid path in discharge-required response", bakery.ErrorField(err))
 		} else {
 			cookiePath = reqURL.ResolveReference(relURL).Path
 		}
@@ -460,7 +484,13 @@ func appendURLElem(u, elem string) string {
 
 // AcquireDischarge acquires a discharge macaroon from the caveat location as an HTTP URL.
 // It fits the getDischarge argument type required by bakery.DischargeAll.
-func (c *Client) AcquireDischarge(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+func (c *Client) AcquireDischarge(ctx context.Context, cav macaroon.Caveat, payload []byte) (_ *bakery.Macaroon, err error) {
+	defer func(start time.Time) {
+		observe(c.Metrics, MetricDischargeAcquire, map[string]string{
+			"location": locationLabel(cav.Location),
+			"outcome":  outcome(err),
+		}, start)
+	}(time.Now())
 	m, err := c.acquireDischarge(ctx, cav, payload, nil)
 	if err == nil {
 		return m, nil
@@ -533,17 +563,31 @@ func (c *Client) interact(ctx context.Context, location string, irErr *Error, pa
 	}
 	if irErr.Info.InteractionMethods == nil && irErr.Info.LegacyVisitURL != "" {
 		// It's an old-style error; deal with it differently.
+		start := time.Now()
 		m, err := c.legacyInteract(ctx, location, irErr)
+		observe(c.Metrics, MetricInteraction, map[string]string{
+			"kind":    "legacy",
+			"outcome": outcome(err),
+		}, start)
 		if err != nil {
 			return nil, nil, errgo.Mask(err, IsDischargeError, IsInteractionError)
 		}
 		return nil, m, nil
 	}
 	for _, interactor := range c.InteractionMethods {
-		c.logDebugf(ctx, "checking interaction method %q", interactor.Kind())
+		kind := bakery.Field("interaction_kind", interactor.Kind())
 		if _, ok := irErr.Info.InteractionMethods[interactor.Kind()]; ok {
-			c.logDebugf(ctx, "found possible interaction method %q", interactor.Kind())
+			c.log(ctx, bakery.LevelDebug, "trying interaction method", bakery.LocationField(location), kind)
+			start := time.Now()
 			token, err := interactor.Interact(ctx, c, location, irErr)
+			labels := map[string]string{
+				"kind":    interactor.Kind(),
+				"outcome": outcome(err),
+			}
+			if errgo.Cause(err) == ErrInteractionMethodNotFound {
+				labels["outcome"] = "not_supported"
+			}
+			observe(c.Metrics, MetricInteraction, labels, start)
 			if err != nil {
 				if errgo.Cause(err) == ErrInteractionMethodNotFound {
 					continue
@@ -555,7 +599,7 @@ func (c *Client) interact(ctx context.Context, location string, irErr *Error, pa
 			}
 			return token, nil, nil
 		} else {
-			c.logDebugf(ctx, "interaction method %q not found in %#v", interactor.Kind(), irErr.Info.InteractionMethods)
+			c.log(ctx, bakery.LevelDebug, "interaction method not offered by discharger", bakery.LocationField(location), kind)
 		}
 	}
 	return nil, nil, &InteractionError{
@@ -611,12 +655,8 @@ func (c *Client) legacyInteract(ctx context.Context, location string, irErr *Err
 	}
 }
 
-func (c *Client) logDebugf(ctx context.Context, f string, a ...interface{}) {
-	c.logger().Debugf(ctx, f, a...)
-}
-
-func (c *Client) logInfof(ctx context.Context, f string, a ...interface{}) {
-	c.logger().Infof(ctx, f, a...)
+func (c *Client) log(ctx context.Context, level bakery.LogLevel, msg string, fields ...bakery.LogField) {
+	bakery.Log(ctx, c.logger(), level, msg, fields...)
 }
 
 func (c *Client) logger() bakery.Logger {
@@ -0,0 +1,261 @@
+// Package devicecode enables interactive login from devices that cannot
+// open a web browser, such as SSH sessions and containers. It is
+// modelled on the OAuth 2.0 device authorization grant (RFC 8628).
+package devicecode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+/*
+PROTOCOL
+
+A device-code login works as follows:
+
+       Client                            Login Service
+          |                                    |
+          | Discharge request                  |
+          |----------------------------------->|
+          |                                    |
+          |    Interaction-required error with |
+          |  "device-code" entry holding user  |
+          |  code, verification URL, device    |
+          |  code and poll URL.                |
+          |<-----------------------------------|
+          |                                    |
+    +-------------+                            |
+    |   Display   |   The user visits the      |
+    | user code & |   verification URL on      |
+    |     URL     |   another device and       |
+    +-------------+   enters the user code.    |
+          |                                    |
+          | POST poll URL with device code     |
+          |----------------------------------->|
+          |                                    |
+          |    authorization-pending error     |
+          |<-----------------------------------|
+          |                                    |
+          |         ... (repeated) ...         |
+          |                                    |
+          | POST poll URL with device code     |
+          |----------------------------------->|
+          |                                    |
+          |       Poll response with discharge |
+          |                              token |
+          |<-----------------------------------|
+          |                                    |
+          | Discharge request with             |
+          | discharge token.                   |
+          |----------------------------------->|
+          |                                    |
+          | Discharge macaroon                 |
+          |<-----------------------------------|
+
+The device code is a secret known only to the client and the login
+service; the user code is short-lived and is only used to associate the
+user's login with the pending discharge.
+*/
+
+const (
+	// InteractionMethod is the methodURLs key
+	// used for device-code interaction.
+	InteractionMethod = "device-code"
+
+	// DefaultInterval holds the polling interval used
+	// when the interaction information does not
+	// specify one.
+	DefaultInterval = 5 * time.Second
+)
+
+const (
+	// ErrAuthorizationPending is the error code returned from the
+	// poll URL when the user has not yet completed the login.
+	ErrAuthorizationPending = httpbakery.ErrorCode("authorization pending")
+
+	// ErrSlowDown is the error code returned from the poll URL
+	// when the client is polling too frequently.
+	ErrSlowDown = httpbakery.ErrorCode("slow down")
+
+	// ErrExpiredToken is the error code returned from the poll URL
+	// when the device code has expired.
+	ErrExpiredToken = httpbakery.ErrorCode("expired token")
+)
+
+// InteractionInfo holds the information expected in
+// the device-code interaction entry in an interaction-required
+// error.
+type InteractionInfo struct {
+	// UserCode holds the code that the user should enter
+	// at the verification URL.
+	UserCode string `json:"user-code"`
+
+	// VerificationURL holds the URL that the user should visit,
+	// on any device, to complete the login.
+	VerificationURL string `json:"verification-url"`
+
+	// DeviceCode holds the secret code used by the client
+	// to poll for the discharge token.
+	DeviceCode string `json:"device-code"`
+
+	// PollURL holds the URL (which may be relative to the
+	// discharger location) that the client should poll
+	// with the device code.
+	PollURL string `json:"poll-url"`
+
+	// Interval holds the minimum number of seconds that the
+	// client should wait between poll requests.
+	Interval int `json:"interval,omitempty"`
+
+	// ExpiresIn holds the number of seconds after which the
+	// device and user codes expire.
+	ExpiresIn int `json:"expires-in,omitempty"`
+}
+
+// SetInteraction sets device-code interaction information on the
+// given error, which should be an interaction-required
+// error to be returned from a discharge request.
+func SetInteraction(e *httpbakery.Error, info InteractionInfo) {
+	e.SetInteraction(InteractionMethod, info)
+}
+
+// PollRequest is the request made by the client to the poll URL.
+type PollRequest struct {
+	httprequest.Route `httprequest:"POST"`
+	DeviceCode        string `httprequest:"device-code,form"`
+}
+
+// PollResponse holds the response from a successful poll request.
+type PollResponse struct {
+	Token *httpbakery.DischargeToken `json:"token"`
+}
+
+// Interactor implements httpbakery.Interactor by displaying the user
+// code and verification URL and then polling for the discharge token.
+type Interactor struct {
+	// Display is used to show the user code and verification URL
+	// to the user. If it is nil, they are printed to Output.
+	Display func(info *InteractionInfo) error
+
+	// Output is used to print the user code and verification URL
+	// if Display is nil. If it is nil, os.Stderr will be used.
+	Output io.Writer
+}
+
+var _ httpbakery.Interactor = Interactor{}
+
+// Kind implements httpbakery.Interactor.Kind.
+func (i Interactor) Kind() string {
+	return InteractionMethod
+}
+
+// Interact implements httpbakery.Interactor.Interact.
+func (i Interactor) Interact(ctx context.Context, client *httpbakery.Client, location string, interactionRequiredErr *httpbakery.Error) (*httpbakery.DischargeToken, error) {
+	var p InteractionInfo
+	if err := interactionRequiredErr.InteractionMethod(InteractionMethod, &p); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(httpbakery.ErrInteractionMethodNotFound))
+	}
+	if p.UserCode == "" || p.DeviceCode == "" {
+		return nil, errgo.Newf("no user code or device code found in device-code information")
+	}
+	verificationURL, err := relativeURL(location, p.VerificationURL)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid verification URL %q", p.VerificationURL)
+	}
+	pollURL, err := relativeURL(location, p.PollURL)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid poll URL %q", p.PollURL)
+	}
+	p.VerificationURL = verificationURL.String()
+	p.PollURL = pollURL.String()
+	if err := i.display(&p); err != nil {
+		return nil, errgo.Notef(err, "cannot display user code")
+	}
+	if p.ExpiresIn > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	interval := DefaultInterval
+	if p.Interval > 0 {
+		interval = time.Duration(p.Interval) * time.Second
+	}
+	return poll(ctx, client, &p, interval)
+}
+
+func (i Interactor) display(p *InteractionInfo) error {
+	if i.Display != nil {
+		return i.Display(p)
+	}
+	w := i.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	_, err := fmt.Fprintf(w, "To log in, visit this URL on any device:\n\t%s\nand enter the code:\n\t%s\n", p.VerificationURL, p.UserCode)
+	return err
+}
+
+// poll polls the poll URL until the discharge token is
+// available, the code expires or the context is done.
+func poll(ctx context.Context, client *httpbakery.Client, p *InteractionInfo, interval time.Duration) (*httpbakery.DischargeToken, error) {
+	httpReqClient := &httprequest.Client{
+		Doer:           client,
+		UnmarshalError: httprequest.ErrorUnmarshaler(&httpbakery.Error{}),
+	}
+	for {
+		var resp PollResponse
+		err := httpReqClient.CallURL(ctx, p.PollURL, &PollRequest{
+			DeviceCode: p.DeviceCode,
+		}, &resp)
+		if err == nil {
+			if resp.Token == nil {
+				return nil, errgo.Newf("no token found in poll response")
+			}
+			return resp.Token, nil
+		}
+		switch errorCode(err) {
+		case ErrAuthorizationPending:
+		case ErrSlowDown:
+			interval += DefaultInterval
+		default:
+			return nil, errgo.Notef(err, "cannot acquire discharge token")
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, errgo.Notef(ctx.Err(), "cannot acquire discharge token")
+		}
+	}
+}
+
+func errorCode(err error) httpbakery.ErrorCode {
+	if err, ok := errgo.Cause(err).(*httpbakery.Error); ok {
+		return err.Code
+	}
+	return ""
+}
+
+// relativeURL returns newPath relative to an original URL.
+func relativeURL(base, new string) (*url.URL, error) {
+	if new == "" {
+		return nil, errgo.Newf("empty URL")
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse URL")
+	}
+	newURL, err := url.Parse(new)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse URL")
+	}
+	return baseURL.ResolveReference(newURL), nil
+}
@@ -0,0 +1,219 @@
+package devicecode_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakerytest"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/devicecode"
+)
+
+func TestDeviceCodeLogin(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDeviceCodeDischarger(nil)
+	defer d.Close()
+	m := newMacaroon(c, d.Discharger)
+
+	client := httpbakery.NewClient()
+	var shown *devicecode.InteractionInfo
+	client.AddInteractor(devicecode.Interactor{
+		Display: func(info *devicecode.InteractionInfo) error {
+			shown = info
+			return d.Approve(info.UserCode, checkers.DeclaredCaveat("username", "bob"))
+		},
+	})
+	ms, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ms, qt.HasLen, 2)
+	c.Assert(checkers.InferDeclared(nil, ms), qt.DeepEquals, map[string]string{
+		"username": "bob",
+	})
+	c.Assert(shown.UserCode, qt.Matches, `[B-Z]{4}-[B-Z]{4}`)
+	c.Assert(shown.VerificationURL, qt.Equals, d.Location()+"/device")
+	c.Assert(shown.PollURL, qt.Equals, d.Location()+"/device-code/poll")
+}
+
+func TestDeviceCodeDenied(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDeviceCodeDischarger(nil)
+	defer d.Close()
+	m := newMacaroon(c, d.Discharger)
+
+	client := httpbakery.NewClient()
+	client.AddInteractor(devicecode.Interactor{
+		Display: func(info *devicecode.InteractionInfo) error {
+			return d.Deny(info.UserCode)
+		},
+	})
+	_, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": cannot acquire discharge token: Post .*: authorization denied`)
+}
+
+func TestDeviceCodeDefaultDisplay(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDeviceCodeDischarger(nil)
+	defer d.Close()
+	m := newMacaroon(c, d.Discharger)
+
+	client := httpbakery.NewClient()
+	w := &approvingWriter{
+		approve: func(userCode string) error {
+			return d.Approve(userCode)
+		},
+	}
+	client.AddInteractor(devicecode.Interactor{
+		Output: w,
+	})
+	_, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(w.buf.String(), qt.Matches, `To log in, visit this URL on any device:
+	https://.*/device
+and enter the code:
+	[B-Z]{4}-[B-Z]{4}
+`)
+}
+
+func TestDeviceCodePending(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	h := devicecode.NewHandler(devicecode.HandlerParams{
+		VerificationURL: "/device",
+		Interval:        time.Second,
+	})
+	d.AddHTTPHandlers(h.Handlers())
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			return h.CheckToken(p.Token, p.Caveat)
+		}
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		if err1 := h.SetInteraction(err, p.Caveat); err1 != nil {
+			return nil, errgo.Mask(err1)
+		}
+		return nil, err
+	})
+	m := newMacaroon(c, d)
+
+	client := httpbakery.NewClient()
+	client.AddInteractor(devicecode.Interactor{
+		Display: func(info *devicecode.InteractionInfo) error {
+			cond, err := h.Condition(info.UserCode)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			if cond != "something" {
+				return errgo.Newf("unexpected condition %q", cond)
+			}
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				// Check that the user code is accepted without the
+				// dash and in lower case, as a user might type it.
+				userCode := strings.ToLower(strings.Replace(info.UserCode, "-", "", 1))
+				if err := h.Approve(userCode, nil); err != nil {
+					panic(err)
+				}
+			}()
+			return nil
+		},
+	})
+	ms, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ms, qt.HasLen, 2)
+}
+
+func TestPollErrors(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	h := devicecode.NewHandler(devicecode.HandlerParams{
+		VerificationURL: "/device",
+	})
+	d.AddHTTPHandlers(h.Handlers())
+	irErr := &httpbakery.Error{
+		Code: httpbakery.ErrInteractionRequired,
+	}
+	err := h.SetInteraction(irErr, &bakery.ThirdPartyCaveatInfo{})
+	c.Assert(err, qt.IsNil)
+	var info devicecode.InteractionInfo
+	err = irErr.InteractionMethod(devicecode.InteractionMethod, &info)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.Interval, qt.Equals, 5)
+	c.Assert(info.ExpiresIn, qt.Equals, 600)
+
+	client := &httprequest.Client{
+		BaseURL:        d.Location(),
+		UnmarshalError: httprequest.ErrorUnmarshaler(&httpbakery.Error{}),
+	}
+	poll := func(deviceCode string) error {
+		var resp devicecode.PollResponse
+		return client.CallURL(context.Background(), d.Location()+info.PollURL, &devicecode.PollRequest{
+			DeviceCode: deviceCode,
+		}, &resp)
+	}
+	err = poll(info.DeviceCode)
+	c.Assert(errgo.Cause(err).(*httpbakery.Error).Code, qt.Equals, devicecode.ErrAuthorizationPending)
+	err = poll(info.DeviceCode)
+	c.Assert(errgo.Cause(err).(*httpbakery.Error).Code, qt.Equals, devicecode.ErrSlowDown)
+	err = poll("unknown")
+	c.Assert(errgo.Cause(err).(*httpbakery.Error).Code, qt.Equals, devicecode.ErrExpiredToken)
+
+	// Polling errors are not reported as server errors.
+	resp, err := http.PostForm(d.Location()+info.PollURL, url.Values{
+		"device-code": {info.DeviceCode},
+	})
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+
+	err = h.Approve("BCDF-GHJK", nil)
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+	err = h.Approve(info.UserCode, nil)
+	c.Assert(err, qt.IsNil)
+	err = h.Approve(info.UserCode, nil)
+	c.Assert(err, qt.ErrorMatches, `user code ".*" not found`)
+}
+
+func newMacaroon(c *qt.C, d *bakerytest.Discharger) *bakery.Macaroon {
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: d,
+	})
+	m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  d.Location(),
+		Condition: "something",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	return m
+}
+
+// approvingWriter approves the user code printed to it.
+type approvingWriter struct {
+	buf     bytes.Buffer
+	approve func(userCode string) error
+}
+
+var userCodePat = regexp.MustCompile(`[B-Z]{4}-[B-Z]{4}`)
+
+func (w *approvingWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	if userCode := userCodePat.Find(b); userCode != nil {
+		if err := w.approve(string(userCode)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
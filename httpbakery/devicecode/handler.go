@@ -0,0 +1,339 @@
+package devicecode
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+// DefaultExpiry holds the default length of time for
+// which device and user codes remain valid.
+const DefaultExpiry = 10 * time.Minute
+
+// userCodeAlphabet holds the characters used in user codes. As
+// recommended by RFC 8628, it contains no vowels (to avoid forming
+// words) and no easily confused characters.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// HandlerParams holds the parameters for NewHandler.
+type HandlerParams struct {
+	// VerificationURL holds the URL of the page where the user
+	// enters the user code. The discharger is responsible for
+	// serving this page and calling Handler.Approve or
+	// Handler.Deny when the user has logged in.
+	VerificationURL string
+
+	// PollPath holds the URL path at which the poll endpoint is
+	// served. It should be an absolute path on the discharger's
+	// host. If it is empty, "/device-code/poll" will be used.
+	PollPath string
+
+	// Interval holds the minimum interval between poll requests.
+	// It is rounded up to a whole number of seconds when sent to
+	// the client. If it is zero, DefaultInterval will be used.
+	Interval time.Duration
+
+	// Expiry holds the length of time for which device and user
+	// codes remain valid. If it is zero, DefaultExpiry will be used.
+	Expiry time.Duration
+}
+
+// Handler implements the server side of the device-code interaction
+// method. It holds pending authorizations in memory.
+type Handler struct {
+	p HandlerParams
+
+	mu sync.Mutex
+	// pending holds pending authorizations keyed by device code.
+	pending map[string]*authorization
+	// userCodes maps from user code to device code.
+	userCodes map[string]string
+}
+
+type authorization struct {
+	deviceCode string
+	userCode   string
+	caveatId   []byte
+	caveat     []byte
+	condition  string
+	expires    time.Time
+	lastPoll   time.Time
+	done       bool
+	caveats    []checkers.Caveat
+	err        error
+}
+
+// NewHandler returns a new Handler that uses the given parameters.
+func NewHandler(p HandlerParams) *Handler {
+	if p.PollPath == "" {
+		p.PollPath = "/device-code/poll"
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultInterval
+	}
+	if p.Expiry == 0 {
+		p.Expiry = DefaultExpiry
+	}
+	return &Handler{
+		p:         p,
+		pending:   make(map[string]*authorization),
+		userCodes: make(map[string]string),
+	}
+}
+
+// SetInteraction creates a new pending authorization for the given
+// caveat and adds device-code interaction information for it to the
+// given error, which should be an interaction-required error that's
+// about to be returned from a discharge request.
+func (h *Handler) SetInteraction(e *httpbakery.Error, cav *bakery.ThirdPartyCaveatInfo) error {
+	deviceCode, err := randomString(32, "0123456789abcdef")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.expire(now)
+	var userCode string
+	for {
+		userCode, err = randomString(8, userCodeAlphabet)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		userCode = userCode[:4] + "-" + userCode[4:]
+		if _, ok := h.userCodes[userCode]; !ok {
+			break
+		}
+	}
+	h.pending[deviceCode] = &authorization{
+		deviceCode: deviceCode,
+		userCode:   userCode,
+		caveatId:   cav.Id,
+		caveat:     cav.Caveat,
+		condition:  string(cav.Condition),
+		expires:    now.Add(h.p.Expiry),
+	}
+	h.userCodes[userCode] = deviceCode
+	SetInteraction(e, InteractionInfo{
+		UserCode:        userCode,
+		VerificationURL: h.p.VerificationURL,
+		DeviceCode:      deviceCode,
+		PollURL:         h.p.PollPath,
+		Interval:        int((h.p.Interval + time.Second - 1) / time.Second),
+		ExpiresIn:       int(h.p.Expiry / time.Second),
+	})
+	return nil
+}
+
+// Condition returns the condition of the third party caveat associated
+// with the given user code. It can be used by the verification page to
+// check that the user code is valid and to decide what the user must
+// do to authorize the discharge.
+func (h *Handler) Condition(userCode string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a, err := h.findUserCode(userCode)
+	if err != nil {
+		return "", errgo.Mask(err, errgo.Is(bakery.ErrNotFound))
+	}
+	return a.condition, nil
+}
+
+// Approve approves the pending authorization associated with the given
+// user code. The given caveats will be returned from CheckToken when
+// the client presents its discharge token.
+func (h *Handler) Approve(userCode string, caveats []checkers.Caveat) error {
+	return h.complete(userCode, caveats, nil)
+}
+
+// Deny refuses the pending authorization associated with the given user
+// code. The given error will be returned to the polling client.
+func (h *Handler) Deny(userCode string, err error) error {
+	if err == nil {
+		err = errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "authorization denied")
+	}
+	return h.complete(userCode, nil, err)
+}
+
+func (h *Handler) complete(userCode string, caveats []checkers.Caveat, err error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a, err1 := h.findUserCode(userCode)
+	if err1 != nil {
+		return errgo.Mask(err1, errgo.Is(bakery.ErrNotFound))
+	}
+	if a.done {
+		return errgo.Newf("user code %q already used", userCode)
+	}
+	a.done = true
+	a.caveats, a.err = caveats, err
+	// The user code can't be used again.
+	delete(h.userCodes, userCode)
+	return nil
+}
+
+// findUserCode returns the pending authorization for the given user
+// code. It must be called with h.mu held.
+func (h *Handler) findUserCode(userCode string) (*authorization, error) {
+	h.expire(time.Now())
+	userCode = strings.ToUpper(strings.TrimSpace(userCode))
+	if len(userCode) == 8 {
+		userCode = userCode[:4] + "-" + userCode[4:]
+	}
+	deviceCode, ok := h.userCodes[userCode]
+	if !ok {
+		return nil, errgo.WithCausef(nil, bakery.ErrNotFound, "user code %q not found", userCode)
+	}
+	return h.pending[deviceCode], nil
+}
+
+// CheckToken checks that the given token was obtained for the given
+// caveat and returns the caveats passed to Approve. A token can only be
+// checked successfully once.
+func (h *Handler) CheckToken(token *httpbakery.DischargeToken, cav *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+	if token.Kind != InteractionMethod {
+		return nil, errgo.Newf("invalid discharge token kind %q", token.Kind)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(time.Now())
+	a := h.pending[string(token.Value)]
+	if a == nil || !a.done || a.err != nil {
+		return nil, errgo.Newf("invalid discharge token")
+	}
+	if !bytes.Equal(a.caveat, cav.Caveat) {
+		return nil, errgo.Newf("caveat provided to CheckToken does not match original")
+	}
+	if !bytes.Equal(a.caveatId, cav.Id) {
+		return nil, errgo.Newf("caveat id provided to CheckToken does not match original")
+	}
+	delete(h.pending, a.deviceCode)
+	return a.caveats, nil
+}
+
+// poll returns a discharge token for the given device code if the
+// authorization has been approved.
+func (h *Handler) poll(deviceCode string) (*httpbakery.DischargeToken, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.expire(now)
+	a := h.pending[deviceCode]
+	if a == nil {
+		return nil, errgo.WithCausef(nil, ErrExpiredToken, "device code not found or expired")
+	}
+	if a.done {
+		if a.err != nil {
+			delete(h.pending, deviceCode)
+			return nil, errgo.Mask(a.err, errgo.Any)
+		}
+		return &httpbakery.DischargeToken{
+			Kind:  InteractionMethod,
+			Value: []byte(deviceCode),
+		}, nil
+	}
+	lastPoll := a.lastPoll
+	a.lastPoll = now
+	if !lastPoll.IsZero() && now.Sub(lastPoll) < h.p.Interval {
+		return nil, errgo.WithCausef(nil, ErrSlowDown, "")
+	}
+	return nil, errgo.WithCausef(nil, ErrAuthorizationPending, "")
+}
+
+// expire removes all expired authorizations. It must
+// be called with h.mu held.
+func (h *Handler) expire(now time.Time) {
+	for deviceCode, a := range h.pending {
+		if !now.Before(a.expires) {
+			delete(h.pending, deviceCode)
+			if h.userCodes[a.userCode] == deviceCode {
+				delete(h.userCodes, a.userCode)
+			}
+		}
+	}
+}
+
+// Handlers returns the handlers that serve the poll endpoint.
+// They should be added to the discharger's HTTP server.
+func (h *Handler) Handlers() []httprequest.Handler {
+	srv := httprequest.Server{
+		ErrorMapper: errorToResponse,
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (pollHandler, context.Context, error) {
+		return pollHandler{h}, p.Context, nil
+	})
+	for i := range hs {
+		hs[i].Path = h.p.PollPath
+	}
+	return hs
+}
+
+// pollHandler defines the httprequest handler methods for a Handler.
+type pollHandler struct {
+	h *Handler
+}
+
+// pollRequest is the request to poll for a discharge token. The path
+// is replaced by HandlerParams.PollPath in Handler.Handlers.
+type pollRequest struct {
+	httprequest.Route `httprequest:"POST /device-code/poll"`
+	DeviceCode        string `httprequest:"device-code,form"`
+}
+
+// Poll serves the poll endpoint.
+func (h pollHandler) Poll(req *pollRequest) (*PollResponse, error) {
+	token, err := h.h.poll(req.DeviceCode)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return &PollResponse{
+		Token: token,
+	}, nil
+}
+
+// errorToResponse maps errors from the poll endpoint to HTTP
+// responses. As in RFC 8628, the errors returned while polling
+// normally result in a 400 status rather than the 500 status
+// that httpbakery.ErrorToResponse would use.
+func errorToResponse(ctx context.Context, err error) (int, interface{}) {
+	status, body := httpbakery.ErrorToResponse(ctx, err)
+	switch errgo.Cause(err) {
+	case ErrAuthorizationPending, ErrSlowDown, ErrExpiredToken:
+		status = http.StatusBadRequest
+	}
+	return status, body
+}
+
+// randomString returns a random string of length n made from
+// characters in the given alphabet, which must hold no more than
+// 256 characters. Each character is chosen with equal probability.
+func randomString(n int, alphabet string) (string, error) {
+	// Bytes at or above limit are rejected so that
+	// b%len(alphabet) is uniformly distributed.
+	limit := 256 - 256%len(alphabet)
+	s := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(s) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("cannot generate %d random bytes: %v", n, err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(s) < n {
+				s = append(s, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(s), nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"net/http"
 	"path"
+	"time"
 	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
@@ -109,6 +110,19 @@ type DischargerParams struct {
 	// Key holds the key pair of the discharger.
 	Key *bakery.KeyPair
 
+	// KeyOpener may be set instead of Key when the discharger's
+	// private key is not held in memory. If both are set,
+	// KeyOpener is used.
+	KeyOpener bakery.KeyOpener
+
+	// Keys may be set instead of Key or KeyOpener to allow the
+	// discharger's key to be rotated. Caveats encrypted for any
+	// key in the set will be discharged, and the upcoming and
+	// deprecated keys are advertised alongside the primary key
+	// at the /discharge/info endpoint. If Keys is set, Key and
+	// KeyOpener are ignored.
+	Keys *bakery.KeySet
+
 	// Locator is used to find public keys when adding
 	// third-party caveats on discharge macaroons.
 	// If this is nil, no third party caveats may be added.
@@ -121,6 +135,28 @@ type DischargerParams struct {
 	// by falling back to calling ErrorToResponse to ensure
 	// that the standard bakery errors are marshaled in the expected way.
 	ErrorToResponse func(ctx context.Context, err error) (int, interface{})
+
+	// Auditor is used to record an audit event for each discharge
+	// request. A bakery.AuditDischargeIssued event is recorded when
+	// a caveat is discharged, preceded by a
+	// bakery.AuditInteractionCompleted event if a discharge token
+	// was used. A bakery.AuditInteractionStarted event is recorded
+	// when the checker returns an error with an
+	// ErrInteractionRequired code, and a
+	// bakery.AuditDischargeRefused event is recorded for any other
+	// error. If it is nil, no events will be recorded.
+	Auditor bakery.Auditor
+
+	// Metrics is used to record the MetricDischargeRequest metric.
+	// If it is nil, no metrics will be recorded.
+	Metrics bakery.Metrics
+
+	// RequestIDHeader holds the name of the HTTP header, for
+	// example "X-Request-Id", that holds the id of each request.
+	// If it is non-empty, the id is attached to the request context
+	// (see ContextWithRequestIDHeader) so that it is available to
+	// the caveat checker, the Auditor and any log messages.
+	RequestIDHeader string
 }
 
 // Discharger represents a third-party caveat discharger.
@@ -151,6 +187,22 @@ type DischargerParams struct {
 //	result:
 //		public key of service
 //		expiry time of key
+//
+// GET /discharge/info
+//	result:
+//		{
+//			PublicKey: primary public key of service
+//			Version: latest bakery version supported
+//			UpcomingPublicKeys: public keys that will become primary
+//			DeprecatedPublicKeys: public keys that are being retired
+//		}
+//
+// GET /discharge/conditions
+//	(only if the caveat checker implements ConditionLister)
+//	result:
+//		{
+//			Conditions: supported conditions, as []ConditionInfo
+//		}
 type Discharger struct {
 	p DischargerParams
 }
@@ -164,6 +216,14 @@ func NewDischarger(p DischargerParams) *Discharger {
 	if p.Locator == nil {
 		p.Locator = emptyLocator{}
 	}
+	if p.Keys == nil {
+		if p.KeyOpener == nil && p.Key != nil {
+			p.KeyOpener = p.Key
+		}
+		p.Keys = &bakery.KeySet{
+			Primary: p.KeyOpener,
+		}
+	}
 	if p.CheckerP == nil {
 		p.CheckerP = ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, cp ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
 			return p.Checker.CheckThirdPartyCaveat(ctx, cp.Caveat, cp.Request, cp.Token)
@@ -174,6 +234,17 @@ func NewDischarger(p DischargerParams) *Discharger {
 	}
 }
 
+// primaryPublicKey returns the public key of the discharger's
+// primary key. It returns an error if there is no primary key, which
+// happens when no key was provided in DischargerParams or when
+// DischargerParams.Keys holds only upcoming or deprecated keys.
+func (d *Discharger) primaryPublicKey() (*bakery.PublicKey, error) {
+	if d.p.Keys.Primary == nil {
+		return nil, errgo.Newf("discharger has no primary key")
+	}
+	return d.p.Keys.Primary.PublicKey(), nil
+}
+
 type emptyLocator struct{}
 
 func (emptyLocator) ThirdPartyInfo(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
@@ -198,12 +269,24 @@ func (d *Discharger) Handlers() []httprequest.Handler {
 	f := func(p httprequest.Params) (dischargeHandler, context.Context, error) {
 		return dischargeHandler{
 			discharger: d,
-		}, p.Context, nil
+		}, ContextWithRequestIDHeader(p.Context, p.Request, d.p.RequestIDHeader), nil
 	}
 	srv := httprequest.Server{
 		ErrorMapper: d.p.ErrorToResponse,
 	}
-	return srv.Handlers(f)
+	hs := srv.Handlers(f)
+	if _, ok := d.p.CheckerP.(ConditionLister); ok {
+		return hs
+	}
+	// Only serve the conditions endpoint if there
+	// are conditions to report.
+	handlers := hs[:0]
+	for _, h := range hs {
+		if h.Path != dischargeConditionsPath {
+			handlers = append(handlers, h)
+		}
+	}
+	return handlers
 }
 
 //go:generate httprequest-generate-client github.com/go-macaroon-bakery/macaroon-bakery/v3-unstable/httpbakery dischargeHandler dischargeClient
@@ -234,7 +317,19 @@ type dischargeResponse struct {
 }
 
 // Discharge discharges a third party caveat.
-func (h dischargeHandler) Discharge(p httprequest.Params, r *dischargeRequest) (*dischargeResponse, error) {
+func (h dischargeHandler) Discharge(p httprequest.Params, r *dischargeRequest) (_ *dischargeResponse, err error) {
+	defer func(start time.Time) {
+		o := "ok"
+		if err != nil {
+			o = "refused"
+			if isInteractionRequired(err) {
+				o = "interaction_required"
+			}
+		}
+		observe(h.discharger.p.Metrics, MetricDischargeRequest, map[string]string{
+			"outcome": o,
+		}, start)
+	}(time.Now())
 	id, err := maybeBase64Decode(r.Id, r.Id64)
 	if err != nil {
 		return nil, errgo.Notef(err, "bad caveat id")
@@ -264,28 +359,72 @@ func (h dischargeHandler) Discharge(p httprequest.Params, r *dischargeRequest) (
 			Value: tokenVal,
 		}
 	}
+	var (
+		condition string
+		caveats   []checkers.Caveat
+	)
 	m, err := bakery.Discharge(p.Context, bakery.DischargeParams{
 		Id:     id,
 		Caveat: caveat,
-		Key:    h.discharger.p.Key,
+		Keys:   h.discharger.p.Keys,
 		Checker: bakery.ThirdPartyCaveatCheckerFunc(
 			func(ctx context.Context, cav *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
-				return h.discharger.p.CheckerP.CheckThirdPartyCaveat(ctx, ThirdPartyCaveatCheckerParams{
+				condition = string(cav.Condition)
+				caveats1, err := h.discharger.p.CheckerP.CheckThirdPartyCaveat(ctx, ThirdPartyCaveatCheckerParams{
 					Caveat:   cav,
 					Request:  p.Request,
 					Response: p.Response,
 					Token:    token,
 				})
+				caveats = caveats1
+				return caveats1, err
 			},
 		),
 		Locator: h.discharger.p.Locator,
 	})
+	h.discharger.audit(p.Context, condition, caveats, token, m, err)
 	if err != nil {
 		return nil, errgo.NoteMask(err, "cannot discharge", errgo.Any)
 	}
 	return &dischargeResponse{m}, nil
 }
 
+// isInteractionRequired reports whether err has an *Error
+// cause with an ErrInteractionRequired code.
+func isInteractionRequired(err error) bool {
+	cause, ok := errgo.Cause(err).(*Error)
+	return ok && cause.Code == ErrInteractionRequired
+}
+
+// audit records the audit events for a discharge request.
+// The condition is empty if the caveat could not be decoded.
+func (d *Discharger) audit(ctx context.Context, condition string, caveats []checkers.Caveat, token *DischargeToken, m *bakery.Macaroon, err error) {
+	if d.p.Auditor == nil {
+		return
+	}
+	var e bakery.AuditEvent
+	if condition != "" {
+		e.Conditions = []string{condition}
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.Kind = bakery.AuditDischargeRefused
+		if isInteractionRequired(err) {
+			e.Kind = bakery.AuditInteractionStarted
+		}
+		d.p.Auditor.AuditEvent(ctx, e)
+		return
+	}
+	e.MacaroonIds = [][]byte{m.M().Id()}
+	if token != nil {
+		e.Kind = bakery.AuditInteractionCompleted
+		d.p.Auditor.AuditEvent(ctx, e)
+	}
+	e.Kind = bakery.AuditDischargeIssued
+	e.Caveats = caveats
+	d.p.Auditor.AuditEvent(ctx, e)
+}
+
 // publicKeyRequest specifies the /publickey endpoint.
 type publicKeyRequest struct {
 	httprequest.Route `httprequest:"GET /publickey"`
@@ -304,25 +443,75 @@ type dischargeInfoRequest struct {
 // dischargeInfoResponse is the response to a /discharge/info GET
 // request.
 type dischargeInfoResponse struct {
-	PublicKey *bakery.PublicKey
-	Version   bakery.Version
+	PublicKey            *bakery.PublicKey
+	Version              bakery.Version
+	UpcomingPublicKeys   []*bakery.PublicKey `json:",omitempty"`
+	DeprecatedPublicKeys []*bakery.PublicKey `json:",omitempty"`
 }
 
 // PublicKey returns the public key of the discharge service.
 func (h dischargeHandler) PublicKey(*publicKeyRequest) (publicKeyResponse, error) {
+	pk, err := h.discharger.primaryPublicKey()
+	if err != nil {
+		return publicKeyResponse{}, errgo.Mask(err)
+	}
 	return publicKeyResponse{
-		PublicKey: &h.discharger.p.Key.Public,
+		PublicKey: pk,
 	}, nil
 }
 
 // DischargeInfo returns information on the discharger.
 func (h dischargeHandler) DischargeInfo(*dischargeInfoRequest) (dischargeInfoResponse, error) {
+	pk, err := h.discharger.primaryPublicKey()
+	if err != nil {
+		return dischargeInfoResponse{}, errgo.Mask(err)
+	}
+	ks := h.discharger.p.Keys
 	return dischargeInfoResponse{
-		PublicKey: &h.discharger.p.Key.Public,
-		Version:   bakery.LatestVersion,
+		PublicKey:            pk,
+		Version:              bakery.LatestVersion,
+		UpcomingPublicKeys:   publicKeys(ks.Upcoming),
+		DeprecatedPublicKeys: publicKeys(ks.Deprecated),
 	}, nil
 }
 
+// dischargeConditionsPath holds the path of the
+// /discharge/conditions endpoint.
+const dischargeConditionsPath = "/discharge/conditions"
+
+// dischargeConditionsRequest specifies the /discharge/conditions endpoint.
+type dischargeConditionsRequest struct {
+	httprequest.Route `httprequest:"GET /discharge/conditions"`
+}
+
+// dischargeConditionsResponse is the response to a
+// /discharge/conditions GET request.
+type dischargeConditionsResponse struct {
+	Conditions []ConditionInfo
+}
+
+// DischargeConditions returns the conditions supported by the discharger.
+func (h dischargeHandler) DischargeConditions(*dischargeConditionsRequest) (*dischargeConditionsResponse, error) {
+	lister, ok := h.discharger.p.CheckerP.(ConditionLister)
+	if !ok {
+		return nil, errgo.Newf("discharger does not list its conditions")
+	}
+	return &dischargeConditionsResponse{
+		Conditions: lister.Conditions(),
+	}, nil
+}
+
+func publicKeys(keys []bakery.KeyOpener) []*bakery.PublicKey {
+	if len(keys) == 0 {
+		return nil
+	}
+	pks := make([]*bakery.PublicKey, len(keys))
+	for i, key := range keys {
+		pks[i] = key.PublicKey()
+	}
+	return pks
+}
+
 // mkHTTPHandler converts an httprouter handler to an http.Handler,
 // assuming that the httprouter handler has no wildcard path
 // parameters.
@@ -5,3 +5,5 @@ type PublicKeyResponse publicKeyResponse
 const MaxDischargeRetries = maxDischargeRetries
 
 var LegacyGetInteractionMethods = legacyGetInteractionMethods
+
+var CacheLifetime = cacheLifetime
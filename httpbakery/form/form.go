@@ -79,6 +79,19 @@ type InteractionInfo struct {
 	URL string `json:"url"`
 }
 
+// SetInteraction sets form interaction information on the
+// given error, which should be an interaction-required
+// error to be returned from a discharge request.
+//
+// The given URL (which may be relative to the discharger
+// location) will be used by the client to fetch the form
+// schema and to post the completed form.
+func SetInteraction(e *httpbakery.Error, formURL string) {
+	e.SetInteraction(InteractionMethod, InteractionInfo{
+		URL: formURL,
+	})
+}
+
 // LoginRequest is a request to perform a login using the provided form.
 type LoginRequest struct {
 	httprequest.Route `httprequest:"POST"`
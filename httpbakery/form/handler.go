@@ -0,0 +1,245 @@
+package form
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juju/schema"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/environschema.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+// DefaultTokenExpiry holds the length of time that a discharge
+// token issued by a LoginHandler remains valid if
+// LoginHandlerParams.TokenExpiry is zero.
+const DefaultTokenExpiry = 5 * time.Minute
+
+// CredentialChecker is used by LoginHandler to check the
+// credentials submitted by a client in a form login.
+type CredentialChecker interface {
+	// CheckCredentials checks the given form values, which have
+	// already been validated against the login schema, and returns
+	// the identity of the user that has logged in. The identity
+	// will later be returned from LoginHandler.CheckToken.
+	//
+	// If the credentials are not valid, it should return an error
+	// with an httpbakery.ErrPermissionDenied cause.
+	CheckCredentials(ctx context.Context, req *http.Request, form map[string]interface{}) (string, error)
+}
+
+// CredentialCheckerFunc implements CredentialChecker
+// by calling a function.
+type CredentialCheckerFunc func(ctx context.Context, req *http.Request, form map[string]interface{}) (string, error)
+
+// CheckCredentials implements CredentialChecker.CheckCredentials.
+func (f CredentialCheckerFunc) CheckCredentials(ctx context.Context, req *http.Request, form map[string]interface{}) (string, error) {
+	return f(ctx, req, form)
+}
+
+// LoginHandlerParams holds the parameters for NewLoginHandler.
+type LoginHandlerParams struct {
+	// Schema holds the fields that the client will be asked
+	// to fill out.
+	Schema environschema.Fields
+
+	// Checker is used to check the credentials submitted
+	// by the client.
+	Checker CredentialChecker
+
+	// Path holds the URL path at which the form handlers will be
+	// served. This is also the URL that will be sent to the client
+	// in the interaction-required error, so it should be an
+	// absolute path on the discharger's host. If it is empty,
+	// "/form" will be used.
+	Path string
+
+	// TokenExpiry holds the length of time that an issued discharge
+	// token remains valid. If it is zero, DefaultTokenExpiry will
+	// be used.
+	TokenExpiry time.Duration
+
+	// RequestIDHeader holds the name of the HTTP header, for
+	// example "X-Request-Id", that holds the id of each request.
+	// If it is non-empty, the id is attached to the context
+	// passed to Checker (see httpbakery.ContextWithRequestIDHeader).
+	RequestIDHeader string
+}
+
+// LoginHandler implements the server side of the form interaction
+// method. It serves the login schema, checks the submitted form
+// and issues discharge tokens that can be checked by the
+// discharger's third party caveat checker with CheckToken.
+//
+// Issued tokens are held in memory and may be used only once.
+type LoginHandler struct {
+	p      LoginHandlerParams
+	fields schema.Fields
+	dflts  schema.Defaults
+
+	mu     sync.Mutex
+	tokens map[string]issuedToken
+}
+
+type issuedToken struct {
+	identity string
+	expires  time.Time
+}
+
+// NewLoginHandler returns a new LoginHandler that
+// uses the given parameters.
+func NewLoginHandler(p LoginHandlerParams) (*LoginHandler, error) {
+	if len(p.Schema) == 0 {
+		return nil, errgo.Newf("no fields in login schema")
+	}
+	if p.Checker == nil {
+		return nil, errgo.Newf("no credential checker specified")
+	}
+	if p.Path == "" {
+		p.Path = "/form"
+	}
+	if p.TokenExpiry == 0 {
+		p.TokenExpiry = DefaultTokenExpiry
+	}
+	fields, dflts, err := p.Schema.ValidationSchema()
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid login schema")
+	}
+	return &LoginHandler{
+		p:      p,
+		fields: fields,
+		dflts:  dflts,
+		tokens: make(map[string]issuedToken),
+	}, nil
+}
+
+// SetInteraction adds form interaction information pointing
+// at the handler to the given error, which should be
+// an interaction-required error that's about to be returned
+// from a discharge request.
+func (h *LoginHandler) SetInteraction(e *httpbakery.Error) {
+	SetInteraction(e, h.p.Path)
+}
+
+// CheckToken checks that the given token was issued by the handler and
+// has not expired, and returns the identity that was returned from the
+// credential checker when the token was issued. A token can only be
+// checked successfully once.
+func (h *LoginHandler) CheckToken(token *httpbakery.DischargeToken) (string, error) {
+	if token == nil {
+		return "", errgo.Newf("no discharge token")
+	}
+	if token.Kind != InteractionMethod {
+		return "", errgo.Newf("invalid discharge token kind %q", token.Kind)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.expireTokens(now)
+	t, ok := h.tokens[string(token.Value)]
+	if !ok {
+		return "", errgo.Newf("discharge token not found")
+	}
+	delete(h.tokens, string(token.Value))
+	return t.identity, nil
+}
+
+// Handlers returns the handlers that serve the form schema
+// and accept the form submission. They should be added
+// to the discharger's HTTP server (for example with
+// bakerytest.Discharger.AddHTTPHandlers).
+func (h *LoginHandler) Handlers() []httprequest.Handler {
+	srv := httprequest.Server{
+		ErrorMapper: httpbakery.ErrorToResponse,
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (loginHandler, context.Context, error) {
+		return loginHandler{h}, httpbakery.ContextWithRequestIDHeader(p.Context, p.Request, h.p.RequestIDHeader), nil
+	})
+	for i := range hs {
+		hs[i].Path = h.p.Path
+	}
+	return hs
+}
+
+// newToken returns a new discharge token associated with the
+// given identity.
+func (h *LoginHandler) newToken(identity string) (*httpbakery.DischargeToken, error) {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, errgo.Notef(err, "cannot generate token")
+	}
+	value := fmt.Sprintf("%x", buf[:])
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.expireTokens(now)
+	h.tokens[value] = issuedToken{
+		identity: identity,
+		expires:  now.Add(h.p.TokenExpiry),
+	}
+	return &httpbakery.DischargeToken{
+		Kind:  InteractionMethod,
+		Value: []byte(value),
+	}, nil
+}
+
+// expireTokens removes all tokens that have expired
+// by the given time. It must be called with h.mu held.
+func (h *LoginHandler) expireTokens(now time.Time) {
+	for v, t := range h.tokens {
+		if !now.Before(t.expires) {
+			delete(h.tokens, v)
+		}
+	}
+}
+
+// loginHandler defines the httprequest handler methods
+// for a LoginHandler.
+type loginHandler struct {
+	h *LoginHandler
+}
+
+// schemaRequest is the request to fetch the login schema. The path is
+// replaced by LoginHandlerParams.Path in LoginHandler.Handlers.
+type schemaRequest struct {
+	httprequest.Route `httprequest:"GET /form"`
+}
+
+// loginRequest is the request to submit the login form. The path is
+// replaced by LoginHandlerParams.Path in LoginHandler.Handlers.
+type loginRequest struct {
+	httprequest.Route `httprequest:"POST /form"`
+	Body              LoginBody `httprequest:",body"`
+}
+
+// Schema serves the login schema.
+func (h loginHandler) Schema(*schemaRequest) (*SchemaResponse, error) {
+	return &SchemaResponse{
+		Schema: h.h.p.Schema,
+	}, nil
+}
+
+// Login checks the submitted form and returns a discharge token.
+func (h loginHandler) Login(p httprequest.Params, req *loginRequest) (*LoginResponse, error) {
+	v, err := schema.StrictFieldMap(h.h.fields, h.h.dflts).Coerce(req.Body.Form, nil)
+	if err != nil {
+		return nil, errgo.WithCausef(err, httpbakery.ErrBadRequest, "invalid form")
+	}
+	identity, err := h.h.p.Checker.CheckCredentials(p.Context, p.Request, v.(map[string]interface{}))
+	if err != nil {
+		return nil, errgo.NoteMask(err, "cannot log in", errgo.Any)
+	}
+	token, err := h.h.newToken(identity)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &LoginResponse{
+		Token: token,
+	}, nil
+}
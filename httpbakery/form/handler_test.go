@@ -0,0 +1,169 @@
+package form_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	esform "gopkg.in/juju/environschema.v1/form"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakerytest"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/form"
+)
+
+var loginHandlerTests = []struct {
+	about       string
+	form        map[string]interface{}
+	expectError string
+}{{
+	about: "valid credentials",
+	form: map[string]interface{}{
+		"username": "bob",
+		"password": "secret",
+	},
+}, {
+	about: "invalid credentials",
+	form: map[string]interface{}{
+		"username": "bob",
+		"password": "wrong",
+	},
+	expectError: `cannot get discharge from ".*": cannot submit form: Post .*: cannot log in: invalid password`,
+}, {
+	about: "form does not match schema",
+	form: map[string]interface{}{
+		"username": "bob",
+		"password": 1234,
+	},
+	expectError: `cannot get discharge from ".*": cannot submit form: Post .*: invalid form: password: expected string, got float64\(1234\)`,
+}, {
+	about: "unexpected field",
+	form: map[string]interface{}{
+		"username": "bob",
+		"password": "secret",
+		"other":    "x",
+	},
+	expectError: `cannot get discharge from ".*": cannot submit form: Post .*: invalid form: unknown key "other" \(value "x"\)`,
+}}
+
+func TestLoginHandler(t *testing.T) {
+	c := qt.New(t)
+	discharger := bakerytest.NewDischarger(nil)
+	defer discharger.Close()
+	h, err := form.NewLoginHandler(form.LoginHandlerParams{
+		Schema: userPassForm,
+		Checker: form.CredentialCheckerFunc(func(ctx context.Context, req *http.Request, f map[string]interface{}) (string, error) {
+			if f["password"] != "secret" {
+				return "", errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "invalid password")
+			}
+			return f["username"].(string), nil
+		}),
+	})
+	c.Assert(err, qt.IsNil)
+	discharger.AddHTTPHandlers(h.Handlers())
+	discharger.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			username, err := h.CheckToken(p.Token)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			return []checkers.Caveat{checkers.DeclaredCaveat("username", username)}, nil
+		}
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		h.SetInteraction(err)
+		return nil, err
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: discharger,
+	})
+	for i, test := range loginHandlerTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		m, err := b.Oven.NewMacaroon(context.TODO(), bakery.LatestVersion, []checkers.Caveat{{
+			Location:  discharger.Location(),
+			Condition: "test condition",
+		}}, identchecker.LoginOp)
+		c.Assert(err, qt.IsNil)
+
+		client := httpbakery.NewClient()
+		client.AddInteractor(form.Interactor{
+			Filler: fillerFunc(func(esform.Form) (map[string]interface{}, error) {
+				return test.form, nil
+			}),
+		})
+		ms, err := client.DischargeAll(context.Background(), m)
+		if test.expectError != "" {
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, qt.IsNil)
+		c.Assert(ms, qt.HasLen, 2)
+		c.Assert(checkers.InferDeclared(nil, ms), qt.DeepEquals, map[string]string{
+			"username": "bob",
+		})
+	}
+}
+
+func TestLoginHandlerTokenCanOnlyBeUsedOnce(t *testing.T) {
+	c := qt.New(t)
+	discharger := bakerytest.NewDischarger(nil)
+	defer discharger.Close()
+	h, err := form.NewLoginHandler(form.LoginHandlerParams{
+		Schema: userPassForm,
+		Checker: form.CredentialCheckerFunc(func(ctx context.Context, req *http.Request, f map[string]interface{}) (string, error) {
+			return f["username"].(string), nil
+		}),
+	})
+	c.Assert(err, qt.IsNil)
+	discharger.AddHTTPHandlers(h.Handlers())
+	var token *httpbakery.DischargeToken
+	discharger.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		token = p.Token
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		h.SetInteraction(err)
+		return nil, err
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: discharger,
+	})
+	m, err := b.Oven.NewMacaroon(context.TODO(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  discharger.Location(),
+		Condition: "test condition",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	client := httpbakery.NewClient()
+	client.AddInteractor(form.Interactor{
+		Filler: fillerFunc(func(esform.Form) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"username": "bob",
+				"password": "secret",
+			}, nil
+		}),
+	})
+	_, err = client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(token, qt.Not(qt.IsNil))
+
+	username, err := h.CheckToken(token)
+	c.Assert(err, qt.IsNil)
+	c.Assert(username, qt.Equals, "bob")
+
+	_, err = h.CheckToken(token)
+	c.Assert(err, qt.ErrorMatches, `discharge token not found`)
+}
+
+func TestNewLoginHandlerErrors(t *testing.T) {
+	c := qt.New(t)
+	_, err := form.NewLoginHandler(form.LoginHandlerParams{})
+	c.Assert(err, qt.ErrorMatches, `no fields in login schema`)
+	_, err = form.NewLoginHandler(form.LoginHandlerParams{
+		Schema: userPassForm,
+	})
+	c.Assert(err, qt.ErrorMatches, `no credential checker specified`)
+}
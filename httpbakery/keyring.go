@@ -4,33 +4,132 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
 
 	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
 )
 
 var _ bakery.ThirdPartyLocator = (*ThirdPartyLocator)(nil)
 
+// DefaultThirdPartyInfoTTL holds the length of time for which
+// ThirdPartyLocator caches third party information when the discharger
+// does not say how long the information may be cached for.
+const DefaultThirdPartyInfoTTL = time.Hour
+
+// refreshRetryInterval holds the time to wait before trying
+// a background refresh again after one has failed.
+const refreshRetryInterval = 30 * time.Second
+
+// backgroundRefreshTimeout holds the maximum length of time
+// that a fetch of third party information can take. Fetches
+// are not bound to the context of any one caller, because
+// several callers may be waiting for the result.
+const backgroundRefreshTimeout = time.Minute
+
+// ThirdPartyLocatorParams holds parameters for
+// NewThirdPartyLocatorWithParams.
+type ThirdPartyLocatorParams struct {
+	// Client is used to make HTTP requests to dischargers.
+	// If it is nil, http.DefaultClient will be used.
+	Client httprequest.Doer
+
+	// Cache holds third party information that is known in advance,
+	// for example from a configuration file. Entries in it never
+	// expire and are used in preference to fetching information
+	// from the discharger. Information fetched from dischargers is
+	// also added to it, so that other users of the store see it,
+	// although the locator itself consults only its own expiring
+	// copy for locations that it has fetched. If it is nil, a new
+	// cache will be created.
+	Cache *bakery.ThirdPartyStore
+
+	// DefaultTTL holds the length of time for which fetched
+	// information is cached when the discharger's response has no
+	// Cache-Control max-age directive or Expires header. If it is
+	// zero, DefaultThirdPartyInfoTTL will be used.
+	DefaultTTL time.Duration
+
+	// Pins, if non-nil, holds public keys that are pinned for
+	// discharge locations. When information is fetched for a
+	// location that has pinned keys, the discharger's public key
+	// must be one of them, or a *KeyMismatchError is returned.
+	// Locations with no pinned keys are trusted as usual, unless
+	// TrustOnFirstUse is set.
+	//
+	// Pins are not checked for information held in Cache.
+	Pins PinStore
+
+	// TrustOnFirstUse specifies that the first key fetched for a
+	// location with no pinned keys should be added to Pins, along
+	// with any upcoming keys advertised in the same response.
+	// Upcoming keys advertised in later responses are not pinned;
+	// to allow a key rotation after first use, add the new key
+	// with Pins.AddPinnedKeys. It has no effect if Pins is nil.
+	TrustOnFirstUse bool
+
+	// OnKeyMismatch, if non-nil, is called whenever a discharger's
+	// key does not match the keys pinned for it. It can be used
+	// to audit such events.
+	OnKeyMismatch func(ctx context.Context, err *KeyMismatchError)
+
+	// Logger is used to log failures to refresh information.
+	// If it is nil, nothing will be logged.
+	Logger bakery.Logger
+
+	// Clock is used to tell the time. If it is nil,
+	// the wall clock will be used.
+	Clock checkers.Clock
+}
+
 // NewThirdPartyLocator returns a new third party
 // locator that uses the given client to find
 // information about third parties and
-// uses the given cache as a backing.
+// uses the given cache for information that is known
+// in advance.
 //
 // If cache is nil, a new cache will be created.
 //
 // If client is nil, http.DefaultClient will be used.
 func NewThirdPartyLocator(client httprequest.Doer, cache *bakery.ThirdPartyStore) *ThirdPartyLocator {
-	if cache == nil {
-		cache = bakery.NewThirdPartyStore()
+	return NewThirdPartyLocatorWithParams(ThirdPartyLocatorParams{
+		Client: client,
+		Cache:  cache,
+	})
+}
+
+// NewThirdPartyLocatorWithParams returns a new third party locator
+// configured with the given parameters.
+func NewThirdPartyLocatorWithParams(p ThirdPartyLocatorParams) *ThirdPartyLocator {
+	if p.Cache == nil {
+		p.Cache = bakery.NewThirdPartyStore()
 	}
-	if client == nil {
-		client = http.DefaultClient
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	if p.DefaultTTL == 0 {
+		p.DefaultTTL = DefaultThirdPartyInfoTTL
+	}
+	if p.Clock == nil {
+		p.Clock = wallClock{}
 	}
 	return &ThirdPartyLocator{
-		client: client,
-		cache:  cache,
+		client:          p.Client,
+		cache:           p.Cache,
+		defaultTTL:      p.DefaultTTL,
+		pins:            p.Pins,
+		trustOnFirstUse: p.TrustOnFirstUse,
+		onKeyMismatch:   p.OnKeyMismatch,
+		logger:          p.Logger,
+		clock:           p.Clock,
+		entries:         make(map[string]*thirdPartyEntry),
+		inflight:        make(map[string]*refreshCall),
 	}
 }
 
@@ -43,10 +142,66 @@ var AllowInsecureThirdPartyLocator = false
 // ThirdPartyLocator represents locator that can interrogate
 // third party discharge services for information. By default it refuses
 // to use insecure URLs.
+//
+// Information fetched from a discharger is cached for as long as the
+// discharger's Cache-Control or Expires response headers allow, or for
+// a default length of time if it does not specify. Shortly before
+// cached information expires, it is refreshed in the background; if
+// the information cannot be refreshed, the stale information continues
+// to be used.
+//
+// Keys may also be pinned for discharge locations, in which case
+// information from a discharger whose key does not match is rejected.
 type ThirdPartyLocator struct {
-	client        httprequest.Doer
-	allowInsecure bool
-	cache         *bakery.ThirdPartyStore
+	client          httprequest.Doer
+	allowInsecure   bool
+	cache           *bakery.ThirdPartyStore
+	defaultTTL      time.Duration
+	pins            PinStore
+	trustOnFirstUse bool
+	onKeyMismatch   func(ctx context.Context, err *KeyMismatchError)
+	logger          bakery.Logger
+	clock           checkers.Clock
+
+	// mu guards the fields below it.
+	mu sync.Mutex
+
+	// entries holds information fetched from dischargers,
+	// keyed by location.
+	entries map[string]*thirdPartyEntry
+
+	// inflight holds the fetches currently in progress,
+	// keyed by location.
+	inflight map[string]*refreshCall
+}
+
+// refreshCall holds a fetch of third party information
+// that is in progress. The info and err fields are set
+// before done is closed.
+type refreshCall struct {
+	done chan struct{}
+	info bakery.ThirdPartyInfo
+	err  error
+}
+
+// thirdPartyEntry holds cached information about a third party.
+// Only the refreshing and refreshAt fields change after
+// the entry has been created; they are guarded by
+// ThirdPartyLocator.mu.
+type thirdPartyEntry struct {
+	info bakery.ThirdPartyInfo
+
+	// expires holds the time after which the
+	// information must be fetched again.
+	expires time.Time
+
+	// refreshAt holds the time after which the
+	// information will be refreshed in the background.
+	refreshAt time.Time
+
+	// refreshing holds whether a background
+	// refresh is in progress.
+	refreshing bool
 }
 
 // AllowInsecure allows insecure URLs. This can be useful
@@ -62,13 +217,93 @@ func (kr *ThirdPartyLocator) AllowInsecure() {
 //
 // It refuses to fetch information from non-HTTPS URLs.
 func (kr *ThirdPartyLocator) ThirdPartyInfo(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
-	// If the cache has an entry in, we can use it regardless of URL scheme.
-	// This allows entries for notionally insecure URLs to be added by other means (for
-	// example via a config file).
-	info, err := kr.cache.ThirdPartyInfo(ctx, loc)
-	if err == nil {
-		return info, nil
+	now := kr.clock.Now()
+	kr.mu.Lock()
+	e := kr.entries[loc]
+	if e != nil && now.Before(e.expires) {
+		if !now.Before(e.refreshAt) && !e.refreshing {
+			e.refreshing = true
+			go kr.backgroundRefresh(bakery.RequestIDFromContext(ctx), loc, e)
+		}
+		kr.mu.Unlock()
+		return e.info, nil
+	}
+	kr.mu.Unlock()
+	if e == nil {
+		// If the cache has an entry in, we can use it regardless of URL scheme.
+		// This allows entries for notionally insecure URLs to be added by other means (for
+		// example via a config file).
+		info, err := kr.cache.ThirdPartyInfo(ctx, loc)
+		if err == nil {
+			return info, nil
+		}
+	}
+	info, err := kr.Refresh(ctx, loc)
+	if err != nil {
+		if _, ok := errgo.Cause(err).(*KeyMismatchError); !ok && e != nil {
+			bakery.Log(ctx, kr.logger, bakery.LevelWarn, "cannot refresh third party information; using stale information", bakery.LocationField(loc), bakery.ErrorField(err))
+			return e.info, nil
+		}
+		return bakery.ThirdPartyInfo{}, errgo.Mask(err, isKeyMismatchError)
 	}
+	return info, nil
+}
+
+// Refresh fetches the information for the given location from the
+// discharger, regardless of any cached information, and caches it.
+// It can be used when a discharger fails to decrypt a third party
+// caveat, which may indicate that the discharger has changed its key.
+// Unlike ThirdPartyInfo, it returns an error if the information
+// cannot be fetched.
+//
+// Concurrent calls for the same location share a single fetch. The
+// fetch does not use ctx, so that it is not abandoned when the caller
+// that started it gives up; each caller stops waiting for it when its
+// own context is done.
+func (kr *ThirdPartyLocator) Refresh(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
+	kr.mu.Lock()
+	call := kr.inflight[loc]
+	if call == nil {
+		call = &refreshCall{
+			done: make(chan struct{}),
+		}
+		kr.inflight[loc] = call
+		go kr.refresh(bakery.RequestIDFromContext(ctx), loc, call)
+	}
+	kr.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.info, errgo.Mask(call.err, errgo.Any)
+	case <-ctx.Done():
+		return bakery.ThirdPartyInfo{}, errgo.Notef(ctx.Err(), "cannot fetch third party information for %q", loc)
+	}
+}
+
+// refresh performs the fetch for the given call, which has been
+// started by Refresh, and closes call.done when it is complete.
+func (kr *ThirdPartyLocator) refresh(requestID, loc string, call *refreshCall) {
+	ctx, cancel := refreshContext(requestID)
+	defer cancel()
+	call.info, call.err = kr.fetch(ctx, loc)
+	kr.mu.Lock()
+	delete(kr.inflight, loc)
+	kr.mu.Unlock()
+	close(call.done)
+}
+
+// refreshContext returns a context for a fetch that is not tied to
+// any request. It holds the given request id, if any, so that the
+// request that caused the fetch can be identified in log messages.
+func refreshContext(requestID string) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if requestID != "" {
+		ctx = bakery.ContextWithRequestID(ctx, requestID)
+	}
+	return context.WithTimeout(ctx, backgroundRefreshTimeout)
+}
+
+// fetch implements Refresh.
+func (kr *ThirdPartyLocator) fetch(ctx context.Context, loc string) (bakery.ThirdPartyInfo, error) {
 	u, err := url.Parse(loc)
 	if err != nil {
 		return bakery.ThirdPartyInfo{}, errgo.Notef(err, "invalid discharge URL %q", loc)
@@ -76,12 +311,92 @@ func (kr *ThirdPartyLocator) ThirdPartyInfo(ctx context.Context, loc string) (ba
 	if u.Scheme != "https" && !kr.allowInsecure && !AllowInsecureThirdPartyLocator {
 		return bakery.ThirdPartyInfo{}, errgo.Newf("untrusted discharge URL %q", loc)
 	}
-	info, err = ThirdPartyInfoForLocation(ctx, kr.client, loc)
+	dinfo, err := fetchDischargerInfo(ctx, kr.client, loc)
 	if err != nil {
-		return bakery.ThirdPartyInfo{}, errgo.Mask(err)
+		return bakery.ThirdPartyInfo{}, errgo.Mask(err, errgo.Any)
 	}
-	kr.cache.AddInfo(loc, info)
-	return info, nil
+	if err := kr.checkPins(ctx, loc, dinfo); err != nil {
+		return bakery.ThirdPartyInfo{}, errgo.Mask(err, isKeyMismatchError)
+	}
+	now := kr.clock.Now()
+	ttl, ok := cacheLifetime(dinfo.header, now)
+	if !ok {
+		ttl = kr.defaultTTL
+	}
+	kr.cache.AddInfo(loc, dinfo.info)
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.entries[loc] = &thirdPartyEntry{
+		info:    dinfo.info,
+		expires: now.Add(ttl),
+		// Start refreshing when three quarters
+		// of the lifetime has passed.
+		refreshAt: now.Add(ttl - ttl/4),
+	}
+	return dinfo.info, nil
+}
+
+// backgroundRefresh refreshes the information for the
+// given location, which is currently held in e. The request id
+// of the request that triggered the refresh is used when logging.
+func (kr *ThirdPartyLocator) backgroundRefresh(requestID, loc string, e *thirdPartyEntry) {
+	ctx, cancel := refreshContext(requestID)
+	defer cancel()
+	_, err := kr.Refresh(ctx, loc)
+	if err == nil {
+		return
+	}
+	bakery.Log(ctx, kr.logger, bakery.LevelWarn, "cannot refresh third party information", bakery.LocationField(loc), bakery.ErrorField(err))
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	e.refreshing = false
+	e.refreshAt = kr.clock.Now().Add(refreshRetryInterval)
+}
+
+// cacheLifetime returns how long a response with the given header
+// may be cached for, according to its Cache-Control and Expires
+// headers. It returns false if the header does not say.
+func cacheLifetime(h http.Header, now time.Time) (time.Duration, bool) {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			name, val := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, val = directive[:i], directive[i+1:]
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-cache", "no-store":
+				return 0, true
+			case "max-age":
+				secs, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(val), `"`), 10, 64)
+				if err != nil || secs < 0 {
+					return 0, true
+				}
+				return time.Duration(secs) * time.Second, true
+			}
+		}
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires header means that the
+			// response has already expired.
+			return 0, true
+		}
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			now = date
+		}
+		if t.Before(now) {
+			return 0, true
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
 }
 
 // ThirdPartyInfoForLocation returns information on the third party
@@ -89,25 +404,135 @@ func (kr *ThirdPartyLocator) ThirdPartyInfo(ctx context.Context, loc string) (ba
 // insecure if an http: URL scheme is used. If client is nil,
 // http.DefaultClient will be used.
 func ThirdPartyInfoForLocation(ctx context.Context, client httprequest.Doer, url string) (bakery.ThirdPartyInfo, error) {
-	dclient := newDischargeClient(url, client)
+	dinfo, err := fetchDischargerInfo(ctx, client, url)
+	if err != nil {
+		return bakery.ThirdPartyInfo{}, errgo.Mask(err, errgo.Any)
+	}
+	return dinfo.info, nil
+}
+
+// dischargerInfo holds information fetched from a discharger.
+type dischargerInfo struct {
+	info bakery.ThirdPartyInfo
+
+	// upcoming holds any keys that the discharger
+	// advertises that it will use in the future.
+	upcoming []bakery.PublicKey
+
+	// header holds the header of the response that
+	// the information was taken from.
+	header http.Header
+}
+
+// fetchDischargerInfo is like ThirdPartyInfoForLocation except
+// that it returns more of the information in the response.
+func fetchDischargerInfo(ctx context.Context, client httprequest.Doer, url string) (*dischargerInfo, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	recorder := &headerRecorder{
+		doer: client,
+	}
+	dclient := newDischargeClient(url, recorder)
 	info, err := dclient.DischargeInfo(ctx, &dischargeInfoRequest{})
 	if err == nil {
-		return bakery.ThirdPartyInfo{
-			PublicKey: *info.PublicKey,
-			Version:   info.Version,
+		return &dischargerInfo{
+			info: bakery.ThirdPartyInfo{
+				PublicKey: *info.PublicKey,
+				Version:   info.Version,
+			},
+			upcoming: derefPublicKeys(info.UpcomingPublicKeys),
+			header:   recorder.header,
 		}, nil
 	}
 	derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
 	if !ok || derr.Response.StatusCode != http.StatusNotFound {
-		return bakery.ThirdPartyInfo{}, errgo.Mask(err)
+		return nil, errgo.Mask(err)
 	}
 	// The new endpoint isn't there, so try the old one.
 	pkResp, err := dclient.PublicKey(ctx, &publicKeyRequest{})
 	if err != nil {
-		return bakery.ThirdPartyInfo{}, errgo.Mask(err)
+		return nil, errgo.Mask(err)
 	}
-	return bakery.ThirdPartyInfo{
-		PublicKey: *pkResp.PublicKey,
-		Version:   bakery.Version1,
+	return &dischargerInfo{
+		info: bakery.ThirdPartyInfo{
+			PublicKey: *pkResp.PublicKey,
+			Version:   bakery.Version1,
+		},
+		header: recorder.header,
 	}, nil
 }
+
+// headerRecorder is an httprequest.Doer that records the
+// header of the most recent response.
+type headerRecorder struct {
+	doer   httprequest.Doer
+	header http.Header
+}
+
+// Do implements httprequest.Doer.Do.
+func (r *headerRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.doer.Do(req)
+	if resp != nil {
+		r.header = resp.Header
+	}
+	return resp, err
+}
+
+// DischargerKeys holds the public keys advertised
+// by a third party discharger.
+type DischargerKeys struct {
+	// Primary holds the key that the discharger currently
+	// uses. New third party caveats should be encrypted
+	// for this key.
+	Primary bakery.PublicKey
+
+	// Upcoming holds keys that the discharger will use in
+	// the future.
+	Upcoming []bakery.PublicKey
+
+	// Deprecated holds keys that the discharger has used in the
+	// past and still accepts, but will stop accepting in the future.
+	Deprecated []bakery.PublicKey
+}
+
+// DischargerKeysForLocation returns the public keys advertised by the
+// third party discharge server running at the given location URL.
+// Servers that do not advertise upcoming or deprecated keys
+// are reported as having only a primary key. As with
+// ThirdPartyInfoForLocation, this is insecure if an http: URL scheme
+// is used. If client is nil, http.DefaultClient will be used.
+func DischargerKeysForLocation(ctx context.Context, client httprequest.Doer, url string) (*DischargerKeys, error) {
+	info, err := newDischargeClient(url, client).DischargeInfo(ctx, &dischargeInfoRequest{})
+	if err != nil {
+		derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+		if !ok || derr.Response.StatusCode != http.StatusNotFound {
+			return nil, errgo.Mask(err)
+		}
+		tpInfo, err := ThirdPartyInfoForLocation(ctx, client, url)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return &DischargerKeys{
+			Primary: tpInfo.PublicKey,
+		}, nil
+	}
+	if info.PublicKey == nil {
+		return nil, errgo.Newf("no public key found in discharge info")
+	}
+	return &DischargerKeys{
+		Primary:    *info.PublicKey,
+		Upcoming:   derefPublicKeys(info.UpcomingPublicKeys),
+		Deprecated: derefPublicKeys(info.DeprecatedPublicKeys),
+	}, nil
+}
+
+func derefPublicKeys(pks []*bakery.PublicKey) []bakery.PublicKey {
+	var keys []bakery.PublicKey
+	for _, pk := range pks {
+		if pk != nil {
+			keys = append(keys, *pk)
+		}
+	}
+	return keys
+}
@@ -1,6 +1,7 @@
 package httpbakery_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 	"net/url"
 	"sync"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"gopkg.in/errgo.v1"
@@ -121,6 +123,108 @@ func TestConcurrentThirdPartyInfo(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFetchedInfoAddedToCache(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	cache := bakery.NewThirdPartyStore()
+	kr := httpbakery.NewThirdPartyLocator(nil, cache)
+	_, err := kr.ThirdPartyInfo(testContext, d.Location())
+	c.Assert(err, qt.IsNil)
+	info, err := cache.ThirdPartyInfo(testContext, d.Location())
+	c.Assert(err, qt.IsNil)
+	c.Assert(info, qt.DeepEquals, bakery.ThirdPartyInfo{
+		PublicKey: d.Key.Public,
+		Version:   bakery.LatestVersion,
+	})
+}
+
+func TestConcurrentRefreshSharesFetch(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-release
+		httprequest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"PublicKey": &key.Public,
+			"Version":   bakery.LatestVersion,
+		})
+	}))
+	defer srv.Close()
+	kr := httpbakery.NewThirdPartyLocator(nil, nil)
+	kr.AllowInsecure()
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := kr.Refresh(testContext, srv.URL)
+			c.Check(err, qt.IsNil)
+			c.Check(info.PublicKey, qt.Equals, key.Public)
+		}()
+	}
+	// Give the goroutines time to start their requests.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(requests, qt.Equals, 1)
+}
+
+func TestRefreshNotCancelledWithCaller(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		httprequest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"PublicKey": &key.Public,
+			"Version":   bakery.LatestVersion,
+		})
+	}))
+	defer srv.Close()
+	kr := httpbakery.NewThirdPartyLocator(nil, nil)
+	kr.AllowInsecure()
+
+	// The first caller gives up while the fetch is in progress.
+	ctx, cancel := context.WithCancel(testContext)
+	done := make(chan error)
+	go func() {
+		_, err := kr.Refresh(ctx, srv.URL)
+		done <- err
+	}()
+	<-started
+	cancel()
+	err := <-done
+	c.Assert(err, qt.ErrorMatches, `cannot fetch third party information for ".*": context canceled`)
+
+	// A second caller shares the fetch, which is still
+	// in progress and succeeds.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	info, err := kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, key.Public)
+	select {
+	case <-started:
+		c.Fatalf("unexpected second fetch")
+	default:
+	}
+}
+
 func TestCustomHTTPClient(t *testing.T) {
 	c := qt.New(t)
 	client := &http.Client{
@@ -215,3 +319,276 @@ type errorTransport struct{}
 func (errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return nil, errgo.New("custom round trip error")
 }
+
+func TestDischargerKeysForLocation(t *testing.T) {
+	c := qt.New(t)
+	primary := bakery.MustGenerateKey()
+	upcoming := bakery.MustGenerateKey()
+	deprecated := bakery.MustGenerateKey()
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Keys: &bakery.KeySet{
+			Primary:    primary,
+			Upcoming:   []bakery.KeyOpener{upcoming},
+			Deprecated: []bakery.KeyOpener{deprecated},
+		},
+	})
+	mux := http.NewServeMux()
+	d.AddMuxHandlers(mux, "/")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	keys, err := httpbakery.DischargerKeysForLocation(testContext, nil, server.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.DeepEquals, &httpbakery.DischargerKeys{
+		Primary:    primary.Public,
+		Upcoming:   []bakery.PublicKey{upcoming.Public},
+		Deprecated: []bakery.PublicKey{deprecated.Public},
+	})
+
+	// Clients that only know about third party info
+	// see the primary key.
+	info, err := httpbakery.ThirdPartyInfoForLocation(testContext, nil, server.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, primary.Public)
+}
+
+func TestDischargerWithoutPrimaryKey(t *testing.T) {
+	c := qt.New(t)
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Keys: &bakery.KeySet{
+			Upcoming: []bakery.KeyOpener{bakery.MustGenerateKey()},
+		},
+	})
+	mux := http.NewServeMux()
+	d.AddMuxHandlers(mux, "/")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, err := httpbakery.DischargerKeysForLocation(testContext, nil, server.URL)
+	c.Assert(err, qt.ErrorMatches, `.*discharger has no primary key`)
+	_, err = httpbakery.ThirdPartyInfoForLocation(testContext, nil, server.URL)
+	c.Assert(err, qt.ErrorMatches, `.*discharger has no primary key`)
+}
+
+func TestDischargerKeysForLocationFallbackToOldVersion(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publickey", func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusOK, &httpbakery.PublicKeyResponse{
+			PublicKey: &key.Public,
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	keys, err := httpbakery.DischargerKeysForLocation(testContext, nil, server.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.DeepEquals, &httpbakery.DischargerKeys{
+		Primary: key.Public,
+	})
+}
+
+func TestThirdPartyLocatorExpiry(t *testing.T) {
+	c := qt.New(t)
+	key1 := bakery.MustGenerateKey()
+	key2 := bakery.MustGenerateKey()
+	var (
+		mu       sync.Mutex
+		key      = key1
+		fail     bool
+		requests = make(chan struct{}, 10)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		defer func() { requests <- struct{}{} }()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		httprequest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"PublicKey": &key.Public,
+			"Version":   bakery.LatestVersion,
+		})
+	}))
+	defer srv.Close()
+	setKey := func(k *bakery.KeyPair, f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		key, fail = k, f
+	}
+	clock := &testClock{
+		now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+		Clock: clock,
+	})
+	kr.AllowInsecure()
+	assertKey := func(k *bakery.KeyPair) {
+		info, err := kr.ThirdPartyInfo(testContext, srv.URL)
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.PublicKey, qt.Equals, k.Public)
+	}
+	assertRequests := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-requests:
+			case <-time.After(5 * time.Second):
+				c.Fatalf("timed out waiting for request")
+			}
+		}
+		select {
+		case <-requests:
+			c.Fatalf("unexpected request")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	assertKey(key1)
+	assertRequests(1)
+
+	// The key changes, but the old one is cached.
+	setKey(key2, false)
+	clock.Advance(30 * time.Second)
+	assertKey(key1)
+	assertRequests(0)
+
+	// Near to expiry, the cached key is returned but
+	// refreshed in the background.
+	clock.Advance(20 * time.Second)
+	assertKey(key1)
+	assertRequests(1)
+	assertKey(key2)
+	assertRequests(0)
+
+	// When the information has expired and cannot be
+	// fetched, the stale information is used.
+	setKey(key1, true)
+	clock.Advance(2 * time.Minute)
+	assertKey(key2)
+	assertRequests(1)
+
+	// A forced refresh returns the error.
+	_, err := kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.ErrorMatches, `Get .*/discharge/info: .*500 Internal Server Error.*`)
+	assertRequests(1)
+
+	// A forced refresh fetches new information
+	// regardless of expiry.
+	setKey(key1, false)
+	info, err := kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, key1.Public)
+	assertRequests(1)
+	assertKey(key1)
+	assertRequests(0)
+}
+
+func TestThirdPartyLocatorDefaultTTL(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	clock := &testClock{
+		now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+		Client:     httpbakery.NewHTTPClient(),
+		DefaultTTL: time.Minute,
+		Clock:      clock,
+	})
+	loc := d.Location()
+	_, err := kr.ThirdPartyInfo(testContext, loc)
+	c.Assert(err, qt.IsNil)
+	d.Close()
+
+	// The information is still cached.
+	clock.Advance(30 * time.Second)
+	_, err = kr.ThirdPartyInfo(testContext, loc)
+	c.Assert(err, qt.IsNil)
+
+	// A forced refresh fails because the discharger has gone.
+	_, err = kr.Refresh(testContext, loc)
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+var cacheLifetimeTests = []struct {
+	about       string
+	header      http.Header
+	expectTTL   time.Duration
+	expectFound bool
+}{{
+	about: "no headers",
+}, {
+	about:       "max-age",
+	header:      http.Header{"Cache-Control": {"public, max-age=300"}},
+	expectTTL:   5 * time.Minute,
+	expectFound: true,
+}, {
+	about:       "no-cache",
+	header:      http.Header{"Cache-Control": {"no-cache"}},
+	expectFound: true,
+}, {
+	about:       "invalid max-age",
+	header:      http.Header{"Cache-Control": {"max-age=foo"}},
+	expectFound: true,
+}, {
+	about: "max-age takes precedence over expires",
+	header: http.Header{
+		"Cache-Control": {"max-age=10"},
+		"Expires":       {"Wed, 01 Jan 2020 01:00:00 GMT"},
+	},
+	expectTTL:   10 * time.Second,
+	expectFound: true,
+}, {
+	about:       "expires",
+	header:      http.Header{"Expires": {"Wed, 01 Jan 2020 01:00:00 GMT"}},
+	expectTTL:   time.Hour,
+	expectFound: true,
+}, {
+	about: "expires relative to date",
+	header: http.Header{
+		"Expires": {"Wed, 01 Jan 2020 01:00:00 GMT"},
+		"Date":    {"Wed, 01 Jan 2020 00:30:00 GMT"},
+	},
+	expectTTL:   30 * time.Minute,
+	expectFound: true,
+}, {
+	about:       "expires in the past",
+	header:      http.Header{"Expires": {"Tue, 31 Dec 2019 00:00:00 GMT"}},
+	expectFound: true,
+}, {
+	about:       "invalid expires",
+	header:      http.Header{"Expires": {"0"}},
+	expectFound: true,
+}}
+
+func TestCacheLifetime(t *testing.T) {
+	c := qt.New(t)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range cacheLifetimeTests {
+		c.Run(test.about, func(c *qt.C) {
+			ttl, ok := httpbakery.CacheLifetime(test.header, now)
+			c.Assert(ok, qt.Equals, test.expectFound)
+			c.Assert(ttl, qt.Equals, test.expectTTL)
+		})
+	}
+}
+
+// testClock is a clock that only moves when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
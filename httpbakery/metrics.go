@@ -0,0 +1,61 @@
+package httpbakery
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// Names of the metrics recorded by the httpbakery package.
+// See bakery.Metrics.
+const (
+	// MetricDischargeAcquire records the time taken by
+	// Client.AcquireDischarge, including any interaction. It is
+	// labelled with "location", the scheme and host of the
+	// location of the third party caveat, and "outcome", which is
+	// "ok" or "error". Any path in the location is omitted so that
+	// the number of distinct labels stays small.
+	MetricDischargeAcquire = "bakery_discharge_acquire_seconds"
+
+	// MetricInteraction records the time taken by each
+	// interaction method tried by the client. It is labelled with
+	// "kind", the interaction kind ("legacy" for legacy
+	// interactions), and "outcome", which is one of "ok",
+	// "not_supported" or "error".
+	MetricInteraction = "bakery_interaction_seconds"
+
+	// MetricDischargeRequest records the time taken by the
+	// Discharger to handle discharge requests. It is labelled with
+	// "outcome", which is one of "ok", "interaction_required" or
+	// "refused".
+	MetricDischargeRequest = "bakery_discharge_request_seconds"
+)
+
+// observe records the time since start in the named histogram
+// of m with the given labels. It does nothing if m is nil.
+func observe(m bakery.Metrics, name string, labels map[string]string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.ObserveDuration(name, labels, time.Since(start))
+}
+
+// outcome returns "ok" if err is nil or "error" otherwise.
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// locationLabel returns the value of the "location" label for a
+// third party caveat location: its scheme and host, or "invalid" if
+// the location is not an absolute URL.
+func locationLabel(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
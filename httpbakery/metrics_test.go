@@ -0,0 +1,79 @@
+package httpbakery_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/prommetrics"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func TestDischargeMetrics(t *testing.T) {
+	c := qt.New(t)
+	metrics := prommetrics.New(prommetrics.Params{})
+	key := bakery.MustGenerateKey()
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Key: key,
+		CheckerP: httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+			switch string(p.Caveat.Condition) {
+			case "ok":
+				return nil, nil
+			case "interact":
+				if p.Token != nil {
+					return nil, nil
+				}
+				err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+				err.SetInteraction("test", nil)
+				return nil, err
+			}
+			return nil, errgo.Newf("refused")
+		}),
+		Metrics: metrics,
+	})
+	srv := httptest.NewServer(handlersToMux(d))
+	defer srv.Close()
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo(srv.URL, bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	// A location with a path is labelled with its scheme and host only.
+	locator.AddInfo(srv.URL+"/some/path", bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	b := newBakery("loc", locator, nil)
+	client := httpbakery.NewClient()
+	client.AddInteractor(tokenInteractor{})
+	client.Metrics = metrics
+
+	for _, cav := range []checkers.Caveat{
+		{Location: srv.URL, Condition: "ok"},
+		{Location: srv.URL, Condition: "interact"},
+		{Location: srv.URL, Condition: "other"},
+		{Location: srv.URL + "/some/path", Condition: "ok"},
+	} {
+		m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{cav}, testOp)
+		c.Assert(err, qt.IsNil)
+		client.DischargeAll(testContext, m)
+	}
+	var buf strings.Builder
+	metrics.WriteTo(&buf)
+	for _, line := range []string{
+		`bakery_discharge_acquire_seconds_count{location="` + srv.URL + `",outcome="error"} 2`,
+		`bakery_discharge_acquire_seconds_count{location="` + srv.URL + `",outcome="ok"} 2`,
+		`bakery_discharge_request_seconds_count{outcome="interaction_required"} 1`,
+		`bakery_discharge_request_seconds_count{outcome="ok"} 2`,
+		`bakery_discharge_request_seconds_count{outcome="refused"} 1`,
+		`bakery_interaction_seconds_count{kind="test",outcome="ok"} 1`,
+	} {
+		c.Assert(strings.Contains(buf.String(), line+"\n"), qt.Equals, true, qt.Commentf("%s", line))
+	}
+}
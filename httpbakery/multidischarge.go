@@ -0,0 +1,158 @@
+package httpbakery
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+)
+
+// DischargerTenant holds the parameters for one of the
+// dischargers served by a MultiDischarger.
+type DischargerTenant struct {
+	// Host, if non-empty, specifies that the tenant serves requests
+	// whose Host header matches it. If it holds no port, requests
+	// to any port on the host match. An IPv6 address should be
+	// enclosed in square brackets, as in a URL, for example "[::1]".
+	Host string
+
+	// PathPrefix, if non-empty, specifies that the tenant serves
+	// requests under the given path, for example "/tenant-a". The
+	// discharger endpoints are served relative to it, so the
+	// tenant's discharge location is the URL of the prefix.
+	PathPrefix string
+
+	// Params holds the parameters for the tenant's discharger.
+	Params DischargerParams
+}
+
+// MultiDischarger is an http.Handler that serves the discharge
+// endpoints of several dischargers, each with its own keys, caveat
+// checker and locator. Each request is served by the tenant that
+// matches its Host header and path. Tenants that specify a host take
+// precedence over those that do not, and otherwise the tenant with the
+// longest matching path prefix is used.
+type MultiDischarger struct {
+	tenants []*dischargerTenant
+}
+
+// dischargerTenant holds a tenant of a MultiDischarger.
+type dischargerTenant struct {
+	// host and port hold the host and port from
+	// DischargerTenant.Host. The port is empty if
+	// the host did not specify one.
+	host       string
+	port       string
+	pathPrefix string
+	handler    http.Handler
+}
+
+// NewMultiDischarger returns a MultiDischarger that serves the given
+// tenants. It returns an error if a tenant has no primary key or if two tenants
+// have the same host and path prefix.
+func NewMultiDischarger(tenants []DischargerTenant) (*MultiDischarger, error) {
+	d := &MultiDischarger{
+		tenants: make([]*dischargerTenant, 0, len(tenants)),
+	}
+	seen := make(map[[2]string]bool)
+	for _, t := range tenants {
+		host := strings.ToLower(t.Host)
+		prefix := strings.TrimSuffix(t.PathPrefix, "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return nil, errgo.Newf("path prefix %q does not start with /", t.PathPrefix)
+		}
+		if t.Params.Key == nil && t.Params.KeyOpener == nil && t.Params.Keys == nil {
+			return nil, errgo.Newf("no key for discharger tenant with host %q and path prefix %q", t.Host, t.PathPrefix)
+		}
+		if t.Params.Keys != nil && t.Params.Keys.Primary == nil {
+			return nil, errgo.Newf("no primary key for discharger tenant with host %q and path prefix %q", t.Host, t.PathPrefix)
+		}
+		if seen[[2]string{host, prefix}] {
+			return nil, errgo.Newf("duplicate discharger tenant with host %q and path prefix %q", t.Host, t.PathPrefix)
+		}
+		seen[[2]string{host, prefix}] = true
+		root := prefix
+		if root == "" {
+			root = "/"
+		}
+		mux := http.NewServeMux()
+		NewDischarger(t.Params).AddMuxHandlers(mux, root)
+		host, port := splitHostPort(host)
+		d.tenants = append(d.tenants, &dischargerTenant{
+			host:       host,
+			port:       port,
+			pathPrefix: prefix,
+			handler:    mux,
+		})
+	}
+	return d, nil
+}
+
+// ServeHTTP implements http.Handler.ServeHTTP.
+func (d *MultiDischarger) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t := d.tenant(req)
+	if t == nil {
+		httprequest.WriteJSON(w, http.StatusNotFound, &Error{
+			Message: "no discharger found for " + req.Host + req.URL.Path,
+		})
+		return
+	}
+	t.handler.ServeHTTP(w, req)
+}
+
+// tenant returns the tenant that should serve the given request,
+// or nil if there is none.
+func (d *MultiDischarger) tenant(req *http.Request) *dischargerTenant {
+	var best *dischargerTenant
+	for _, t := range d.tenants {
+		if !t.matches(req) {
+			continue
+		}
+		if best == nil || t.moreSpecific(best) {
+			best = t
+		}
+	}
+	return best
+}
+
+// matches reports whether the tenant can serve the given request.
+func (t *dischargerTenant) matches(req *http.Request) bool {
+	if t.host != "" {
+		host, port := splitHostPort(strings.ToLower(req.Host))
+		if host != t.host || (t.port != "" && port != t.port) {
+			return false
+		}
+	}
+	if t.pathPrefix == "" {
+		return true
+	}
+	p := req.URL.Path
+	return p == t.pathPrefix || strings.HasPrefix(p, t.pathPrefix+"/")
+}
+
+// moreSpecific reports whether t should be preferred
+// to t1 when both match a request.
+func (t *dischargerTenant) moreSpecific(t1 *dischargerTenant) bool {
+	if (t.host != "") != (t1.host != "") {
+		return t.host != ""
+	}
+	return len(t.pathPrefix) > len(t1.pathPrefix)
+}
+
+// splitHostPort splits the given host, which may have a port, into
+// its host and port. The port is empty if there is none. Any brackets
+// around an IPv6 address are removed, so "[::1]" and "[::1]:8080" both
+// have the host "::1".
+func splitHostPort(hostPort string) (host, port string) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err == nil {
+		return host, port
+	}
+	host = hostPort
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return host, ""
+}
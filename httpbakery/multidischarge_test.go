@@ -0,0 +1,203 @@
+package httpbakery_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func TestMultiDischarger(t *testing.T) {
+	c := qt.New(t)
+	keyA := bakery.MustGenerateKey()
+	keyB := bakery.MustGenerateKey()
+	keyC := bakery.MustGenerateKey()
+	keyD := bakery.MustGenerateKey()
+	keyE := bakery.MustGenerateKey()
+	d, err := httpbakery.NewMultiDischarger([]httpbakery.DischargerTenant{{
+		Host: "a.example.com",
+		Params: httpbakery.DischargerParams{
+			Key:      keyA,
+			CheckerP: conditionChecker("a"),
+		},
+	}, {
+		PathPrefix: "/b",
+		Params: httpbakery.DischargerParams{
+			Key:      keyB,
+			CheckerP: conditionChecker("b"),
+		},
+	}, {
+		Host:       "a.example.com",
+		PathPrefix: "/c/",
+		Params: httpbakery.DischargerParams{
+			Key:      keyC,
+			CheckerP: conditionChecker("c"),
+		},
+	}, {
+		Host: "[::1]",
+		Params: httpbakery.DischargerParams{
+			Key:      keyD,
+			CheckerP: conditionChecker("d"),
+		},
+	}, {
+		Host: "[::2]:8443",
+		Params: httpbakery.DischargerParams{
+			Key:      keyE,
+			CheckerP: conditionChecker("e"),
+		},
+	}})
+	c.Assert(err, qt.IsNil)
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	// Make a client that sends all requests to the server,
+	// regardless of host name.
+	client := httpbakery.NewHTTPClient()
+	client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+
+	tests := []struct {
+		loc         string
+		expectKey   *bakery.KeyPair
+		expectError string
+	}{{
+		loc:       "http://a.example.com",
+		expectKey: keyA,
+	}, {
+		loc:       "http://A.example.com:8080",
+		expectKey: keyA,
+	}, {
+		loc:       "http://b.example.com/b",
+		expectKey: keyB,
+	}, {
+		// Host-specific tenants take precedence, so
+		// the path prefix is not considered.
+		loc:         "http://a.example.com/b",
+		expectError: `.*404 page not found`,
+	}, {
+		loc:       "http://a.example.com/c",
+		expectKey: keyC,
+	}, {
+		loc:         "http://b.example.com",
+		expectError: `.*no discharger found for b.example.com/discharge/info`,
+	}, {
+		loc:       "http://[::1]",
+		expectKey: keyD,
+	}, {
+		loc:       "http://[::1]:8080",
+		expectKey: keyD,
+	}, {
+		loc:       "http://[::2]:8443",
+		expectKey: keyE,
+	}, {
+		loc:         "http://[::2]:8080",
+		expectError: `.*no discharger found for \[::2\]:8080/discharge/info`,
+	}}
+	for _, test := range tests {
+		c.Run(test.loc, func(c *qt.C) {
+			keys, err := httpbakery.DischargerKeysForLocation(testContext, client, test.loc)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(keys.Primary, qt.Equals, test.expectKey.Public)
+		})
+	}
+
+	// Check that caveats are discharged by the right tenant.
+	locator := httpbakery.NewThirdPartyLocator(client, nil)
+	locator.AllowInsecure()
+	b := newBakery("loc", locator, nil)
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{{
+		Location:  "http://a.example.com",
+		Condition: "a",
+	}, {
+		Location:  "http://b.example.com/b",
+		Condition: "b",
+	}, {
+		Location:  "http://a.example.com/c",
+		Condition: "c",
+	}}, testOp)
+	c.Assert(err, qt.IsNil)
+	bclient := httpbakery.NewClient()
+	bclient.Client = client
+	ms, err := bclient.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ms, qt.HasLen, 4)
+	_, err = b.Checker.Auth(ms).Allow(testContext, testOp)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestMultiDischargerError(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	tests := []struct {
+		about       string
+		tenants     []httpbakery.DischargerTenant
+		expectError string
+	}{{
+		about: "no key",
+		tenants: []httpbakery.DischargerTenant{{
+			Host: "a.example.com",
+		}},
+		expectError: `no key for discharger tenant with host "a.example.com" and path prefix ""`,
+	}, {
+		about: "no primary key",
+		tenants: []httpbakery.DischargerTenant{{
+			Host: "a.example.com",
+			Params: httpbakery.DischargerParams{
+				Keys: &bakery.KeySet{
+					Deprecated: []bakery.KeyOpener{key},
+				},
+			},
+		}},
+		expectError: `no primary key for discharger tenant with host "a.example.com" and path prefix ""`,
+	}, {
+		about: "duplicate",
+		tenants: []httpbakery.DischargerTenant{{
+			PathPrefix: "/a",
+			Params:     httpbakery.DischargerParams{Key: key},
+		}, {
+			PathPrefix: "/a/",
+			Params:     httpbakery.DischargerParams{Key: key},
+		}},
+		expectError: `duplicate discharger tenant with host "" and path prefix "/a/"`,
+	}, {
+		about: "relative path prefix",
+		tenants: []httpbakery.DischargerTenant{{
+			PathPrefix: "a",
+			Params:     httpbakery.DischargerParams{Key: key},
+		}},
+		expectError: `path prefix "a" does not start with /`,
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			_, err := httpbakery.NewMultiDischarger(test.tenants)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+// conditionChecker returns a checker that
+// accepts only the given condition.
+func conditionChecker(cond string) httpbakery.ThirdPartyCaveatCheckerP {
+	return httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if string(p.Caveat.Condition) != cond {
+			return nil, errgo.Newf("unexpected condition %q", p.Caveat.Condition)
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,341 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// jwtHeader holds the fields of a JWT header that are used.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwt holds a parsed, but not yet verified, JWT.
+type jwt struct {
+	header jwtHeader
+	// claims holds all the claims in the token.
+	claims map[string]interface{}
+	// registered holds the registered claims that are checked.
+	registered registeredClaims
+	// signed holds the signed part of the token.
+	signed []byte
+	sig    []byte
+}
+
+type registeredClaims struct {
+	Issuer    string       `json:"iss"`
+	Subject   string       `json:"sub"`
+	Audience  audience     `json:"aud"`
+	Expiry    *numericDate `json:"exp"`
+	NotBefore *numericDate `json:"nbf"`
+}
+
+// audience holds the "aud" claim, which may
+// be either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return errgo.Newf("invalid audience")
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, a1 := range a {
+		if a1 == s {
+			return true
+		}
+	}
+	return false
+}
+
+// numericDate holds a time encoded as a number
+// of seconds since the Unix epoch.
+type numericDate struct {
+	time.Time
+}
+
+func (d *numericDate) UnmarshalJSON(data []byte) error {
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return errgo.Newf("invalid date")
+	}
+	sec := int64(f)
+	d.Time = time.Unix(sec, int64((f-float64(sec))*1e9))
+	return nil
+}
+
+// parseJWT parses a JWT in compact serialization form.
+// It does not verify the token's signature or claims.
+func parseJWT(token string) (*jwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errgo.Newf("token does not have three parts")
+	}
+	var t jwt
+	if err := decodeSegment(parts[0], &t.header); err != nil {
+		return nil, errgo.Notef(err, "invalid header")
+	}
+	if err := decodeSegment(parts[1], &t.claims); err != nil {
+		return nil, errgo.Notef(err, "invalid claims")
+	}
+	if err := decodeSegment(parts[1], &t.registered); err != nil {
+		return nil, errgo.Notef(err, "invalid claims")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid signature encoding")
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+	t.sig = sig
+	return &t, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// algorithm holds information about a supported signing algorithm.
+type algorithm struct {
+	// kty holds the JWK key type used with the algorithm.
+	kty  string
+	hash crypto.Hash
+	// curve holds the curve used by ECDSA algorithms.
+	curve elliptic.Curve
+	pss   bool
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {kty: "RSA", hash: crypto.SHA256},
+	"RS384": {kty: "RSA", hash: crypto.SHA384},
+	"RS512": {kty: "RSA", hash: crypto.SHA512},
+	"PS256": {kty: "RSA", hash: crypto.SHA256, pss: true},
+	"PS384": {kty: "RSA", hash: crypto.SHA384, pss: true},
+	"PS512": {kty: "RSA", hash: crypto.SHA512, pss: true},
+	"ES256": {kty: "EC", hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {kty: "EC", hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {kty: "EC", hash: crypto.SHA512, curve: elliptic.P521()},
+	"EdDSA": {kty: "OKP"},
+}
+
+// verifySignature verifies the token's signature with the given key,
+// which must be suitable for the algorithm in the token's header.
+func (t *jwt) verifySignature(key publicKey) error {
+	alg, ok := algorithms[t.header.Alg]
+	if !ok {
+		return errgo.Newf("unsupported algorithm %q", t.header.Alg)
+	}
+	if key.alg != "" && key.alg != t.header.Alg {
+		return errgo.Newf("key %q cannot be used with algorithm %q", key.kid, t.header.Alg)
+	}
+	var digest []byte
+	if alg.hash != 0 {
+		h := alg.hash.New()
+		h.Write(t.signed)
+		digest = h.Sum(nil)
+	}
+	switch k := key.key.(type) {
+	case *rsa.PublicKey:
+		if alg.kty != "RSA" {
+			break
+		}
+		var err error
+		if alg.pss {
+			err = rsa.VerifyPSS(k, alg.hash, digest, t.sig, &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+			})
+		} else {
+			err = rsa.VerifyPKCS1v15(k, alg.hash, digest, t.sig)
+		}
+		if err != nil {
+			return errgo.Newf("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg.kty != "EC" || k.Curve != alg.curve {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.sig) != 2*size {
+			return errgo.Newf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(t.sig[:size])
+		s := new(big.Int).SetBytes(t.sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errgo.Newf("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg.kty != "OKP" {
+			break
+		}
+		if !ed25519.Verify(k, t.signed, t.sig) {
+			return errgo.Newf("invalid signature")
+		}
+		return nil
+	}
+	return errgo.Newf("key %q cannot be used with algorithm %q", key.kid, t.header.Alg)
+}
+
+// publicKey holds a public key from a JSON Web Key Set.
+type publicKey struct {
+	kid string
+	// alg holds the algorithm that the key is restricted
+	// to, or the empty string if it is not restricted.
+	alg string
+	key crypto.PublicKey
+}
+
+// jwks defines the format of a JSON Web Key Set, as
+// defined by RFC 7517.
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses a JSON Web Key Set. Keys that are not for
+// signatures or are of unsupported types are ignored. Keys that are
+// invalid are skipped and the reasons are returned in invalid, so
+// that one bad key does not prevent the others from being used. It
+// returns an error if every key in the set is invalid.
+func parseJWKS(data []byte) (keys []publicKey, invalid []error, err error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, nil, errgo.Notef(err, "cannot unmarshal key set")
+	}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			invalid = append(invalid, errgo.Notef(err, "invalid key %d (%q)", i, k.Kid))
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		keys = append(keys, publicKey{
+			kid: k.Kid,
+			alg: k.Alg,
+			key: key,
+		})
+	}
+	if len(keys) == 0 && len(invalid) > 0 {
+		return nil, invalid, errgo.Notef(invalid[0], "no valid keys in key set")
+	}
+	return keys, invalid, nil
+}
+
+// publicKey returns the public key held in k, or nil
+// if the key type is not supported.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid modulus")
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid exponent")
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, errgo.Newf("invalid exponent")
+		}
+		return &rsa.PublicKey{
+			N: n,
+			E: int(e.Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid x coordinate")
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid y coordinate")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errgo.Newf("point not on curve")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     x,
+			Y:     y,
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errgo.Newf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(data) == 0 {
+		return nil, errgo.Newf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,600 @@
+// Package oidc provides an identchecker.IdentityClient that
+// authenticates users with OpenID Connect ID tokens, or other JWTs,
+// presented as bearer tokens.
+//
+// A service that has authenticated a user with a token can mint a login
+// macaroon holding the user's identity (see Identity.Caveats), so that
+// later requests can use the macaroon without a third party discharge.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+// ErrInvalidToken is used as the cause of errors returned
+// when a bearer token is present but is not valid.
+var ErrInvalidToken = errgo.New("invalid bearer token")
+
+// Declared attribute names used for identities. See
+// Identity.Caveats and IdentityClient.DeclaredIdentity.
+const (
+	DeclaredUsername = "username"
+	DeclaredDomain   = "domain"
+	DeclaredGroups   = "groups"
+)
+
+const (
+	// DefaultRefreshInterval holds the default interval
+	// at which the key set is refreshed.
+	DefaultRefreshInterval = time.Hour
+
+	// MinRefreshInterval holds the minimum interval between
+	// refreshes of the key set triggered by a token signed
+	// with an unknown key or by a failure to read the key set.
+	MinRefreshInterval = time.Minute
+
+	// DefaultLeeway holds the default allowance for clock
+	// skew when checking token validity times.
+	DefaultLeeway = time.Minute
+)
+
+// Params holds parameters for NewIdentityClient.
+type Params struct {
+	// Issuer holds the expected value of the "iss" claim of
+	// tokens. It must be specified.
+	Issuer string
+
+	// Audience holds the value that must be present in the
+	// "aud" claim of tokens, usually the OAuth client id of
+	// the service. It must be specified.
+	Audience string
+
+	// JWKSFile holds the path of a file holding the JSON Web Key
+	// Set used to verify tokens.
+	JWKSFile string
+
+	// JWKSURL holds the URL from which the JSON Web Key Set used
+	// to verify tokens is fetched. If both JWKSFile and JWKSURL are
+	// empty, the URL is found from the issuer's OpenID Connect
+	// discovery document at Issuer + "/.well-known/openid-configuration".
+	JWKSURL string
+
+	// HTTPClient is used to fetch the key set. If it is nil,
+	// http.DefaultClient will be used.
+	HTTPClient *http.Client
+
+	// RefreshInterval holds the interval after which the key set
+	// is read again. The key set is also read again, at most once
+	// every MinRefreshInterval, when a token is signed with an
+	// unknown key or when it could not be read the last time.
+	// If it is zero, DefaultRefreshInterval will be used.
+	RefreshInterval time.Duration
+
+	// Leeway holds the allowance for clock skew when checking the
+	// "exp" and "nbf" claims. If it is zero, DefaultLeeway will be
+	// used. If it is negative, no allowance is made.
+	Leeway time.Duration
+
+	// UsernameClaim holds the claim that holds the user's id.
+	// If it is empty, "sub" will be used.
+	UsernameClaim string
+
+	// DomainClaim holds the claim that holds the user's domain.
+	// If it is empty, identities will have no domain.
+	DomainClaim string
+
+	// GroupsClaim holds the claim that holds the groups that the
+	// user is a member of, as a string or an array of strings. If it
+	// is empty, "groups" will be used.
+	GroupsClaim string
+
+	// Caveats holds the caveats returned by IdentityFromContext
+	// when the request has no bearer token, usually third party
+	// caveats addressed to an identity provider. If it is empty,
+	// such requests have no identity.
+	Caveats []checkers.Caveat
+
+	// Clock is used to check token validity times. If it is nil,
+	// the system clock will be used.
+	Clock checkers.Clock
+
+	// Logger is used to log failures to refresh the key set
+	// and invalid keys in the key set. If it is nil, nothing
+	// will be logged.
+	Logger bakery.Logger
+}
+
+// IdentityClient implements identchecker.IdentityClient by verifying
+// JWT bearer tokens found in the Authorization header of the HTTP
+// request associated with the context (see httpbakery.ContextWithRequest).
+type IdentityClient struct {
+	p Params
+
+	// mu guards the fields below it.
+	mu        sync.Mutex
+	keys      []publicKey
+	fetchTime time.Time
+	jwksURL   string
+
+	// fetchErr holds the error from the most recent
+	// refresh, or nil if it succeeded.
+	fetchErr error
+
+	// refreshing holds the refresh in progress, if any.
+	refreshing *refreshCall
+}
+
+// refreshTimeout holds the maximum length of time that a refresh of
+// the key set can take. Refreshes are not bound to the context of the
+// request that started them, because other requests may be waiting
+// for the result.
+const refreshTimeout = time.Minute
+
+// refreshCall represents a refresh of the key set. Concurrent
+// requests for keys that need a refresh share a single call.
+type refreshCall struct {
+	// done is closed when the refresh has completed.
+	done chan struct{}
+	err  error
+}
+
+var _ identchecker.IdentityClient = (*IdentityClient)(nil)
+
+// NewIdentityClient returns a new IdentityClient using the given
+// parameters. If p.JWKSFile is specified, the key set is read
+// immediately; otherwise it is fetched when first needed.
+func NewIdentityClient(p Params) (*IdentityClient, error) {
+	if p.Issuer == "" {
+		return nil, errgo.Newf("no issuer specified")
+	}
+	if p.Audience == "" {
+		return nil, errgo.Newf("no audience specified")
+	}
+	if p.JWKSFile != "" && p.JWKSURL != "" {
+		return nil, errgo.Newf("both key set file and URL specified")
+	}
+	if p.HTTPClient == nil {
+		p.HTTPClient = http.DefaultClient
+	}
+	if p.RefreshInterval == 0 {
+		p.RefreshInterval = DefaultRefreshInterval
+	}
+	if p.Leeway == 0 {
+		p.Leeway = DefaultLeeway
+	} else if p.Leeway < 0 {
+		p.Leeway = 0
+	}
+	if p.UsernameClaim == "" {
+		p.UsernameClaim = "sub"
+	}
+	if p.GroupsClaim == "" {
+		p.GroupsClaim = "groups"
+	}
+	c := &IdentityClient{
+		p:       p,
+		jwksURL: p.JWKSURL,
+	}
+	if p.JWKSFile != "" {
+		c.fetchTime = time.Now()
+		if err := c.refresh(context.Background()); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return c, nil
+}
+
+// IdentityFromContext implements identchecker.IdentityClient.IdentityFromContext.
+// If the request has no bearer token, it returns the caveats specified
+// in the parameters. If the token is invalid, it returns an error with
+// an ErrInvalidToken cause.
+func (c *IdentityClient) IdentityFromContext(ctx context.Context) (identchecker.Identity, []checkers.Caveat, error) {
+	req := httpbakery.RequestFromContext(ctx)
+	if req == nil {
+		return nil, c.p.Caveats, nil
+	}
+	token := bearerToken(req)
+	if token == "" {
+		return nil, c.p.Caveats, nil
+	}
+	id, err := c.Verify(ctx, token)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrInvalidToken))
+	}
+	return id, nil, nil
+}
+
+// DeclaredIdentity implements identchecker.IdentityClient.DeclaredIdentity
+// by returning the identity declared with the DeclaredUsername,
+// DeclaredDomain and DeclaredGroups attributes, as added by the caveats
+// returned by Identity.Caveats.
+func (c *IdentityClient) DeclaredIdentity(ctx context.Context, declared map[string]string) (identchecker.Identity, error) {
+	username := declared[DeclaredUsername]
+	if username == "" {
+		return nil, errgo.Newf("no declared user name")
+	}
+	id := &Identity{
+		Username:   username,
+		DomainName: declared[DeclaredDomain],
+	}
+	if groups := declared[DeclaredGroups]; groups != "" {
+		id.Groups = strings.Split(groups, ",")
+	}
+	return id, nil
+}
+
+// Verify verifies the given token and returns the identity it holds.
+// If the token is invalid, it returns an error with an ErrInvalidToken
+// cause.
+func (c *IdentityClient) Verify(ctx context.Context, token string) (*Identity, error) {
+	t, err := parseJWT(token)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrInvalidToken, "invalid token")
+	}
+	if _, ok := algorithms[t.header.Alg]; !ok {
+		return nil, errgo.WithCausef(nil, ErrInvalidToken, "unsupported algorithm %q", t.header.Alg)
+	}
+	keys, err := c.keysFor(ctx, t.header.Kid)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get keys")
+	}
+	if err := verifyWithKeys(t, keys); err != nil {
+		return nil, errgo.WithCausef(err, ErrInvalidToken, "")
+	}
+	if err := c.checkClaims(t); err != nil {
+		return nil, errgo.WithCausef(err, ErrInvalidToken, "")
+	}
+	id, err := c.identity(t)
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrInvalidToken, "")
+	}
+	return id, nil
+}
+
+// verifyWithKeys verifies the signature of t with any of the given
+// keys that have the same key id.
+func verifyWithKeys(t *jwt, keys []publicKey) error {
+	var err error
+	for _, k := range keys {
+		if t.header.Kid != "" && k.kid != t.header.Kid {
+			continue
+		}
+		if err = t.verifySignature(k); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		return errgo.Newf("no key found for key id %q", t.header.Kid)
+	}
+	return errgo.Mask(err)
+}
+
+// checkClaims checks the registered claims of t.
+func (c *IdentityClient) checkClaims(t *jwt) error {
+	claims := t.registered
+	if claims.Issuer != c.p.Issuer {
+		return errgo.Newf("unexpected issuer %q", claims.Issuer)
+	}
+	if !claims.Audience.contains(c.p.Audience) {
+		return errgo.Newf("token not intended for audience %q", c.p.Audience)
+	}
+	now := c.now()
+	if claims.Expiry == nil {
+		return errgo.Newf("token has no expiry time")
+	}
+	if !now.Before(claims.Expiry.Add(c.p.Leeway)) {
+		return errgo.Newf("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(c.p.Leeway).Before(claims.NotBefore.Time) {
+		return errgo.Newf("token is not yet valid")
+	}
+	return nil
+}
+
+// identity returns the identity held in the claims of t.
+func (c *IdentityClient) identity(t *jwt) (*Identity, error) {
+	username, ok := t.claims[c.p.UsernameClaim].(string)
+	if !ok || username == "" {
+		return nil, errgo.Newf("no %q claim in token", c.p.UsernameClaim)
+	}
+	id := &Identity{
+		Username: username,
+		Claims:   t.claims,
+		Expiry:   t.registered.Expiry.Time,
+	}
+	if c.p.DomainClaim != "" {
+		domain, ok := t.claims[c.p.DomainClaim].(string)
+		if !ok {
+			return nil, errgo.Newf("no %q claim in token", c.p.DomainClaim)
+		}
+		id.DomainName = domain
+	}
+	switch groups := t.claims[c.p.GroupsClaim].(type) {
+	case nil:
+	case string:
+		id.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			g, ok := g.(string)
+			if !ok {
+				return nil, errgo.Newf("invalid %q claim in token", c.p.GroupsClaim)
+			}
+			id.Groups = append(id.Groups, g)
+		}
+	default:
+		return nil, errgo.Newf("invalid %q claim in token", c.p.GroupsClaim)
+	}
+	return id, nil
+}
+
+// keysFor returns the keys to use to verify a token signed
+// with the given key id, refreshing the key set if needed.
+func (c *IdentityClient) keysFor(ctx context.Context, kid string) ([]publicKey, error) {
+	c.mu.Lock()
+	call := c.refreshing
+	if call == nil {
+		since := time.Since(c.fetchTime)
+		if c.keys != nil && since < c.p.RefreshInterval && (since < MinRefreshInterval || hasKey(c.keys, kid)) {
+			keys := c.keys
+			c.mu.Unlock()
+			return keys, nil
+		}
+		if c.keys == nil && c.fetchErr != nil && since < MinRefreshInterval {
+			// The last attempt to read the key set failed
+			// recently, so don't try again yet.
+			err := c.fetchErr
+			c.mu.Unlock()
+			return nil, errgo.Mask(err)
+		}
+		// Start a new refresh. The key set is fetched without
+		// holding c.mu so that requests that do not need a
+		// refresh are not held up.
+		call = &refreshCall{
+			done: make(chan struct{}),
+		}
+		c.refreshing = call
+		c.fetchTime = time.Now()
+		go c.backgroundRefresh(bakery.RequestIDFromContext(ctx), call)
+	}
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, errgo.Mask(ctx.Err(), errgo.Any)
+	}
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+	if call.err != nil && keys == nil {
+		return nil, errgo.Mask(call.err)
+	}
+	return keys, nil
+}
+
+// backgroundRefresh performs the refresh for the given call, which has
+// been started by keysFor, and closes call.done when it is complete.
+// The request id of the request that started the refresh is used when
+// logging.
+func (c *IdentityClient) backgroundRefresh(requestID string, call *refreshCall) {
+	ctx := context.Background()
+	if requestID != "" {
+		ctx = bakery.ContextWithRequestID(ctx, requestID)
+	}
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	call.err = c.refresh(ctx)
+	if call.err != nil {
+		bakery.Log(ctx, c.p.Logger, bakery.LevelWarn, "cannot refresh key set", bakery.ErrorField(call.err))
+	}
+	c.mu.Lock()
+	c.fetchErr = call.err
+	c.refreshing = nil
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func hasKey(keys []publicKey, kid string) bool {
+	if kid == "" {
+		return true
+	}
+	for _, k := range keys {
+		if k.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh reads the key set again and, if successful, replaces the
+// current keys with it. If it fails, the previous keys continue to
+// be used.
+// It must be called without c.mu held.
+func (c *IdentityClient) refresh(ctx context.Context) error {
+	var data []byte
+	var err error
+	if c.p.JWKSFile != "" {
+		data, err = ioutil.ReadFile(c.p.JWKSFile)
+	} else {
+		data, err = c.fetchJWKS(ctx)
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	keys, invalid, err := parseJWKS(data)
+	for _, err := range invalid {
+		bakery.Log(ctx, c.p.Logger, bakery.LevelWarn, "ignoring key in key set", bakery.ErrorField(err))
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	return nil
+}
+
+// fetchJWKS fetches the key set, first discovering
+// its URL if necessary.
+func (c *IdentityClient) fetchJWKS(ctx context.Context) ([]byte, error) {
+	c.mu.Lock()
+	jwksURL := c.jwksURL
+	c.mu.Unlock()
+	if jwksURL == "" {
+		data, err := c.get(ctx, strings.TrimSuffix(c.p.Issuer, "/")+"/.well-known/openid-configuration")
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot get discovery document")
+		}
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal discovery document")
+		}
+		if doc.Issuer != c.p.Issuer {
+			return nil, errgo.Newf("discovery document has unexpected issuer %q", doc.Issuer)
+		}
+		if doc.JWKSURI == "" {
+			return nil, errgo.Newf("no jwks_uri in discovery document")
+		}
+		jwksURL = doc.JWKSURI
+		c.mu.Lock()
+		c.jwksURL = jwksURL
+		c.mu.Unlock()
+	}
+	data, err := c.get(ctx, jwksURL)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get key set")
+	}
+	return data, nil
+}
+
+func (c *IdentityClient) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp, err := c.p.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("GET %s: unexpected status %q", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+func (c *IdentityClient) now() time.Time {
+	if c.p.Clock != nil {
+		return c.p.Clock.Now()
+	}
+	return time.Now()
+}
+
+// bearerToken returns the bearer token in the request's
+// Authorization header, or the empty string if there is none.
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < len("bearer ") || !strings.EqualFold(auth[:len("bearer ")], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[len("bearer "):])
+}
+
+// Identity holds an identity authenticated with a token
+// or declared in a login macaroon.
+type Identity struct {
+	// Username holds the id of the user.
+	Username string
+
+	// DomainName holds the domain of the user.
+	DomainName string
+
+	// Groups holds the groups that the user is a member of.
+	Groups []string
+
+	// Claims holds all the claims in the token. It is nil
+	// for identities declared in a login macaroon.
+	Claims map[string]interface{}
+
+	// Expiry holds the expiry time of the token. It is zero
+	// for identities declared in a login macaroon.
+	Expiry time.Time
+}
+
+var _ identchecker.ACLIdentity = (*Identity)(nil)
+
+// Id implements identchecker.Identity.Id.
+func (id *Identity) Id() string {
+	return id.Username
+}
+
+// Domain implements identchecker.Identity.Domain.
+func (id *Identity) Domain() string {
+	return id.DomainName
+}
+
+// Allow implements identchecker.ACLIdentity.Allow by allowing the
+// identity access to ACLs that contain its user name, the group
+// "everyone" or any of its groups.
+func (id *Identity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, g := range acl {
+		if g == id.Username || g == identchecker.Everyone {
+			return true, nil
+		}
+		for _, g1 := range id.Groups {
+			if g == g1 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Caveats returns first party caveats that declare the identity, for
+// adding to a login macaroon. IdentityClient.DeclaredIdentity recovers
+// the identity from them. If the identity has an expiry time, a
+// time-before caveat ensures that the macaroon expires no later than
+// the token it was created from. For example:
+//
+//	m, err := oven.NewMacaroon(ctx, bakery.LatestVersion, id.Caveats(), identchecker.LoginOp)
+//
+// Group names containing commas cannot be declared and are omitted.
+func (id *Identity) Caveats() []checkers.Caveat {
+	caveats := []checkers.Caveat{
+		checkers.DeclaredCaveat(DeclaredUsername, id.Username),
+	}
+	if id.DomainName != "" {
+		caveats = append(caveats, checkers.DeclaredCaveat(DeclaredDomain, id.DomainName))
+	}
+	groups := make([]string, 0, len(id.Groups))
+	for _, g := range id.Groups {
+		if g != "" && !strings.Contains(g, ",") {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) > 0 {
+		caveats = append(caveats, checkers.DeclaredCaveat(DeclaredGroups, strings.Join(groups, ",")))
+	}
+	if !id.Expiry.IsZero() {
+		caveats = append(caveats, checkers.TimeBeforeCaveat(id.Expiry))
+	}
+	return caveats
+}
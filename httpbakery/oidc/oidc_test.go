@@ -0,0 +1,686 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/oidc"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "test-client"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type stoppedClock struct {
+	t time.Time
+}
+
+func (c stoppedClock) Now() time.Time {
+	return c.t
+}
+
+func TestVerify(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	client := newClientWithFile(c, keys, oidc.Params{
+		DomainClaim: "domain",
+	})
+	for _, alg := range []string{"RS256", "PS256", "ES256", "EdDSA"} {
+		c.Run(alg, func(c *qt.C) {
+			token := keys.sign(c, alg, map[string]interface{}{
+				"groups": []string{"editors", "readers"},
+				"domain": "example.com",
+				"email":  "bob@example.com",
+			})
+			id, err := client.Verify(context.Background(), token)
+			c.Assert(err, qt.IsNil)
+			c.Assert(id.Id(), qt.Equals, "bob")
+			c.Assert(id.Domain(), qt.Equals, "example.com")
+			c.Assert(id.Groups, qt.DeepEquals, []string{"editors", "readers"})
+			c.Assert(id.Claims["email"], qt.Equals, "bob@example.com")
+			c.Assert(id.Expiry.Equal(epoch.Add(time.Hour)), qt.Equals, true)
+			ok, err := id.Allow(context.Background(), []string{"readers"})
+			c.Assert(err, qt.IsNil)
+			c.Assert(ok, qt.Equals, true)
+		})
+	}
+}
+
+var verifyErrorTests = []struct {
+	about       string
+	alg         string
+	claims      map[string]interface{}
+	token       func(keys *testKeys, c *qt.C) string
+	expectError string
+}{{
+	about:       "malformed token",
+	token:       func(*testKeys, *qt.C) string { return "xxx" },
+	expectError: `invalid token: token does not have three parts`,
+}, {
+	about: "unsigned token",
+	token: func(keys *testKeys, c *qt.C) string {
+		return encodeSegment(c, map[string]string{"alg": "none"}) + "." + encodeSegment(c, keys.claims(nil)) + "."
+	},
+	expectError: `unsupported algorithm "none"`,
+}, {
+	about: "bad signature",
+	token: func(keys *testKeys, c *qt.C) string {
+		return keys.sign(c, "RS256", nil) + "AAAA"
+	},
+	expectError: `invalid signature`,
+}, {
+	about: "wrong algorithm for key",
+	token: func(keys *testKeys, c *qt.C) string {
+		return keys.signWithKey(c, "ES256", "rsa", nil)
+	},
+	expectError: `key "rsa" cannot be used with algorithm "ES256"`,
+}, {
+	about: "unknown key",
+	token: func(keys *testKeys, c *qt.C) string {
+		return keys.signWithKey(c, "RS256", "other", nil)
+	},
+	expectError: `no key found for key id "other"`,
+}, {
+	about:       "wrong issuer",
+	alg:         "RS256",
+	claims:      map[string]interface{}{"iss": "https://other.example.com"},
+	expectError: `unexpected issuer "https://other.example.com"`,
+}, {
+	about:       "wrong audience",
+	alg:         "RS256",
+	claims:      map[string]interface{}{"aud": []string{"a", "b"}},
+	expectError: `token not intended for audience "test-client"`,
+}, {
+	about:       "expired",
+	alg:         "ES256",
+	claims:      map[string]interface{}{"exp": epoch.Add(-2 * time.Minute).Unix()},
+	expectError: `token has expired`,
+}, {
+	about:       "no expiry",
+	alg:         "ES256",
+	claims:      map[string]interface{}{"exp": nil},
+	expectError: `token has no expiry time`,
+}, {
+	about:       "not yet valid",
+	alg:         "EdDSA",
+	claims:      map[string]interface{}{"nbf": epoch.Add(2 * time.Minute).Unix()},
+	expectError: `token is not yet valid`,
+}, {
+	about:       "no subject",
+	alg:         "EdDSA",
+	claims:      map[string]interface{}{"sub": nil},
+	expectError: `no "sub" claim in token`,
+}, {
+	about:       "invalid groups",
+	alg:         "EdDSA",
+	claims:      map[string]interface{}{"groups": []int{1}},
+	expectError: `invalid "groups" claim in token`,
+}}
+
+func TestVerifyError(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	client := newClientWithFile(c, keys, oidc.Params{})
+	for _, test := range verifyErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			var token string
+			if test.token != nil {
+				token = test.token(keys, c)
+			} else {
+				token = keys.sign(c, test.alg, test.claims)
+			}
+			id, err := client.Verify(context.Background(), token)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), qt.Equals, oidc.ErrInvalidToken)
+			c.Assert(id, qt.IsNil)
+		})
+	}
+}
+
+func TestLeeway(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	token := keys.sign(c, "RS256", map[string]interface{}{
+		"exp": epoch.Add(-30 * time.Second).Unix(),
+	})
+	client := newClientWithFile(c, keys, oidc.Params{})
+	_, err := client.Verify(context.Background(), token)
+	c.Assert(err, qt.IsNil)
+
+	client = newClientWithFile(c, keys, oidc.Params{
+		Leeway: -1,
+	})
+	_, err = client.Verify(context.Background(), token)
+	c.Assert(err, qt.ErrorMatches, `token has expired`)
+}
+
+func TestKeysFromDiscovery(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	var issuer string
+	fetches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		w.Write(keys.jwks(c))
+	})
+	srv := httptest.NewServer(mux)
+	c.Cleanup(srv.Close)
+	issuer = srv.URL
+
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   issuer,
+		Audience: testAudience,
+		Clock:    stoppedClock{epoch},
+	})
+	c.Assert(err, qt.IsNil)
+	for i := 0; i < 2; i++ {
+		id, err := client.Verify(context.Background(), keys.sign(c, "RS256", map[string]interface{}{
+			"iss": issuer,
+		}))
+		c.Assert(err, qt.IsNil)
+		c.Assert(id.Id(), qt.Equals, "bob")
+	}
+	c.Assert(fetches, qt.Equals, 1)
+}
+
+func TestKeysFromURLError(t *testing.T) {
+	c := qt.New(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	c.Cleanup(srv.Close)
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSURL:  srv.URL + "/keys",
+	})
+	c.Assert(err, qt.IsNil)
+	keys := newTestKeys(c)
+	_, err = client.Verify(context.Background(), keys.sign(c, "RS256", nil))
+	c.Assert(err, qt.ErrorMatches, `cannot get keys: cannot get key set: GET .*/keys: unexpected status "404 Not Found"`)
+	c.Assert(errgo.Cause(err), qt.Not(qt.Equals), oidc.ErrInvalidToken)
+}
+
+func TestFailedRefreshNotRetried(t *testing.T) {
+	c := qt.New(t)
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		http.NotFound(w, req)
+	}))
+	c.Cleanup(srv.Close)
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSURL:  srv.URL + "/keys",
+	})
+	c.Assert(err, qt.IsNil)
+	keys := newTestKeys(c)
+	token := keys.sign(c, "RS256", nil)
+
+	// Requests within MinRefreshInterval of a failed fetch
+	// return its error without fetching the key set again.
+	for i := 0; i < 3; i++ {
+		_, err = client.Verify(context.Background(), token)
+		c.Assert(err, qt.ErrorMatches, `cannot get keys: cannot get key set: GET .*/keys: unexpected status "404 Not Found"`)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(fetches, qt.Equals, 1)
+}
+
+func TestInvalidKeysIgnored(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	path := filepath.Join(c.Mkdir(), "keys.json")
+	err := ioutil.WriteFile(path, keys.jwks(c, map[string]string{
+		"kty": "RSA",
+		"kid": "bad",
+		"n":   b64(keys.rsa.N.Bytes()),
+		"e":   b64([]byte{1}),
+	}), 0666)
+	c.Assert(err, qt.IsNil)
+	var logger logRecorder
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSFile: path,
+		Clock:    stoppedClock{epoch},
+		Logger:   &logger,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(logger.msgs, qt.DeepEquals, []string{
+		`ignoring key in key set: invalid key 4 ("bad"): invalid exponent`,
+	})
+	id, err := client.Verify(context.Background(), keys.sign(c, "RS256", nil))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Id(), qt.Equals, "bob")
+
+	// A key set in which every key is invalid is rejected.
+	err = ioutil.WriteFile(path, []byte(`{"keys":[{"kty":"RSA","kid":"bad"}]}`), 0666)
+	c.Assert(err, qt.IsNil)
+	_, err = oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSFile: path,
+	})
+	c.Assert(err, qt.ErrorMatches, `no valid keys in key set: invalid key 0 \("bad"\): invalid modulus: .*`)
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		w.Write(keys.jwks(c))
+	}))
+	c.Cleanup(srv.Close)
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSURL:  srv.URL,
+		Clock:    stoppedClock{epoch},
+	})
+	c.Assert(err, qt.IsNil)
+	token := keys.sign(c, "RS256", nil)
+
+	var wg sync.WaitGroup
+	verify := func() {
+		defer wg.Done()
+		id, err := client.Verify(context.Background(), token)
+		c.Check(err, qt.IsNil)
+		c.Check(id.Id(), qt.Equals, "bob")
+	}
+	wg.Add(1)
+	go verify()
+	<-started
+
+	// While the key set is being fetched, a request whose
+	// context is cancelled returns immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Verify(ctx, token)
+	c.Assert(err, qt.ErrorMatches, `cannot get keys: context canceled`)
+
+	// Other requests share the fetch in progress.
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go verify()
+	}
+	close(release)
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(fetches, qt.Equals, 1)
+}
+
+func TestRefreshNotCancelledWithCaller(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		w.Write(keys.jwks(c))
+	}))
+	c.Cleanup(srv.Close)
+	client, err := oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSURL:  srv.URL,
+		Clock:    stoppedClock{epoch},
+	})
+	c.Assert(err, qt.IsNil)
+	token := keys.sign(c, "RS256", nil)
+
+	// The request that starts the fetch gives up while
+	// it is in progress.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := client.Verify(ctx, token)
+		done <- err
+	}()
+	<-started
+	cancel()
+	c.Assert(<-done, qt.ErrorMatches, `cannot get keys: context canceled`)
+
+	// The fetch continues, and a later request uses its result.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	id, err := client.Verify(context.Background(), token)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Id(), qt.Equals, "bob")
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(fetches, qt.Equals, 1)
+}
+
+func TestNewIdentityClientError(t *testing.T) {
+	c := qt.New(t)
+	_, err := oidc.NewIdentityClient(oidc.Params{
+		Audience: testAudience,
+	})
+	c.Assert(err, qt.ErrorMatches, `no issuer specified`)
+	_, err = oidc.NewIdentityClient(oidc.Params{
+		Issuer: testIssuer,
+	})
+	c.Assert(err, qt.ErrorMatches, `no audience specified`)
+	_, err = oidc.NewIdentityClient(oidc.Params{
+		Issuer:   testIssuer,
+		Audience: testAudience,
+		JWKSFile: filepath.Join(c.Mkdir(), "keys.json"),
+	})
+	c.Assert(err, qt.ErrorMatches, `open .*: no such file or directory`)
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	caveats := []checkers.Caveat{{
+		Location:  "https://idp.example.com",
+		Condition: "is-authenticated-user",
+	}}
+	client := newClientWithFile(c, keys, oidc.Params{
+		Caveats: caveats,
+	})
+
+	// No request.
+	id, cavs, err := client.IdentityFromContext(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.IsNil)
+	c.Assert(cavs, qt.DeepEquals, caveats)
+
+	// No bearer token.
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("bob", "pass")
+	id, cavs, err = client.IdentityFromContext(httpbakery.ContextWithRequest(context.Background(), req))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.IsNil)
+	c.Assert(cavs, qt.DeepEquals, caveats)
+
+	req.Header.Set("Authorization", "Bearer "+keys.sign(c, "RS256", nil))
+	id, cavs, err = client.IdentityFromContext(httpbakery.ContextWithRequest(context.Background(), req))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Id(), qt.Equals, "bob")
+	c.Assert(cavs, qt.HasLen, 0)
+
+	req.Header.Set("Authorization", "Bearer xxx")
+	id, _, err = client.IdentityFromContext(httpbakery.ContextWithRequest(context.Background(), req))
+	c.Assert(err, qt.ErrorMatches, `invalid token: .*`)
+	c.Assert(errgo.Cause(err), qt.Equals, oidc.ErrInvalidToken)
+	c.Assert(id, qt.IsNil)
+}
+
+func TestLoginMacaroonFromToken(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	client := newClientWithFile(c, keys, oidc.Params{})
+	b := identchecker.NewBakery(identchecker.BakeryParams{
+		Location:       "service",
+		IdentityClient: client,
+		Authorizer: identchecker.ACLAuthorizer{
+			GetACL: func(ctx context.Context, op bakery.Op) ([]string, bool, error) {
+				return []string{"editors"}, false, nil
+			},
+		},
+	})
+	ctx := checkers.ContextWithClock(context.Background(), stoppedClock{epoch})
+	op := bakery.Op{"doc", "write"}
+
+	// Authorize with the bearer token.
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+keys.sign(c, "ES256", map[string]interface{}{
+		"groups": "editors",
+	}))
+	authInfo, err := b.Checker.Auth().Allow(httpbakery.ContextWithRequest(ctx, req), identchecker.LoginOp, op)
+	c.Assert(err, qt.IsNil)
+	id := authInfo.Identity.(*oidc.Identity)
+	c.Assert(id.Id(), qt.Equals, "bob")
+
+	// Mint a login macaroon from the identity and use it
+	// without the token.
+	m, err := b.Oven.NewMacaroon(ctx, bakery.LatestVersion, id.Caveats(), identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	authInfo, err = b.Checker.Auth(macaroon.Slice{m.M()}).Allow(ctx, op)
+	c.Assert(err, qt.IsNil)
+	c.Assert(authInfo.Identity, qt.DeepEquals, &oidc.Identity{
+		Username: "bob",
+		Groups:   []string{"editors"},
+	})
+
+	// The macaroon expires with the token.
+	ctx = checkers.ContextWithClock(context.Background(), stoppedClock{epoch.Add(2 * time.Hour)})
+	_, err = b.Checker.Auth(macaroon.Slice{m.M()}).Allow(ctx, op)
+	c.Assert(err, qt.ErrorMatches, `.*macaroon has expired`)
+}
+
+func TestDeclaredIdentityError(t *testing.T) {
+	c := qt.New(t)
+	keys := newTestKeys(c)
+	client := newClientWithFile(c, keys, oidc.Params{})
+	_, err := client.DeclaredIdentity(context.Background(), map[string]string{"x": "y"})
+	c.Assert(err, qt.ErrorMatches, `no declared user name`)
+}
+
+func newClientWithFile(c *qt.C, keys *testKeys, p oidc.Params) *oidc.IdentityClient {
+	path := filepath.Join(c.Mkdir(), "keys.json")
+	err := ioutil.WriteFile(path, keys.jwks(c), 0666)
+	c.Assert(err, qt.IsNil)
+	p.Issuer = testIssuer
+	p.Audience = testAudience
+	p.JWKSFile = path
+	if p.Clock == nil {
+		p.Clock = stoppedClock{epoch}
+	}
+	client, err := oidc.NewIdentityClient(p)
+	c.Assert(err, qt.IsNil)
+	return client
+}
+
+// testKeys holds a signing key for each kind of key
+// supported by the oidc package.
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(c *qt.C) *testKeys {
+	var keys testKeys
+	var err error
+	keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, qt.IsNil)
+	keys.ecdsa, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+	_, keys.ed25519, err = ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, qt.IsNil)
+	return &keys
+}
+
+// jwks returns the public keys as a JSON Web Key Set,
+// followed by any extra keys.
+func (keys *testKeys) jwks(c *qt.C, extra ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": append([]map[string]string{{
+			"kty": "RSA",
+			"kid": "rsa",
+			"use": "sig",
+			"n":   b64(keys.rsa.N.Bytes()),
+			"e":   b64(big.NewInt(int64(keys.rsa.E)).Bytes()),
+		}, {
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   b64(keys.ecdsa.X.Bytes()),
+			"y":   b64(keys.ecdsa.Y.Bytes()),
+		}, {
+			"kty": "OKP",
+			"kid": "ed",
+			"crv": "Ed25519",
+			"x":   b64(keys.ed25519.Public().(ed25519.PublicKey)),
+		}, {
+			// Encryption keys are ignored.
+			"kty": "RSA",
+			"kid": "enc",
+			"use": "enc",
+		}}, extra...),
+	})
+	c.Assert(err, qt.IsNil)
+	return data
+}
+
+// claims returns a valid set of claims with the given
+// claims added. Claims with a nil value are removed.
+func (keys *testKeys) claims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "bob",
+		"iat": epoch.Unix(),
+		"exp": epoch.Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+var kidForAlg = map[string]string{
+	"RS256": "rsa",
+	"PS256": "rsa",
+	"ES256": "ec",
+	"EdDSA": "ed",
+}
+
+// sign returns a token holding the given claims, in addition
+// to the default claims, signed with the given algorithm.
+func (keys *testKeys) sign(c *qt.C, alg string, claims map[string]interface{}) string {
+	return keys.signWithKey(c, alg, kidForAlg[alg], claims)
+}
+
+// signWithKey is like sign except that it specifies the key id in the
+// token header independently of the algorithm.
+func (keys *testKeys) signWithKey(c *qt.C, alg, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(c, map[string]string{
+		"alg": alg,
+		"kid": kid,
+		"typ": "JWT",
+	}) + "." + encodeSegment(c, keys.claims(claims))
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, keys.rsa, crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, keys.rsa, crypto.SHA256, digest[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+		})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, keys.ecdsa, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "EdDSA":
+		sig = ed25519.Sign(keys.ed25519, []byte(signed))
+	default:
+		c.Fatalf("unknown algorithm %q", alg)
+	}
+	c.Assert(err, qt.IsNil)
+	return signed + "." + b64(sig)
+}
+
+func encodeSegment(c *qt.C, v interface{}) string {
+	data, err := json.Marshal(v)
+	c.Assert(err, qt.IsNil)
+	return b64(data)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// logRecorder is a bakery.StructuredLogger that
+// records the messages logged to it.
+type logRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *logRecorder) Debugf(ctx context.Context, f string, args ...interface{}) {}
+
+func (l *logRecorder) Infof(ctx context.Context, f string, args ...interface{}) {}
+
+func (l *logRecorder) Log(ctx context.Context, level bakery.LogLevel, msg string, fields ...bakery.LogField) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, f := range fields {
+		if f.Key == "error" {
+			msg += ": " + f.Value.(string)
+		}
+	}
+	l.msgs = append(l.msgs, msg)
+}
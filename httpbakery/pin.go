@@ -0,0 +1,253 @@
+package httpbakery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// KeyMismatchError is returned by ThirdPartyLocator when a discharger
+// returns a public key that does not match any of the keys pinned for
+// its location. This may indicate that the discharger has been
+// compromised or misconfigured, so the information is not used.
+type KeyMismatchError struct {
+	// Location holds the location of the discharger.
+	Location string
+
+	// Pinned holds the keys pinned for the location.
+	Pinned []bakery.PublicKey
+
+	// PublicKey holds the key returned by the discharger.
+	PublicKey bakery.PublicKey
+}
+
+// Error implements the error interface.
+func (e *KeyMismatchError) Error() string {
+	return fmt.Sprintf("public key %s of discharger at %q does not match any pinned key", e.PublicKey, e.Location)
+}
+
+// PinStore stores the public keys that are trusted for
+// discharge locations.
+type PinStore interface {
+	// PinnedKeys returns the keys pinned for the given location.
+	// It returns no keys and no error if no keys are pinned.
+	PinnedKeys(ctx context.Context, loc string) ([]bakery.PublicKey, error)
+
+	// AddPinnedKeys adds the given keys to those
+	// pinned for the given location.
+	AddPinnedKeys(ctx context.Context, loc string, keys []bakery.PublicKey) error
+
+	// PinIfUnpinned pins the given keys for the given location
+	// if no keys are pinned for it, and returns the keys pinned
+	// for the location after the call. The check and the update
+	// must happen atomically, so that concurrent calls cannot
+	// pin different keys.
+	PinIfUnpinned(ctx context.Context, loc string, keys []bakery.PublicKey) ([]bakery.PublicKey, error)
+}
+
+// FilePinStore is a PinStore that holds pinned keys in a JSON file.
+// The file holds an object mapping each location to a list of
+// base64-encoded public keys, for example:
+//
+//	{
+//		"https://discharger.example.com": [
+//			"r2EP9VmrNS6D0X1ny5DX7KrUj0SRsAnZ0cNxw6nqFQ0="
+//		]
+//	}
+//
+// A trailing slash on a location is ignored.
+type FilePinStore struct {
+	path string
+
+	mu   sync.Mutex
+	pins map[string][]bakery.PublicKey
+}
+
+var _ PinStore = (*FilePinStore)(nil)
+
+// NewFilePinStore returns a PinStore that reads pins from and writes
+// pins to the file with the given path. The file need not exist;
+// it is created when a key is first added.
+func NewFilePinStore(path string) (*FilePinStore, error) {
+	pins, err := ReadPinFile(path)
+	if err != nil && !os.IsNotExist(errgo.Cause(err)) {
+		return nil, errgo.Mask(err)
+	}
+	if pins == nil {
+		pins = make(map[string][]bakery.PublicKey)
+	}
+	return &FilePinStore{
+		path: path,
+		pins: pins,
+	}, nil
+}
+
+// ReadPinFile reads the keys pinned in the given file. See FilePinStore
+// for the file format.
+func ReadPinFile(path string) (map[string][]bakery.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errgo.Mask(err, os.IsNotExist)
+	}
+	var filePins map[string][]bakery.PublicKey
+	if err := json.Unmarshal(data, &filePins); err != nil {
+		return nil, errgo.Notef(err, "cannot parse pin file %q", path)
+	}
+	pins := make(map[string][]bakery.PublicKey)
+	for loc, keys := range filePins {
+		loc = canonicalPinLocation(loc)
+		pins[loc] = addKeys(pins[loc], keys)
+	}
+	return pins, nil
+}
+
+// PinnedKeys implements PinStore.PinnedKeys.
+func (s *FilePinStore) PinnedKeys(ctx context.Context, loc string) ([]bakery.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.pins[canonicalPinLocation(loc)]
+	return append([]bakery.PublicKey(nil), keys...), nil
+}
+
+// AddPinnedKeys implements PinStore.AddPinnedKeys
+// by adding the keys and rewriting the file.
+func (s *FilePinStore) AddPinnedKeys(ctx context.Context, loc string, keys []bakery.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc = canonicalPinLocation(loc)
+	oldKeys := s.pins[loc]
+	newKeys := addKeys(append([]bakery.PublicKey(nil), oldKeys...), keys)
+	if len(newKeys) == len(oldKeys) {
+		return nil
+	}
+	s.pins[loc] = newKeys
+	if err := s.write(); err != nil {
+		s.pins[loc] = oldKeys
+		return errgo.Notef(err, "cannot write pin file")
+	}
+	return nil
+}
+
+// PinIfUnpinned implements PinStore.PinIfUnpinned.
+func (s *FilePinStore) PinIfUnpinned(ctx context.Context, loc string, keys []bakery.PublicKey) ([]bakery.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc = canonicalPinLocation(loc)
+	if oldKeys := s.pins[loc]; len(oldKeys) > 0 {
+		return append([]bakery.PublicKey(nil), oldKeys...), nil
+	}
+	newKeys := addKeys(nil, keys)
+	s.pins[loc] = newKeys
+	if err := s.write(); err != nil {
+		delete(s.pins, loc)
+		return nil, errgo.Notef(err, "cannot write pin file")
+	}
+	return append([]bakery.PublicKey(nil), newKeys...), nil
+}
+
+// write atomically replaces the pin file
+// with the current pins.
+func (s *FilePinStore) write() error {
+	data, err := json.MarshalIndent(s.pins, "", "\t")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".bakery-pins")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Chmod(0644); err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := f.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// addKeys returns keys with any of newKeys that
+// it does not already contain appended.
+func addKeys(keys, newKeys []bakery.PublicKey) []bakery.PublicKey {
+	for _, k := range newKeys {
+		if !containsKey(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func containsKey(keys []bakery.PublicKey, key bakery.PublicKey) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalPinLocation(loc string) string {
+	return strings.TrimSuffix(loc, "/")
+}
+
+// checkPins checks the key in the given information fetched from loc
+// against the keys pinned for loc, pinning keys when trust on first use
+// is enabled and no keys are pinned yet.
+//
+// Upcoming keys are pinned only on first use. Public keys are not
+// secret, so a response that advertises an upcoming key proves
+// nothing about that key even when its primary key is pinned;
+// trusting it would let anyone who can serve a response for the
+// location replace the pinned key. Once keys are pinned, further keys
+// must be added explicitly with PinStore.AddPinnedKeys.
+func (kr *ThirdPartyLocator) checkPins(ctx context.Context, loc string, dinfo *dischargerInfo) error {
+	if kr.pins == nil {
+		return nil
+	}
+	pinned, err := kr.pins.PinnedKeys(ctx, loc)
+	if err != nil {
+		return errgo.Notef(err, "cannot get pinned keys")
+	}
+	if len(pinned) == 0 && kr.trustOnFirstUse {
+		keys := append([]bakery.PublicKey{dinfo.info.PublicKey}, dinfo.upcoming...)
+		// Another fetch may have pinned keys since we looked,
+		// in which case they are returned and checked below.
+		pinned, err = kr.pins.PinIfUnpinned(ctx, loc, keys)
+		if err != nil {
+			return errgo.Notef(err, "cannot pin keys")
+		}
+	}
+	if len(pinned) > 0 && !containsKey(pinned, dinfo.info.PublicKey) {
+		merr := &KeyMismatchError{
+			Location:  loc,
+			Pinned:    pinned,
+			PublicKey: dinfo.info.PublicKey,
+		}
+		if kr.onKeyMismatch != nil {
+			kr.onKeyMismatch(ctx, merr)
+		}
+		return merr
+	}
+	return nil
+}
+
+func isKeyMismatchError(err error) bool {
+	_, ok := err.(*KeyMismatchError)
+	return ok
+}
@@ -0,0 +1,256 @@
+package httpbakery_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func TestPinnedKeys(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	otherKey := bakery.MustGenerateKey()
+	srv := newInfoServer(key)
+	defer srv.Close()
+
+	pinFile := filepath.Join(c.TempDir(), "pins.json")
+	err := ioutil.WriteFile(pinFile, []byte(`{"`+srv.URL+`/": ["`+key.Public.String()+`"]}`), 0644)
+	c.Assert(err, qt.IsNil)
+	pins, err := httpbakery.NewFilePinStore(pinFile)
+	c.Assert(err, qt.IsNil)
+
+	var mismatches []*httpbakery.KeyMismatchError
+	kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+		Pins: pins,
+		OnKeyMismatch: func(ctx context.Context, err *httpbakery.KeyMismatchError) {
+			mismatches = append(mismatches, err)
+		},
+	})
+	kr.AllowInsecure()
+	info, err := kr.ThirdPartyInfo(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, key.Public)
+	c.Assert(mismatches, qt.HasLen, 0)
+
+	// When the discharger's key changes, the new key
+	// is rejected even though the old one is cached.
+	srv.setKeys(otherKey)
+	_, err = kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.ErrorMatches, `public key .* of discharger at ".*" does not match any pinned key`)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httpbakery.KeyMismatchError{
+		Location:  srv.URL,
+		Pinned:    []bakery.PublicKey{key.Public},
+		PublicKey: otherKey.Public,
+	})
+	c.Assert(mismatches, qt.DeepEquals, []*httpbakery.KeyMismatchError{errgo.Cause(err).(*httpbakery.KeyMismatchError)})
+
+	// The mismatch is not treated as a transient failure,
+	// so stale information is not used.
+	kr = httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+		Pins: pins,
+	})
+	kr.AllowInsecure()
+	_, err = kr.ThirdPartyInfo(testContext, srv.URL)
+	_, ok := errgo.Cause(err).(*httpbakery.KeyMismatchError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("error: %v", err))
+
+	// Locations without pinned keys are trusted as usual.
+	srv2 := newInfoServer(otherKey)
+	defer srv2.Close()
+	info, err = kr.ThirdPartyInfo(testContext, srv2.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, otherKey.Public)
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	nextKey := bakery.MustGenerateKey()
+	otherKey := bakery.MustGenerateKey()
+	srv := newInfoServer(key, nextKey)
+	defer srv.Close()
+
+	pinFile := filepath.Join(c.TempDir(), "pins.json")
+	newLocator := func() *httpbakery.ThirdPartyLocator {
+		pins, err := httpbakery.NewFilePinStore(pinFile)
+		c.Assert(err, qt.IsNil)
+		kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+			Pins:            pins,
+			TrustOnFirstUse: true,
+		})
+		kr.AllowInsecure()
+		return kr
+	}
+	info, err := newLocator().ThirdPartyInfo(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, key.Public)
+
+	// Both the primary and the upcoming key have been pinned.
+	pins, err := httpbakery.ReadPinFile(pinFile)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.DeepEquals, map[string][]bakery.PublicKey{
+		srv.URL: {key.Public, nextKey.Public},
+	})
+
+	// A rotation to the upcoming key is accepted by a new
+	// locator using the same pin file.
+	srv.setKeys(nextKey)
+	info, err = newLocator().ThirdPartyInfo(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(info.PublicKey, qt.Equals, nextKey.Public)
+
+	// An unexpected key is not.
+	srv.setKeys(otherKey)
+	_, err = newLocator().ThirdPartyInfo(testContext, srv.URL)
+	_, ok := errgo.Cause(err).(*httpbakery.KeyMismatchError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("error: %v", err))
+	pins, err = httpbakery.ReadPinFile(pinFile)
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.DeepEquals, map[string][]bakery.PublicKey{
+		srv.URL: {key.Public, nextKey.Public},
+	})
+}
+
+func TestTrustOnFirstUseDoesNotPinLaterUpcomingKeys(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	attackerKey := bakery.MustGenerateKey()
+	srv := newInfoServer(key)
+	defer srv.Close()
+
+	pinFile := filepath.Join(c.TempDir(), "pins.json")
+	pins, err := httpbakery.NewFilePinStore(pinFile)
+	c.Assert(err, qt.IsNil)
+	kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+		Pins:            pins,
+		TrustOnFirstUse: true,
+	})
+	kr.AllowInsecure()
+	_, err = kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+
+	// A response that replays the pinned primary key and
+	// advertises another key as upcoming is accepted, but the
+	// upcoming key is not pinned.
+	srv.setKeys(key, attackerKey)
+	_, err = kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	srv.setKeys(attackerKey)
+	_, err = kr.Refresh(testContext, srv.URL)
+	_, ok := errgo.Cause(err).(*httpbakery.KeyMismatchError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("error: %v", err))
+	keys, err := pins.PinnedKeys(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.DeepEquals, []bakery.PublicKey{key.Public})
+
+	// Keys can be added explicitly.
+	err = pins.AddPinnedKeys(testContext, srv.URL, []bakery.PublicKey{attackerKey.Public})
+	c.Assert(err, qt.IsNil)
+	_, err = kr.Refresh(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestTrustOnFirstUseConcurrent(t *testing.T) {
+	c := qt.New(t)
+	// The server returns a different key for every request.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"PublicKey": &bakery.MustGenerateKey().Public,
+			"Version":   bakery.LatestVersion,
+		})
+	}))
+	defer srv.Close()
+	pins, err := httpbakery.NewFilePinStore(filepath.Join(c.TempDir(), "pins.json"))
+	c.Assert(err, qt.IsNil)
+
+	const n = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		// Use a separate locator for each fetch so
+		// that the fetches are not shared.
+		kr := httpbakery.NewThirdPartyLocatorWithParams(httpbakery.ThirdPartyLocatorParams{
+			Pins:            pins,
+			TrustOnFirstUse: true,
+		})
+		kr.AllowInsecure()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := kr.Refresh(testContext, srv.URL)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+				return
+			}
+			_, ok := errgo.Cause(err).(*httpbakery.KeyMismatchError)
+			c.Check(ok, qt.Equals, true, qt.Commentf("error: %v", err))
+		}()
+	}
+	wg.Wait()
+	c.Assert(succeeded, qt.Equals, 1)
+	keys, err := pins.PinnedKeys(testContext, srv.URL)
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.HasLen, 1)
+}
+
+func TestReadPinFileError(t *testing.T) {
+	c := qt.New(t)
+	pinFile := filepath.Join(c.TempDir(), "pins.json")
+	err := ioutil.WriteFile(pinFile, []byte(`{"https://example.com": ["bad key"]}`), 0644)
+	c.Assert(err, qt.IsNil)
+	_, err = httpbakery.NewFilePinStore(pinFile)
+	c.Assert(err, qt.ErrorMatches, `cannot parse pin file ".*": .*`)
+}
+
+// infoServer is an HTTP server that serves discharger
+// information with keys that can be changed.
+type infoServer struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	keys []*bakery.KeyPair
+}
+
+// newInfoServer returns a new infoServer that reports the first of the
+// given keys as the primary key and the others as upcoming keys.
+func newInfoServer(keys ...*bakery.KeyPair) *infoServer {
+	srv := &infoServer{
+		keys: keys,
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.serveInfo))
+	return srv
+}
+
+func (srv *infoServer) setKeys(keys ...*bakery.KeyPair) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.keys = keys
+}
+
+func (srv *infoServer) serveInfo(w http.ResponseWriter, req *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var upcoming []*bakery.PublicKey
+	for _, k := range srv.keys[1:] {
+		upcoming = append(upcoming, &k.Public)
+	}
+	httprequest.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"PublicKey":          &srv.keys[0].Public,
+		"Version":            bakery.LatestVersion,
+		"UpcomingPublicKeys": upcoming,
+	})
+}
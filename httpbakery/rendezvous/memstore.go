@@ -0,0 +1,94 @@
+package rendezvous
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// MemStore is an in-memory implementation of Store. It is suitable
+// for dischargers that run as a single process.
+type MemStore struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// NewMemStore returns a new, empty, in-memory store.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		entries: make(map[string]*Entry),
+	}
+}
+
+// Insert implements Store.Insert.
+func (s *MemStore) Insert(ctx context.Context, e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, e := range s.entries {
+		if !now.Before(e.Expires) {
+			delete(s.entries, id)
+		}
+	}
+	if _, ok := s.entries[e.Id]; ok {
+		return errgo.Newf("duplicate rendezvous id %q", e.Id)
+	}
+	e1 := *e
+	s.entries[e.Id] = &e1
+	return nil
+}
+
+// Get implements Store.Get.
+func (s *MemStore) Get(ctx context.Context, id string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return nil, errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	}
+	e1 := *e
+	return &e1, nil
+}
+
+// Complete implements Store.Complete.
+func (s *MemStore) Complete(ctx context.Context, id string, result *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || e.Done {
+		return errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	}
+	e.Done = true
+	e.Caveats = result.Caveats
+	e.ErrorCode = result.ErrorCode
+	e.ErrorMessage = result.ErrorMessage
+	e.Token = result.Token
+	return nil
+}
+
+// CollectToken implements Store.CollectToken.
+func (s *MemStore) CollectToken(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || !e.Done || e.TokenCollected {
+		return errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	}
+	e.TokenCollected = true
+	return nil
+}
+
+// Remove implements Store.Remove.
+func (s *MemStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; !ok {
+		return errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	}
+	delete(s.entries, id)
+	return nil
+}
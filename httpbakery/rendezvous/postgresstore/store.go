@@ -0,0 +1,320 @@
+// Package postgresstore provides an implementation of rendezvous.Store
+// that uses Postgres as a persistent store, so that the rendezvous
+// state can be shared between several replicas of a discharger.
+package postgresstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/template"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/rendezvous"
+)
+
+type stmtId int
+
+const (
+	insertStmt stmtId = iota
+	getStmt
+	completeStmt
+	collectTokenStmt
+	removeStmt
+	numStmts
+)
+
+var initStatements = `
+BEGIN;
+
+-- See postgresrootkeystore for the rationale behind the advisory lock.
+SELECT pg_advisory_xact_lock(34577509138);
+
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+	id TEXT PRIMARY KEY NOT NULL,
+	caveat_id BYTEA,
+	caveat BYTEA,
+	condition TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	done BOOLEAN NOT NULL DEFAULT FALSE,
+	caveats TEXT,
+	error_code TEXT NOT NULL DEFAULT '',
+	error_message TEXT NOT NULL DEFAULT '',
+	token BYTEA,
+	token_collected BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE OR REPLACE FUNCTION {{.ExpireFunc}}() RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+	BEGIN
+		DELETE FROM {{.Table}} WHERE expires < NOW();
+		RETURN NEW;
+	END;
+$$;
+
+CREATE INDEX IF NOT EXISTS {{.ExpireIndex}} ON {{.Table}} (expires);
+
+DROP TRIGGER IF EXISTS {{.ExpireTrigger}} ON {{.Table}};
+
+CREATE TRIGGER {{.ExpireTrigger}}
+   BEFORE INSERT ON {{.Table}}
+   EXECUTE PROCEDURE {{.ExpireFunc}}();
+
+COMMIT;
+`
+
+type templateParams struct {
+	Table         string
+	ExpireFunc    string
+	ExpireIndex   string
+	ExpireTrigger string
+}
+
+// Store implements rendezvous.Store by storing entries
+// in a Postgres table.
+type Store struct {
+	db    *sql.DB
+	table string
+	stmts [numStmts]*sql.Stmt
+
+	// initDBOnce guards initDBErr.
+	initDBOnce sync.Once
+	initDBErr  error
+}
+
+var _ rendezvous.Store = (*Store)(nil)
+
+// New returns a store that uses the given table in the given Postgres
+// database. The table will be created lazily when the store is first
+// used. It also creates other SQL resources using the table name as a
+// prefix.
+//
+// The returned Store must be closed after use.
+func New(db *sql.DB, table string) *Store {
+	return &Store{
+		db:    db,
+		table: table,
+	}
+}
+
+// Close closes the Store. This must be called after using the store.
+func (s *Store) Close() error {
+	var retErr error
+	for _, stmt := range s.stmts {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return errgo.Mask(retErr)
+}
+
+// Insert implements rendezvous.Store.Insert.
+func (s *Store) Insert(ctx context.Context, e *rendezvous.Entry) error {
+	if err := s.initDB(); err != nil {
+		return errgo.Mask(err)
+	}
+	_, err := s.stmts[insertStmt].ExecContext(ctx, e.Id, e.CaveatId, e.Caveat, e.Condition, e.Expires)
+	return errgo.Mask(err)
+}
+
+// Get implements rendezvous.Store.Get.
+func (s *Store) Get(ctx context.Context, id string) (*rendezvous.Entry, error) {
+	if err := s.initDB(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var (
+		e         rendezvous.Entry
+		caveats   sql.NullString
+		errorCode string
+	)
+	err := s.stmts[getStmt].QueryRowContext(ctx, id).Scan(
+		&e.Id,
+		&e.CaveatId,
+		&e.Caveat,
+		&e.Condition,
+		&e.Expires,
+		&e.Done,
+		&caveats,
+		&errorCode,
+		&e.ErrorMessage,
+		&e.Token,
+		&e.TokenCollected,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	case err != nil:
+		return nil, errgo.Mask(err)
+	}
+	e.ErrorCode = httpbakery.ErrorCode(errorCode)
+	if caveats.Valid {
+		if err := json.Unmarshal([]byte(caveats.String), &e.Caveats); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal caveats")
+		}
+	}
+	return &e, nil
+}
+
+// Complete implements rendezvous.Store.Complete.
+func (s *Store) Complete(ctx context.Context, id string, result *rendezvous.Entry) error {
+	if err := s.initDB(); err != nil {
+		return errgo.Mask(err)
+	}
+	caveats, err := marshalCaveats(result.Caveats)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	r, err := s.stmts[completeStmt].ExecContext(ctx, id, caveats, string(result.ErrorCode), result.ErrorMessage, result.Token)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(checkUpdated(r, id), errgo.Is(bakery.ErrNotFound))
+}
+
+// CollectToken implements rendezvous.Store.CollectToken.
+func (s *Store) CollectToken(ctx context.Context, id string) error {
+	if err := s.initDB(); err != nil {
+		return errgo.Mask(err)
+	}
+	r, err := s.stmts[collectTokenStmt].ExecContext(ctx, id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(checkUpdated(r, id), errgo.Is(bakery.ErrNotFound))
+}
+
+// Remove implements rendezvous.Store.Remove.
+func (s *Store) Remove(ctx context.Context, id string) error {
+	if err := s.initDB(); err != nil {
+		return errgo.Mask(err)
+	}
+	r, err := s.stmts[removeStmt].ExecContext(ctx, id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(checkUpdated(r, id), errgo.Is(bakery.ErrNotFound))
+}
+
+func checkUpdated(r sql.Result, id string) error {
+	n, err := r.RowsAffected()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if n == 0 {
+		return errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q not found", id)
+	}
+	return nil
+}
+
+func marshalCaveats(caveats []checkers.Caveat) (sql.NullString, error) {
+	if caveats == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(caveats)
+	if err != nil {
+		return sql.NullString{}, errgo.Notef(err, "cannot marshal caveats")
+	}
+	return sql.NullString{
+		String: string(data),
+		Valid:  true,
+	}, nil
+}
+
+func (s *Store) initDB() error {
+	s.initDBOnce.Do(func() {
+		s.initDBErr = s._initDB()
+	})
+	if s.initDBErr != nil {
+		return errgo.Notef(s.initDBErr, "cannot initialize database")
+	}
+	return nil
+}
+
+func (s *Store) _initDB() error {
+	p := &templateParams{
+		Table:         s.table,
+		ExpireFunc:    s.table + "_expire_func",
+		ExpireIndex:   s.table + "_index_expire",
+		ExpireTrigger: s.table + "_trigger",
+	}
+	if _, err := s.db.Exec(templateVal(p, initStatements)); err != nil {
+		return errgo.Notef(err, "cannot initialize table")
+	}
+	if err := s.prepareAll(p); err != nil {
+		return errgo.Notef(err, "cannot prepare statements")
+	}
+	return nil
+}
+
+func (s *Store) prepareAll(p *templateParams) error {
+	stmts := []struct {
+		id   stmtId
+		tmpl string
+	}{{
+		id: insertStmt,
+		tmpl: `
+INSERT INTO {{.Table}} (id, caveat_id, caveat, condition, expires) VALUES ($1, $2, $3, $4, $5)
+`,
+	}, {
+		id: getStmt,
+		tmpl: `
+SELECT id, caveat_id, caveat, condition, expires, done, caveats, error_code, error_message, token, token_collected
+FROM {{.Table}} WHERE id=$1
+`,
+	}, {
+		id: completeStmt,
+		tmpl: `
+UPDATE {{.Table}}
+SET done=TRUE, caveats=$2, error_code=$3, error_message=$4, token=$5
+WHERE id=$1 AND NOT done
+`,
+	}, {
+		id: collectTokenStmt,
+		tmpl: `
+UPDATE {{.Table}} SET token_collected=TRUE WHERE id=$1 AND done AND NOT token_collected
+`,
+	}, {
+		id: removeStmt,
+		tmpl: `
+DELETE FROM {{.Table}} WHERE id=$1
+`,
+	}}
+	for _, stmt := range stmts {
+		if err := s.prepare(stmt.id, p, stmt.tmpl); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) prepare(id stmtId, p *templateParams, tmpl string) error {
+	if s.stmts[id] != nil {
+		panic(fmt.Sprintf("statement %v prepared twice", id))
+	}
+	stmt, err := s.db.Prepare(templateVal(p, tmpl))
+	if err != nil {
+		return errgo.Notef(err, "statement %v (%q) invalid", id, templateVal(p, tmpl))
+	}
+	s.stmts[id] = stmt
+	return nil
+}
+
+func templateVal(p *templateParams, s string) string {
+	tmpl := template.Must(template.New("").Parse(s))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		panic(errgo.Notef(err, "cannot create initialization statements"))
+	}
+	return buf.String()
+}
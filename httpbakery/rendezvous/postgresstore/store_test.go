@@ -0,0 +1,117 @@
+package postgresstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/postgrestest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/rendezvous"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/rendezvous/postgresstore"
+)
+
+const testTable = "testrendezvous"
+
+func newStore(c *qt.C) *postgresstore.Store {
+	db, err := postgrestest.New()
+	if err == postgrestest.ErrDisabled {
+		c.Skip("postgres testing is disabled")
+	}
+	c.Assert(err, qt.Equals, nil)
+	store := postgresstore.New(db.DB, testTable)
+	c.Defer(func() {
+		err := store.Close()
+		c.Check(err, qt.Equals, nil)
+		err = db.Close()
+		c.Check(err, qt.Equals, nil)
+	})
+	return store
+}
+
+func TestStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	ctx := context.Background()
+	store := newStore(c)
+
+	expires := time.Now().Add(time.Minute).Round(time.Millisecond)
+	err := store.Insert(ctx, &rendezvous.Entry{
+		Id:        "id0",
+		CaveatId:  []byte("caveat id"),
+		Caveat:    []byte("caveat"),
+		Condition: "something",
+		Expires:   expires,
+	})
+	c.Assert(err, qt.IsNil)
+
+	e, err := store.Get(ctx, "id0")
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.Expires.Equal(expires), qt.Equals, true)
+	e.Expires = time.Time{}
+	c.Assert(e, qt.DeepEquals, &rendezvous.Entry{
+		Id:        "id0",
+		CaveatId:  []byte("caveat id"),
+		Caveat:    []byte("caveat"),
+		Condition: "something",
+	})
+
+	err = store.CollectToken(ctx, "id0")
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+
+	err = store.Complete(ctx, "id0", &rendezvous.Entry{
+		Caveats: []checkers.Caveat{{Condition: "x"}},
+		Token:   []byte("token"),
+	})
+	c.Assert(err, qt.IsNil)
+	err = store.Complete(ctx, "id0", &rendezvous.Entry{})
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+
+	e, err = store.Get(ctx, "id0")
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.Done, qt.Equals, true)
+	c.Assert(e.Caveats, qt.DeepEquals, []checkers.Caveat{{Condition: "x"}})
+	c.Assert(e.Token, qt.DeepEquals, []byte("token"))
+
+	err = store.CollectToken(ctx, "id0")
+	c.Assert(err, qt.IsNil)
+	err = store.CollectToken(ctx, "id0")
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+
+	err = store.Remove(ctx, "id0")
+	c.Assert(err, qt.IsNil)
+	err = store.Remove(ctx, "id0")
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+	_, err = store.Get(ctx, "id0")
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+}
+
+func TestServiceWithStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	ctx := context.Background()
+	svc := rendezvous.New(rendezvous.Params{
+		Store:        newStore(c),
+		PollInterval: time.Millisecond,
+	})
+	cav := &bakery.ThirdPartyCaveatInfo{
+		Condition: []byte("something"),
+		Caveat:    []byte("caveat"),
+		Id:        []byte("caveat id"),
+	}
+	id, err := svc.NewDischarge(ctx, cav)
+	c.Assert(err, qt.IsNil)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		svc.DischargeComplete(ctx, id, []checkers.Caveat{{Condition: "x"}})
+	}()
+	token, err := svc.Wait(ctx, id)
+	c.Assert(err, qt.IsNil)
+	caveats, err := svc.CheckToken(ctx, token, cav)
+	c.Assert(err, qt.IsNil)
+	c.Assert(caveats, qt.DeepEquals, []checkers.Caveat{{Condition: "x"}})
+}
@@ -0,0 +1,441 @@
+// Package rendezvous provides a rendezvous service for dischargers that
+// use web-browser interaction (see httpbakery.WebBrowserInteractor).
+//
+// When a discharger returns an interaction-required error, it creates a
+// new rendezvous with Service.NewDischarge and points the client at
+// a visit URL of its own and at the wait-token URL served by the
+// service. When the user has completed the interaction in their web
+// browser, the discharger calls Service.DischargeComplete (or
+// Service.DischargeFailed) and the client's long-poll request to the
+// wait-token URL returns a discharge token. The discharger then checks
+// that token with Service.CheckToken when the client retries the
+// discharge.
+//
+// All state is held in a Store, so when a persistent store (see the
+// postgresstore subpackage) is shared between several replicas of a
+// discharger, the interaction may be completed by a different replica
+// from the one that serves the wait request.
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+// TokenKind holds the kind of the discharge tokens
+// issued by a Service.
+const TokenKind = "rendezvous"
+
+const (
+	// DefaultExpiry holds the default length of time for
+	// which a rendezvous remains valid.
+	DefaultExpiry = 15 * time.Minute
+
+	// DefaultPollInterval holds the default interval
+	// at which the store is polled by waiting requests.
+	DefaultPollInterval = time.Second
+)
+
+const (
+	// ErrWaitTimeout is the error code returned from the wait
+	// endpoint when the rendezvous has not completed in time.
+	ErrWaitTimeout = httpbakery.ErrorCode("timeout waiting for rendezvous")
+
+	// ErrTokenCollected is the error code returned from the wait
+	// endpoint when the discharge token has already been
+	// retrieved by an earlier wait request.
+	ErrTokenCollected = httpbakery.ErrorCode("discharge token already collected")
+)
+
+// Entry holds the state of a single rendezvous.
+type Entry struct {
+	// Id holds the identifier of the rendezvous.
+	Id string
+
+	// CaveatId holds the id of the third party caveat
+	// being discharged.
+	CaveatId []byte
+
+	// Caveat holds the encrypted third party caveat
+	// being discharged.
+	Caveat []byte
+
+	// Condition holds the condition of the third party
+	// caveat being discharged.
+	Condition string
+
+	// Expires holds the time at which the rendezvous expires.
+	Expires time.Time
+
+	// Done holds whether the discharge has completed,
+	// successfully or otherwise.
+	Done bool
+
+	// Caveats holds the caveats passed to DischargeComplete.
+	Caveats []checkers.Caveat
+
+	// ErrorCode and ErrorMessage hold the error
+	// passed to DischargeFailed.
+	ErrorCode    httpbakery.ErrorCode
+	ErrorMessage string
+
+	// Token holds the secret part of the discharge token
+	// issued when the discharge completed successfully.
+	Token []byte
+
+	// TokenCollected holds whether the discharge token
+	// has been returned from a wait request.
+	TokenCollected bool
+}
+
+// Store is the interface used by Service to hold rendezvous state.
+// Implementations must be safe to call concurrently.
+//
+// Methods that find an entry by id should return an error
+// with a bakery.ErrNotFound cause when there is no such
+// entry or when it is not in the required state.
+//
+// A store may remove entries after they have expired.
+type Store interface {
+	// Insert adds a new entry to the store.
+	Insert(ctx context.Context, e *Entry) error
+
+	// Get returns the entry with the given id.
+	Get(ctx context.Context, id string) (*Entry, error)
+
+	// Complete marks the entry with the given id as done and sets
+	// its Caveats, ErrorCode, ErrorMessage and Token fields from
+	// the given entry. It fails if the entry is already done.
+	Complete(ctx context.Context, id string, result *Entry) error
+
+	// CollectToken marks the token of the entry with the given id
+	// as collected. It fails if the entry is not done or the token
+	// has already been collected.
+	CollectToken(ctx context.Context, id string) error
+
+	// Remove removes the entry with the given id.
+	Remove(ctx context.Context, id string) error
+}
+
+// Params holds parameters for New.
+type Params struct {
+	// Store holds the store used for rendezvous state.
+	// If this is nil, a new MemStore will be used.
+	Store Store
+
+	// WaitTokenPath holds the URL path at which the wait-token
+	// endpoint is served. It should be an absolute path on the
+	// discharger's host. If it is empty, "/wait-token" will be used.
+	WaitTokenPath string
+
+	// Expiry holds the length of time for which a new rendezvous
+	// remains valid. If it is zero, DefaultExpiry will be used.
+	Expiry time.Duration
+
+	// WaitTimeout holds the maximum length of time that a request to
+	// the wait-token endpoint will block. If it is zero, a request
+	// blocks until the rendezvous completes or expires. Note that
+	// httpbakery.WebBrowserInteractor does not retry a wait request
+	// that times out, so a non-zero value should only be used with
+	// clients that do.
+	WaitTimeout time.Duration
+
+	// PollInterval holds the interval at which a waiting
+	// request polls the store. If it is zero,
+	// DefaultPollInterval will be used.
+	PollInterval time.Duration
+
+	// RequestIDHeader holds the name of the HTTP header, for
+	// example "X-Request-Id", that holds the id of each request.
+	// If it is non-empty, the id is attached to the request
+	// context with httpbakery.ContextWithRequestIDHeader.
+	RequestIDHeader string
+}
+
+// Service implements a rendezvous service.
+type Service struct {
+	p Params
+}
+
+// New returns a new rendezvous service that uses the given parameters.
+func New(p Params) *Service {
+	if p.Store == nil {
+		p.Store = NewMemStore()
+	}
+	if p.WaitTokenPath == "" {
+		p.WaitTokenPath = "/wait-token"
+	}
+	if p.Expiry == 0 {
+		p.Expiry = DefaultExpiry
+	}
+	if p.PollInterval == 0 {
+		p.PollInterval = DefaultPollInterval
+	}
+	return &Service{
+		p: p,
+	}
+}
+
+// NewDischarge creates a new rendezvous associated with the given
+// caveat information and returns its id. The id is suitable for
+// including in a visit URL; it does not allow the holder to
+// obtain the discharge token.
+func (s *Service) NewDischarge(ctx context.Context, cav *bakery.ThirdPartyCaveatInfo) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if err := s.p.Store.Insert(ctx, &Entry{
+		Id:        id,
+		CaveatId:  cav.Id,
+		Caveat:    cav.Caveat,
+		Condition: string(cav.Condition),
+		Expires:   time.Now().Add(s.p.Expiry),
+	}); err != nil {
+		return "", errgo.Notef(err, "cannot create rendezvous")
+	}
+	return id, nil
+}
+
+// Info returns the entry for the rendezvous with the given id.
+// If there is no such rendezvous, or it has expired, it returns
+// an error with a bakery.ErrNotFound cause.
+func (s *Service) Info(ctx context.Context, id string) (*Entry, error) {
+	e, err := s.p.Store.Get(ctx, id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(bakery.ErrNotFound))
+	}
+	if !time.Now().Before(e.Expires) {
+		return nil, errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q has expired", id)
+	}
+	return e, nil
+}
+
+// DischargeComplete marks the rendezvous with the given id as completed
+// with the given caveats, which will be returned from CheckToken.
+func (s *Service) DischargeComplete(ctx context.Context, id string, caveats []checkers.Caveat) error {
+	token, err := randomHex(24)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return s.complete(ctx, id, &Entry{
+		Caveats: caveats,
+		Token:   []byte(token),
+	})
+}
+
+// DischargeFailed marks the rendezvous with the given id as failed with
+// the given error, which will be returned to the waiting client.
+func (s *Service) DischargeFailed(ctx context.Context, id string, err error) error {
+	e := &Entry{
+		ErrorMessage: err.Error(),
+	}
+	if coder, ok := errgo.Cause(err).(interface {
+		ErrorCode() httpbakery.ErrorCode
+	}); ok {
+		e.ErrorCode = coder.ErrorCode()
+	}
+	return s.complete(ctx, id, e)
+}
+
+func (s *Service) complete(ctx context.Context, id string, result *Entry) error {
+	if _, err := s.Info(ctx, id); err != nil {
+		return errgo.Mask(err, errgo.Is(bakery.ErrNotFound))
+	}
+	if err := s.p.Store.Complete(ctx, id, result); err != nil {
+		if errgo.Cause(err) == bakery.ErrNotFound {
+			return errgo.WithCausef(nil, bakery.ErrNotFound, "rendezvous %q already completed", id)
+		}
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// WaitTokenURL returns the URL path that can be long-polled to
+// acquire the discharge token for the rendezvous with the given id.
+func (s *Service) WaitTokenURL(id string) string {
+	return s.p.WaitTokenPath + "?id=" + url.QueryEscape(id)
+}
+
+// SetInteraction adds web-browser interaction information for the
+// rendezvous with the given id to the given interaction-required
+// error. The visitURL parameter holds the URL of the discharger's
+// login page for the rendezvous.
+func (s *Service) SetInteraction(e *httpbakery.Error, id, visitURL string) {
+	httpbakery.SetWebBrowserInteraction(e, visitURL, s.WaitTokenURL(id))
+}
+
+// Wait waits for the rendezvous with the given id to complete and
+// returns the resulting discharge token. The token is returned
+// only once; subsequent calls return an error with an
+// ErrTokenCollected cause.
+//
+// If the rendezvous does not exist or expires before it completes,
+// Wait returns an error with a bakery.ErrNotFound cause. If
+// Params.WaitTimeout is non-zero and passes first, it returns an
+// error with an ErrWaitTimeout cause.
+func (s *Service) Wait(ctx context.Context, id string) (*httpbakery.DischargeToken, error) {
+	if s.p.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.p.WaitTimeout)
+		defer cancel()
+	}
+	for {
+		e, err := s.Info(ctx, id)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, errgo.WithCausef(nil, ErrWaitTimeout, "")
+			}
+			return nil, errgo.Mask(err, errgo.Is(bakery.ErrNotFound))
+		}
+		if e.Done {
+			return s.collectToken(ctx, e)
+		}
+		select {
+		case <-time.After(s.p.PollInterval):
+		case <-ctx.Done():
+			return nil, errgo.WithCausef(nil, ErrWaitTimeout, "")
+		}
+	}
+}
+
+func (s *Service) collectToken(ctx context.Context, e *Entry) (*httpbakery.DischargeToken, error) {
+	if e.ErrorMessage != "" {
+		return nil, &httpbakery.Error{
+			Code:    e.ErrorCode,
+			Message: e.ErrorMessage,
+		}
+	}
+	if e.TokenCollected {
+		return nil, errgo.WithCausef(nil, ErrTokenCollected, "")
+	}
+	if err := s.p.Store.CollectToken(ctx, e.Id); err != nil {
+		if errgo.Cause(err) == bakery.ErrNotFound {
+			return nil, errgo.WithCausef(nil, ErrTokenCollected, "")
+		}
+		return nil, errgo.Mask(err)
+	}
+	return &httpbakery.DischargeToken{
+		Kind:  TokenKind,
+		Value: []byte(e.Id + "." + string(e.Token)),
+	}, nil
+}
+
+// CheckToken checks that the given token was issued for the given
+// caveat and returns the caveats passed to DischargeComplete. The
+// rendezvous is removed, so a token can only be checked successfully
+// once.
+func (s *Service) CheckToken(ctx context.Context, token *httpbakery.DischargeToken, cav *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+	if token.Kind != TokenKind {
+		return nil, errgo.Newf("invalid discharge token kind %q", token.Kind)
+	}
+	id, secret, ok := splitToken(string(token.Value))
+	if !ok {
+		return nil, errgo.Newf("malformed discharge token")
+	}
+	e, err := s.Info(ctx, id)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid discharge token")
+	}
+	if !e.Done || e.ErrorMessage != "" || subtle.ConstantTimeCompare(e.Token, []byte(secret)) != 1 {
+		return nil, errgo.Newf("invalid discharge token")
+	}
+	if !bytes.Equal(e.Caveat, cav.Caveat) {
+		return nil, errgo.Newf("caveat provided to CheckToken does not match original")
+	}
+	if !bytes.Equal(e.CaveatId, cav.Id) {
+		return nil, errgo.Newf("caveat id provided to CheckToken does not match original")
+	}
+	if err := s.p.Store.Remove(ctx, id); err != nil {
+		if errgo.Cause(err) == bakery.ErrNotFound {
+			return nil, errgo.Newf("discharge token already used")
+		}
+		return nil, errgo.Mask(err)
+	}
+	return e.Caveats, nil
+}
+
+// Handlers returns the handlers that serve the wait-token endpoint.
+// They should be added to the discharger's HTTP server.
+func (s *Service) Handlers() []httprequest.Handler {
+	srv := httprequest.Server{
+		ErrorMapper: errorToResponse,
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (handler, context.Context, error) {
+		return handler{s}, httpbakery.ContextWithRequestIDHeader(p.Context, p.Request, s.p.RequestIDHeader), nil
+	})
+	for i := range hs {
+		hs[i].Path = s.p.WaitTokenPath
+	}
+	return hs
+}
+
+// handler defines the httprequest handler methods for a Service.
+type handler struct {
+	s *Service
+}
+
+// waitTokenRequest is the request to wait for a discharge token. The
+// path is replaced by Params.WaitTokenPath in Service.Handlers.
+type waitTokenRequest struct {
+	httprequest.Route `httprequest:"GET /wait-token"`
+	Id                string `httprequest:"id,form"`
+}
+
+// WaitToken serves the wait-token endpoint.
+func (h handler) WaitToken(p httprequest.Params, req *waitTokenRequest) (*httpbakery.WaitTokenResponse, error) {
+	token, err := h.s.Wait(p.Context, req.Id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return &httpbakery.WaitTokenResponse{
+		Kind:  token.Kind,
+		Token: string(token.Value),
+	}, nil
+}
+
+// errorToResponse maps errors from the wait-token endpoint to HTTP
+// responses. It differs from httpbakery.ErrorToResponse only in that
+// the errors specific to waiting do not result in a 500 status.
+func errorToResponse(ctx context.Context, err error) (int, interface{}) {
+	status, body := httpbakery.ErrorToResponse(ctx, err)
+	switch errgo.Cause(err) {
+	case bakery.ErrNotFound:
+		status = http.StatusNotFound
+	case ErrWaitTimeout:
+		status = http.StatusRequestTimeout
+	case ErrTokenCollected:
+		status = http.StatusBadRequest
+	}
+	return status, body
+}
+
+func splitToken(s string) (id, secret string, ok bool) {
+	i := strings.Index(s, ".")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate %d random bytes: %v", n, err)
+	}
+	return fmt.Sprintf("%x", b), nil
+}
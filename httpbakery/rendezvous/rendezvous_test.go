@@ -0,0 +1,253 @@
+package rendezvous_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakerytest"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/rendezvous"
+)
+
+func TestBrowserInteraction(t *testing.T) {
+	c := qt.New(t)
+	// Use two services sharing the same store to simulate
+	// two replicas of a discharger.
+	store := rendezvous.NewMemStore()
+	svc1 := rendezvous.New(rendezvous.Params{
+		Store:        store,
+		PollInterval: time.Millisecond,
+	})
+	svc2 := rendezvous.New(rendezvous.Params{
+		Store:        store,
+		PollInterval: time.Millisecond,
+	})
+	discharger := bakerytest.NewDischarger(nil)
+	defer discharger.Close()
+	discharger.AddHTTPHandlers(svc2.Handlers())
+	discharger.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			return svc1.CheckToken(ctx, p.Token, p.Caveat)
+		}
+		id, err := svc1.NewDischarge(ctx, p.Caveat)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		err1 := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		svc1.SetInteraction(err1, id, "/visit?id="+id)
+		return nil, err1
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: discharger,
+	})
+	m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  discharger.Location(),
+		Condition: "something",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+
+	client := httpbakery.NewClient()
+	client.AddInteractor(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: func(u *url.URL) error {
+			id := u.Query().Get("id")
+			e, err := svc2.Info(context.Background(), id)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			if e.Condition != "something" {
+				return errgo.Newf("unexpected condition %q", e.Condition)
+			}
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				err := svc2.DischargeComplete(context.Background(), id, []checkers.Caveat{
+					checkers.DeclaredCaveat("username", "bob"),
+				})
+				if err != nil {
+					panic(err)
+				}
+			}()
+			return nil
+		},
+	})
+	ms, err := client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ms, qt.HasLen, 2)
+	c.Assert(checkers.InferDeclared(nil, ms), qt.DeepEquals, map[string]string{
+		"username": "bob",
+	})
+}
+
+func TestDischargeFailed(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		PollInterval: time.Millisecond,
+	})
+	discharger := bakerytest.NewDischarger(nil)
+	defer discharger.Close()
+	discharger.AddHTTPHandlers(svc.Handlers())
+	discharger.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		id, err := svc.NewDischarge(ctx, p.Caveat)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		err1 := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		svc.SetInteraction(err1, id, "/visit?id="+id)
+		return nil, err1
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: discharger,
+	})
+	m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  discharger.Location(),
+		Condition: "something",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+
+	client := httpbakery.NewClient()
+	client.AddInteractor(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: func(u *url.URL) error {
+			return svc.DischargeFailed(context.Background(), u.Query().Get("id"), errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "user said no"))
+		},
+	})
+	_, err = client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": cannot acquire discharge token: user said no`)
+}
+
+func TestWaitTimeout(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		PollInterval: time.Millisecond,
+		WaitTimeout:  20 * time.Millisecond,
+	})
+	id, err := svc.NewDischarge(context.Background(), &bakery.ThirdPartyCaveatInfo{
+		Condition: []byte("something"),
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = svc.Wait(context.Background(), id)
+	c.Assert(errgo.Cause(err), qt.Equals, rendezvous.ErrWaitTimeout)
+}
+
+func TestTokenCollectedOnce(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	svc := rendezvous.New(rendezvous.Params{})
+	cav := &bakery.ThirdPartyCaveatInfo{
+		Condition: []byte("something"),
+		Caveat:    []byte("caveat"),
+		Id:        []byte("caveat id"),
+	}
+	id, err := svc.NewDischarge(ctx, cav)
+	c.Assert(err, qt.IsNil)
+	err = svc.DischargeComplete(ctx, id, []checkers.Caveat{{Condition: "x"}})
+	c.Assert(err, qt.IsNil)
+	err = svc.DischargeComplete(ctx, id, nil)
+	c.Assert(err, qt.ErrorMatches, `rendezvous ".*" already completed`)
+
+	token, err := svc.Wait(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(token.Kind, qt.Equals, rendezvous.TokenKind)
+
+	_, err = svc.Wait(ctx, id)
+	c.Assert(errgo.Cause(err), qt.Equals, rendezvous.ErrTokenCollected)
+
+	// A token for a different caveat is refused.
+	_, err = svc.CheckToken(ctx, token, &bakery.ThirdPartyCaveatInfo{
+		Caveat: []byte("other caveat"),
+		Id:     []byte("caveat id"),
+	})
+	c.Assert(err, qt.ErrorMatches, `caveat provided to CheckToken does not match original`)
+
+	// A forged token is refused.
+	_, err = svc.CheckToken(ctx, &httpbakery.DischargeToken{
+		Kind:  rendezvous.TokenKind,
+		Value: []byte(id + ".forged"),
+	}, cav)
+	c.Assert(err, qt.ErrorMatches, `invalid discharge token`)
+
+	caveats, err := svc.CheckToken(ctx, token, cav)
+	c.Assert(err, qt.IsNil)
+	c.Assert(caveats, qt.DeepEquals, []checkers.Caveat{{Condition: "x"}})
+
+	_, err = svc.CheckToken(ctx, token, cav)
+	c.Assert(err, qt.ErrorMatches, `invalid discharge token: rendezvous ".*" not found`)
+}
+
+func TestExpiry(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	svc := rendezvous.New(rendezvous.Params{
+		Expiry: time.Millisecond,
+	})
+	id, err := svc.NewDischarge(ctx, &bakery.ThirdPartyCaveatInfo{})
+	c.Assert(err, qt.IsNil)
+	time.Sleep(5 * time.Millisecond)
+	_, err = svc.Info(ctx, id)
+	c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`rendezvous %q has expired`, id))
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+	err = svc.DischargeComplete(ctx, id, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+}
+
+func TestWaitTokenURL(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		WaitTokenPath: "/login/wait",
+	})
+	c.Assert(svc.WaitTokenURL("1234"), qt.Equals, "/login/wait?id=1234")
+	hs := svc.Handlers()
+	c.Assert(hs, qt.HasLen, 1)
+	c.Assert(hs[0].Method, qt.Equals, http.MethodGet)
+	c.Assert(hs[0].Path, qt.Equals, "/login/wait")
+}
+
+func TestWaitUntilExpiry(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		Expiry:       20 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	id, err := svc.NewDischarge(context.Background(), &bakery.ThirdPartyCaveatInfo{})
+	c.Assert(err, qt.IsNil)
+	_, err = svc.Wait(context.Background(), id)
+	c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`rendezvous %q has expired`, id))
+	c.Assert(errgo.Cause(err), qt.Equals, bakery.ErrNotFound)
+}
+
+func TestWaitTokenErrorStatus(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		PollInterval: time.Millisecond,
+		WaitTimeout:  20 * time.Millisecond,
+	})
+	discharger := bakerytest.NewDischarger(nil)
+	defer discharger.Close()
+	discharger.AddHTTPHandlers(svc.Handlers())
+	id, err := svc.NewDischarge(context.Background(), &bakery.ThirdPartyCaveatInfo{})
+	c.Assert(err, qt.IsNil)
+
+	get := func(id string) int {
+		resp, err := http.Get(discharger.Location() + svc.WaitTokenURL(id))
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	c.Assert(get("unknown"), qt.Equals, http.StatusNotFound)
+	c.Assert(get(id), qt.Equals, http.StatusRequestTimeout)
+
+	err = svc.DischargeComplete(context.Background(), id, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(get(id), qt.Equals, http.StatusOK)
+	c.Assert(get(id), qt.Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,39 @@
+package httpbakery
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+)
+
+// ContextWithRequestIDHeader returns the context with the value of
+// the given header in req attached as the request id with
+// bakery.ContextWithRequestID, so that it is included in log
+// messages. If header is empty or the request does not hold the
+// header, ctx is returned unchanged.
+func ContextWithRequestIDHeader(ctx context.Context, req *http.Request, header string) context.Context {
+	if header == "" || req == nil {
+		return ctx
+	}
+	id := req.Header.Get(header)
+	if id == "" {
+		return ctx
+	}
+	return bakery.ContextWithRequestID(ctx, id)
+}
+
+// RequestIDHandler returns a handler that calls h with the request
+// id taken from the given header attached to the request context
+// (see ContextWithRequestIDHeader). It can be used to wrap handlers
+// that do not have their own RequestIDHeader parameter, such as
+// those of a service that calls ContextWithRequest.
+func RequestIDHandler(header string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := ContextWithRequestIDHeader(req.Context(), req, header)
+		if ctx != req.Context() {
+			req = req.WithContext(ctx)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,89 @@
+package httpbakery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func TestContextWithRequestIDHeader(t *testing.T) {
+	c := qt.New(t)
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("X-Request-Id", "req-1")
+
+	ctx := httpbakery.ContextWithRequestIDHeader(testContext, req, "X-Request-Id")
+	c.Assert(bakery.RequestIDFromContext(ctx), qt.Equals, "req-1")
+
+	ctx = httpbakery.ContextWithRequestIDHeader(testContext, req, "")
+	c.Assert(bakery.RequestIDFromContext(ctx), qt.Equals, "")
+
+	ctx = httpbakery.ContextWithRequestIDHeader(testContext, req, "X-Other")
+	c.Assert(bakery.RequestIDFromContext(ctx), qt.Equals, "")
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	c := qt.New(t)
+	var gotID string
+	h := httpbakery.RequestIDHandler("X-Request-Id", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotID = bakery.RequestIDFromContext(req.Context())
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "req-2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	c.Assert(gotID, qt.Equals, "req-2")
+}
+
+func TestDischargerRequestIDHeader(t *testing.T) {
+	c := qt.New(t)
+	var checkerID, auditID string
+	key := bakery.MustGenerateKey()
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Key: key,
+		CheckerP: httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+			checkerID = bakery.RequestIDFromContext(ctx)
+			return nil, nil
+		}),
+		Auditor: bakery.AuditorFunc(func(ctx context.Context, e bakery.AuditEvent) {
+			auditID = bakery.RequestIDFromContext(ctx)
+		}),
+		RequestIDHeader: "X-Request-Id",
+	})
+	srv := httptest.NewServer(handlersToMux(d))
+	defer srv.Close()
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo(srv.URL, bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	b := newBakery("loc", locator, nil)
+	client := httpbakery.NewClient()
+	client.Client.Transport = requestIDTransport("req-3")
+
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{{
+		Location:  srv.URL,
+		Condition: "something",
+	}}, testOp)
+	c.Assert(err, qt.IsNil)
+	_, err = client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(checkerID, qt.Equals, "req-3")
+	c.Assert(auditID, qt.Equals, "req-3")
+}
+
+// requestIDTransport is an http.RoundTripper that adds
+// an X-Request-Id header holding its value to each request.
+type requestIDTransport string
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Request-Id", string(t))
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,226 @@
+package httpbakery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+)
+
+// ConditionInfo describes a third party caveat condition
+// supported by a discharger.
+type ConditionInfo struct {
+	// Name holds the name of the condition.
+	Name string
+
+	// Description holds a human-readable description of the
+	// condition and its arguments.
+	Description string `json:",omitempty"`
+}
+
+// ConditionLister is implemented by third party caveat checkers that
+// can report the conditions that they support. If the CheckerP of a
+// Discharger implements ConditionLister, the conditions are served
+// at the /discharge/conditions endpoint.
+type ConditionLister interface {
+	// Conditions returns the supported conditions, sorted by name.
+	Conditions() []ConditionInfo
+}
+
+// ConditionHandler checks a third party caveat condition
+// on behalf of a ConditionRouter. It returns any caveats
+// to be added to the discharge macaroon.
+type ConditionHandler func(ctx context.Context, req *ConditionRequest) ([]checkers.Caveat, error)
+
+// ConditionRequest holds a third party caveat condition
+// being checked by a ConditionHandler.
+type ConditionRequest struct {
+	ThirdPartyCaveatCheckerParams
+
+	// Name holds the name of the condition.
+	Name string
+
+	// Arg holds the argument to the condition,
+	// which may be empty.
+	Arg string
+
+	declared []checkers.Caveat
+}
+
+// Args returns the space-separated fields of the argument.
+func (req *ConditionRequest) Args() []string {
+	return strings.Fields(req.Arg)
+}
+
+// ExactArgs returns the space-separated fields of the argument.
+// It returns an ErrBadRequest error if there are not exactly n
+// of them.
+func (req *ConditionRequest) ExactArgs(n int) ([]string, error) {
+	args := req.Args()
+	if len(args) != n {
+		return nil, req.badRequest("expected %d arguments, got %d", n, len(args))
+	}
+	return args, nil
+}
+
+// MinArgs returns the space-separated fields of the argument. It
+// returns an ErrBadRequest error if there are fewer than n of them.
+func (req *ConditionRequest) MinArgs(n int) ([]string, error) {
+	args := req.Args()
+	if len(args) < n {
+		return nil, req.badRequest("expected at least %d arguments, got %d", n, len(args))
+	}
+	return args, nil
+}
+
+// TimeArg parses the argument as a time in RFC3339 format, as used by
+// checkers.TimeBeforeCaveat. It returns an ErrBadRequest error if the
+// argument is not a valid time.
+func (req *ConditionRequest) TimeArg() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(req.Arg))
+	if err != nil {
+		return time.Time{}, req.badRequest("invalid time %q", req.Arg)
+	}
+	return t, nil
+}
+
+// Declare adds a declared caveat with the given key and value to the
+// discharge macaroon. Keys required by a need-declared caveat that are
+// not declared are given empty values.
+func (req *ConditionRequest) Declare(key, value string) {
+	req.declared = append(req.declared, checkers.DeclaredCaveat(key, value))
+}
+
+// badRequest returns an ErrBadRequest error describing
+// a problem with the condition's argument.
+func (req *ConditionRequest) badRequest(f string, a ...interface{}) error {
+	return errgo.WithCausef(nil, ErrBadRequest, "caveat %q not satisfied: %s", req.Name, fmt.Sprintf(f, a...))
+}
+
+// ConditionRouter is a ThirdPartyCaveatCheckerP that dispatches
+// third party caveats to handlers registered for their condition
+// names. It returns an error with a checkers.ErrCaveatNotRecognized
+// cause for conditions that have no handler.
+//
+// Need-declared caveats (see checkers.NeedDeclaredCaveat) are
+// normally unwrapped by bakery.Discharge before the checker is
+// called. If ConditionRouter is called directly with a need-declared
+// caveat, it unwraps it in the same way.
+type ConditionRouter struct {
+	routes map[string]conditionRoute
+}
+
+type conditionRoute struct {
+	description string
+	handler     ConditionHandler
+}
+
+var (
+	_ ThirdPartyCaveatCheckerP = (*ConditionRouter)(nil)
+	_ ConditionLister          = (*ConditionRouter)(nil)
+)
+
+// NewConditionRouter returns a new ConditionRouter
+// with no registered conditions.
+func NewConditionRouter() *ConditionRouter {
+	return &ConditionRouter{
+		routes: make(map[string]conditionRoute),
+	}
+}
+
+// Handle registers the handler for the given condition name, with a
+// description that is reported by Conditions. It panics if a handler
+// is already registered for the condition or if the name is not
+// valid.
+func (r *ConditionRouter) Handle(name, description string, h ConditionHandler) {
+	if name == "" || strings.ContainsAny(name, " \t\n") || name == checkers.CondNeedDeclared {
+		panic(errgo.Newf("invalid condition name %q", name))
+	}
+	if _, ok := r.routes[name]; ok {
+		panic(errgo.Newf("condition %q registered twice", name))
+	}
+	r.routes[name] = conditionRoute{
+		description: description,
+		handler:     h,
+	}
+}
+
+// Conditions implements ConditionLister.Conditions.
+func (r *ConditionRouter) Conditions() []ConditionInfo {
+	infos := make([]ConditionInfo, 0, len(r.routes))
+	for name, route := range r.routes {
+		infos = append(infos, ConditionInfo{
+			Name:        name,
+			Description: route.description,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// CheckThirdPartyCaveat implements ThirdPartyCaveatCheckerP by calling
+// the handler registered for the caveat's condition.
+func (r *ConditionRouter) CheckThirdPartyCaveat(ctx context.Context, p ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+	condition := string(p.Caveat.Condition)
+	var needDeclared []string
+	if cond, arg, err := checkers.ParseCaveat(condition); err == nil && cond == checkers.CondNeedDeclared {
+		parts := strings.SplitN(arg, " ", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errgo.WithCausef(nil, ErrBadRequest, "need-declared caveat requires an argument, got %q", arg)
+		}
+		needDeclared = strings.Split(parts[0], ",")
+		condition = parts[1]
+	}
+	name, arg, err := checkers.ParseCaveat(condition)
+	if err != nil {
+		return nil, errgo.WithCausef(err, checkers.ErrCaveatNotRecognized, "cannot parse caveat %q", condition)
+	}
+	route, ok := r.routes[name]
+	if !ok {
+		return nil, errgo.NoteMask(checkers.ErrCaveatNotRecognized, fmt.Sprintf("caveat %q not satisfied", condition), errgo.Any)
+	}
+	req := &ConditionRequest{
+		ThirdPartyCaveatCheckerParams: p,
+		Name:                          name,
+		Arg:                           arg,
+	}
+	caveats, err := route.handler(ctx, req)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	caveats = append(caveats, req.declared...)
+	return addNeedDeclared(caveats, needDeclared), nil
+}
+
+// addNeedDeclared returns caveats with empty declarations
+// added for any of the given keys that are not declared.
+func addNeedDeclared(caveats []checkers.Caveat, keys []string) []checkers.Caveat {
+	if len(keys) == 0 {
+		return caveats
+	}
+	declared := make(map[string]bool)
+	for _, cav := range caveats {
+		if cav.Location != "" {
+			continue
+		}
+		cond, arg, _ := checkers.ParseCaveat(cav.Condition)
+		if cond != checkers.CondDeclared {
+			continue
+		}
+		declared[strings.SplitN(arg, " ", 2)[0]] = true
+	}
+	for _, key := range keys {
+		if !declared[key] {
+			caveats = append(caveats, checkers.DeclaredCaveat(key, ""))
+			declared[key] = true
+		}
+	}
+	return caveats
+}
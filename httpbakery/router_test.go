@@ -0,0 +1,216 @@
+package httpbakery_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+func newTestRouter() *httpbakery.ConditionRouter {
+	r := httpbakery.NewConditionRouter()
+	r.Handle("is-user", "is-user <name>: the client is the named user", func(ctx context.Context, req *httpbakery.ConditionRequest) ([]checkers.Caveat, error) {
+		args, err := req.ExactArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		req.Declare("username", args[0])
+		return nil, nil
+	})
+	r.Handle("in-groups", "in-groups <group>...: the client is in all the groups", func(ctx context.Context, req *httpbakery.ConditionRequest) ([]checkers.Caveat, error) {
+		args, err := req.MinArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range args {
+			if g != "admin" {
+				return nil, errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "not in group %q", g)
+			}
+		}
+		return []checkers.Caveat{checkers.DeclaredCaveat("groups", req.Arg)}, nil
+	})
+	r.Handle("before", "before <time>: the current time is before the given time", func(ctx context.Context, req *httpbakery.ConditionRequest) ([]checkers.Caveat, error) {
+		t, err := req.TimeArg()
+		if err != nil {
+			return nil, err
+		}
+		return []checkers.Caveat{checkers.TimeBeforeCaveat(t)}, nil
+	})
+	return r
+}
+
+var conditionRouterTests = []struct {
+	about         string
+	condition     string
+	expectCaveats []checkers.Caveat
+	expectError   string
+	expectCause   error
+}{{
+	about:         "declared value",
+	condition:     "is-user bob",
+	expectCaveats: []checkers.Caveat{checkers.DeclaredCaveat("username", "bob")},
+}, {
+	about:         "returned caveats",
+	condition:     "in-groups admin admin",
+	expectCaveats: []checkers.Caveat{checkers.DeclaredCaveat("groups", "admin admin")},
+}, {
+	about:         "time argument",
+	condition:     "before 2020-01-01T00:00:00Z",
+	expectCaveats: []checkers.Caveat{checkers.TimeBeforeCaveat(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))},
+}, {
+	about:       "handler error",
+	condition:   "in-groups admin other",
+	expectError: `not in group "other"`,
+	expectCause: httpbakery.ErrPermissionDenied,
+}, {
+	about:       "too many arguments",
+	condition:   "is-user bob alice",
+	expectError: `caveat "is-user" not satisfied: expected 1 arguments, got 2`,
+	expectCause: httpbakery.ErrBadRequest,
+}, {
+	about:       "too few arguments",
+	condition:   "in-groups",
+	expectError: `caveat "in-groups" not satisfied: expected at least 1 arguments, got 0`,
+	expectCause: httpbakery.ErrBadRequest,
+}, {
+	about:       "invalid time",
+	condition:   "before yesterday",
+	expectError: `caveat "before" not satisfied: invalid time "yesterday"`,
+	expectCause: httpbakery.ErrBadRequest,
+}, {
+	about:       "unknown condition",
+	condition:   "is-admin",
+	expectError: `caveat "is-admin" not satisfied: caveat not recognized`,
+	expectCause: checkers.ErrCaveatNotRecognized,
+}, {
+	about:       "unparsable condition",
+	condition:   "",
+	expectError: `cannot parse caveat "": empty caveat`,
+	expectCause: checkers.ErrCaveatNotRecognized,
+}, {
+	about:     "need-declared",
+	condition: "need-declared username,email is-user bob",
+	expectCaveats: []checkers.Caveat{
+		checkers.DeclaredCaveat("username", "bob"),
+		checkers.DeclaredCaveat("email", ""),
+	},
+}, {
+	about:       "need-declared without condition",
+	condition:   "need-declared username",
+	expectError: `need-declared caveat requires an argument, got "username"`,
+	expectCause: httpbakery.ErrBadRequest,
+}}
+
+func TestConditionRouter(t *testing.T) {
+	c := qt.New(t)
+	r := newTestRouter()
+	for _, test := range conditionRouterTests {
+		c.Run(test.about, func(c *qt.C) {
+			caveats, err := r.CheckThirdPartyCaveat(testContext, httpbakery.ThirdPartyCaveatCheckerParams{
+				Caveat: &bakery.ThirdPartyCaveatInfo{
+					Condition: []byte(test.condition),
+				},
+			})
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(caveats, qt.DeepEquals, test.expectCaveats)
+		})
+	}
+}
+
+func TestConditionRouterRegisterTwice(t *testing.T) {
+	c := qt.New(t)
+	r := newTestRouter()
+	c.Assert(func() {
+		r.Handle("is-user", "", nil)
+	}, qt.PanicMatches, `condition "is-user" registered twice`)
+	c.Assert(func() {
+		r.Handle("need-declared", "", nil)
+	}, qt.PanicMatches, `invalid condition name "need-declared"`)
+}
+
+func TestDischargerConditions(t *testing.T) {
+	c := qt.New(t)
+	key := bakery.MustGenerateKey()
+	router := newTestRouter()
+
+	d := httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Key:      key,
+		CheckerP: router,
+	})
+	srv := httptest.NewServer(handlersToMux(d))
+	defer srv.Close()
+	var resp struct {
+		Conditions []httpbakery.ConditionInfo
+	}
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.Get(testContext, "/discharge/conditions", &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Conditions, qt.DeepEquals, []httpbakery.ConditionInfo{{
+		Name:        "before",
+		Description: "before <time>: the current time is before the given time",
+	}, {
+		Name:        "in-groups",
+		Description: "in-groups <group>...: the client is in all the groups",
+	}, {
+		Name:        "is-user",
+		Description: "is-user <name>: the client is the named user",
+	}})
+
+	// The endpoint is not served for other checkers.
+	d = httpbakery.NewDischarger(httpbakery.DischargerParams{
+		Key:      key,
+		CheckerP: conditionChecker("x"),
+	})
+	srv1 := httptest.NewServer(handlersToMux(d))
+	defer srv1.Close()
+	httpResp, err := http.Get(srv1.URL + "/discharge/conditions")
+	c.Assert(err, qt.IsNil)
+	httpResp.Body.Close()
+	c.Assert(httpResp.StatusCode, qt.Equals, http.StatusNotFound)
+
+	// Check that need-declared caveats are satisfied by
+	// values declared by the handler when discharged
+	// through the Discharger.
+	locator := bakery.NewThirdPartyStore()
+	locator.AddInfo(srv.URL, bakery.ThirdPartyInfo{
+		PublicKey: key.Public,
+		Version:   bakery.LatestVersion,
+	})
+	b := newBakery("loc", locator, nil)
+	m, err := b.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{
+		checkers.NeedDeclaredCaveat(checkers.Caveat{
+			Location:  srv.URL,
+			Condition: "is-user bob",
+		}, "username", "email"),
+	}, testOp)
+	c.Assert(err, qt.IsNil)
+	ms, err := httpbakery.NewClient().DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	c.Assert(checkers.InferDeclared(nil, ms), qt.DeepEquals, map[string]string{
+		"username": "bob",
+		"email":    "",
+	})
+}
+
+// handlersToMux returns a ServeMux serving the given discharger.
+func handlersToMux(d *httpbakery.Discharger) *http.ServeMux {
+	mux := http.NewServeMux()
+	d.AddMuxHandlers(mux, "/")
+	return mux
+}
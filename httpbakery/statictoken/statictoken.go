@@ -0,0 +1,272 @@
+// Package statictoken enables non-interactive login for automated
+// systems that hold a pre-provisioned secret token rather than a bakery
+// key pair.
+package statictoken
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+/*
+PROTOCOL
+
+A static token login works as follows:
+
+       Client                            Login Service
+          |                                    |
+          | Discharge request                  |
+          |----------------------------------->|
+          |                                    |
+          |    Interaction-required error with |
+          |   "static-token" entry holding the |
+          |                      exchange URL. |
+          |<-----------------------------------|
+          |                                    |
+          | POST exchange URL with the         |
+          | pre-provisioned secret token       |
+          |----------------------------------->|
+          |                                    |
+          |    Exchange response holding a     |
+          |    short-lived discharge token     |
+          |<-----------------------------------|
+          |                                    |
+          | Discharge request with             |
+          | discharge token.                   |
+          |----------------------------------->|
+          |                                    |
+          | Discharge macaroon                 |
+          |<-----------------------------------|
+
+The secret token is only ever sent in the body of the exchange request,
+so it does not appear in the discharge request parameters. The client
+only sends it to the discharger it was provisioned for: the discharge
+location must match the interactor's configured location, and the
+exchange URL must be on the same scheme and host as that location.
+*/
+
+const (
+	// InteractionMethod is the methodURLs key
+	// used for static token interaction.
+	InteractionMethod = "static-token"
+
+	// TokenEnvVar holds the name of the environment variable
+	// from which Interactor reads the secret token by default.
+	TokenEnvVar = "BAKERY_STATIC_TOKEN"
+
+	// TokenFileEnvVar holds the name of the environment variable
+	// that names a file from which Interactor reads the secret
+	// token by default.
+	TokenFileEnvVar = "BAKERY_STATIC_TOKEN_FILE"
+
+	// LocationEnvVar holds the name of the environment variable
+	// from which Interactor reads the location of the discharger
+	// that the secret token is for, if its Location field is empty.
+	LocationEnvVar = "BAKERY_STATIC_TOKEN_LOCATION"
+)
+
+// InteractionInfo holds the information expected in
+// the static-token interaction entry in an interaction-required
+// error.
+type InteractionInfo struct {
+	// URL holds the URL (which may be relative to the discharger
+	// location) to which the secret token should be posted.
+	URL string `json:"url"`
+}
+
+// SetInteraction sets static token interaction information on the
+// given error, which should be an interaction-required
+// error to be returned from a discharge request.
+func SetInteraction(e *httpbakery.Error, exchangeURL string) {
+	e.SetInteraction(InteractionMethod, InteractionInfo{
+		URL: exchangeURL,
+	})
+}
+
+// ExchangeRequest is a request to exchange a secret token
+// for a discharge token.
+type ExchangeRequest struct {
+	httprequest.Route `httprequest:"POST"`
+	Body              ExchangeBody `httprequest:",body"`
+}
+
+// ExchangeBody holds the body of an exchange request.
+type ExchangeBody struct {
+	Token string `json:"token"`
+}
+
+// ExchangeResponse holds the response to an exchange request.
+type ExchangeResponse struct {
+	Token *httpbakery.DischargeToken `json:"token"`
+}
+
+// Interactor implements httpbakery.Interactor by presenting
+// a pre-provisioned secret token.
+//
+// The token is taken from the first of the following that is set:
+// the Token field, the File field, the BAKERY_STATIC_TOKEN environment
+// variable, and the file named by the BAKERY_STATIC_TOKEN_FILE
+// environment variable. If no token is found, the interactor
+// reports that the interaction method is not supported, so other
+// interactors will be tried.
+//
+// The token is only sent to the discharger it is for. Location (or,
+// if that is empty, the BAKERY_STATIC_TOKEN_LOCATION environment
+// variable) must be set to the URL of that discharger; a discharge
+// location matches it when the scheme and host are the same and the
+// path is equal to, or below, the path of the configured URL. For
+// other locations, the interactor reports that the interaction method
+// is not supported. The token is never posted to an exchange URL on a
+// different scheme or host from the configured location.
+type Interactor struct {
+	// Location holds the URL of the discharger that the token
+	// is for. It is required, either here or in the environment.
+	Location string
+
+	// Token holds the secret token.
+	Token string
+
+	// File holds the name of a file containing the secret token.
+	File string
+}
+
+var _ httpbakery.Interactor = Interactor{}
+
+// Kind implements httpbakery.Interactor.Kind.
+func (i Interactor) Kind() string {
+	return InteractionMethod
+}
+
+// Interact implements httpbakery.Interactor.Interact.
+func (i Interactor) Interact(ctx context.Context, client *httpbakery.Client, location string, interactionRequiredErr *httpbakery.Error) (*httpbakery.DischargeToken, error) {
+	var p InteractionInfo
+	if err := interactionRequiredErr.InteractionMethod(InteractionMethod, &p); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(httpbakery.ErrInteractionMethodNotFound))
+	}
+	allowed, err := i.location()
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(httpbakery.ErrInteractionMethodNotFound))
+	}
+	loc, err := url.Parse(location)
+	if err != nil || !locationMatches(allowed, loc) {
+		return nil, errgo.WithCausef(nil, httpbakery.ErrInteractionMethodNotFound, "static token is not for %q", location)
+	}
+	exchangeURL, err := relativeURL(location, p.URL)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid exchange URL %q", p.URL)
+	}
+	if !sameHost(allowed, exchangeURL) {
+		return nil, errgo.Newf("exchange URL %q is not on the static token location %q", exchangeURL, allowed)
+	}
+	token, err := i.secret()
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(httpbakery.ErrInteractionMethodNotFound))
+	}
+	var resp ExchangeResponse
+	err = (&httprequest.Client{
+		Doer: client,
+	}).CallURL(ctx, exchangeURL.String(), &ExchangeRequest{
+		Body: ExchangeBody{
+			Token: token,
+		},
+	}, &resp)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot exchange static token")
+	}
+	if resp.Token == nil {
+		return nil, errgo.Newf("no token found in exchange response")
+	}
+	return resp.Token, nil
+}
+
+// location returns the parsed location of the discharger
+// that the token is for.
+func (i Interactor) location() (*url.URL, error) {
+	s := i.Location
+	if s == "" {
+		s = os.Getenv(LocationEnvVar)
+	}
+	if s == "" {
+		return nil, errgo.WithCausef(nil, httpbakery.ErrInteractionMethodNotFound, "no static token location found")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid static token location")
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errgo.Newf("static token location %q is not absolute", s)
+	}
+	return u, nil
+}
+
+// locationMatches reports whether the discharge location loc
+// is at or below the allowed location.
+func locationMatches(allowed, loc *url.URL) bool {
+	if !sameHost(allowed, loc) {
+		return false
+	}
+	prefix := strings.TrimSuffix(allowed.Path, "/")
+	if prefix == "" {
+		return true
+	}
+	path := strings.TrimSuffix(loc.Path, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// sameHost reports whether u1 and u2 have the same scheme and host.
+func sameHost(u1, u2 *url.URL) bool {
+	return strings.EqualFold(u1.Scheme, u2.Scheme) && strings.EqualFold(u1.Host, u2.Host)
+}
+
+// secret returns the secret token to use.
+func (i Interactor) secret() (string, error) {
+	if i.Token != "" {
+		return i.Token, nil
+	}
+	if i.File != "" {
+		return readTokenFile(i.File)
+	}
+	if token := os.Getenv(TokenEnvVar); token != "" {
+		return token, nil
+	}
+	if file := os.Getenv(TokenFileEnvVar); file != "" {
+		return readTokenFile(file)
+	}
+	return "", errgo.WithCausef(nil, httpbakery.ErrInteractionMethodNotFound, "no static token found")
+}
+
+func readTokenFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot read static token")
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errgo.Newf("no static token found in %q", file)
+	}
+	return token, nil
+}
+
+// relativeURL returns newPath relative to an original URL.
+func relativeURL(base, new string) (*url.URL, error) {
+	if new == "" {
+		return nil, errgo.Newf("empty URL")
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse URL")
+	}
+	newURL, err := url.Parse(new)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse URL")
+	}
+	return baseURL.ResolveReference(newURL), nil
+}
@@ -0,0 +1,302 @@
+package statictoken_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/identchecker"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakerytest"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/statictoken"
+)
+
+// dischargerLocation is replaced by the location of the test
+// discharger when used as a location in staticTokenTests.
+const dischargerLocation = "discharger"
+
+var staticTokenTests = []struct {
+	about       string
+	interactor  statictoken.Interactor
+	env         map[string]string
+	tokenFile   string
+	expectError string
+}{{
+	about: "token in interactor",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+		Token:    "bob-token",
+	},
+}, {
+	about: "token in environment",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+	},
+	env: map[string]string{
+		statictoken.TokenEnvVar: "bob-token",
+	},
+}, {
+	about: "token in file",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+		File:     "token",
+	},
+	tokenFile: "bob-token\n",
+}, {
+	about: "token file in environment",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+	},
+	env: map[string]string{
+		statictoken.TokenFileEnvVar: "token",
+	},
+	tokenFile: "bob-token\n",
+}, {
+	about: "location in environment",
+	interactor: statictoken.Interactor{
+		Token: "bob-token",
+	},
+	env: map[string]string{
+		statictoken.LocationEnvVar: dischargerLocation,
+	},
+}, {
+	about: "location below discharger path",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation + "/",
+		Token:    "bob-token",
+	},
+}, {
+	about: "no location",
+	interactor: statictoken.Interactor{
+		Token: "bob-token",
+	},
+	expectError: `cannot get discharge from ".*": cannot start interactive session: no supported interaction method`,
+}, {
+	about: "token for another location",
+	interactor: statictoken.Interactor{
+		Location: "https://other.example.com",
+		Token:    "bob-token",
+	},
+	expectError: `cannot get discharge from ".*": cannot start interactive session: no supported interaction method`,
+}, {
+	about: "token for another path",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation + "/other",
+		Token:    "bob-token",
+	},
+	expectError: `cannot get discharge from ".*": cannot start interactive session: no supported interaction method`,
+}, {
+	about: "relative location",
+	interactor: statictoken.Interactor{
+		Location: "/some/path",
+		Token:    "bob-token",
+	},
+	expectError: `cannot get discharge from ".*": static token location "/some/path" is not absolute`,
+}, {
+	about: "invalid token",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+		Token:    "other-token",
+	},
+	expectError: `cannot get discharge from ".*": cannot exchange static token: Post .*: invalid static token`,
+}, {
+	about: "expired token",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+		Token:    "expired-token",
+	},
+	expectError: `cannot get discharge from ".*": cannot exchange static token: Post .*: static token has expired`,
+}, {
+	about: "no token",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+	},
+	expectError: `cannot get discharge from ".*": cannot start interactive session: no supported interaction method`,
+}, {
+	about: "missing token file",
+	interactor: statictoken.Interactor{
+		Location: dischargerLocation,
+		File:     "token",
+	},
+	expectError: `cannot get discharge from ".*": cannot read static token: open .*: no such file or directory`,
+}}
+
+func TestStaticToken(t *testing.T) {
+	c := qt.New(t)
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	v := statictoken.NewValidator(statictoken.ValidatorParams{})
+	v.AddToken("bob-token", "bob", time.Time{})
+	err := v.AddTokenHash(statictoken.HashToken("expired-token"), "alice", time.Now().Add(-time.Minute))
+	c.Assert(err, qt.IsNil)
+	d.AddHTTPHandlers(v.Handlers())
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			username, err := v.CheckToken(p.Token)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			return []checkers.Caveat{checkers.DeclaredCaveat("username", username)}, nil
+		}
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		v.SetInteraction(err)
+		return nil, err
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: d,
+	})
+	for i, test := range staticTokenTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Logf("test %d: %s", i, test.about)
+			c.Setenv(statictoken.TokenEnvVar, "")
+			c.Setenv(statictoken.TokenFileEnvVar, "")
+			c.Setenv(statictoken.LocationEnvVar, "")
+			dir := c.TempDir()
+			for k, val := range test.env {
+				switch k {
+				case statictoken.TokenFileEnvVar:
+					val = filepath.Join(dir, val)
+				case statictoken.LocationEnvVar:
+					val = strings.Replace(val, dischargerLocation, d.Location(), 1)
+				}
+				c.Setenv(k, val)
+			}
+			interactor := test.interactor
+			interactor.Location = strings.Replace(interactor.Location, dischargerLocation, d.Location(), 1)
+			if interactor.File != "" {
+				interactor.File = filepath.Join(dir, interactor.File)
+			}
+			if test.tokenFile != "" {
+				err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte(test.tokenFile), 0600)
+				c.Assert(err, qt.IsNil)
+			}
+			m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+				Location:  d.Location(),
+				Condition: "test condition",
+			}}, identchecker.LoginOp)
+			c.Assert(err, qt.IsNil)
+
+			client := httpbakery.NewClient()
+			client.AddInteractor(interactor)
+			ms, err := client.DischargeAll(context.Background(), m)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(ms, qt.HasLen, 2)
+			c.Assert(checkers.InferDeclared(nil, ms), qt.DeepEquals, map[string]string{
+				"username": "bob",
+			})
+		})
+	}
+}
+
+func TestStaticTokenNotSentToOtherDischarger(t *testing.T) {
+	c := qt.New(t)
+	v := statictoken.NewValidator(statictoken.ValidatorParams{})
+	v.AddToken("bob-token", "bob", time.Time{})
+
+	// The foreign discharger asks for a static token and records
+	// any exchange requests that it receives.
+	var foreignExchanges int
+	foreign := bakerytest.NewDischarger(nil)
+	defer foreign.Close()
+	foreign.AddHTTPHandlers([]httprequest.Handler{{
+		Method: "POST",
+		Path:   "/static-token",
+		Handle: func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+			foreignExchanges++
+			http.Error(w, "unexpected exchange", http.StatusInternalServerError)
+		},
+	}})
+	foreign.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		statictoken.SetInteraction(err, "/static-token")
+		return nil, err
+	})
+
+	// The trusted discharger directs the client to the foreign
+	// discharger's exchange URL.
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	d.AddHTTPHandlers(v.Handlers())
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		err := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		statictoken.SetInteraction(err, foreign.Location()+"/static-token")
+		return nil, err
+	})
+
+	locator := bakery.NewThirdPartyStore()
+	for _, d := range []*bakerytest.Discharger{d, foreign} {
+		info, err := d.ThirdPartyInfo(context.Background(), d.Location())
+		c.Assert(err, qt.IsNil)
+		locator.AddInfo(d.Location(), info)
+	}
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: locator,
+	})
+	client := httpbakery.NewClient()
+	client.AddInteractor(statictoken.Interactor{
+		Location: d.Location(),
+		Token:    "bob-token",
+	})
+
+	m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  foreign.Location(),
+		Condition: "test condition",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	_, err = client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": cannot start interactive session: no supported interaction method`)
+
+	m, err = b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+		Location:  d.Location(),
+		Condition: "test condition",
+	}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	_, err = client.DischargeAll(context.Background(), m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": exchange URL ".*/static-token" is not on the static token location ".*"`)
+
+	c.Assert(foreignExchanges, qt.Equals, 0)
+}
+
+func TestValidatorTokenHash(t *testing.T) {
+	c := qt.New(t)
+	token, err := statictoken.GenerateToken()
+	c.Assert(err, qt.IsNil)
+	c.Assert(token, qt.HasLen, 64)
+	hash := statictoken.HashToken(token)
+	c.Assert(hash, qt.Not(qt.Equals), token)
+	c.Assert(hash, qt.HasLen, 64)
+
+	v := statictoken.NewValidator(statictoken.ValidatorParams{})
+	err = v.AddTokenHash(token[:10], "bob", time.Time{})
+	c.Assert(err, qt.ErrorMatches, `invalid token hash ".*"`)
+	err = v.AddTokenHash("not hex", "bob", time.Time{})
+	c.Assert(err, qt.ErrorMatches, `invalid token hash "not hex"`)
+
+	_, err = v.CheckToken(&httpbakery.DischargeToken{
+		Kind:  "other",
+		Value: []byte("x"),
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid discharge token kind "other"`)
+	_, err = v.CheckToken(&httpbakery.DischargeToken{
+		Kind:  statictoken.InteractionMethod,
+		Value: []byte("x"),
+	})
+	c.Assert(err, qt.ErrorMatches, `discharge token not found`)
+}
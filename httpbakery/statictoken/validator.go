@@ -0,0 +1,249 @@
+package statictoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+// DefaultTokenExpiry holds the length of time that a discharge
+// token issued by a Validator remains valid if
+// ValidatorParams.TokenExpiry is zero.
+const DefaultTokenExpiry = time.Minute
+
+// ValidatorParams holds the parameters for NewValidator.
+type ValidatorParams struct {
+	// Path holds the URL path at which the exchange handler will
+	// be served. This is also the URL that will be sent to the
+	// client in the interaction-required error, so it should be an
+	// absolute path on the discharger's host. If it is empty,
+	// "/static-token" will be used.
+	Path string
+
+	// TokenExpiry holds the length of time that an issued discharge
+	// token remains valid. If it is zero, DefaultTokenExpiry will
+	// be used.
+	TokenExpiry time.Duration
+}
+
+// Validator implements the server side of the static-token interaction
+// method. It holds a set of secret tokens, each associated with an
+// identity, and exchanges them for discharge tokens that can be checked
+// by the discharger's third party caveat checker with CheckToken.
+//
+// Secret tokens are only held as SHA-256 hashes, so the values
+// passed to AddTokenHash can be stored in configuration files
+// without revealing the tokens themselves. Because of this, secret
+// tokens should be generated with enough entropy that they
+// cannot be guessed; GenerateToken can be used for that.
+//
+// Issued discharge tokens are held in memory and may be used only once.
+type Validator struct {
+	p ValidatorParams
+
+	mu      sync.Mutex
+	secrets map[string]secretToken
+	issued  map[string]issuedToken
+}
+
+type secretToken struct {
+	identity string
+	// expires holds the time after which the token
+	// may no longer be used. If it's zero, the token
+	// never expires.
+	expires time.Time
+}
+
+type issuedToken struct {
+	identity string
+	expires  time.Time
+}
+
+// NewValidator returns a new Validator that uses the given
+// parameters. It holds no secret tokens initially.
+func NewValidator(p ValidatorParams) *Validator {
+	if p.Path == "" {
+		p.Path = "/static-token"
+	}
+	if p.TokenExpiry == 0 {
+		p.TokenExpiry = DefaultTokenExpiry
+	}
+	return &Validator{
+		p:       p,
+		secrets: make(map[string]secretToken),
+		issued:  make(map[string]issuedToken),
+	}
+}
+
+// GenerateToken returns a new randomly generated secret token.
+func GenerateToken() (string, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", errgo.Notef(err, "cannot generate token")
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// HashToken returns the hash of the given secret token
+// in the form accepted by Validator.AddTokenHash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddToken adds the given secret token, which will authenticate
+// as the given identity. If expires is non-zero, the token may not
+// be used after that time. The token itself is not retained.
+func (v *Validator) AddToken(token, identity string, expires time.Time) {
+	v.addHash(HashToken(token), identity, expires)
+}
+
+// AddTokenHash is like AddToken except that it is passed the
+// hash of the token as returned by HashToken.
+func (v *Validator) AddTokenHash(hash, identity string, expires time.Time) error {
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return errgo.Newf("invalid token hash %q", hash)
+	}
+	v.addHash(hash, identity, expires)
+	return nil
+}
+
+func (v *Validator) addHash(hash, identity string, expires time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets[hash] = secretToken{
+		identity: identity,
+		expires:  expires,
+	}
+}
+
+// RemoveTokenHash removes the secret token with the given hash.
+// Discharge tokens that have already been issued in exchange for
+// it remain valid until they expire.
+func (v *Validator) RemoveTokenHash(hash string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.secrets, hash)
+}
+
+// SetInteraction adds static-token interaction information pointing
+// at the validator to the given error, which should be
+// an interaction-required error that's about to be returned
+// from a discharge request.
+func (v *Validator) SetInteraction(e *httpbakery.Error) {
+	SetInteraction(e, v.p.Path)
+}
+
+// CheckToken checks that the given discharge token was issued by the
+// validator and has not expired, and returns the identity associated
+// with the secret token that it was exchanged for. A discharge token
+// can only be checked successfully once.
+func (v *Validator) CheckToken(token *httpbakery.DischargeToken) (string, error) {
+	if token == nil {
+		return "", errgo.Newf("no discharge token")
+	}
+	if token.Kind != InteractionMethod {
+		return "", errgo.Newf("invalid discharge token kind %q", token.Kind)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.expireTokens(time.Now())
+	t, ok := v.issued[string(token.Value)]
+	if !ok {
+		return "", errgo.Newf("discharge token not found")
+	}
+	delete(v.issued, string(token.Value))
+	return t.identity, nil
+}
+
+// Handlers returns the handler that exchanges secret tokens for
+// discharge tokens. It should be added to the discharger's HTTP
+// server (for example with bakerytest.Discharger.AddHTTPHandlers).
+func (v *Validator) Handlers() []httprequest.Handler {
+	srv := httprequest.Server{
+		ErrorMapper: httpbakery.ErrorToResponse,
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (exchangeHandler, context.Context, error) {
+		return exchangeHandler{v}, p.Context, nil
+	})
+	for i := range hs {
+		hs[i].Path = v.p.Path
+	}
+	return hs
+}
+
+// exchange returns a new discharge token in exchange for the
+// given secret token.
+func (v *Validator) exchange(secret string) (*httpbakery.DischargeToken, error) {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, errgo.Notef(err, "cannot generate token")
+	}
+	value := fmt.Sprintf("%x", buf[:])
+	hash := HashToken(secret)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	v.expireTokens(now)
+	t, ok := v.secrets[hash]
+	if !ok {
+		return nil, errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "invalid static token")
+	}
+	if !t.expires.IsZero() && !now.Before(t.expires) {
+		return nil, errgo.WithCausef(nil, httpbakery.ErrPermissionDenied, "static token has expired")
+	}
+	v.issued[value] = issuedToken{
+		identity: t.identity,
+		expires:  now.Add(v.p.TokenExpiry),
+	}
+	return &httpbakery.DischargeToken{
+		Kind:  InteractionMethod,
+		Value: []byte(value),
+	}, nil
+}
+
+// expireTokens removes all issued tokens that have expired
+// by the given time. It must be called with v.mu held.
+func (v *Validator) expireTokens(now time.Time) {
+	for val, t := range v.issued {
+		if !now.Before(t.expires) {
+			delete(v.issued, val)
+		}
+	}
+}
+
+// exchangeHandler defines the httprequest handler methods
+// for a Validator.
+type exchangeHandler struct {
+	v *Validator
+}
+
+// exchangeRequest is the request to exchange a secret token. The path
+// is replaced by ValidatorParams.Path in Validator.Handlers.
+type exchangeRequest struct {
+	httprequest.Route `httprequest:"POST /static-token"`
+	Body              ExchangeBody `httprequest:",body"`
+}
+
+// Exchange exchanges a secret token for a discharge token.
+func (h exchangeHandler) Exchange(req *exchangeRequest) (*ExchangeResponse, error) {
+	if req.Body.Token == "" {
+		return nil, errgo.WithCausef(nil, httpbakery.ErrBadRequest, "no token provided")
+	}
+	token, err := h.v.exchange(req.Body.Token)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(httpbakery.ErrPermissionDenied))
+	}
+	return &ExchangeResponse{
+		Token: token,
+	}, nil
+}
@@ -0,0 +1,3 @@
+package terminal
+
+var CanOpenWebBrowserForOS = canOpenWebBrowser
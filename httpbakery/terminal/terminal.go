@@ -0,0 +1,171 @@
+// Package terminal provides an interactor for programs running in a
+// terminal that prints the URL to be visited for web-browser-based
+// interaction, optionally as a QR code.
+package terminal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+
+	"github.com/juju/webbrowser"
+	"gopkg.in/errgo.v1"
+	"rsc.io/qr"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+)
+
+var (
+	_ httpbakery.Interactor       = Interactor{}
+	_ httpbakery.LegacyInteractor = Interactor{}
+)
+
+// Interactor handles web-browser-based interaction-required
+// errors for programs running in a terminal, including remote
+// terminals where no web browser can be opened. It prints the URL to be
+// visited (and optionally a QR code for it, so that it can be opened
+// on a phone), opens a web browser if one is likely to be available,
+// and then waits for the interaction to complete in the same way as
+// httpbakery.WebBrowserInteractor.
+//
+// It implements the httpbakery.Interactor interface with the same kind
+// as httpbakery.WebBrowserInteractor, so it can be used in its place
+// with httpbakery.Client.AddInteractor.
+type Interactor struct {
+	// Output is used to print the URL to be visited.
+	// If it's nil, os.Stderr will be used.
+	Output io.Writer
+
+	// QRCode specifies that a QR code for the URL should be
+	// printed using ANSI escape sequences.
+	QRCode bool
+
+	// CanOpenWebBrowser reports whether a web browser can be
+	// opened. If it's nil, CanOpenWebBrowser will be used.
+	CanOpenWebBrowser func() bool
+
+	// OpenWebBrowser is used to visit a page in the user's web
+	// browser when CanOpenWebBrowser returns true. If it's nil,
+	// the page will be opened with the system's default browser.
+	OpenWebBrowser func(*url.URL) error
+}
+
+// Kind implements httpbakery.Interactor.Kind.
+func (Interactor) Kind() string {
+	return httpbakery.WebBrowserInteractionKind
+}
+
+// Interact implements httpbakery.Interactor.Interact by printing the
+// visit URL and waiting for the interaction to complete.
+func (ti Interactor) Interact(ctx context.Context, client *httpbakery.Client, location string, irErr *httpbakery.Error) (*httpbakery.DischargeToken, error) {
+	wi := httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: ti.visit,
+	}
+	token, err := wi.Interact(ctx, client, location, irErr)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return token, nil
+}
+
+// LegacyInteract implements httpbakery.LegacyInteractor by printing
+// the visit URL.
+func (ti Interactor) LegacyInteract(ctx context.Context, client *httpbakery.Client, location string, visitURL *url.URL) error {
+	if err := ti.visit(visitURL); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// visit prints the given URL, opening a web browser if possible.
+func (ti Interactor) visit(u *url.URL) error {
+	w := ti.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	canOpen := ti.CanOpenWebBrowser
+	if canOpen == nil {
+		canOpen = CanOpenWebBrowser
+	}
+	opened := false
+	if canOpen() {
+		open := ti.OpenWebBrowser
+		if open == nil {
+			open = webbrowser.Open
+		}
+		if err := open(u); err == nil {
+			opened = true
+		} else if err != webbrowser.ErrNoBrowser {
+			return errgo.Notef(err, "cannot open web browser")
+		}
+	}
+	if opened {
+		fmt.Fprintf(w, "Opening an authorization web page in your browser.\n")
+		fmt.Fprintf(w, "If it does not open, please open this URL:\n%s\n", u)
+	} else {
+		fmt.Fprintf(w, "Please open this URL in a web browser to authorize:\n%s\n", u)
+	}
+	if !ti.QRCode {
+		return nil
+	}
+	code, err := qr.Encode(u.String(), qr.L)
+	if err != nil {
+		return errgo.Notef(err, "cannot make QR code")
+	}
+	fmt.Fprintf(w, "or scan this QR code:\n")
+	return errgo.Mask(writeQRCode(w, code))
+}
+
+// qrQuietZone holds the number of blank modules
+// printed around a QR code.
+const qrQuietZone = 4
+
+// writeQRCode writes the given QR code to w, using ANSI escape
+// sequences to print each module as two characters with a black or
+// white background.
+func writeQRCode(w io.Writer, code *qr.Code) error {
+	const (
+		black = "\x1b[40m  "
+		white = "\x1b[47m  "
+		reset = "\x1b[0m\n"
+	)
+	bw := bufio.NewWriter(w)
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y++ {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			// Black reports false outside the code, so
+			// the quiet zone is printed as white.
+			if code.Black(x, y) {
+				bw.WriteString(black)
+			} else {
+				bw.WriteString(white)
+			}
+		}
+		bw.WriteString(reset)
+	}
+	return bw.Flush()
+}
+
+// CanOpenWebBrowser reports whether it is likely that a web browser
+// can be opened to show a page to the user. It returns false when
+// running in an SSH session or, on systems that use X11 or Wayland,
+// when there is no display available.
+func CanOpenWebBrowser() bool {
+	return canOpenWebBrowser(runtime.GOOS, os.Getenv)
+}
+
+func canOpenWebBrowser(goos string, getenv func(string) string) bool {
+	if getenv("SSH_CONNECTION") != "" || getenv("SSH_TTY") != "" {
+		return false
+	}
+	switch goos {
+	case "windows", "darwin":
+		return true
+	case "linux", "freebsd", "netbsd", "openbsd":
+		return getenv("DISPLAY") != "" || getenv("WAYLAND_DISPLAY") != ""
+	}
+	return false
+}
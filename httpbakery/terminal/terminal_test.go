@@ -0,0 +1,176 @@
+package terminal_test
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakery/checkers"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/bakerytest"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/rendezvous"
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/httpbakery/terminal"
+)
+
+var interactorTests = []struct {
+	about          string
+	canOpen        bool
+	qrCode         bool
+	expectOpened   bool
+	expectOutput   string
+	expectQRPrefix string
+}{{
+	about:        "no browser",
+	expectOutput: `Please open this URL in a web browser to authorize:\nhttps://.*/visit\?id=[0-9a-f]+\n`,
+}, {
+	about:        "browser",
+	canOpen:      true,
+	expectOpened: true,
+	expectOutput: `Opening an authorization web page in your browser.\nIf it does not open, please open this URL:\nhttps://.*/visit\?id=[0-9a-f]+\n`,
+}, {
+	about:          "QR code",
+	qrCode:         true,
+	expectOutput:   `(?s)Please open this URL in a web browser to authorize:\nhttps://.*/visit\?id=[0-9a-f]+\nor scan this QR code:\n(\x1b\[4[07]m  )+\x1b\[0m\n.*`,
+	expectQRPrefix: strings.Repeat("\x1b[47m  ", 4),
+}}
+
+func TestInteractor(t *testing.T) {
+	c := qt.New(t)
+	svc := rendezvous.New(rendezvous.Params{
+		PollInterval: time.Millisecond,
+	})
+	d := bakerytest.NewDischarger(nil)
+	defer d.Close()
+	d.AddHTTPHandlers(svc.Handlers())
+	d.CheckerP = httpbakery.ThirdPartyCaveatCheckerPFunc(func(ctx context.Context, p httpbakery.ThirdPartyCaveatCheckerParams) ([]checkers.Caveat, error) {
+		if p.Token != nil {
+			return svc.CheckToken(ctx, p.Token, p.Caveat)
+		}
+		id, err := svc.NewDischarge(ctx, p.Caveat)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		err1 := httpbakery.NewInteractionRequiredError(nil, p.Request)
+		svc.SetInteraction(err1, id, "/visit?id="+id)
+		return nil, err1
+	})
+	b := bakery.New(bakery.BakeryParams{
+		Key:     bakery.MustGenerateKey(),
+		Locator: d,
+	})
+	for i, test := range interactorTests {
+		c.Logf("test %d: %s", i, test.about)
+		m, err := b.Oven.NewMacaroon(context.Background(), bakery.LatestVersion, []checkers.Caveat{{
+			Location:  d.Location(),
+			Condition: "something",
+		}}, bakery.Op{Entity: "something", Action: "read"})
+		c.Assert(err, qt.IsNil)
+		w := &completingWriter{
+			complete: func(id string) error {
+				return svc.DischargeComplete(context.Background(), id, nil)
+			},
+		}
+		opened := false
+		client := httpbakery.NewClient()
+		client.AddInteractor(terminal.Interactor{
+			Output: w,
+			QRCode: test.qrCode,
+			CanOpenWebBrowser: func() bool {
+				return test.canOpen
+			},
+			OpenWebBrowser: func(*url.URL) error {
+				opened = true
+				return nil
+			},
+		})
+		ms, err := client.DischargeAll(context.Background(), m)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ms, qt.HasLen, 2)
+		c.Assert(opened, qt.Equals, test.expectOpened)
+		c.Assert(w.buf.String(), qt.Matches, test.expectOutput)
+		if test.expectQRPrefix != "" {
+			lines := strings.Split(w.buf.String(), "\n")
+			c.Assert(strings.HasPrefix(lines[3], test.expectQRPrefix), qt.Equals, true)
+		}
+	}
+}
+
+var canOpenWebBrowserTests = []struct {
+	goos   string
+	env    map[string]string
+	expect bool
+}{{
+	goos:   "linux",
+	expect: false,
+}, {
+	goos: "linux",
+	env: map[string]string{
+		"DISPLAY": ":0",
+	},
+	expect: true,
+}, {
+	goos: "linux",
+	env: map[string]string{
+		"WAYLAND_DISPLAY": "wayland-0",
+	},
+	expect: true,
+}, {
+	goos: "linux",
+	env: map[string]string{
+		"DISPLAY":        "localhost:10.0",
+		"SSH_CONNECTION": "1.2.3.4 5678 5.6.7.8 22",
+	},
+	expect: false,
+}, {
+	goos:   "darwin",
+	expect: true,
+}, {
+	goos: "darwin",
+	env: map[string]string{
+		"SSH_TTY": "/dev/ttys001",
+	},
+	expect: false,
+}, {
+	goos:   "windows",
+	expect: true,
+}, {
+	goos:   "plan9",
+	expect: false,
+}}
+
+func TestCanOpenWebBrowser(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range canOpenWebBrowserTests {
+		getenv := func(key string) string {
+			return test.env[key]
+		}
+		c.Check(terminal.CanOpenWebBrowserForOS(test.goos, getenv), qt.Equals, test.expect, qt.Commentf("%s %v", test.goos, test.env))
+	}
+}
+
+// completingWriter completes the rendezvous whose
+// visit URL is written to it.
+type completingWriter struct {
+	buf      bytes.Buffer
+	complete func(id string) error
+}
+
+var visitIdPat = regexp.MustCompile(`/visit\?id=([0-9a-f]+)`)
+
+func (w *completingWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	if m := visitIdPat.FindSubmatch(b); m != nil {
+		if err := w.complete(string(m[1])); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
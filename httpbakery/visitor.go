@@ -28,9 +28,7 @@ func legacyGetInteractionMethods(ctx context.Context, logger bakery.Logger, clie
 		// When a discharger doesn't support retrieving interaction methods,
 		// we expect to get an error, because it's probably returning an HTML
 		// page not JSON.
-		if logger != nil {
-			logger.Debugf(ctx, "ignoring error: cannot get interaction methods: %v; %s", err, errgo.Details(err))
-		}
+		bakery.Log(ctx, logger, bakery.LevelDebug, "ignoring error: cannot get interaction methods", bakery.Field("url", u.String()), bakery.ErrorField(err), bakery.Field("details", errgo.Details(err)))
 		methodURLs = make(map[string]*url.URL)
 	}
 	if methodURLs["interactive"] == nil {
@@ -0,0 +1,85 @@
+// Package reloadfile holds a value parsed from a file that is read
+// again as it is used, so that changes to the file take effect
+// without a restart.
+package reloadfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// File holds the value parsed from a file.
+type File struct {
+	path     string
+	interval time.Duration
+	parse    func(data []byte) (interface{}, error)
+
+	// mu guards the fields below it.
+	mu         sync.Mutex
+	value      interface{}
+	data       []byte
+	lastReload time.Time
+}
+
+// New returns a File that reads the file at the given path and
+// parses it with parse. The file is checked for changes by Value at
+// most once per interval; if interval is not positive, it is only
+// read again when Reload is called. New returns an error if the
+// file cannot be read or parsed.
+func New(path string, interval time.Duration, parse func(data []byte) (interface{}, error)) (*File, error) {
+	f := &File{
+		path:     path,
+		interval: interval,
+		parse:    parse,
+	}
+	if err := f.Reload(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return f, nil
+}
+
+// Reload reads the file again. If the file cannot be read
+// or parsed, it returns an error and the previous value
+// continues to be used.
+func (f *File) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reload()
+}
+
+// reload is the internal version of Reload.
+// It must be called with f.mu held.
+func (f *File) reload() error {
+	f.lastReload = time.Now()
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if f.value != nil && bytes.Equal(data, f.data) {
+		return nil
+	}
+	value, err := f.parse(data)
+	if err != nil {
+		return errgo.Notef(err, "cannot parse %q", f.path)
+	}
+	f.value, f.data = value, data
+	return nil
+}
+
+// Value returns the current value, first reading the file again
+// if the reload interval has passed. If that fails, the previous
+// value is returned along with the error, which the caller
+// will usually log.
+func (f *File) Value() (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	if f.interval > 0 && time.Since(f.lastReload) >= f.interval {
+		err = f.reload()
+	}
+	return f.value, err
+}
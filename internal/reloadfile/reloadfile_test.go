@@ -0,0 +1,91 @@
+package reloadfile_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/reloadfile"
+)
+
+func TestFile(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.TempDir(), "file")
+	writeFile := func(s string) {
+		err := ioutil.WriteFile(path, []byte(s), 0600)
+		c.Assert(err, qt.IsNil)
+	}
+	parses := 0
+	parse := func(data []byte) (interface{}, error) {
+		parses++
+		if strings.HasPrefix(string(data), "bad") {
+			return nil, errgo.New("bad data")
+		}
+		return string(data), nil
+	}
+
+	_, err := reloadfile.New(path, time.Millisecond, parse)
+	c.Assert(err, qt.ErrorMatches, `open .*: no such file or directory`)
+
+	writeFile("bad")
+	_, err = reloadfile.New(path, time.Millisecond, parse)
+	c.Assert(err, qt.ErrorMatches, `cannot parse ".*": bad data`)
+
+	writeFile("one")
+	parses = 0
+	f, err := reloadfile.New(path, time.Millisecond, parse)
+	c.Assert(err, qt.IsNil)
+	v, err := f.Value()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, "one")
+
+	// An unchanged file is not parsed again.
+	time.Sleep(2 * time.Millisecond)
+	v, err = f.Value()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, "one")
+	c.Assert(parses, qt.Equals, 1)
+
+	// A changed file is read once the interval has passed.
+	writeFile("two")
+	time.Sleep(2 * time.Millisecond)
+	v, err = f.Value()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, "two")
+
+	// If the file becomes invalid, the previous value is
+	// returned along with the error.
+	writeFile("bad again")
+	time.Sleep(2 * time.Millisecond)
+	v, err = f.Value()
+	c.Assert(err, qt.ErrorMatches, `cannot parse ".*": bad data`)
+	c.Assert(v, qt.Equals, "two")
+}
+
+func TestFileWithoutInterval(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.TempDir(), "file")
+	err := ioutil.WriteFile(path, []byte("one"), 0600)
+	c.Assert(err, qt.IsNil)
+	f, err := reloadfile.New(path, -1, func(data []byte) (interface{}, error) {
+		return string(data), nil
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = ioutil.WriteFile(path, []byte("two"), 0600)
+	c.Assert(err, qt.IsNil)
+	v, err := f.Value()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, "one")
+
+	err = f.Reload()
+	c.Assert(err, qt.IsNil)
+	v, err = f.Value()
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, "two")
+}
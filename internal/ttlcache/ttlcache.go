@@ -0,0 +1,147 @@
+// Package ttlcache provides a cache of values that expire, bounded in
+// size by evicting the least recently used entry.
+package ttlcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Clock is used to tell the time. It is implemented
+// by checkers.Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// Cache holds values that expire, up to a maximum number of entries.
+// When the cache is full, adding an entry evicts the entry that was
+// least recently used, so each operation takes constant time.
+// Expired entries are removed when they are next looked up or
+// when they are evicted. It is safe to call concurrently.
+type Cache struct {
+	maxSize int
+	clock   Clock
+
+	// mu guards the fields below it.
+	mu sync.Mutex
+
+	// lru holds the entries with the most
+	// recently used at the front.
+	lru *list.List
+
+	// entries maps from each key to its
+	// element in lru.
+	entries map[interface{}]*list.Element
+}
+
+// entry holds an entry in the cache.
+type entry struct {
+	key     interface{}
+	value   interface{}
+	expires time.Time
+}
+
+// New returns a new cache that holds at most maxSize entries. If clock
+// is nil, the system clock will be used.
+func New(maxSize int, clock Clock) *Cache {
+	if clock == nil {
+		clock = wallClock{}
+	}
+	return &Cache{
+		maxSize: maxSize,
+		clock:   clock,
+		lru:     list.New(),
+		entries: make(map[interface{}]*list.Element),
+	}
+}
+
+// Now returns the current time according to the cache's clock.
+func (c *Cache) Now() time.Time {
+	return c.clock.Now()
+}
+
+// Get returns the value for the given key and reports whether
+// it was found. Entries that have expired are not found.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.value, true
+}
+
+// Add adds the given value to the cache, replacing any existing value
+// for the key. The entry expires at the given time. If the cache is
+// full, the least recently used entry is evicted.
+func (c *Cache) Add(key, value interface{}, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() > 0 && c.lru.Len() >= c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&entry{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+}
+
+// RemoveFunc removes all entries for which f returns true. Unlike the
+// other operations, it takes time proportional to the size of the
+// cache.
+func (c *Cache) RemoveFunc(f func(key, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*entry); f(e.key, e.value) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// Clear removes all entries from the cache.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[interface{}]*list.Element)
+}
+
+// Len returns the number of entries in the cache, including
+// any that have expired but have not yet been removed.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove removes the given element from the cache.
+// Called with c.mu held.
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
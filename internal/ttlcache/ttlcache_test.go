@@ -0,0 +1,92 @@
+package ttlcache_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/go-macaroon-bakery/macaroon-bakery/v3/internal/ttlcache"
+)
+
+var epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestGetAndExpiry(t *testing.T) {
+	c := qt.New(t)
+	clk := &clock{now: epoch}
+	cache := ttlcache.New(10, clk)
+	c.Assert(cache.Now(), qt.Equals, epoch)
+
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+
+	cache.Add("a", 1, epoch.Add(time.Minute))
+	v, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(v, qt.Equals, 1)
+
+	// Adding an existing key replaces its value and expiry.
+	cache.Add("a", 2, epoch.Add(2*time.Minute))
+	c.Assert(cache.Len(), qt.Equals, 1)
+	clk.now = epoch.Add(time.Minute)
+	v, ok = cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(v, qt.Equals, 2)
+
+	// Expired entries are not found, and are removed.
+	clk.now = epoch.Add(2 * time.Minute)
+	_, ok = cache.Get("a")
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(cache.Len(), qt.Equals, 0)
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := qt.New(t)
+	cache := ttlcache.New(3, &clock{now: epoch})
+	expires := epoch.Add(time.Minute)
+	cache.Add("a", 1, expires)
+	cache.Add("b", 2, expires)
+	cache.Add("c", 3, expires)
+
+	// Using "a" makes "b" the least recently used.
+	_, ok := cache.Get("a")
+	c.Assert(ok, qt.IsTrue)
+	cache.Add("d", 4, expires)
+	c.Assert(cache.Len(), qt.Equals, 3)
+	_, ok = cache.Get("b")
+	c.Assert(ok, qt.IsFalse)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok := cache.Get(key)
+		c.Assert(ok, qt.IsTrue, qt.Commentf("key %q", key))
+	}
+}
+
+func TestRemoveFuncAndClear(t *testing.T) {
+	c := qt.New(t)
+	cache := ttlcache.New(10, &clock{now: epoch})
+	expires := epoch.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		cache.Add(i, i, expires)
+	}
+	cache.RemoveFunc(func(key, value interface{}) bool {
+		return value.(int)%2 == 0
+	})
+	c.Assert(cache.Len(), qt.Equals, 2)
+	for i := 0; i < 5; i++ {
+		_, ok := cache.Get(i)
+		c.Assert(ok, qt.Equals, i%2 == 1, qt.Commentf("key %d", i))
+	}
+
+	cache.Clear()
+	c.Assert(cache.Len(), qt.Equals, 0)
+	_, ok := cache.Get(1)
+	c.Assert(ok, qt.IsFalse)
+}